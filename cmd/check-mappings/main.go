@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	_ "github.com/joho/godotenv/autoload"
+	"gopkg.in/yaml.v3"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+var (
+	configFile   = flag.String("config", "./config/config.yaml", "Path to the config file")
+	mappingsFile = flag.String("mappings", "./config/mappings.yaml", "Path to the file with ID mappings")
+	redmineAddr  = flag.String("redmine-addr", "http://localhost:3000", "Address of the Redmine instance")
+	discover     = flag.Bool("discover", false, "Instead of checking the mappings, look up all IDs by name and print the result in mappings.yaml format. Users are copied from the existing mappings file, if there is one")
+	output       = flag.String("output", "", "With --discover, write the result into this file instead of printing it")
+)
+
+func runMain(ctx context.Context) error {
+	b, err := os.ReadFile(*configFile)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	config := &config.Config{}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return fmt.Errorf("parsing config file: %w", err)
+	}
+
+	apiKey := os.Getenv("MODKIT_REDMINE_API_KEY")
+	if apiKey == "" {
+		apiKey = config.RedmineAPIKey
+	}
+
+	client := tickets.NewClient(*redmineAddr, apiKey)
+
+	if *discover {
+		return discoverMappings(client)
+	}
+
+	if err := tickets.LoadMappingsFromFile(*mappingsFile); err != nil {
+		return err
+	}
+
+	problems, err := tickets.ValidateMappings(client, tickets.Mappings())
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("All mappings match Redmine.")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	return fmt.Errorf("found %d problem(s) in %q", len(problems), *mappingsFile)
+}
+
+func discoverMappings(client tickets.Backend) error {
+	m, err := tickets.DiscoverMappings(client)
+	if err != nil {
+		return err
+	}
+
+	switch err := tickets.LoadMappingsFromFile(*mappingsFile); {
+	case err == nil:
+		m.Users = tickets.Mappings().Users
+	case errors.Is(err, fs.ErrNotExist):
+	default:
+		return err
+	}
+
+	if *output != "" {
+		return tickets.WriteMappingsToFile(*output, m)
+	}
+	return yaml.NewEncoder(os.Stdout).Encode(m)
+}
+
+func main() {
+	flag.Parse()
+
+	if err := runMain(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
@@ -7,23 +7,29 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
+	"github.com/valkey-io/valkey-go"
 	"golang.org/x/oauth2"
-	"gopkg.in/yaml.v3"
 
-	"bsky.watch/redmine"
 	"bsky.watch/utils/xrpcauth"
 	"github.com/bluesky-social/indigo/xrpc"
 
 	"bsky.watch/modkit/pkg/cliutil"
 	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/jobqueue"
+	"bsky.watch/modkit/pkg/redminehandler"
+	"bsky.watch/modkit/pkg/reload"
+	"bsky.watch/modkit/pkg/reputation"
 	"bsky.watch/modkit/pkg/tickets"
 )
 
+var cfg redminehandler.Config
+
 func runMain(ctx context.Context) error {
 	ctx = cliutil.SetupLogging(ctx, &cfg.LoggingConfig)
 	log := zerolog.Ctx(ctx)
@@ -53,11 +59,20 @@ func runMain(ctx context.Context) error {
 
 	ticketsClient := tickets.NewClient(cfg.RedmineAddr, cfg.RedmineAPIKey)
 
-	if cfg.Mappings == "" {
-		return fmt.Errorf("please provide the file with ID mappings")
-	}
-	if err := tickets.LoadMappingsFromFile(cfg.Mappings); err != nil {
-		return err
+	if cfg.Mappings != "" {
+		if err := tickets.LoadMappingsFromFile(cfg.Mappings); err != nil {
+			return err
+		}
+		if err := tickets.CheckMappings(ticketsClient, tickets.Mappings()); err != nil {
+			return err
+		}
+	} else {
+		log.Info().Msgf("No ID mappings file provided, discovering IDs by name")
+		m, err := tickets.DiscoverMappings(ticketsClient)
+		if err != nil {
+			return err
+		}
+		tickets.SetMappings(m)
 	}
 
 	if cfg.TicketIDEncryptionKey == "" {
@@ -73,67 +88,73 @@ func runMain(ctx context.Context) error {
 
 	log.Info().Msgf("Startup complete")
 
-	var modkitConfig config.Config
-	b, err := os.ReadFile(cfg.ConfigPath)
+	modkitConfig, err := config.Load(cfg.ConfigPath)
 	if err != nil {
-		return fmt.Errorf("reading %q: %w", cfg.ConfigPath, err)
+		return err
 	}
-	if err := yaml.Unmarshal(b, &modkitConfig); err != nil {
-		return fmt.Errorf("parsing %q: %w", cfg.ConfigPath, err)
+	if err := modkitConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
 	}
 
-	fields, err := ticketsClient.CustomFields()
-	if err != nil {
-		return fmt.Errorf("querying custom fields: %w", err)
-	}
-	var addToListField, labelsField *redmine.CustomFieldDefinition
-	for _, cf := range fields {
-		switch cf.Id {
-		case tickets.Mappings().Fields.AddToLists:
-			cf := cf
-			addToListField = &cf
-		case tickets.Mappings().Fields.Labels:
-			cf := cf
-			labelsField = &cf
-		}
+	if err := redminehandler.UpdateCustomFields(ticketsClient, modkitConfig); err != nil {
+		return err
 	}
-	if addToListField != nil {
-		values := map[string]string{}
-		for id, l := range modkitConfig.Lists {
-			values[id] = fmt.Sprintf("%s [%s]", l.Name, id)
-		}
 
-		if err := updateCustomFieldValues(ticketsClient, addToListField, values); err != nil {
-			return err
-		}
+	clients := map[string]*xrpc.Client{
+		modkitConfig.ModerationAccount.DID: client,
+	}
+
+	if cfg.WebhookSecret == "" && cfg.WebhookSkipSignature {
+		log.Warn().Msgf("Webhook secret is not set, requests will be accepted without signature verification")
 	}
 
-	if labelsField != nil && len(modkitConfig.LabelerPolicies.LabelValueDefinitions) > 0 {
-		values := map[string]string{}
-		for _, label := range modkitConfig.LabelerPolicies.LabelValueDefinitions {
-			displayName := label.Identifier
-			if len(label.Locales) > 0 {
-				displayName = label.Locales[0].Name
+	var jobs *jobqueue.Queue
+	var reputationStore *reputation.Store
+	var valkeyClient valkey.Client
+	if cfg.ValkeyAddr != "" {
+		if cfg.ConsumerName == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("failed to get hostname, please specify consumer name explicitly: %w", err)
 			}
-			values[label.Identifier] = fmt.Sprintf("%s [%s]", displayName, label.Identifier)
+			cfg.ConsumerName = hostname
 		}
-		for _, label := range modkitConfig.SkipLabels {
-			delete(values, label)
+
+		c, err := valkey.NewClient(valkey.ClientOption{
+			InitAddress: []string{cfg.ValkeyAddr},
+		})
+		if err != nil {
+			return fmt.Errorf("creating valkey client for %q: %w", cfg.ValkeyAddr, err)
 		}
-		if err := updateCustomFieldValues(ticketsClient, labelsField, values); err != nil {
-			return err
+		jobs, err = redminehandler.NewJobQueue(ctx, c, cfg.ConsumerName)
+		if err != nil {
+			return fmt.Errorf("creating job queue: %w", err)
 		}
+		reputationStore = reputation.NewStore(c)
+		valkeyClient = c
+	} else {
+		log.Warn().Msgf("Valkey address is not set, actions will be executed synchronously without retries and reporter statistics won't be updated")
 	}
 
-	clients := map[string]*xrpc.Client{
-		modkitConfig.ModerationAccount.DID: client,
-	}
-
-	handler, err := NewHandler(ticketsClient, &modkitConfig, client, clients, cfg.ListServerURL)
+	handler, err := redminehandler.NewHandler(ticketsClient, modkitConfig, &cfg, client, clients, jobs, reputationStore, valkeyClient)
 	if err != nil {
 		return fmt.Errorf("constructing handler: %w", err)
 	}
 
+	if jobs != nil {
+		go func() {
+			if err := handler.RunJobs(ctx); err != nil {
+				log.Fatal().Err(err).Msgf("Job worker stopped: %s", err)
+			}
+		}()
+	}
+
+	reloader := reload.New(cfg.ConfigPath, cfg.Mappings, modkitConfig, ticketsClient)
+	reloader.BeforeSwap(func(ctx context.Context, c *config.Config) error {
+		return redminehandler.UpdateCustomFields(ticketsClient, c)
+	})
+	go reloader.Run(ctx, cfg.ReloadPollInterval)
+
 	mux := http.NewServeMux()
 	mux.Handle("/webhook", handler)
 
@@ -148,11 +169,20 @@ func main() {
 	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", ":8081", "Address to expose metrics on")
 	flag.StringVar(&cfg.TicketIDEncryptionKey, "ticket-id-encryption-key", "", "Secret key for encrypting IDs returned to the user")
 	flag.StringVar(&cfg.RedmineAddr, "redmine-addr", "", "Address of the Redmine instance")
-	flag.StringVar(&cfg.Mappings, "mappings", "", "Path to the file with ID mappings")
+	flag.StringVar(&cfg.Mappings, "mappings", "", "Path to the file with ID mappings. If not set, IDs are looked up in Redmine by name")
 	flag.StringVar(&cfg.ListServerURL, "listserver-addr", "", "Address of the listserver")
 	flag.BoolVar(&cfg.DumpPayloads, "dump-payloads", false, "If set, will log the full payloads received from Redmine")
 	flag.StringVar(&cfg.LabelerPublicURL, "labeler-url", "", "Address of the labeler's query API")
 	flag.StringVar(&cfg.LabelerAdminURL, "labeler-admin-url", "", "Address of the labeler's admin API")
+	flag.StringVar(&cfg.WebhookSecret, "webhook-secret", "", "Shared secret for verifying webhook request signatures (prefer MODKIT_WEBHOOK_SECRET env var)")
+	flag.BoolVar(&cfg.WebhookSkipSignature, "insecure-skip-webhook-signature", false, "Accept unsigned webhook requests if --webhook-secret is not set. Only for local development")
+	flag.StringVar(&cfg.ValkeyAddr, "valkey-addr", "", "Address of the valkey instance to use for the job queue and reporter statistics, the same one that report-processor uses. If not set, actions are executed synchronously without retries")
+	flag.StringVar(&cfg.ConsumerName, "consumer-name", "", "Name to use when reading from the job queue. Must be unique for each running instance. Defaults to the hostname")
+	flag.DurationVar(&cfg.ReloadPollInterval, "reload-poll-interval", 0, "How often to check config and mappings files for changes. If zero, they are only reloaded on SIGHUP")
+	flag.Func("webhook-allowed-ips", "Comma-separated list of IP addresses or CIDR ranges that are allowed to send webhook requests. If empty, any address is allowed", func(s string) error {
+		cfg.WebhookAllowedIPs = strings.Split(s, ",")
+		return nil
+	})
 
 	cliutil.RegisterLoggingFlags(&cfg.LoggingConfig)
 
@@ -167,7 +197,3 @@ func main() {
 		os.Exit(1)
 	}
 }
-
-func ptr[T any](v T) *T {
-	return &v
-}
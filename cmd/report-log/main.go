@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+	"gopkg.in/yaml.v3"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/reportqueue"
+	"bsky.watch/modkit/pkg/reportstore"
+)
+
+var (
+	dbPath     = flag.String("db", "reports.sqlite", "Path to the report log database")
+	configPath = flag.String("config", "", "Path to the config file. Needed for looking up reports by the ID returned to the user")
+	jsonOutput = flag.Bool("json", false, "Print entries as JSON")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command> [args...]
+
+Commands:
+  get <id>...           Print reports with given IDs. Both internal IDs and
+                        IDs returned to the user are accepted, the latter
+                        require --config flag.
+  subject <did|uri>     Print reports about an account or a record.
+                        Reports about records are included when DID is given.
+  reporter <did>        Print reports sent by the given account.
+  range <from> [to]     Print reports received in the given time range.
+                        Times are in RFC 3339 format or a duration relative
+                        to now (e.g., 24h).
+  triage <from> [to]    Evaluate triage rules from the config against reports
+                        received in the given time range and print what
+                        they would do. Nothing is changed in Redmine.
+                        Requires --config flag.
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseRange parses the arguments of the commands that take a time range.
+func parseRange(args []string) (time.Time, time.Time, error) {
+	to := time.Now()
+	if len(args) < 1 || len(args) > 2 {
+		return time.Time{}, to, fmt.Errorf("please specify start and optionally end of the time range")
+	}
+	from, err := parseTime(args[0])
+	if err != nil {
+		return from, to, fmt.Errorf("parsing %q: %w", args[0], err)
+	}
+	if len(args) > 1 {
+		to, err = parseTime(args[1])
+		if err != nil {
+			return from, to, fmt.Errorf("parsing %q: %w", args[1], err)
+		}
+	}
+	return from, to, nil
+}
+
+func loadIdCipher() (*reportqueue.IdCipher, error) {
+	if *configPath == "" {
+		return nil, nil
+	}
+	var modkitConfig config.Config
+	b, err := os.ReadFile(*configPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %w", *configPath, err)
+	}
+	if err := yaml.Unmarshal(b, &modkitConfig); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", *configPath, err)
+	}
+	return reportqueue.NewIdCipher(modkitConfig.TicketIDEncryptionKey)
+}
+
+func printReports(reports []reportstore.Report) error {
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER-FACING ID\tTIMESTAMP\tSENDER\tSUBJECT\tREASON TYPE\tTICKET\tRECORD TICKET")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%d\t%d\n", r.ID, r.EncryptedID, r.Timestamp.Format(time.RFC3339),
+			r.Sender, r.Subject, r.ReasonType, r.AccountTicketID, r.RecordTicketID)
+	}
+	return w.Flush()
+}
+
+func getReports(ctx context.Context, store reportstore.Store, ids []string) ([]reportstore.Report, error) {
+	idCipher, err := loadIdCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	r := []reportstore.Report{}
+	for _, s := range ids {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid report ID %q: %w", s, err)
+		}
+
+		candidates := []uint64{uint64(n)}
+		if idCipher != nil {
+			candidates = append(candidates, idCipher.Decrypt(n))
+		}
+
+		found := false
+		for _, id := range candidates {
+			report, err := store.Get(ctx, id)
+			if errors.Is(err, reportstore.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, *report)
+			found = true
+			break
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "Report %s not found\n", s)
+		}
+	}
+	return r, nil
+}
+
+func runMain(ctx context.Context) error {
+	if flag.NArg() < 1 {
+		usage()
+		return fmt.Errorf("missing command")
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	store, err := reportstore.OpenSQLiteStoreReadOnly(ctx, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var reports []reportstore.Report
+	switch command {
+	case "get":
+		if len(args) == 0 {
+			return fmt.Errorf("please specify report IDs")
+		}
+		reports, err = getReports(ctx, store, args)
+	case "subject":
+		if len(args) != 1 {
+			return fmt.Errorf("please specify exactly one subject")
+		}
+		reports, err = store.BySubject(ctx, args[0])
+	case "reporter":
+		if len(args) != 1 {
+			return fmt.Errorf("please specify exactly one DID")
+		}
+		reports, err = store.ByReporter(ctx, args[0])
+	case "range":
+		from, to, err := parseRange(args)
+		if err != nil {
+			return err
+		}
+		reports, err = store.ByTimeRange(ctx, from, to)
+		if err != nil {
+			return err
+		}
+	case "triage":
+		from, to, err := parseRange(args)
+		if err != nil {
+			return err
+		}
+		return dryRunTriage(ctx, store, from, to)
+	default:
+		usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		return err
+	}
+	return printReports(reports)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := runMain(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
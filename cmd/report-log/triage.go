@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/moderation"
+	"bsky.watch/modkit/pkg/reportstore"
+	"bsky.watch/modkit/pkg/tickets"
+	"bsky.watch/modkit/pkg/triage"
+)
+
+var (
+	mappingsPath = flag.String("mappings", "", "Path to the ID mappings file. Needed by the triage command to tell which reports were sent by moderators")
+	appviewURL   = flag.String("appview-url", "https://public.api.bsky.app", "AppView used by the triage command to fetch profiles of reported accounts. Set to empty to skip conditions on them")
+	labelerURL   = flag.String("labeler-url", "", "Labeler used by the triage command to fetch labels of reported subjects")
+)
+
+type triageResult struct {
+	Report   reportstore.Report
+	Decision triage.Decision
+}
+
+// dryRunTriage evaluates triage rules from the config against the reports
+// received in the given time range, without changing anything.
+func dryRunTriage(ctx context.Context, store reportstore.Store, from time.Time, to time.Time) error {
+	if *configPath == "" {
+		return fmt.Errorf("--config is required for the triage command")
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if len(cfg.Triage) == 0 {
+		return fmt.Errorf("no triage rules in %q", *configPath)
+	}
+	if *mappingsPath != "" {
+		err := tickets.LoadMappingsFromFile(*mappingsPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "%q does not exist, all reports are treated as sent by non-moderators\n", *mappingsPath)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	reports, err := store.ByTimeRange(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	client := &xrpc.Client{Host: *appviewURL}
+	profiles := map[string]*bsky.ActorDefs_ProfileViewDetailed{}
+	histories := map[string][]reportstore.Report{}
+
+	results := []triageResult{}
+	for _, r := range reports {
+		input := triage.NewReport(&r)
+
+		if *appviewURL != "" {
+			profile, found := profiles[r.SubjectDID]
+			if !found {
+				profile, err = bsky.ActorGetProfile(ctx, client, r.SubjectDID)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to fetch profile of %s: %s\n", r.SubjectDID, err)
+				}
+				profiles[r.SubjectDID] = profile
+			}
+			if profile != nil {
+				input.SetProfile(profile)
+			}
+		}
+		if *labelerURL != "" {
+			labels, err := moderation.QueryLabels(ctx, client, *labelerURL, r.SubjectDID, r.Subject)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to fetch labels of %s: %s\n", r.Subject, err)
+			} else {
+				input.AddLabels(labels)
+			}
+		}
+		if cfg.Triage.VolumeWindow() > 0 {
+			history, found := histories[r.SubjectDID]
+			if !found {
+				history, err = store.BySubject(ctx, r.SubjectDID)
+				if err != nil {
+					return err
+				}
+				histories[r.SubjectDID] = history
+			}
+			input.SetHistory(r.ID, history)
+		}
+
+		results = append(results, triageResult{Report: r, Decision: cfg.Triage.Evaluate(input)})
+	}
+	return printTriageResults(results)
+}
+
+func printTriageResults(results []triageResult) error {
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIMESTAMP\tSUBJECT\tREASON TYPE\tRULES\tPRIORITY\tASSIGNEE\tTRACKER\tPROJECT\tWATCHERS")
+	for _, r := range results {
+		d := r.Decision
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Report.ID, r.Report.Timestamp.Format(time.RFC3339),
+			r.Report.Subject, r.Report.ReasonType, strings.Join(d.Rules, ","), d.Priority, d.Assignee,
+			d.Tracker, d.Project, strings.Join(d.Watchers, ","))
+	}
+	return w.Flush()
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/kelseyhightower/envconfig"
@@ -17,9 +18,15 @@ import (
 	"bsky.watch/utils/xrpcauth"
 
 	"bsky.watch/modkit/pkg/cliutil"
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/reload"
+	"bsky.watch/modkit/pkg/reportprocessor"
+	"bsky.watch/modkit/pkg/reportstore"
 	"bsky.watch/modkit/pkg/tickets"
 )
 
+var cfg reportprocessor.Config
+
 func runMain(ctx context.Context) error {
 	ctx = cliutil.SetupLogging(ctx, &cfg.LoggingConfig)
 	log := zerolog.Ctx(ctx)
@@ -48,22 +55,66 @@ func runMain(ctx context.Context) error {
 	}
 
 	ticketsClient := tickets.NewClient(cfg.RedmineAddr, cfg.RedmineAPIKey)
+	if cfg.TicketCacheTTL > 0 {
+		ticketsClient = tickets.WithLookupCache(ticketsClient, cfg.TicketCacheSize, cfg.TicketCacheTTL)
+	}
 
 	if cfg.Mappings != "" {
 		if err := tickets.LoadMappingsFromFile(cfg.Mappings); err != nil {
 			return err
 		}
+		if err := tickets.CheckMappings(ticketsClient, tickets.Mappings()); err != nil {
+			return err
+		}
+	} else {
+		log.Info().Msgf("No ID mappings file provided, discovering IDs by name")
+		m, err := tickets.DiscoverMappings(ticketsClient)
+		if err != nil {
+			return err
+		}
+		tickets.SetMappings(m)
 	}
 
 	if cfg.TicketIDEncryptionKey == "" {
 		return fmt.Errorf("missing ticket ID encryption key")
 	}
 
-	handler, err := NewHandler(ctx, client, ticketsClient, &cfg)
+	if cfg.ConsumerName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname, please specify consumer name explicitly: %w", err)
+		}
+		cfg.ConsumerName = hostname
+	}
+
+	modkitConfig, err := config.Load(cfg.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if err := modkitConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	var reportStore reportstore.Store
+	if cfg.ReportStore != "" {
+		store, err := reportstore.NewSQLiteStore(ctx, cfg.ReportStore)
+		if err != nil {
+			return fmt.Errorf("opening report store: %w", err)
+		}
+		defer store.Close()
+		reportStore = store
+	} else {
+		log.Warn().Msgf("Report store is not configured, processed reports will not be logged")
+	}
+
+	handler, err := reportprocessor.NewHandler(ctx, client, ticketsClient, reportStore, modkitConfig, &cfg)
 	if err != nil {
 		return fmt.Errorf("constructing report handler: %w", err)
 	}
 
+	reloader := reload.New(cfg.ConfigPath, cfg.Mappings, modkitConfig, ticketsClient)
+	go reloader.Run(ctx, cfg.ReloadPollInterval)
+
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
 		if err := http.ListenAndServe(cfg.MetricsAddr, nil); err != nil {
@@ -84,7 +135,18 @@ func main() {
 	flag.StringVar(&cfg.TicketIDEncryptionKey, "ticket-id-encryption-key", "", "Secret key for encrypting IDs returned to the user")
 	flag.StringVar(&cfg.PersistentValkeyAddr, "valkey-addr", "", "Address of the valkey instance to use")
 	flag.StringVar(&cfg.RedmineAddr, "redmine-addr", "", "Address of the Redmine instance")
-	flag.StringVar(&cfg.Mappings, "mappings", "", "Path to the file with ID mappings")
+	flag.StringVar(&cfg.Mappings, "mappings", "", "Path to the file with ID mappings. If not set, IDs are looked up in Redmine by name")
+	flag.StringVar(&cfg.ListServerURL, "listserver-addr", "", "Address of the listserver, used for including current list memberships into appeal tickets")
+	flag.StringVar(&cfg.LabelerPublicURL, "labeler-url", "", "Address of the labeler's query API, used for including current labels into appeal tickets")
+	flag.StringVar(&cfg.ConsumerName, "consumer-name", "", "Name to use when reading from the report queue. Must be unique for each running instance. Defaults to the hostname")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 4, "Number of reports to process in parallel. Reports about the same account are always processed one at a time")
+	flag.StringVar(&cfg.ReportStore, "report-store", "", "Path to the SQLite database for logging processed reports")
+	flag.IntVar(&cfg.TicketCacheSize, "ticket-cache-size", 1000, "Maximum number of cached ticket lookups")
+	flag.DurationVar(&cfg.TicketCacheTTL, "ticket-cache-ttl", 30*time.Second, "How long to cache ticket lookups for. Changes made by this instance are reflected immediately, while changes made in Redmine UI or by redmine-handler can take up to this long to be noticed. 0 disables caching")
+	flag.DurationVar(&cfg.ReloadPollInterval, "reload-poll-interval", 0, "How often to check config and mappings files for changes. If zero, they are only reloaded on SIGHUP")
+	flag.DurationVar(&cfg.ClaimIdleTimeout, "claim-idle-timeout", 10*time.Minute, "Reports that were not acknowledged by another instance for this long will be picked up by this one. 0 disables reclaiming")
+
+	flag.DurationVar(&cfg.NoteAggregationWindow, "note-aggregation-window", 0, "Reports for the same ticket that arrive within this long after a report note are added to that note instead of posting a new one, with a single profile snapshot. Requires --valkey-addr. 0 disables aggregation")
 
 	cliutil.RegisterLoggingFlags(&cfg.LoggingConfig)
 
@@ -99,7 +161,3 @@ func main() {
 		os.Exit(1)
 	}
 }
-
-func ptr[T any](v T) *T {
-	return &v
-}
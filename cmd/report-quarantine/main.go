@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/modkit/pkg/reportqueue"
+)
+
+var (
+	valkeyAddr = flag.String("valkey-addr", "localhost:6379", "Address of the valkey instance with the report queue")
+	sender     = flag.String("sender", "", "Only operate on reports from this DID")
+	subject    = flag.String("subject", "", "Only operate on reports about this DID or at:// URI (DIDs also match reports about records in their repo)")
+	olderThan  = flag.Duration("older-than", 0, "Only operate on reports quarantined more than this long ago")
+	all        = flag.Bool("all", false, "Operate on all quarantined reports (required for 'requeue' and 'purge' without any other filter)")
+	dryRun     = flag.Bool("dry-run", false, "Only print what would be done")
+	jsonOutput = flag.Bool("json", false, "Print entries as JSON")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command> [ids...]
+
+Commands:
+  list            Print quarantined reports.
+  show <id>...    Print full content of quarantined reports.
+  requeue [id...] Move quarantined reports back into the main queue.
+  purge [id...]   Permanently delete quarantined reports.
+
+If IDs are not provided, 'requeue' and 'purge' operate on all reports matching
+the filter flags.
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func matches(e *reportqueue.QuarantinedEntry) bool {
+	if *sender != "" && e.ReportedBy != *sender {
+		return false
+	}
+	if *subject != "" {
+		s := e.Subject()
+		if s != *subject && !strings.HasPrefix(s, fmt.Sprintf("at://%s/", *subject)) {
+			return false
+		}
+	}
+	if *olderThan > 0 && time.Since(e.QuarantinedAt) < *olderThan {
+		return false
+	}
+	return true
+}
+
+func hasFilter() bool {
+	return *all || *sender != "" || *subject != "" || *olderThan > 0
+}
+
+func printEntries(entries []reportqueue.QuarantinedEntry) error {
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tQUARANTINED AT\tREPORT ID\tSENDER\tSUBJECT")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.AckToken, e.QuarantinedAt.Format(time.RFC3339), e.ID, e.ReportedBy, e.Subject())
+	}
+	return w.Flush()
+}
+
+func selectEntries(ctx context.Context, consumer *reportqueue.ValkeyConsumer, ids []string) ([]reportqueue.QuarantinedEntry, error) {
+	if len(ids) > 0 {
+		r := []reportqueue.QuarantinedEntry{}
+		for _, id := range ids {
+			e, err := consumer.QuarantinedReport(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, e)
+		}
+		return r, nil
+	}
+
+	entries, err := consumer.QuarantinedReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := []reportqueue.QuarantinedEntry{}
+	for _, e := range entries {
+		if matches(&e) {
+			r = append(r, e)
+		}
+	}
+	return r, nil
+}
+
+func runMain(ctx context.Context) error {
+	if flag.NArg() < 1 {
+		usage()
+		return fmt.Errorf("missing command")
+	}
+	command, ids := flag.Arg(0), flag.Args()[1:]
+
+	c, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress: []string{*valkeyAddr},
+	})
+	if err != nil {
+		return fmt.Errorf("creating valkey client for %q: %w", *valkeyAddr, err)
+	}
+	defer c.Close()
+
+	consumer, err := reportqueue.NewValkeyConsumer(ctx, c, "report-processor", "report-quarantine", 0)
+	if err != nil {
+		return fmt.Errorf("creating queue consumer for %q: %w", *valkeyAddr, err)
+	}
+
+	switch command {
+	case "list":
+		entries, err := selectEntries(ctx, consumer, nil)
+		if err != nil {
+			return err
+		}
+		return printEntries(entries)
+	case "show":
+		if len(ids) == 0 {
+			return fmt.Errorf("please specify IDs of entries to show")
+		}
+		entries, err := selectEntries(ctx, consumer, ids)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "requeue":
+		if len(ids) == 0 && !hasFilter() {
+			return fmt.Errorf("refusing to requeue everything without --all flag")
+		}
+		if *dryRun {
+			entries, err := selectEntries(ctx, consumer, ids)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Would requeue %d reports:\n", len(entries))
+			return printEntries(entries)
+		}
+		if len(ids) > 0 {
+			if err := consumer.Requeue(ctx, ids...); err != nil {
+				return err
+			}
+			fmt.Printf("Requeued %d reports.\n", len(ids))
+			return nil
+		}
+		requeued, err := consumer.RequeueMatching(ctx, matches)
+		for _, e := range requeued {
+			fmt.Printf("Requeued %s (report ID %s)\n", e.AckToken, e.ID)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Requeued %d reports.\n", len(requeued))
+	case "purge":
+		if len(ids) == 0 && !hasFilter() {
+			return fmt.Errorf("refusing to purge everything without --all flag")
+		}
+		if len(ids) == 0 && *olderThan > 0 && *sender == "" && *subject == "" {
+			if *dryRun {
+				entries, err := selectEntries(ctx, consumer, nil)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Would purge %d reports:\n", len(entries))
+				return printEntries(entries)
+			}
+			n, err := consumer.PurgeQuarantine(ctx, time.Now().Add(-*olderThan))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Purged %d reports.\n", n)
+			return nil
+		}
+
+		entries, err := selectEntries(ctx, consumer, ids)
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			fmt.Printf("Would purge %d reports:\n", len(entries))
+			return printEntries(entries)
+		}
+		toDelete := []string{}
+		for _, e := range entries {
+			toDelete = append(toDelete, e.AckToken)
+		}
+		n, err := consumer.DeleteQuarantined(ctx, toDelete...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d reports.\n", n)
+	default:
+		usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := runMain(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
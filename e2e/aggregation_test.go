@@ -0,0 +1,127 @@
+package e2e
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func TestNoteAggregation(t *testing.T) {
+	h := newHarness(t, harnessOptions{aggregationWindow: time.Minute})
+	subject := h.network.addAccount("spammer.example.com", "Spammer")
+	alice := h.network.addAccount("alice.example.com", "Alice")
+	bob := h.network.addAccount("bob.example.com", "Bob")
+	carol := h.network.addAccount("carol.example.com", "Carol")
+	uri := h.network.addPost(subject, "3kaaa", "cheap watches")
+
+	reports := []struct {
+		from   *account
+		report map[string]any
+		reason string
+	}{
+		{alice, accountReport(subject.DID, "com.atproto.moderation.defs#reasonSpam", "Spamming replies"), "Spamming replies"},
+		{bob, accountReport(subject.DID, "com.atproto.moderation.defs#reasonSpam", "Same link everywhere"), "Same link everywhere"},
+		{carol, recordReport(uri, "com.atproto.moderation.defs#reasonSpam", "Selling watches"), "Selling watches"},
+	}
+	for _, r := range reports {
+		status, _ := h.createReport(r.from, r.report)
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+	}
+
+	ticket := h.waitForTicket("all reports", func(issue *redmine.Issue) bool {
+		if !h.ticketFor(subject.DID, tickets.Mappings().TicketTypes.Ticket)(issue) {
+			return false
+		}
+		for _, r := range reports {
+			if !strings.Contains(notes(issue), r.reason) {
+				return false
+			}
+		}
+		return true
+	})
+
+	count := 0
+	for _, j := range ticket.Journals {
+		if j.Notes != "" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Got %d notes, want 1:\n%s", count, notes(ticket))
+	}
+	for _, s := range []string{"Reported by [Alice (", "Reported by [Bob (", "Reported by [Carol (", "cheap watches"} {
+		if !strings.Contains(notes(ticket), s) {
+			t.Errorf("Note doesn't contain %q:\n%s", s, notes(ticket))
+		}
+	}
+	if c := strings.Count(notes(ticket), "_Report "); c != len(reports) {
+		t.Errorf("Note has %d report headers, want %d:\n%s", c, len(reports), notes(ticket))
+	}
+
+	snapshots := 0
+	for _, a := range h.redmine.Attachments(ticket.Id) {
+		if strings.HasPrefix(a.Filename, "profile_") {
+			snapshots++
+		}
+	}
+	if snapshots != 1 {
+		t.Errorf("Got %d profile snapshots, want 1", snapshots)
+	}
+}
+
+func TestNoteAggregationKeepsReportJSON(t *testing.T) {
+	h := newHarness(t, harnessOptions{aggregationWindow: time.Minute, perRecordTickets: true})
+	subject := h.network.addAccount("spammer.example.com", "Spammer")
+	alice := h.network.addAccount("alice.example.com", "Alice")
+	bob := h.network.addAccount("bob.example.com", "Bob")
+	uri := h.network.addPost(subject, "3kaaa", "cheap watches")
+
+	reportFiles := func(ticketId int) []string {
+		r := []string{}
+		for _, a := range h.redmine.Attachments(ticketId) {
+			if strings.HasPrefix(a.Filename, "report_") {
+				r = append(r, a.Filename)
+			}
+		}
+		return r
+	}
+
+	reasons := []string{"Selling watches", "Same link everywhere"}
+	for i, from := range []*account{alice, bob} {
+		status, _ := h.createReport(from, recordReport(uri, "com.atproto.moderation.defs#reasonSpam", reasons[i]))
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+	}
+
+	ticket := h.waitForTicket("record ticket with all reports", func(issue *redmine.Issue) bool {
+		if customField(issue, tickets.Mappings().Fields.Subject) != uri {
+			return false
+		}
+		for _, s := range reasons {
+			if !strings.Contains(notes(issue), s) {
+				return false
+			}
+		}
+		// Report JSON of an aggregated report is attached after the note is updated.
+		return len(reportFiles(issue.Id)) >= len(reasons)
+	})
+	if c := strings.Count(notes(ticket), "_Report "); c != len(reasons) {
+		t.Errorf("Note has %d report headers, want %d:\n%s", c, len(reasons), notes(ticket))
+	}
+
+	files := reportFiles(ticket.Id)
+	for _, from := range []*account{alice, bob} {
+		if !slices.ContainsFunc(files, func(name string) bool { return strings.Contains(name, from.DID) }) {
+			t.Errorf("Report JSON from %s is not attached, got %q", from.DID, files)
+		}
+	}
+}
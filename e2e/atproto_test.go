@@ -0,0 +1,404 @@
+package e2e
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multicodec"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// account is a canned atproto account served by fakeNetwork.
+type account struct {
+	DID         string
+	Handle      string
+	DisplayName string
+
+	key *ecdsa.PrivateKey
+	// records are keyed by "collection/rkey".
+	records map[string]any
+	// cids override the CID returned for records, keyed the same way.
+	cids map[string]string
+	// createdAt is not reported in the profile if zero.
+	createdAt time.Time
+	// follows is the set of followed DIDs.
+	follows map[string]bool
+	// status is "deactivated", "takendown", "tombstoned", or empty
+	// for active accounts. "unindexed" accounts are active, but unknown
+	// to the appview.
+	status string
+}
+
+// fakeNetwork serves the parts of the atproto network that modkit talks to:
+// a PLC directory with DID documents, and a server acting both as the PDS
+// hosting all accounts and as the appview.
+type fakeNetwork struct {
+	t   *testing.T
+	plc *httptest.Server
+	pds *httptest.Server
+
+	mu       sync.Mutex
+	accounts map[string]*account
+}
+
+func newFakeNetwork(t *testing.T) *fakeNetwork {
+	t.Helper()
+
+	n := &fakeNetwork{t: t, accounts: map[string]*account{}}
+
+	n.plc = httptest.NewServer(http.HandlerFunc(n.serveDIDDocument))
+	t.Cleanup(n.plc.Close)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /xrpc/app.bsky.actor.getProfile", n.getProfile)
+	mux.HandleFunc("GET /xrpc/com.atproto.repo.getRecord", n.getRecord)
+	mux.HandleFunc("GET /xrpc/com.atproto.identity.resolveHandle", n.resolveHandle)
+	mux.HandleFunc("GET /xrpc/app.bsky.graph.getRelationships", n.getRelationships)
+	mux.HandleFunc("GET /xrpc/com.atproto.sync.getRepoStatus", n.getRepoStatus)
+	n.pds = httptest.NewServer(mux)
+	t.Cleanup(n.pds.Close)
+
+	return n
+}
+
+// addAccount creates a new account with a freshly generated P-256 signing key.
+func (n *fakeNetwork) addAccount(handle string, displayName string) *account {
+	n.t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		n.t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(handle))
+	a := &account{
+		DID:         "did:plc:" + strings.ToLower(base32.StdEncoding.EncodeToString(hash[:]))[:24],
+		Handle:      handle,
+		DisplayName: displayName,
+		key:         key,
+		records:     map[string]any{},
+		cids:        map[string]string{},
+		follows:     map[string]bool{},
+	}
+	n.accounts[a.DID] = a
+	return a
+}
+
+// addPost adds a text post to the account's repo and returns its AT URI.
+func (n *fakeNetwork) addPost(a *account, rkey string, text string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	a.records["app.bsky.feed.post/"+rkey] = &bsky.FeedPost{
+		LexiconTypeID: "app.bsky.feed.post",
+		Text:          text,
+		CreatedAt:     time.Now().Format(time.RFC3339),
+	}
+	return fmt.Sprintf("at://%s/app.bsky.feed.post/%s", a.DID, rkey)
+}
+
+// editPost replaces the text of the post, giving it a new CID.
+func (n *fakeNetwork) editPost(a *account, rkey string, text string, cid string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	a.records["app.bsky.feed.post/"+rkey].(*bsky.FeedPost).Text = text
+	a.cids["app.bsky.feed.post/"+rkey] = cid
+}
+
+// deletePost removes the post from the account's repo.
+func (n *fakeNetwork) deletePost(a *account, rkey string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(a.records, "app.bsky.feed.post/"+rkey)
+}
+
+// setStatus changes the status of the account, see account.status.
+func (n *fakeNetwork) setStatus(a *account, status string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	a.status = status
+}
+
+// statusError writes the error that the appview (profile is true) or PDS
+// returns for an inactive account. Returns false if the account is active.
+func (n *fakeNetwork) statusError(w http.ResponseWriter, a *account, profile bool) bool {
+	n.mu.Lock()
+	status := a.status
+	n.mu.Unlock()
+
+	switch {
+	case status == "deactivated" && profile:
+		writeXRPCError(w, http.StatusBadRequest, "AccountDeactivated", "Account is deactivated")
+	case status == "deactivated":
+		writeXRPCError(w, http.StatusBadRequest, "RepoDeactivated", "Repo has been deactivated")
+	case status == "takendown" && profile:
+		writeXRPCError(w, http.StatusBadRequest, "AccountTakedown", "Account has been suspended")
+	case status == "takendown":
+		writeXRPCError(w, http.StatusBadRequest, "RepoTakendown", "Repo has been takendown")
+	case (status == "tombstoned" || status == "unindexed") && profile:
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	case status == "tombstoned":
+		writeXRPCError(w, http.StatusBadRequest, "RepoNotFound", "Could not find repo")
+	default:
+		return false
+	}
+	return true
+}
+
+// setCreatedAt sets the account creation time reported in its profile.
+func (n *fakeNetwork) setCreatedAt(a *account, t time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	a.createdAt = t
+}
+
+// follow makes a follow b.
+func (n *fakeNetwork) follow(a *account, b *account) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	a.follows[b.DID] = true
+}
+
+func (n *fakeNetwork) account(didOrHandle string) *account {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if a, ok := n.accounts[didOrHandle]; ok {
+		return a
+	}
+	for _, a := range n.accounts {
+		if a.Handle == didOrHandle {
+			return a
+		}
+	}
+	return nil
+}
+
+// serviceAuthToken returns a service auth JWT signed by the account,
+// the same way PDS issues them for proxied requests.
+func (n *fakeNetwork) serviceAuthToken(a *account, aud string, lxm string) string {
+	n.t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.DID,
+		"aud": aud,
+		"lxm": lxm,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	s, err := token.SignedString(a.key)
+	if err != nil {
+		n.t.Fatalf("Failed to sign the token: %s", err)
+	}
+	return s
+}
+
+func publicKeyMultibase(key *ecdsa.PublicKey) (string, error) {
+	b := binary.AppendUvarint(nil, uint64(multicodec.P256Pub))
+	b = append(b, elliptic.MarshalCompressed(elliptic.P256(), key.X, key.Y)...)
+	return multibase.Encode(multibase.Base58BTC, b)
+}
+
+func (n *fakeNetwork) serveDIDDocument(w http.ResponseWriter, req *http.Request) {
+	a := n.account(strings.TrimPrefix(req.URL.Path, "/"))
+	if a == nil || !strings.HasPrefix(a.DID, "did:plc:") {
+		http.NotFound(w, req)
+		return
+	}
+	n.mu.Lock()
+	tombstoned := a.status == "tombstoned"
+	n.mu.Unlock()
+	if tombstoned {
+		http.Error(w, "DID not available", http.StatusGone)
+		return
+	}
+
+	key, err := publicKeyMultibase(&a.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"@context": []string{
+			"https://www.w3.org/ns/did/v1",
+			"https://w3id.org/security/multikey/v1",
+		},
+		"id":          a.DID,
+		"alsoKnownAs": []string{"at://" + a.Handle},
+		"verificationMethod": []map[string]any{{
+			"id":                 a.DID + "#atproto",
+			"type":               "Multikey",
+			"controller":         a.DID,
+			"publicKeyMultibase": key,
+		}},
+		"service": []map[string]any{{
+			"id":              "#atproto_pds",
+			"type":            "AtprotoPersonalDataServer",
+			"serviceEndpoint": n.pds.URL,
+		}},
+	})
+}
+
+func (n *fakeNetwork) getProfile(w http.ResponseWriter, req *http.Request) {
+	a := n.account(req.URL.Query().Get("actor"))
+	if a == nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "Profile not found")
+		return
+	}
+	if n.statusError(w, a, true) {
+		return
+	}
+	profile := &bsky.ActorDefs_ProfileViewDetailed{
+		Did:         a.DID,
+		Handle:      a.Handle,
+		DisplayName: &a.DisplayName,
+	}
+	n.mu.Lock()
+	if !a.createdAt.IsZero() {
+		profile.CreatedAt = ptr(a.createdAt.Format(time.RFC3339))
+	}
+	n.mu.Unlock()
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (n *fakeNetwork) getRepoStatus(w http.ResponseWriter, req *http.Request) {
+	a := n.account(req.URL.Query().Get("did"))
+	if a == nil {
+		writeXRPCError(w, http.StatusBadRequest, "RepoNotFound", "Could not find repo")
+		return
+	}
+	n.mu.Lock()
+	status := a.status
+	n.mu.Unlock()
+
+	out := &atproto.SyncGetRepoStatus_Output{Did: a.DID, Active: true}
+	switch status {
+	case "tombstoned":
+		writeXRPCError(w, http.StatusBadRequest, "RepoNotFound", "Could not find repo")
+		return
+	case "deactivated", "takendown":
+		out.Active = false
+		out.Status = &status
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (n *fakeNetwork) getRelationships(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	a := n.account(q.Get("actor"))
+	if a == nil {
+		writeXRPCError(w, http.StatusBadRequest, "ActorNotFound", "Actor not found")
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	out := &bsky.GraphGetRelationships_Output{Actor: &a.DID}
+	for _, did := range q["others"] {
+		other, found := n.accounts[did]
+		if !found {
+			out.Relationships = append(out.Relationships, &bsky.GraphGetRelationships_Output_Relationships_Elem{
+				GraphDefs_NotFoundActor: &bsky.GraphDefs_NotFoundActor{Actor: did, NotFound: true},
+			})
+			continue
+		}
+		rel := &bsky.GraphDefs_Relationship{Did: did}
+		if a.follows[did] {
+			rel.Following = ptr(fmt.Sprintf("at://%s/app.bsky.graph.follow/%s", a.DID, did))
+		}
+		if other.follows[a.DID] {
+			rel.FollowedBy = ptr(fmt.Sprintf("at://%s/app.bsky.graph.follow/%s", did, a.DID))
+		}
+		out.Relationships = append(out.Relationships, &bsky.GraphGetRelationships_Output_Relationships_Elem{
+			GraphDefs_Relationship: rel,
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (n *fakeNetwork) getRecord(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	a := n.account(q.Get("repo"))
+	if a == nil {
+		writeXRPCError(w, http.StatusBadRequest, "RepoNotFound", "Could not find repo")
+		return
+	}
+	if n.statusError(w, a, false) {
+		return
+	}
+
+	key := q.Get("collection") + "/" + q.Get("rkey")
+	n.mu.Lock()
+	record, found := a.records[key]
+	cid := a.cids[key]
+	if found {
+		// Serialize while holding the lock, as editPost modifies records in place.
+		b, err := json.Marshal(record)
+		if err != nil {
+			n.mu.Unlock()
+			writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+			return
+		}
+		record = json.RawMessage(b)
+	}
+	n.mu.Unlock()
+	if cid == "" {
+		cid = "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"
+	}
+	// Like a real PDS, only the current version can be fetched.
+	if !found || (q.Get("cid") != "" && q.Get("cid") != cid) {
+		writeXRPCError(w, http.StatusBadRequest, "RecordNotFound", "Could not locate record")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"uri":   fmt.Sprintf("at://%s/%s/%s", a.DID, q.Get("collection"), q.Get("rkey")),
+		"cid":   cid,
+		"value": record,
+	})
+}
+
+func (n *fakeNetwork) resolveHandle(w http.ResponseWriter, req *http.Request) {
+	a := n.account(req.URL.Query().Get("handle"))
+	if a == nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "Unable to resolve handle")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"did": a.DID})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeXRPCError(w http.ResponseWriter, status int, name string, message string) {
+	writeJSON(w, status, map[string]string{"error": name, "message": message})
+}
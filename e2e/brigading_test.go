@@ -0,0 +1,79 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func TestBrigadingDetection(t *testing.T) {
+	h := newHarness(t, harnessOptions{
+		brigading: config.BrigadingConfig{
+			Window:        time.Hour,
+			MinReporters:  3,
+			MaxAccountAge: 30 * 24 * time.Hour,
+			SameReason:    true,
+			FollowGraph:   true,
+		},
+		escalation: config.EscalationConfig{
+			Thresholds: []config.EscalationThreshold{{Window: time.Hour, MinReports: 5}},
+		},
+	})
+	subject := h.network.addAccount("target.example.com", "Target")
+	carol := h.network.addAccount("carol.example.com", "Carol")
+	fresh1 := h.network.addAccount("fresh1.example.com", "Fresh 1")
+	h.network.setCreatedAt(fresh1, time.Now().Add(-time.Hour))
+	fresh2 := h.network.addAccount("fresh2.example.com", "Fresh 2")
+	h.network.setCreatedAt(fresh2, time.Now().Add(-2*time.Hour))
+	copycat := h.network.addAccount("copycat.example.com", "Copycat")
+	friend := h.network.addAccount("friend.example.com", "Friend")
+	h.network.follow(friend, fresh1)
+
+	report := func(reporter *account, reason string, wait func(notes string) bool) *redmine.Issue {
+		t.Helper()
+		status, _ := h.createReport(reporter, accountReport(subject.DID, "com.atproto.moderation.defs#reasonRude", reason))
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+		return h.waitForTicket("report from "+reporter.Handle, func(issue *redmine.Issue) bool {
+			return h.ticketFor(subject.DID, tickets.Mappings().TicketTypes.Ticket)(issue) && wait(notes(issue))
+		})
+	}
+	posted := func(reason string) func(string) bool {
+		return func(notes string) bool { return strings.Contains(notes, reason) }
+	}
+
+	report(carol, "Harassing people in replies", posted("Harassing people in replies"))
+	// Two suspicious reporters are not enough yet, so both are posted.
+	report(fresh1, "This account is a bot", posted("This account is a bot"))
+	report(copycat, "this account is a  bot", posted("this account is a  bot"))
+
+	// Third suspicious reporter follows the first one.
+	report(friend, "Please ban", posted("3 reports from 3 reporters within 1h look coordinated"))
+	ticket := report(fresh2, "Spam", posted("4 reports from 4 reporters within 1h look coordinated"))
+
+	// Not suspicious, so posted as usual.
+	ticket = report(carol, "Still harassing", posted("Still harassing"))
+
+	n := notes(ticket)
+	if c := strings.Count(n, "Suspected brigading"); c != 1 {
+		t.Errorf("Got %d brigading notes, want 1:\n%s", c, n)
+	}
+	for _, s := range []string{"fresh1.example.com", "copycat.example.com", "friend.example.com", "fresh2.example.com"} {
+		if !strings.Contains(n, "["+s+"]") {
+			t.Errorf("Brigading summary doesn't mention %s:\n%s", s, n)
+		}
+	}
+	if strings.Contains(n, "Reported by [Friend (") || strings.Contains(n, "Reported by [Fresh 2 (") {
+		t.Errorf("Collapsed report was posted as a separate note:\n%s", n)
+	}
+	if strings.Contains(n, "Escalated") {
+		t.Errorf("Ticket was escalated:\n%s", n)
+	}
+}
@@ -0,0 +1,92 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/tickets"
+	"bsky.watch/modkit/pkg/triage"
+)
+
+func TestVolumeEscalation(t *testing.T) {
+	var mu sync.Mutex
+	notifications := []map[string]any{}
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		notifications = append(notifications, payload)
+		mu.Unlock()
+	}))
+	t.Cleanup(notify.Close)
+
+	h := newHarness(t, harnessOptions{
+		// Start with low priority, so that escalation is visible.
+		triage: triage.Rules{{Name: "low", Set: triage.Actions{Priority: "low"}}},
+		escalation: config.EscalationConfig{
+			Thresholds: []config.EscalationThreshold{
+				{Window: time.Hour, MinReports: 3, MinReporters: 2, Priority: "high", Notify: true},
+			},
+			NotifyURL: notify.URL,
+		},
+	})
+	alice := h.network.addAccount("alice.example.com", "Alice")
+	bob := h.network.addAccount("bob.example.com", "Bob")
+	subject := h.network.addAccount("troll.example.com", "Troll")
+
+	report := func(reporter *account, reason string) *redmine.Issue {
+		t.Helper()
+		status, _ := h.createReport(reporter, accountReport(subject.DID, "com.atproto.moderation.defs#reasonRude", reason))
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+		return h.waitForTicket("report posted", func(issue *redmine.Issue) bool {
+			return h.ticketFor(subject.DID, tickets.Mappings().TicketTypes.Ticket)(issue) &&
+				strings.Contains(notes(issue), reason)
+		})
+	}
+
+	// Same reporter twice: not enough unique reporters.
+	report(alice, "first report")
+	ticket := report(alice, "second report")
+	if strings.Contains(notes(ticket), "Escalated") {
+		t.Errorf("Ticket escalated after reports from one reporter")
+	}
+	if prio, _ := tickets.GetPriority(ticket); prio != tickets.PriorityLow {
+		t.Errorf("Priority is %v, want low", prio)
+	}
+
+	report(bob, "third report")
+	ticket = h.waitForTicket("ticket escalated", func(issue *redmine.Issue) bool {
+		prio, _ := tickets.GetPriority(issue)
+		return issue.Id == ticket.Id && prio == tickets.PriorityHigh &&
+			strings.Contains(notes(issue), "Escalated: 3 reports from 2 reporters in 1h")
+	})
+
+	// Further reports within the window don't escalate again.
+	ticket = report(bob, "fourth report")
+	if n := strings.Count(notes(ticket), "Escalated"); n != 1 {
+		t.Errorf("Got %d escalation notes, want 1", n)
+	}
+
+	// Reports about the same account are processed one at a time, so the
+	// notification was sent before the fourth report got posted.
+	mu.Lock()
+	if len(notifications) != 1 {
+		t.Errorf("Got %d notifications, want 1", len(notifications))
+	} else if n := notifications[0]; n["did"] != subject.DID || n["ticketId"] != float64(ticket.Id) || n["reports"] != float64(3) {
+		t.Errorf("Unexpected notification: %v", n)
+	}
+	mu.Unlock()
+}
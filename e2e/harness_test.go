@@ -0,0 +1,497 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rs/zerolog"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/redmine"
+	"github.com/bluesky-social/indigo/api"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/did"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/redminehandler"
+	"bsky.watch/modkit/pkg/reportprocessor"
+	"bsky.watch/modkit/pkg/reportqueue"
+	"bsky.watch/modkit/pkg/reportreceiver"
+	"bsky.watch/modkit/pkg/reportstore"
+	"bsky.watch/modkit/pkg/reputation"
+	"bsky.watch/modkit/pkg/resolver"
+	"bsky.watch/modkit/pkg/tickets"
+	"bsky.watch/modkit/pkg/triage"
+)
+
+const (
+	redmineAPIKey         = "e2e-api-key"
+	webhookSecret         = "e2e-webhook-secret"
+	ticketIDEncryptionKey = "0123456789abcdef"
+	moderatorLogin        = "moderator"
+)
+
+// harness runs report-receiver, report-processor and redmine-handler
+// in-process, with every external dependency replaced by a local stand-in.
+type harness struct {
+	t      *testing.T
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	valkey  *miniredis.Miniredis
+	network *fakeNetwork
+	redmine *fakeRedmine
+	labeler *fakeLabeler
+
+	// modAccount is the account that receives reports.
+	modAccount  *account
+	moderatorId int
+
+	receiver *httptest.Server
+	webhook  *httptest.Server
+}
+
+type harnessOptions struct {
+	perRecordTickets  bool
+	triage            triage.Rules
+	escalation        config.EscalationConfig
+	reputation        config.ReputationConfig
+	brigading         config.BrigadingConfig
+	aggregationWindow time.Duration
+	skipLabels        []string
+	// reportStore enables the report store in the report processor.
+	reportStore bool
+}
+
+func newHarness(t *testing.T, opts harnessOptions) *harness {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = zerolog.New(zerolog.NewTestWriter(t)).Level(zerolog.DebugLevel).WithContext(ctx)
+
+	h := &harness{
+		t:       t,
+		ctx:     ctx,
+		cancel:  cancel,
+		valkey:  miniredis.RunT(t),
+		network: newFakeNetwork(t),
+		redmine: newFakeRedmine(t, "modkit", redmineAPIKey),
+		labeler: newFakeLabeler(t),
+	}
+	h.modAccount = h.network.addAccount("mod.example.com", "Moderation")
+	h.moderatorId = h.redmine.AddUser(moderatorLogin)
+	setupRedmine(h.redmine)
+
+	prevResolver := resolver.Resolver
+	r := did.NewMultiResolver()
+	r.AddHandler("plc", &api.PLCServer{Host: h.network.plc.URL})
+	resolver.Resolver = r
+
+	prevMappings := tickets.Mappings()
+	tickets.SetMappings(testMappings())
+
+	t.Cleanup(func() {
+		cancel()
+		resolver.Resolver = prevResolver
+		tickets.SetMappings(prevMappings)
+	})
+
+	if err := tickets.CheckMappings(tickets.NewClient(h.redmine.URL(), redmineAPIKey), tickets.Mappings()); err != nil {
+		t.Fatal(err)
+	}
+
+	modkitConfig := &config.Config{
+		ModerationAccount:      config.ModAccountConfig{DID: h.modAccount.DID},
+		TicketIDEncryptionKey:  ticketIDEncryptionKey,
+		EnablePerRecordTickets: opts.perRecordTickets,
+		Triage:                 opts.triage,
+		Escalation:             opts.escalation,
+		Reputation:             opts.reputation,
+		Brigading:              opts.brigading,
+		SkipLabels:             opts.skipLabels,
+		LabelerPolicies: bsky.LabelerDefs_LabelerPolicies{
+			LabelValues: []*string{ptr("spam"), ptr("troll")},
+			LabelValueDefinitions: []*atproto.LabelDefs_LabelValueDefinition{
+				{Identifier: "spam", Locales: []*atproto.LabelDefs_LabelValueDefinitionStrings{{Lang: "en", Name: "Spam"}}},
+				{Identifier: "troll", Locales: []*atproto.LabelDefs_LabelValueDefinitionStrings{{Lang: "en", Name: "Troll"}}},
+			},
+		},
+	}
+	client := &xrpc.Client{Client: &http.Client{}, Host: h.network.pds.URL}
+
+	h.startReceiver(modkitConfig)
+	h.startProcessor(modkitConfig, client, opts)
+	h.startRedmineHandler(modkitConfig, client)
+
+	return h
+}
+
+func (h *harness) valkeyClient() valkey.Client {
+	h.t.Helper()
+
+	c, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{h.valkey.Addr()},
+		DisableCache: true,
+	})
+	if err != nil {
+		h.t.Fatalf("Failed to create valkey client: %s", err)
+	}
+	h.t.Cleanup(c.Close)
+	return c
+}
+
+func (h *harness) startReceiver(modkitConfig *config.Config) {
+	h.t.Helper()
+
+	writer, err := reportqueue.NewValkeyWriter(h.ctx, h.valkeyClient(), 1)
+	if err != nil {
+		h.t.Fatalf("Failed to create report writer: %s", err)
+	}
+	cfg := &reportreceiver.Config{
+		OwnDIDs:               []string{modkitConfig.ModerationAccount.DID},
+		TicketIDEncryptionKey: modkitConfig.TicketIDEncryptionKey,
+	}
+	handler, err := reportreceiver.NewHandler(h.ctx, cfg, writer)
+	if err != nil {
+		h.t.Fatalf("Failed to create report receiver: %s", err)
+	}
+	h.receiver = httptest.NewServer(h.withLogger(handler))
+	h.t.Cleanup(h.receiver.Close)
+}
+
+func (h *harness) startProcessor(modkitConfig *config.Config, client *xrpc.Client, opts harnessOptions) {
+	h.t.Helper()
+
+	cfg := &reportprocessor.Config{
+		TicketIDEncryptionKey:  modkitConfig.TicketIDEncryptionKey,
+		PersistentValkeyAddr:   h.valkey.Addr(),
+		ConsumerName:           "e2e",
+		Concurrency:            2,
+		EnablePerRecordTickets: modkitConfig.EnablePerRecordTickets,
+		LabelerPublicURL:       h.labeler.URL(),
+		NoteAggregationWindow:  opts.aggregationWindow,
+	}
+	var store reportstore.Store
+	if opts.reportStore {
+		s, err := reportstore.NewSQLiteStore(h.ctx, filepath.Join(h.t.TempDir(), "reports.sqlite"))
+		if err != nil {
+			h.t.Fatalf("Failed to open report store: %s", err)
+		}
+		h.t.Cleanup(func() { s.Close() })
+		store = s
+	}
+	processor, err := reportprocessor.NewHandler(h.ctx, client, tickets.NewClient(h.redmine.URL(), redmineAPIKey), store, modkitConfig, cfg)
+	if err != nil {
+		h.t.Fatalf("Failed to create report processor: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		processor.Run(h.ctx)
+	}()
+	h.t.Cleanup(func() {
+		h.cancel()
+		<-done
+	})
+}
+
+func (h *harness) startRedmineHandler(modkitConfig *config.Config, client *xrpc.Client) {
+	h.t.Helper()
+
+	ticketsClient := tickets.NewClient(h.redmine.URL(), redmineAPIKey)
+	if err := redminehandler.UpdateCustomFields(ticketsClient, modkitConfig); err != nil {
+		h.t.Fatalf("Failed to update custom fields: %s", err)
+	}
+
+	jobs, err := redminehandler.NewJobQueue(h.ctx, h.valkeyClient(), "e2e")
+	if err != nil {
+		h.t.Fatalf("Failed to create job queue: %s", err)
+	}
+	cfg := &redminehandler.Config{
+		WebhookSecret:    webhookSecret,
+		LabelerPublicURL: h.labeler.URL(),
+		LabelerAdminURL:  h.labeler.URL(),
+	}
+	clients := map[string]*xrpc.Client{modkitConfig.ModerationAccount.DID: client}
+	valkeyClient := h.valkeyClient()
+	handler, err := redminehandler.NewHandler(ticketsClient, modkitConfig, cfg, client, clients, jobs, reputation.NewStore(valkeyClient), valkeyClient)
+	if err != nil {
+		h.t.Fatalf("Failed to create redmine-handler: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.RunJobs(h.ctx)
+	}()
+	h.t.Cleanup(func() {
+		h.cancel()
+		<-done
+	})
+
+	h.webhook = httptest.NewServer(h.withLogger(handler))
+	h.t.Cleanup(h.webhook.Close)
+}
+
+func (h *harness) withLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req.WithContext(zerolog.Ctx(h.ctx).WithContext(req.Context())))
+	})
+}
+
+// createReport sends the report to report-receiver on behalf of the account,
+// authenticated with a service auth token. Returns the HTTP status code and
+// the response body.
+func (h *harness) createReport(from *account, report map[string]any) (int, map[string]any) {
+	h.t.Helper()
+
+	token := h.network.serviceAuthToken(from, h.modAccount.DID, "com.atproto.moderation.createReport")
+	return h.createReportWithToken(token, report)
+}
+
+func (h *harness) createReportWithToken(token string, report map[string]any) (int, map[string]any) {
+	h.t.Helper()
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		h.t.Fatalf("Failed to marshal report: %s", err)
+	}
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodPost, h.receiver.URL+"/xrpc/com.atproto.moderation.createReport", bytes.NewReader(b))
+	if err != nil {
+		h.t.Fatalf("Failed to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("createReport request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var out map[string]any
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			h.t.Fatalf("Failed to decode createReport response: %s", err)
+		}
+	}
+	return resp.StatusCode, out
+}
+
+// updateAsModerator applies the changes to the ticket on behalf of a moderator,
+// and then delivers the webhook to redmine-handler, the same way Redmine does.
+func (h *harness) updateAsModerator(id int, update func(issue *redmine.Issue)) {
+	h.t.Helper()
+
+	backend := h.redmine.Impersonate(moderatorLogin)
+	issue, err := backend.Issue(id)
+	if err != nil {
+		h.t.Fatalf("Issue(%d) failed: %s", id, err)
+	}
+	update(issue)
+	if err := backend.UpdateIssue(*issue); err != nil {
+		h.t.Fatalf("UpdateIssue(%d) failed: %s", id, err)
+	}
+
+	updated, err := backend.IssueWithJournals(id)
+	if err != nil {
+		h.t.Fatalf("IssueWithJournals(%d) failed: %s", id, err)
+	}
+	journal := updated.Journals[len(updated.Journals)-1]
+	payload := webhookPayload(h.t, updated)
+	payload.Action = "updated"
+	payload.Journal = &redminehandler.Journal{
+		Id:     journal.Id,
+		Notes:  journal.Notes,
+		Author: &redminehandler.User{Id: h.moderatorId, Login: moderatorLogin},
+	}
+	for _, d := range journal.Details {
+		payload.Journal.Details = append(payload.Journal.Details, redminehandler.JournalDetails{
+			Property: d.Property,
+			PropKey:  d.Name,
+			Value:    d.NewValue,
+		})
+	}
+
+	if status := h.sendWebhook(payload); status >= 300 {
+		h.t.Fatalf("Webhook for ticket %d returned status %d", id, status)
+	}
+}
+
+// webhookPayload converts the issue into the format used by the webhook plugin.
+func webhookPayload(t *testing.T, issue *redmine.Issue) *redminehandler.WebhookPayload {
+	t.Helper()
+
+	r := &redminehandler.Issue{
+		Id:          issue.Id,
+		Subject:     issue.Subject,
+		Description: issue.Description,
+		Status:      issue.Status,
+		Tracker:     issue.Tracker,
+		Priority:    issue.Priority,
+	}
+	for _, cf := range issue.CustomFields {
+		b, err := json.Marshal(cf.Value)
+		if err != nil {
+			t.Fatalf("Failed to marshal value of field %d: %s", cf.Id, err)
+		}
+		r.CustomFieldValues = append(r.CustomFieldValues, redminehandler.CustomFieldValue{Id: cf.Id, Name: cf.Name, Value: b})
+	}
+	return &redminehandler.WebhookPayload{Issue: r}
+}
+
+// sendWebhook delivers the signed payload to redmine-handler and returns
+// the HTTP status code.
+func (h *harness) sendWebhook(payload *redminehandler.WebhookPayload) int {
+	h.t.Helper()
+
+	b, err := json.Marshal(map[string]any{"payload": payload})
+	if err != nil {
+		h.t.Fatalf("Failed to marshal webhook payload: %s", err)
+	}
+	ts := fmt.Sprint(time.Now().Unix())
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(b)
+
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodPost, h.webhook.URL+"/webhook", bytes.NewReader(b))
+	if err != nil {
+		h.t.Fatalf("Failed to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Modkit-Timestamp", ts)
+	req.Header.Set("X-Modkit-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("Webhook request failed: %s", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// waitForTicket polls the ticket store until the condition holds for
+// some ticket, and returns that ticket with journals.
+func (h *harness) waitForTicket(desc string, cond func(issue *redmine.Issue) bool) *redmine.Issue {
+	h.t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, issue := range h.redmine.Issues() {
+			full, err := h.redmine.IssueWithJournals(issue.Id)
+			if err != nil {
+				h.t.Fatalf("IssueWithJournals(%d) failed: %s", issue.Id, err)
+			}
+			if cond(full) {
+				return full
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	h.t.Fatalf("Timed out waiting for %s", desc)
+	return nil
+}
+
+// ticketFor returns the ticket of the given type with DID field set to did.
+func (h *harness) ticketFor(did string, ticketType int) func(issue *redmine.Issue) bool {
+	return func(issue *redmine.Issue) bool {
+		return issue.Tracker != nil && issue.Tracker.Id == ticketType &&
+			customField(issue, tickets.Mappings().Fields.DID) == did
+	}
+}
+
+func customField(issue *redmine.Issue, id int) any {
+	for _, cf := range issue.CustomFields {
+		if cf.Id == id {
+			return cf.Value
+		}
+	}
+	return nil
+}
+
+func notes(issue *redmine.Issue) string {
+	r := []string{}
+	for _, j := range issue.Journals {
+		if j.Notes != "" {
+			r = append(r, j.Notes)
+		}
+	}
+	return strings.Join(r, "\n---\n")
+}
+
+func testMappings() tickets.IDMappings {
+	m := tickets.IDMappings{ProjectID: 1}
+	m.Priorities.Low = 1
+	m.Priorities.Normal = 2
+	m.Priorities.High = 3
+	m.Priorities.Urgent = 4
+	m.Statuses.New = 1
+	m.Statuses.InProgress = 2
+	m.Statuses.Completed = 3
+	m.Statuses.Applied = 4
+	m.Statuses.Duplicate = 5
+	m.Statuses.Granted = 6
+	m.TicketTypes.Ticket = 1
+	m.TicketTypes.Appeal = 2
+	m.TicketTypes.RecordTicket = 3
+	m.Fields.DID = 1
+	m.Fields.Handle = 2
+	m.Fields.DisplayName = 3
+	m.Fields.Subject = 4
+	m.Fields.AddToLists = 5
+	m.Fields.Labels = 6
+	return m
+}
+
+// setupRedmine creates the project, custom fields and names for statuses,
+// trackers and priorities matching testMappings.
+func setupRedmine(r *fakeRedmine) {
+	m := testMappings()
+	r.AddProject(m.ProjectID, "Tickets")
+	r.SetNames(
+		map[int]string{
+			m.Statuses.New:        "New",
+			m.Statuses.InProgress: "In progress",
+			m.Statuses.Completed:  "Closed",
+			m.Statuses.Applied:    "Applied",
+			m.Statuses.Duplicate:  "Duplicate",
+			m.Statuses.Granted:    "Granted",
+		},
+		map[int]string{
+			m.TicketTypes.Ticket:       "Ticket",
+			m.TicketTypes.Appeal:       "Appeal",
+			m.TicketTypes.RecordTicket: "Record ticket",
+		},
+		map[int]string{
+			m.Priorities.Low:    "Low",
+			m.Priorities.Normal: "Normal",
+			m.Priorities.High:   "High",
+			m.Priorities.Urgent: "Urgent",
+		})
+	r.SetClosedStatuses(m.Statuses.Applied, m.Statuses.Duplicate, m.Statuses.Granted)
+	r.AddCustomField(redmine.CustomFieldDefinition{Id: m.Fields.DID, Name: "DID", FieldFormat: "string"})
+	r.AddCustomField(redmine.CustomFieldDefinition{Id: m.Fields.Handle, Name: "Handle", FieldFormat: "string"})
+	r.AddCustomField(redmine.CustomFieldDefinition{Id: m.Fields.DisplayName, Name: "Display name", FieldFormat: "string"})
+	r.AddCustomField(redmine.CustomFieldDefinition{Id: m.Fields.Subject, Name: "Subject", FieldFormat: "string"})
+	r.AddCustomField(redmine.CustomFieldDefinition{Id: m.Fields.AddToLists, Name: "Add to lists", FieldFormat: "list", Multiple: true})
+	r.AddCustomField(redmine.CustomFieldDefinition{Id: m.Fields.Labels, Name: "Labels", FieldFormat: "list", Multiple: true})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,107 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+// fakeLabeler serves both the public query API of the labeler and its
+// admin API for adding and removing labels.
+type fakeLabeler struct {
+	server *httptest.Server
+
+	mu sync.Mutex
+	// labels maps subject URI to the set of label values currently applied.
+	labels map[string][]string
+	// requests are all label payloads received via the admin API.
+	requests []atproto.LabelDefs_Label
+}
+
+func newFakeLabeler(t *testing.T) *fakeLabeler {
+	t.Helper()
+
+	l := &fakeLabeler{labels: map[string][]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /xrpc/com.atproto.label.queryLabels", l.queryLabels)
+	mux.HandleFunc("POST /label", l.addLabel)
+	l.server = httptest.NewServer(mux)
+	t.Cleanup(l.server.Close)
+	return l
+}
+
+func (l *fakeLabeler) URL() string {
+	return l.server.URL
+}
+
+// Labels returns the label values currently applied to the subject.
+func (l *fakeLabeler) Labels(uri string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.labels[uri])
+}
+
+// Apply adds a label to the subject, as if it was applied outside of modkit.
+func (l *fakeLabeler) Apply(uri string, val string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.labels[uri] = append(l.labels[uri], val)
+}
+
+// Requests returns all payloads received via the admin API.
+func (l *fakeLabeler) Requests() []atproto.LabelDefs_Label {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.requests)
+}
+
+func (l *fakeLabeler) queryLabels(w http.ResponseWriter, req *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	resp := &atproto.LabelQueryLabels_Output{Labels: []*atproto.LabelDefs_Label{}}
+	for _, uri := range req.URL.Query()["uriPatterns"] {
+		for _, val := range l.labels[uri] {
+			resp.Labels = append(resp.Labels, &atproto.LabelDefs_Label{Uri: uri, Val: val})
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// addLabel responds with 201 Created if the label was added or removed, and
+// with 200 OK if it was a no-op.
+func (l *fakeLabeler) addLabel(w http.ResponseWriter, req *http.Request) {
+	var label atproto.LabelDefs_Label
+	if err := json.NewDecoder(req.Body).Decode(&label); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if label.Uri == "" || label.Val == "" {
+		http.Error(w, "missing uri or val", http.StatusBadRequest)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.requests = append(l.requests, label)
+
+	current := l.labels[label.Uri]
+	has := slices.Contains(current, label.Val)
+	switch {
+	case label.Neg != nil && *label.Neg && has:
+		l.labels[label.Uri] = slices.DeleteFunc(current, func(s string) bool { return s == label.Val })
+		w.WriteHeader(http.StatusCreated)
+	case (label.Neg == nil || !*label.Neg) && !has:
+		l.labels[label.Uri] = append(current, label.Val)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
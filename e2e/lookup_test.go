@@ -0,0 +1,44 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func TestFindByDIDPaginates(t *testing.T) {
+	ctx := context.Background()
+	r := newFakeRedmine(t, "modkit", "secret")
+	setupRedmine(r)
+	prevMappings := tickets.Mappings()
+	tickets.SetMappings(testMappings())
+	t.Cleanup(func() { tickets.SetMappings(prevMappings) })
+
+	const did = "did:plc:prolific"
+	for i := 0; i < 250; i++ {
+		opts := []tickets.TicketOption{tickets.Subject(fmt.Sprintf("post %d", i)), tickets.DID(did), tickets.Type(tickets.TypeRecordTicket)}
+		if i%2 == 1 {
+			opts = append(opts, tickets.Status(tickets.StatusApplied))
+		}
+		if _, err := tickets.Create(ctx, r.MemoryBackend, opts...); err != nil {
+			t.Fatalf("Create: %s", err)
+		}
+	}
+
+	client := tickets.NewClient(r.URL(), "secret")
+	for status, want := range map[tickets.StatusFilter]int{
+		tickets.OpenTickets:   125,
+		tickets.ClosedTickets: 125,
+		tickets.AllTickets:    250,
+	} {
+		found, err := tickets.FindByDID(ctx, client, did, status)
+		if err != nil {
+			t.Fatalf("FindByDID(%q): %s", status, err)
+		}
+		if len(found) != want {
+			t.Errorf("FindByDID(%q) returned %d tickets, want %d", status, len(found), want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package e2e
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func TestMergeCommand(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	first := h.network.addAccount("spammer.example.com", "Spammer")
+	second := h.network.addAccount("spammer2.example.com", "Spammer again")
+
+	for _, did := range []string{first.DID, second.DID} {
+		status, _ := h.createReport(reporter, accountReport(did, "com.atproto.moderation.defs#reasonSpam", "spam"))
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+	}
+	from := h.waitForTicket("first ticket", h.ticketFor(first.DID, tickets.Mappings().TicketTypes.Ticket))
+	into := h.waitForTicket("second ticket", h.ticketFor(second.DID, tickets.Mappings().TicketTypes.Ticket))
+	attachmentsBefore := len(h.redmine.Attachments(into.Id))
+
+	h.updateAsModerator(from.Id, func(issue *redmine.Issue) {
+		issue.Notes = "Same person, see the bio."
+		issue.CustomFields = append(issue.CustomFields, &redmine.CustomField{
+			Id:    tickets.Mappings().Fields.Labels,
+			Value: []string{"Spam [spam]"},
+		})
+	})
+	h.updateAsModerator(from.Id, func(issue *redmine.Issue) {
+		issue.Notes = fmt.Sprintf("/merge #%d", into.Id)
+	})
+
+	from = h.waitForTicket("merged ticket", func(issue *redmine.Issue) bool {
+		return issue.Id == from.Id && issue.Status != nil &&
+			issue.Status.Id == tickets.Mappings().Statuses.Duplicate
+	})
+	into, err := h.redmine.IssueWithJournals(into.Id)
+	if err != nil {
+		t.Fatalf("IssueWithJournals(%d): %s", into.Id, err)
+	}
+
+	if !strings.Contains(notes(into), "> Same person, see the bio.") {
+		t.Errorf("Notes were not copied into the canonical ticket:\n%s", notes(into))
+	}
+	if got := tickets.FieldValues(into, tickets.Mappings().Fields.Labels); !slices.Equal(got, []string{"Spam [spam]"}) {
+		t.Errorf("Labels of the canonical ticket are %v, want [Spam [spam]]", got)
+	}
+	if got := len(h.redmine.Attachments(into.Id)); got <= attachmentsBefore {
+		t.Errorf("Canonical ticket has %d attachments, want more than %d", got, attachmentsBefore)
+	}
+	if !slices.ContainsFunc(h.redmine.Relations(), func(rel redmine.IssueRelation) bool {
+		return rel.IssueId == from.Id && rel.IssueToId == into.Id && rel.RelationType == "duplicates"
+	}) {
+		t.Errorf("Missing duplicates relation from %d to %d: %+v", from.Id, into.Id, h.redmine.Relations())
+	}
+}
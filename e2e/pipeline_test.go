@@ -0,0 +1,206 @@
+package e2e
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func accountReport(did string, reasonType string, reason string) map[string]any {
+	return map[string]any{
+		"reasonType": reasonType,
+		"reason":     reason,
+		"subject": map[string]any{
+			"$type": "com.atproto.admin.defs#repoRef",
+			"did":   did,
+		},
+	}
+}
+
+func recordReport(uri string, reasonType string, reason string) map[string]any {
+	return map[string]any{
+		"reasonType": reasonType,
+		"reason":     reason,
+		"subject": map[string]any{
+			"$type": "com.atproto.repo.strongRef",
+			"uri":   uri,
+			"cid":   "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+		},
+	}
+}
+
+func TestAccountReport(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	subject := h.network.addAccount("spammer.example.com", "Totally Legit")
+
+	status, resp := h.createReport(reporter, accountReport(subject.DID, "com.atproto.moderation.defs#reasonSpam", "buy my stuff"))
+	if status != http.StatusOK {
+		t.Fatalf("createReport returned status %d", status)
+	}
+	if resp["id"] == nil || resp["reportedBy"] != reporter.DID {
+		t.Errorf("Unexpected createReport response: %v", resp)
+	}
+
+	ticket := h.waitForTicket("account ticket with a report", func(issue *redmine.Issue) bool {
+		return h.ticketFor(subject.DID, tickets.Mappings().TicketTypes.Ticket)(issue) &&
+			strings.Contains(notes(issue), "Reported by")
+	})
+	if ticket.Subject != subject.Handle {
+		t.Errorf("Ticket subject is %q, want %q", ticket.Subject, subject.Handle)
+	}
+	if got := customField(ticket, tickets.Mappings().Fields.Handle); got != subject.Handle {
+		t.Errorf("Handle field is %v, want %q", got, subject.Handle)
+	}
+	if got := customField(ticket, tickets.Mappings().Fields.DisplayName); got != subject.DisplayName {
+		t.Errorf("Display name field is %v, want %q", got, subject.DisplayName)
+	}
+	for _, want := range []string{reporter.Handle, "Spam", "> buy my stuff"} {
+		if !strings.Contains(notes(ticket), want) {
+			t.Errorf("Ticket notes do not contain %q:\n%s", want, notes(ticket))
+		}
+	}
+
+	// Second report about the same account goes into the same ticket.
+	status, _ = h.createReport(reporter, accountReport(subject.DID, "com.atproto.moderation.defs#reasonRude", "and they're rude"))
+	if status != http.StatusOK {
+		t.Fatalf("createReport returned status %d", status)
+	}
+	h.waitForTicket("second report on the same ticket", func(issue *redmine.Issue) bool {
+		return issue.Id == ticket.Id && strings.Contains(notes(issue), "and they're rude")
+	})
+	if n := len(h.redmine.Issues()); n != 1 {
+		t.Errorf("Got %d tickets, want 1", n)
+	}
+}
+
+func TestRecordReportCreatesRecordTicket(t *testing.T) {
+	h := newHarness(t, harnessOptions{perRecordTickets: true})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	subject := h.network.addAccount("troll.example.com", "Troll")
+	uri := h.network.addPost(subject, "3kabc", "a very bad post")
+
+	status, _ := h.createReport(reporter, recordReport(uri, "com.atproto.moderation.defs#reasonRude", "not nice"))
+	if status != http.StatusOK {
+		t.Fatalf("createReport returned status %d", status)
+	}
+
+	recordTicket := h.waitForTicket("record ticket with a report", func(issue *redmine.Issue) bool {
+		return customField(issue, tickets.Mappings().Fields.Subject) == uri &&
+			strings.Contains(notes(issue), "not nice")
+	})
+	if recordTicket.Tracker == nil || recordTicket.Tracker.Id != tickets.Mappings().TicketTypes.RecordTicket {
+		t.Errorf("Ticket has tracker %v, want record ticket", recordTicket.Tracker)
+	}
+	if !strings.Contains(recordTicket.Description, "a very bad post") {
+		t.Errorf("Ticket description does not contain the post text:\n%s", recordTicket.Description)
+	}
+
+	accountTicket := h.waitForTicket("account ticket", h.ticketFor(subject.DID, tickets.Mappings().TicketTypes.Ticket))
+	related := slices.ContainsFunc(h.redmine.Relations(), func(r redmine.IssueRelation) bool {
+		return r.IssueId == recordTicket.Id && r.IssueToId == accountTicket.Id
+	})
+	if !related {
+		t.Errorf("Record ticket %d is not related to account ticket %d", recordTicket.Id, accountTicket.Id)
+	}
+}
+
+func TestRejectsInvalidAuth(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	subject := h.network.addAccount("bob.example.com", "Bob")
+	report := accountReport(subject.DID, "com.atproto.moderation.defs#reasonSpam", "")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"garbage", "not-a-jwt"},
+		{"wrong audience", h.network.serviceAuthToken(reporter, "did:plc:someoneelse", "com.atproto.moderation.createReport")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, _ := h.createReportWithToken(test.token, report)
+			if status < 400 {
+				t.Errorf("createReport returned status %d, want an error", status)
+			}
+		})
+	}
+}
+
+func TestApplyLabelsOnRecordTicket(t *testing.T) {
+	h := newHarness(t, harnessOptions{perRecordTickets: true})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	subject := h.network.addAccount("spammer.example.com", "Spammer")
+	uri := h.network.addPost(subject, "3kxyz", "cheap watches")
+
+	status, _ := h.createReport(reporter, recordReport(uri, "com.atproto.moderation.defs#reasonSpam", "selling watches"))
+	if status != http.StatusOK {
+		t.Fatalf("createReport returned status %d", status)
+	}
+	// Wait for the report to be posted, so that the moderator's update
+	// doesn't race with report-processor.
+	ticket := h.waitForTicket("record ticket with a report", func(issue *redmine.Issue) bool {
+		return customField(issue, tickets.Mappings().Fields.Subject) == uri &&
+			strings.Contains(notes(issue), "selling watches")
+	})
+
+	h.updateAsModerator(ticket.Id, func(issue *redmine.Issue) {
+		issue.StatusId = tickets.Mappings().Statuses.Completed
+		issue.CustomFields = append(issue.CustomFields, &redmine.CustomField{
+			Id:    tickets.Mappings().Fields.Labels,
+			Value: []string{"Spam [spam]"},
+		})
+	})
+
+	ticket = h.waitForTicket("labels applied", func(issue *redmine.Issue) bool {
+		return issue.Id == ticket.Id && issue.Status != nil &&
+			issue.Status.Id == tickets.Mappings().Statuses.Applied
+	})
+	if got := h.labeler.Labels(uri); !slices.Equal(got, []string{"spam"}) {
+		t.Errorf("Labels on %q are %v, want [spam]", uri, got)
+	}
+	if !strings.Contains(notes(ticket), "* spam") {
+		t.Errorf("Ticket notes do not mention added label:\n%s", notes(ticket))
+	}
+}
+
+func TestApplyLabelsKeepsSkippedLabels(t *testing.T) {
+	h := newHarness(t, harnessOptions{perRecordTickets: true, skipLabels: []string{"troll"}})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	subject := h.network.addAccount("spammer.example.com", "Spammer")
+	uri := h.network.addPost(subject, "3kxyz", "cheap watches")
+	h.labeler.Apply(uri, "troll")
+
+	status, _ := h.createReport(reporter, recordReport(uri, "com.atproto.moderation.defs#reasonSpam", "selling watches"))
+	if status != http.StatusOK {
+		t.Fatalf("createReport returned status %d", status)
+	}
+	// Wait for the report to be posted, so that the moderator's update
+	// doesn't race with report-processor.
+	ticket := h.waitForTicket("record ticket with a report", func(issue *redmine.Issue) bool {
+		return customField(issue, tickets.Mappings().Fields.Subject) == uri &&
+			strings.Contains(notes(issue), "selling watches")
+	})
+
+	h.updateAsModerator(ticket.Id, func(issue *redmine.Issue) {
+		issue.StatusId = tickets.Mappings().Statuses.Completed
+		issue.CustomFields = append(issue.CustomFields, &redmine.CustomField{
+			Id:    tickets.Mappings().Fields.Labels,
+			Value: []string{"Spam [spam]"},
+		})
+	})
+
+	h.waitForTicket("labels applied", func(issue *redmine.Issue) bool {
+		return issue.Id == ticket.Id && issue.Status != nil &&
+			issue.Status.Id == tickets.Mappings().Statuses.Applied
+	})
+	if got := h.labeler.Labels(uri); !slices.Equal(got, []string{"troll", "spam"}) {
+		t.Errorf("Labels on %q are %v, want [troll spam]", uri, got)
+	}
+}
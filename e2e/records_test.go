@@ -0,0 +1,54 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func TestReportedRecordVersion(t *testing.T) {
+	h := newHarness(t, harnessOptions{perRecordTickets: true})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	subject := h.network.addAccount("spammer.example.com", "Spammer")
+
+	reportPost := func(uri string) *redmine.Issue {
+		t.Helper()
+		status, _ := h.createReport(reporter, recordReport(uri, "com.atproto.moderation.defs#reasonSpam", ""))
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+		return h.waitForTicket("record ticket", func(issue *redmine.Issue) bool {
+			return customField(issue, tickets.Mappings().Fields.Subject) == uri && notes(issue) != ""
+		})
+	}
+
+	unchanged := reportPost(h.network.addPost(subject, "3kaaa", "cheap watches"))
+	for _, s := range []string{"Record changed", "Content unavailable"} {
+		if strings.Contains(unchanged.Description+notes(unchanged), s) {
+			t.Errorf("Ticket for unchanged post mentions %q:\n%s\n%s", s, unchanged.Description, notes(unchanged))
+		}
+	}
+
+	uri := h.network.addPost(subject, "3kbbb", "cheap watches")
+	h.network.editPost(subject, "3kbbb", "nothing to see here", "bafyreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku")
+	changed := reportPost(uri)
+	for _, s := range []string{"**Record changed after the report**", "bafyreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"} {
+		if !strings.Contains(changed.Description, s) {
+			t.Errorf("Description doesn't contain %q:\n%s", s, changed.Description)
+		}
+		if !strings.Contains(notes(changed), s) {
+			t.Errorf("Report note doesn't contain %q:\n%s", s, notes(changed))
+		}
+	}
+
+	uri = h.network.addPost(subject, "3kccc", "cheap watches")
+	h.network.deletePost(subject, "3kccc")
+	deleted := reportPost(uri)
+	if want := "the record was deleted"; !strings.Contains(deleted.Description, want) || !strings.Contains(notes(deleted), want) {
+		t.Errorf("Ticket doesn't mention %q:\n%s\n%s", want, deleted.Description, notes(deleted))
+	}
+}
@@ -0,0 +1,456 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// fakeRedmine serves the subset of Redmine REST API used by modkit,
+// backed by tickets.MemoryBackend.
+type fakeRedmine struct {
+	*tickets.MemoryBackend
+
+	apiKey    string
+	uploadDir string
+	server    *httptest.Server
+}
+
+func newFakeRedmine(t *testing.T, login string, apiKey string) *fakeRedmine {
+	t.Helper()
+
+	r := &fakeRedmine{
+		MemoryBackend: tickets.NewMemoryBackend(login),
+		apiKey:        apiKey,
+		uploadDir:     t.TempDir(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /my/account.json", r.myAccount)
+	mux.HandleFunc("GET /users.json", r.users)
+	mux.HandleFunc("GET /users/{file}", r.user)
+	mux.HandleFunc("GET /projects.json", r.projects)
+	mux.HandleFunc("GET /trackers.json", r.trackers)
+	mux.HandleFunc("GET /issue_statuses.json", r.issueStatuses)
+	mux.HandleFunc("GET /enumerations/issue_priorities.json", r.issuePriorities)
+	mux.HandleFunc("GET /issues.json", r.issues)
+	mux.HandleFunc("POST /issues.json", r.createIssue)
+	mux.HandleFunc("GET /issues/{file}", r.issue)
+	mux.HandleFunc("PUT /issues/{file}", r.updateIssue)
+	mux.HandleFunc("GET /issues/{id}/relations.json", r.relations)
+	mux.HandleFunc("POST /issues/{id}/relations.json", r.createRelation)
+	mux.HandleFunc("DELETE /relations/{file}", r.deleteRelation)
+	mux.HandleFunc("POST /issues/{id}/watchers.json", r.addWatcher)
+	mux.HandleFunc("GET /attachments/download/{id}/{filename}", r.downloadAttachment)
+	mux.HandleFunc("PUT /journals/{file}", r.updateJournal)
+	mux.HandleFunc("POST /uploads.json", r.upload)
+	mux.HandleFunc("GET /custom_fields.json", r.customFields)
+	mux.HandleFunc("PUT /custom_fields/{file}", r.updateCustomField)
+
+	r.server = httptest.NewServer(r.authenticate(mux))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *fakeRedmine) URL() string {
+	return r.server.URL
+}
+
+// authenticate checks the API key and handles impersonation
+// with X-Redmine-Switch-User header.
+func (r *fakeRedmine) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("X-Redmine-API-Key")
+		if key == "" {
+			key = req.URL.Query().Get("key")
+		}
+		if key != r.apiKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (r *fakeRedmine) backend(req *http.Request) tickets.Backend {
+	if login := req.Header.Get("X-Redmine-Switch-User"); login != "" {
+		return r.Impersonate(login)
+	}
+	return r.MemoryBackend
+}
+
+// pathId parses the ID from the path segment like "123.json".
+func pathId(req *http.Request, name string) (int, error) {
+	return strconv.Atoi(strings.TrimSuffix(req.PathValue(name), ".json"))
+}
+
+func writeRedmineError(w http.ResponseWriter, err error) {
+	if strings.Contains(err.Error(), "not found") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, map[string][]string{"errors": {err.Error()}})
+}
+
+func (r *fakeRedmine) myAccount(w http.ResponseWriter, req *http.Request) {
+	u, err := r.backend(req).MyAccount()
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"user": u})
+}
+
+func (r *fakeRedmine) user(w http.ResponseWriter, req *http.Request) {
+	id, err := pathId(req, "file")
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	u, err := r.backend(req).User(id)
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"user": u})
+}
+
+func (r *fakeRedmine) users(w http.ResponseWriter, req *http.Request) {
+	users, err := r.backend(req).Users()
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"users": users})
+}
+
+func (r *fakeRedmine) projects(w http.ResponseWriter, req *http.Request) {
+	projects, err := r.backend(req).Projects()
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"projects": projects})
+}
+
+func (r *fakeRedmine) trackers(w http.ResponseWriter, req *http.Request) {
+	trackers, err := r.backend(req).Trackers()
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"trackers": trackers})
+}
+
+func (r *fakeRedmine) issueStatuses(w http.ResponseWriter, req *http.Request) {
+	statuses, err := r.backend(req).IssueStatuses()
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"issue_statuses": statuses})
+}
+
+func (r *fakeRedmine) issuePriorities(w http.ResponseWriter, req *http.Request) {
+	priorities, err := r.backend(req).IssuePriorities()
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"issue_priorities": priorities})
+}
+
+// issues supports only filtering by a single custom field and status,
+// since that's the only kind of query modkit makes. Pagination works the same
+// way as in Redmine: limit defaults to 25 and is capped at 100.
+func (r *fakeRedmine) issues(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	projectId, err := strconv.Atoi(q.Get("project_id"))
+	if err != nil {
+		writeRedmineError(w, fmt.Errorf("invalid project_id: %w", err))
+		return
+	}
+	field := q.Get("f[]")
+	fieldId, err := strconv.Atoi(strings.TrimPrefix(field, "cf_"))
+	if err != nil || q.Get(fmt.Sprintf("op[%s]", field)) != "=" {
+		writeRedmineError(w, fmt.Errorf("unsupported filter %q", q.Encode()))
+		return
+	}
+	status := tickets.StatusFilter(q.Get("status_id"))
+	if status == "" {
+		status = tickets.OpenTickets
+	}
+	limit := 25
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil {
+			writeRedmineError(w, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+	}
+	limit = min(limit, 100)
+	offset := 0
+	if s := q.Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil {
+			writeRedmineError(w, fmt.Errorf("invalid offset: %w", err))
+			return
+		}
+	}
+
+	issues, err := r.backend(req).FindByCustomField(projectId, fieldId, q.Get(fmt.Sprintf("v[%s][]", field)), status)
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	total := len(issues)
+	issues = issues[min(offset, total):min(offset+limit, total)]
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issues":      issues,
+		"total_count": total,
+		"offset":      offset,
+		"limit":       limit,
+	})
+}
+
+func (r *fakeRedmine) createIssue(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Issue redmine.Issue `json:"issue"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	issue, err := r.backend(req).CreateIssue(body.Issue)
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"issue": issue})
+}
+
+func (r *fakeRedmine) issue(w http.ResponseWriter, req *http.Request) {
+	id, err := pathId(req, "file")
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	var issue *redmine.Issue
+	if strings.Contains(req.URL.Query().Get("include"), "journals") {
+		issue, err = r.backend(req).IssueWithJournals(id)
+	} else {
+		issue, err = r.backend(req).Issue(id)
+	}
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	if !strings.Contains(req.URL.Query().Get("include"), "attachments") {
+		writeJSON(w, http.StatusOK, map[string]any{"issue": issue})
+		return
+	}
+	files, err := r.backend(req).IssueAttachments(id)
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"issue": struct {
+		*redmine.Issue
+		Attachments []tickets.Attachment `json:"attachments"`
+	}{issue, files}})
+}
+
+func (r *fakeRedmine) updateIssue(w http.ResponseWriter, req *http.Request) {
+	id, err := pathId(req, "file")
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	var body struct {
+		Issue redmine.Issue `json:"issue"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.Issue.Id = id
+	if err := r.backend(req).UpdateIssue(body.Issue); err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *fakeRedmine) relations(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	relations, err := r.backend(req).IssueRelations(id)
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"relations": relations})
+}
+
+func (r *fakeRedmine) deleteRelation(w http.ResponseWriter, req *http.Request) {
+	id, err := pathId(req, "file")
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	if err := r.backend(req).DeleteIssueRelation(id); err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *fakeRedmine) downloadAttachment(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	content, err := r.backend(req).DownloadAttachment(tickets.Attachment{Id: id})
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	w.Write(content)
+}
+
+func (r *fakeRedmine) createRelation(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	var body struct {
+		Relation redmine.IssueRelation `json:"relation"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.Relation.IssueId = id
+	relation, err := r.backend(req).CreateIssueRelation(body.Relation)
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"relation": relation})
+}
+
+func (r *fakeRedmine) addWatcher(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	var body struct {
+		UserId int `json:"user_id"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := r.backend(req).AddWatcher(id, body.UserId); err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *fakeRedmine) updateJournal(w http.ResponseWriter, req *http.Request) {
+	id, err := pathId(req, "file")
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	var body struct {
+		Journal redmine.Journal `json:"journal"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.Journal.Id = id
+	if err := r.backend(req).UpdateJournal(&body.Journal); err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *fakeRedmine) upload(w http.ResponseWriter, req *http.Request) {
+	filename := filepath.Base(req.URL.Query().Get("filename"))
+	if filename == "." || filename == "/" {
+		filename = "upload"
+	}
+	content, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// MemoryBackend reads the content from a file, just like the client library does.
+	dir, err := os.MkdirTemp(r.uploadDir, "upload")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path := filepath.Join(dir, filename)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	upload, err := r.backend(req).Upload(path)
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"upload": upload})
+}
+
+func (r *fakeRedmine) customFields(w http.ResponseWriter, req *http.Request) {
+	fields, err := r.backend(req).CustomFields()
+	if err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"custom_fields": fields})
+}
+
+func (r *fakeRedmine) updateCustomField(w http.ResponseWriter, req *http.Request) {
+	id, err := pathId(req, "file")
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	var body struct {
+		CustomField redmine.CustomFieldDefinition `json:"custom_field"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.CustomField.Id = id
+	if err := r.backend(req).UpdateCustomField(body.CustomField); err != nil {
+		writeRedmineError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,92 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/reputation"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func TestReporterReputation(t *testing.T) {
+	h := newHarness(t, harnessOptions{
+		perRecordTickets: true,
+		reputation:       config.ReputationConfig{MinResolved: 1, DeprioritizeBelow: 0.5},
+	})
+	store := reputation.NewStore(h.valkeyClient())
+	alice := h.network.addAccount("alice.example.com", "Alice")
+	bob := h.network.addAccount("bob.example.com", "Bob")
+	subject := h.network.addAccount("spammer.example.com", "Spammer")
+
+	reportPost := func(reporter *account, rkey string) *redmine.Issue {
+		t.Helper()
+		uri := h.network.addPost(subject, rkey, "cheap watches")
+		reason := "spam in " + rkey
+		status, _ := h.createReport(reporter, recordReport(uri, "com.atproto.moderation.defs#reasonSpam", reason))
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+		// Wait for the report to be posted, so that resolving the ticket
+		// doesn't race with report-processor.
+		return h.waitForTicket("record ticket with a report", func(issue *redmine.Issue) bool {
+			return customField(issue, tickets.Mappings().Fields.Subject) == uri &&
+				strings.Contains(notes(issue), reason)
+		})
+	}
+	resolve := func(ticket *redmine.Issue, labels []string) {
+		t.Helper()
+		h.updateAsModerator(ticket.Id, func(issue *redmine.Issue) {
+			issue.StatusId = tickets.Mappings().Statuses.Completed
+			issue.CustomFields = append(issue.CustomFields, &redmine.CustomField{
+				Id:    tickets.Mappings().Fields.Labels,
+				Value: labels,
+			})
+		})
+	}
+	waitForStats := func(reporter *account, want reputation.Stats) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			got, err := store.Get(h.ctx, reporter.DID)
+			if err != nil {
+				t.Fatalf("Get(%q) failed: %s", reporter.DID, err)
+			}
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Stats of %s are %+v, want %+v", reporter.Handle, got, want)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	// Alice's report leads to a label being applied.
+	resolve(reportPost(alice, "3kaaa"), []string{"Spam [spam]"})
+	waitForStats(alice, reputation.Stats{Applied: 1})
+
+	ticket := reportPost(alice, "3kbbb")
+	if want := "reputation 1.00 (1 applied, 0 with no action, 0 duplicates)"; !strings.Contains(notes(ticket), want) {
+		t.Errorf("Report note doesn't contain %q:\n%s", want, notes(ticket))
+	}
+	if prio, _ := tickets.GetPriority(ticket); prio != tickets.PriorityUrgent {
+		t.Errorf("Priority is %v, want urgent", prio)
+	}
+
+	// Bob's report is closed without any action.
+	resolve(reportPost(bob, "3kccc"), []string{})
+	waitForStats(bob, reputation.Stats{NoAction: 1})
+
+	ticket = reportPost(bob, "3kddd")
+	if want := "reputation 0.00"; !strings.Contains(notes(ticket), want) {
+		t.Errorf("Report note doesn't contain %q:\n%s", want, notes(ticket))
+	}
+	if prio, _ := tickets.GetPriority(ticket); prio != tickets.PriorityLow {
+		t.Errorf("Priority is %v, want low", prio)
+	}
+}
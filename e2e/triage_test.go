@@ -0,0 +1,111 @@
+package e2e
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+	"gopkg.in/yaml.v3"
+
+	"bsky.watch/modkit/pkg/tickets"
+	"bsky.watch/modkit/pkg/triage"
+)
+
+func TestTriageRules(t *testing.T) {
+	var rules triage.Rules
+	err := yaml.Unmarshal([]byte(`
+- name: spam
+  match:
+    reasonTypes: [spam]
+    reasonPattern: (?i)crypto
+  set:
+    priority: low
+    assignee: moderator
+    project: spam
+    watchers: [moderator]
+- name: harassment
+  match:
+    reasonTypes: [rude]
+  set:
+    priority: urgent
+`), &rules)
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %s", err)
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Invalid rules: %s", err)
+	}
+
+	h := newHarness(t, harnessOptions{triage: rules})
+	h.redmine.AddSubproject(10, tickets.Mappings().ProjectID, "spam")
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	subject := h.network.addAccount("spammer.example.com", "Totally Legit")
+
+	status, _ := h.createReport(reporter, accountReport(subject.DID, "com.atproto.moderation.defs#reasonSpam", "Crypto giveaway"))
+	if status != http.StatusOK {
+		t.Fatalf("createReport returned status %d", status)
+	}
+	ticket := h.waitForTicket("ticket routed by triage rules", func(issue *redmine.Issue) bool {
+		return h.ticketFor(subject.DID, tickets.Mappings().TicketTypes.Ticket)(issue) &&
+			strings.Contains(notes(issue), "Crypto giveaway")
+	})
+	if prio, _ := tickets.GetPriority(ticket); prio != tickets.PriorityLow {
+		t.Errorf("Priority is %v, want low", prio)
+	}
+	if ticket.ProjectId != 10 {
+		t.Errorf("Project is %d, want 10", ticket.ProjectId)
+	}
+	if ticket.AssignedToId != h.moderatorId {
+		t.Errorf("Assigned to %d, want %d", ticket.AssignedToId, h.moderatorId)
+	}
+	h.waitForTicket("watcher added", func(issue *redmine.Issue) bool {
+		return issue.Id == ticket.Id && slices.Contains(h.redmine.Watchers(ticket.Id), h.moderatorId)
+	})
+
+	// A report matching another rule raises the priority of the same ticket.
+	status, _ = h.createReport(reporter, accountReport(subject.DID, "com.atproto.moderation.defs#reasonRude", "and they're rude"))
+	if status != http.StatusOK {
+		t.Fatalf("createReport returned status %d", status)
+	}
+	h.waitForTicket("priority raised", func(issue *redmine.Issue) bool {
+		prio, _ := tickets.GetPriority(issue)
+		return issue.Id == ticket.Id && strings.Contains(notes(issue), "and they're rude") && prio == tickets.PriorityUrgent
+	})
+	if n := len(h.redmine.Issues()); n != 1 {
+		t.Errorf("Got %d tickets, want 1", n)
+	}
+}
+
+func TestTriageVolume(t *testing.T) {
+	rules := triage.Rules{
+		{Name: "low", Set: triage.Actions{Priority: "low"}},
+		{Name: "burst", Match: triage.Match{Volume: triage.VolumeMatch{MinReports: 3, Window: time.Hour}}, Set: triage.Actions{Priority: "high"}},
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Invalid rules: %s", err)
+	}
+
+	// Volume is counted without the report store.
+	h := newHarness(t, harnessOptions{triage: rules})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	subject := h.network.addAccount("troll.example.com", "Troll")
+
+	for i, want := range []tickets.TicketPriority{tickets.PriorityLow, tickets.PriorityLow, tickets.PriorityHigh} {
+		reason := fmt.Sprintf("report #%d", i+1)
+		status, _ := h.createReport(reporter, accountReport(subject.DID, "com.atproto.moderation.defs#reasonRude", reason))
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+		ticket := h.waitForTicket(reason+" posted", func(issue *redmine.Issue) bool {
+			return h.ticketFor(subject.DID, tickets.Mappings().TicketTypes.Ticket)(issue) &&
+				strings.Contains(notes(issue), reason)
+		})
+		if prio, _ := tickets.GetPriority(ticket); prio != want {
+			t.Errorf("Priority after %s is %v, want %v", reason, prio, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package e2e
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func TestUnavailableContent(t *testing.T) {
+	h := newHarness(t, harnessOptions{reportStore: true})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+
+	report := func(subject map[string]any, did string, want ...string) *redmine.Issue {
+		t.Helper()
+		status, _ := h.createReport(reporter, subject)
+		if status != http.StatusOK {
+			t.Fatalf("createReport returned status %d", status)
+		}
+		return h.waitForTicket(fmt.Sprintf("ticket with %q", want), func(issue *redmine.Issue) bool {
+			if !h.ticketFor(did, tickets.Mappings().TicketTypes.Ticket)(issue) {
+				return false
+			}
+			for _, s := range want {
+				if !strings.Contains(issue.Description+notes(issue), s) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	// Deleted post that was reported before.
+	spammer := h.network.addAccount("spammer.example.com", "Spammer")
+	uri := h.network.addPost(spammer, "3kaaa", "cheap watches")
+	ticket := report(recordReport(uri, "com.atproto.moderation.defs#reasonSpam", "First"), spammer.DID, "cheap watches")
+	h.network.deletePost(spammer, "3kaaa")
+	report(recordReport(uri, "com.atproto.moderation.defs#reasonSpam", "Second"), spammer.DID,
+		"Second", "**Content unavailable**", "the record was deleted", fmt.Sprintf("It was reported earlier on #%d", ticket.Id))
+
+	// Deactivated account.
+	deactivated := h.network.addAccount("gone.example.com", "Gone")
+	h.network.setStatus(deactivated, "deactivated")
+	report(accountReport(deactivated.DID, "com.atproto.moderation.defs#reasonRude", "Rude"), deactivated.DID,
+		"Rude", "**Content unavailable**", "the account is deactivated")
+
+	// Post by an account with a tombstoned DID.
+	tombstoned := h.network.addAccount("tombstoned.example.com", "Tombstoned")
+	uri = h.network.addPost(tombstoned, "3kbbb", "cheap watches")
+	h.network.setStatus(tombstoned, "tombstoned")
+	report(recordReport(uri, "com.atproto.moderation.defs#reasonSpam", "Spam"), tombstoned.DID,
+		"Spam", "**Content unavailable**", uri, "tombstoned")
+}
+
+func TestUnindexedAccountIsRetried(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	reporter := h.network.addAccount("alice.example.com", "Alice")
+	newcomer := h.network.addAccount("new.example.com", "Newcomer")
+	h.network.setStatus(newcomer, "unindexed")
+
+	status, _ := h.createReport(reporter, accountReport(newcomer.DID, "com.atproto.moderation.defs#reasonRude", "Rude"))
+	if status != http.StatusOK {
+		t.Fatalf("createReport returned status %d", status)
+	}
+	// PDS still hosts the account, so the report waits for the appview
+	// instead of being posted as unavailable.
+	time.Sleep(time.Second)
+	h.network.setStatus(newcomer, "")
+
+	ticket := h.waitForTicket("ticket with the report", func(issue *redmine.Issue) bool {
+		return h.ticketFor(newcomer.DID, tickets.Mappings().TicketTypes.Ticket)(issue) &&
+			strings.Contains(issue.Description+notes(issue), "Rude")
+	})
+	if strings.Contains(ticket.Description+notes(ticket), "Content unavailable") {
+		t.Errorf("Report about an account unknown to the appview was posted as unavailable")
+	}
+}
@@ -9,12 +9,17 @@ import (
 	"bsky.watch/redmine"
 )
 
+// UploadClient is the subset of tickets.Backend needed for uploading files.
+type UploadClient interface {
+	Upload(filename string) (*redmine.Upload, error)
+}
+
 type AttachmentCreator struct {
-	client      *redmine.Client
+	client      UploadClient
 	attachments []*redmine.Upload
 }
 
-func NewGlobalAttachmentCreator(client *redmine.Client) *AttachmentCreator {
+func NewGlobalAttachmentCreator(client UploadClient) *AttachmentCreator {
 	return &AttachmentCreator{client: client}
 }
 
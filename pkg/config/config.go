@@ -1,6 +1,17 @@
 package config
 
-import "github.com/bluesky-social/indigo/api/bsky"
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+
+	"bsky.watch/modkit/pkg/triage"
+)
 
 type Config struct {
 	RedmineAPIKey          string                           `yaml:"redmineApiKey"`
@@ -13,6 +24,124 @@ type Config struct {
 	EnablePerRecordTickets bool                             `yaml:"enablePerRecordTickets"`
 	LabelsFromLists        map[string]string                `yaml:"labelsFromLists"`
 	SkipLabels             []string                         `yaml:"skipLabels"`
+	RequireApproval        ApprovalConfig                   `yaml:"requireApproval"`
+	Triage                 triage.Rules                     `yaml:"triage"`
+	Escalation             EscalationConfig                 `yaml:"escalation"`
+	Reputation             ReputationConfig                 `yaml:"reputation"`
+	Brigading              BrigadingConfig                  `yaml:"brigading"`
+}
+
+// ApprovalConfig lists the lists (by ID) and labels that are applied only
+// after a second moderator approves the ticket.
+type ApprovalConfig struct {
+	Lists  []string `yaml:"lists"`
+	Labels []string `yaml:"labels"`
+}
+
+// EscalationConfig raises the priority of account tickets that receive
+// a lot of reports in a short time.
+type EscalationConfig struct {
+	Thresholds []EscalationThreshold `yaml:"thresholds"`
+	// NotifyURL receives a POST request with a JSON summary for every
+	// escalation caused by a threshold with `notify: true`.
+	NotifyURL string `yaml:"notifyURL"`
+}
+
+// EscalationThreshold is crossed when there were at least MinReports reports
+// from at least MinReporters different reporters about the same account
+// within the Window.
+type EscalationThreshold struct {
+	Window       time.Duration `yaml:"window"`
+	MinReports   int           `yaml:"minReports"`
+	MinReporters int           `yaml:"minReporters"`
+	// Priority is one of "low", "normal", "high" or "urgent", "urgent" if
+	// not set. The ticket's priority is only ever raised.
+	Priority string `yaml:"priority"`
+	Notify   bool   `yaml:"notify"`
+}
+
+// MaxWindow returns the largest window used by the thresholds.
+func (c *EscalationConfig) MaxWindow() time.Duration {
+	var r time.Duration
+	for _, t := range c.Thresholds {
+		r = max(r, t.Window)
+	}
+	return r
+}
+
+func (c *EscalationConfig) validate() error {
+	for i, t := range c.Thresholds {
+		if t.Window <= 0 {
+			return fmt.Errorf("threshold #%d: window must be positive", i+1)
+		}
+		if t.MinReports <= 0 && t.MinReporters <= 0 {
+			return fmt.Errorf("threshold #%d: either minReports or minReporters must be set", i+1)
+		}
+		if t.Priority != "" {
+			if _, err := triage.ParsePriority(t.Priority); err != nil {
+				return fmt.Errorf("threshold #%d: %w", i+1, err)
+			}
+		}
+		if t.Notify && c.NotifyURL == "" {
+			return fmt.Errorf("threshold #%d: notify is set, but notifyURL is empty", i+1)
+		}
+	}
+	return nil
+}
+
+// ReputationConfig controls how statistics of reporters are used.
+type ReputationConfig struct {
+	// MinResolved is the number of resolved tickets from a reporter needed
+	// before their score is used. Defaults to 5.
+	MinResolved int `yaml:"minResolved"`
+	// DeprioritizeBelow makes reports from reporters with a lower score
+	// create tickets with low priority and not raise the priority of
+	// existing tickets, unless triage rules set a priority. Disabled if 0.
+	DeprioritizeBelow float64 `yaml:"deprioritizeBelow"`
+}
+
+// MinResolvedTickets returns MinResolved, or the default value if it's not set.
+func (c *ReputationConfig) MinResolvedTickets() int {
+	if c.MinResolved > 0 {
+		return c.MinResolved
+	}
+	return 5
+}
+
+// BrigadingConfig controls detection of coordinated reporting of an account.
+// A reporter is suspicious if any of the enabled signals is present, and
+// once there are MinReporters suspicious reporters within the Window,
+// further suspicious reports are collapsed into a single summary note.
+type BrigadingConfig struct {
+	// Window is how far back reports about the same account are looked at.
+	// Detection is disabled if 0.
+	Window       time.Duration `yaml:"window"`
+	MinReporters int           `yaml:"minReporters"`
+
+	// MaxAccountAge makes reporters with younger accounts suspicious.
+	MaxAccountAge time.Duration `yaml:"maxAccountAge"`
+	// SameReason makes reporters suspicious if the text of their report
+	// is the same as in another report within the window.
+	SameReason bool `yaml:"sameReason"`
+	// FollowGraph makes reporters suspicious if they follow or are followed
+	// by another reporter within the window.
+	FollowGraph bool `yaml:"followGraph"`
+}
+
+func (c *BrigadingConfig) validate() error {
+	if c.Window == 0 {
+		return nil
+	}
+	if c.Window < 0 || c.MaxAccountAge < 0 {
+		return fmt.Errorf("durations can't be negative")
+	}
+	if c.MinReporters < 2 {
+		return fmt.Errorf("minReporters must be at least 2")
+	}
+	if c.MaxAccountAge == 0 && !c.SameReason && !c.FollowGraph {
+		return fmt.Errorf("at least one of maxAccountAge, sameReason or followGraph must be set")
+	}
+	return nil
 }
 
 type ListConfig struct {
@@ -24,3 +153,95 @@ type ModAccountConfig struct {
 	DID      string `yaml:"did"`
 	Password string `yaml:"password"`
 }
+
+// ListFieldValues returns values of "Add to lists" ticket field for each list, keyed by list ID.
+func (c *Config) ListFieldValues() map[string]string {
+	values := map[string]string{}
+	for id, l := range c.Lists {
+		values[id] = fmt.Sprintf("%s [%s]", l.Name, id)
+	}
+	return values
+}
+
+// LabelFieldValues returns values of "Labels" ticket field for each label
+// that can be applied, keyed by label identifier.
+func (c *Config) LabelFieldValues() map[string]string {
+	values := map[string]string{}
+	for _, label := range c.LabelerPolicies.LabelValueDefinitions {
+		displayName := label.Identifier
+		if len(label.Locales) > 0 {
+			displayName = label.Locales[0].Name
+		}
+		values[label.Identifier] = fmt.Sprintf("%s [%s]", displayName, label.Identifier)
+	}
+	for _, label := range c.SkipLabels {
+		delete(values, label)
+	}
+	return values
+}
+
+// Load reads and parses the config file.
+func Load(filename string) (*Config, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %w", filename, err)
+	}
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", filename, err)
+	}
+	return c, nil
+}
+
+// Validate checks the parts of the config that can be changed at runtime.
+func (c *Config) Validate() error {
+	for id, l := range c.Lists {
+		if !strings.HasPrefix(l.URI, "at://") {
+			return fmt.Errorf("list %q: %q is not an AT URI", id, l.URI)
+		}
+		if strings.Contains(id, " ") {
+			return fmt.Errorf("list %q: ID must not contain spaces", id)
+		}
+	}
+	seen := map[string]bool{}
+	for _, label := range c.LabelerPolicies.LabelValueDefinitions {
+		if label.Identifier == "" {
+			return fmt.Errorf("label definition without an identifier")
+		}
+		if seen[label.Identifier] {
+			return fmt.Errorf("label %q is defined more than once", label.Identifier)
+		}
+		seen[label.Identifier] = true
+	}
+	for _, id := range c.RequireApproval.Lists {
+		if _, found := c.Lists[id]; !found {
+			return fmt.Errorf("requireApproval: unknown list %q", id)
+		}
+	}
+	for _, label := range c.RequireApproval.Labels {
+		if !seen[label] {
+			return fmt.Errorf("requireApproval: label %q is not defined in labelerPolicies", label)
+		}
+	}
+	for label, uri := range c.LabelsFromLists {
+		if !strings.HasPrefix(uri, "at://") {
+			return fmt.Errorf("labelsFromLists: %q for label %q is not an AT URI", uri, label)
+		}
+	}
+	if err := c.Triage.Validate(); err != nil {
+		return fmt.Errorf("triage: %w", err)
+	}
+	if err := c.Escalation.validate(); err != nil {
+		return fmt.Errorf("escalation: %w", err)
+	}
+	if err := c.Brigading.validate(); err != nil {
+		return fmt.Errorf("brigading: %w", err)
+	}
+	if c.Reputation.MinResolved < 0 {
+		return fmt.Errorf("reputation: minResolved can't be negative")
+	}
+	if c.Reputation.DeprioritizeBelow < 0 || c.Reputation.DeprioritizeBelow > 1 {
+		return fmt.Errorf("reputation: deprioritizeBelow must be between 0 and 1")
+	}
+	return nil
+}
@@ -4,12 +4,19 @@ import (
 	"context"
 	"fmt"
 	"html/template"
+	"io"
 	"mime"
+	"net/http"
 	"strings"
 
+	"github.com/ipfs/go-cid"
+	"github.com/rs/zerolog"
+
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/xrpc"
+
+	"bsky.watch/modkit/pkg/resolver"
 )
 
 var templateFuncs = template.FuncMap{
@@ -44,17 +51,31 @@ var helperTemplates = template.Must(template.New("quoteTableCell").Funcs(templat
 {{- range slice $l 1}}<br/>{{.}}{{ end -}}
 `))
 
+// Bluesky's CDN serves images re-encoded, so the content there can't be
+// verified against the blob CID. It's only used if the PDS doesn't have the blob.
+// Variable for tests.
+var cdnImageURL = "https://cdn.bsky.app/img/feed_fullsize/plain/%s/%s@jpeg"
+
+// uploadBlob fetches the blob and uploads it to Redmine. If the blob
+// can't be fetched, it returns an empty string and no error, and the caller
+// is expected to render a placeholder instead.
 func uploadBlob(ctx context.Context, client *xrpc.Client, uploader Uploader, blob *util.LexBlob, did string) (string, error) {
-	host := client.Host
-	client.Host = "https://bsky.social"
-	b, err := comatproto.SyncGetBlob(ctx, client, blob.Ref.String(), did)
-	client.Host = host
+	log := zerolog.Ctx(ctx)
+
+	mimeType := blob.MimeType
+	b, err := fetchBlobFromPDS(ctx, client, blob, did)
 	if err != nil {
-		return "", fmt.Errorf("fetching image %q: %w", blob.Ref, err)
+		log.Warn().Err(err).Msgf("Failed to fetch blob %q from the PDS of %q, trying the CDN: %s", blob.Ref, did, err)
+		b, err = fetchImageFromCDN(ctx, client, blob, did)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to fetch image %q from the CDN: %s", blob.Ref, err)
+			return "", nil
+		}
+		mimeType = "image/jpeg"
 	}
 
 	ext := ".png"
-	if exts, err := mime.ExtensionsByType(blob.MimeType); err == nil && len(exts) > 0 {
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
 		ext = exts[0]
 	}
 	filename := fmt.Sprintf("%s%s", blob.Ref, ext)
@@ -64,3 +85,58 @@ func uploadBlob(ctx context.Context, client *xrpc.Client, uploader Uploader, blo
 	}
 	return fmt.Sprintf("/attachments/download/%d/%s", attachment.Id, filename), nil
 }
+
+// fetchBlobFromPDS fetches the blob from the PDS of the account and verifies
+// that the content matches the CID.
+func fetchBlobFromPDS(ctx context.Context, client *xrpc.Client, blob *util.LexBlob, did string) ([]byte, error) {
+	pds, _, err := resolver.GetPDSEndpointAndPublicKey(ctx, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the PDS address: %w", err)
+	}
+	pdsClient := *client
+	pdsClient.Host = pds.String()
+
+	b, err := comatproto.SyncGetBlob(ctx, &pdsClient, blob.Ref.String(), did)
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob: %w", err)
+	}
+	if err := verifyBlob(cid.Cid(blob.Ref), b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func verifyBlob(expected cid.Cid, b []byte) error {
+	got, err := expected.Prefix().Sum(b)
+	if err != nil {
+		return fmt.Errorf("computing CID: %w", err)
+	}
+	if !got.Equals(expected) {
+		return fmt.Errorf("content doesn't match the CID: got %s", got)
+	}
+	return nil
+}
+
+func fetchImageFromCDN(ctx context.Context, client *xrpc.Client, blob *util.LexBlob, did string) ([]byte, error) {
+	if !strings.HasPrefix(blob.MimeType, "image/") {
+		return nil, fmt.Errorf("CDN only serves images, but blob has type %q", blob.MimeType)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(cdnImageURL, did, blob.Ref), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating a request object: %w", err)
+	}
+	httpClient := client.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,184 @@
+package format
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bsky.watch/redmine"
+	"github.com/bluesky-social/indigo/api"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/did"
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"bsky.watch/modkit/pkg/resolver"
+)
+
+const testDID = "did:plc:test"
+
+var (
+	imageBytes = []byte("original image")
+	cdnBytes   = []byte("re-encoded image")
+)
+
+type fakeUploader struct {
+	uploads map[string][]byte
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, filename string, content []byte) (*redmine.Upload, error) {
+	u.uploads[filename] = content
+	return &redmine.Upload{Id: len(u.uploads), Filename: filename}, nil
+}
+
+func blobCID(t *testing.T, b []byte) cid.Cid {
+	t.Helper()
+	c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum(b)
+	if err != nil {
+		t.Fatalf("Computing CID failed: %s", err)
+	}
+	return c
+}
+
+// fakeNetwork serves the DID document, the blob from the PDS and
+// the image from the CDN, and points the resolver and the CDN URL to itself.
+func fakeNetwork(t *testing.T, pdsBlob []byte, cdnStatus int) *xrpc.Client {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	mux.HandleFunc("GET /"+testDID, func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": testDID,
+			"verificationMethod": []map[string]any{{
+				"id":                 testDID + "#atproto",
+				"type":               "Multikey",
+				"controller":         testDID,
+				"publicKeyMultibase": "zQ3shtest",
+			}},
+			"service": []map[string]any{{
+				"id":              "#atproto_pds",
+				"type":            "AtprotoPersonalDataServer",
+				"serviceEndpoint": s.URL,
+			}},
+		})
+	})
+	mux.HandleFunc("GET /xrpc/com.atproto.sync.getBlob", func(w http.ResponseWriter, req *http.Request) {
+		if pdsBlob == nil || req.URL.Query().Get("did") != testDID {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"BlobNotFound","message":"Blob not found"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(pdsBlob)
+	})
+	mux.HandleFunc("GET /img/", func(w http.ResponseWriter, req *http.Request) {
+		if cdnStatus != http.StatusOK {
+			w.WriteHeader(cdnStatus)
+			return
+		}
+		w.Write(cdnBytes)
+	})
+
+	prevResolver := resolver.Resolver
+	r := did.NewMultiResolver()
+	r.AddHandler("plc", &api.PLCServer{Host: s.URL})
+	resolver.Resolver = r
+	prevCDN := cdnImageURL
+	cdnImageURL = s.URL + "/img/feed_fullsize/plain/%s/%s@jpeg"
+	t.Cleanup(func() {
+		resolver.Resolver = prevResolver
+		cdnImageURL = prevCDN
+	})
+
+	return &xrpc.Client{Client: s.Client(), Host: s.URL}
+}
+
+func TestVerifyBlob(t *testing.T) {
+	c := blobCID(t, imageBytes)
+	if err := verifyBlob(c, imageBytes); err != nil {
+		t.Errorf("verifyBlob failed on matching content: %s", err)
+	}
+	if err := verifyBlob(c, cdnBytes); err == nil {
+		t.Errorf("verifyBlob succeeded on mismatched content")
+	}
+}
+
+func TestUploadBlob(t *testing.T) {
+	tests := []struct {
+		name      string
+		mimeType  string
+		pdsBlob   []byte
+		cdnStatus int
+		want      []byte
+	}{
+		{"matching CID", "image/png", imageBytes, http.StatusOK, imageBytes},
+		{"mismatched CID", "image/png", []byte("tampered image"), http.StatusOK, cdnBytes},
+		{"PDS failure", "image/png", nil, http.StatusOK, cdnBytes},
+		{"PDS and CDN failure", "image/png", nil, http.StatusNotFound, nil},
+		{"not an image", "video/mp4", nil, http.StatusOK, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fakeNetwork(t, test.pdsBlob, test.cdnStatus)
+			uploader := &fakeUploader{uploads: map[string][]byte{}}
+			blob := &util.LexBlob{Ref: util.LexLink(blobCID(t, imageBytes)), MimeType: test.mimeType, Size: int64(len(imageBytes))}
+
+			href, err := uploadBlob(context.Background(), client, uploader, blob, testDID)
+			if err != nil {
+				t.Fatalf("uploadBlob failed: %s", err)
+			}
+			if test.want == nil {
+				if href != "" || len(uploader.uploads) > 0 {
+					t.Errorf("uploadBlob returned %q and uploaded %d files, want a placeholder", href, len(uploader.uploads))
+				}
+				return
+			}
+			if len(uploader.uploads) != 1 {
+				t.Fatalf("Got %d uploads, want 1", len(uploader.uploads))
+			}
+			for filename, content := range uploader.uploads {
+				if string(content) != string(test.want) {
+					t.Errorf("Uploaded %q, want %q", content, test.want)
+				}
+				if !strings.HasPrefix(filename, blob.Ref.String()) || !strings.HasSuffix(href, "/"+filename) {
+					t.Errorf("Unexpected filename %q for link %q", filename, href)
+				}
+				if string(test.want) == string(imageBytes) && !strings.HasSuffix(filename, ".png") {
+					t.Errorf("Filename %q doesn't have the extension of the original type", filename)
+				}
+			}
+		})
+	}
+}
+
+func TestPostImagePlaceholder(t *testing.T) {
+	client := fakeNetwork(t, nil, http.StatusNotFound)
+	c := blobCID(t, imageBytes)
+	post := &bsky.FeedPost{
+		Text:      "look",
+		CreatedAt: "2024-01-01T00:00:00Z",
+		Embed: &bsky.FeedPost_Embed{EmbedImages: &bsky.EmbedImages{Images: []*bsky.EmbedImages_Image{{
+			Image: &util.LexBlob{Ref: util.LexLink(c), MimeType: "image/png", Size: int64(len(imageBytes))},
+		}}}},
+	}
+	author := &bsky.ActorDefs_ProfileViewDetailed{Did: testDID, Handle: "test.example.com"}
+
+	text, err := Post(context.Background(), client, post, author, "3kaaa", &fakeUploader{uploads: map[string][]byte{}})
+	if err != nil {
+		t.Fatalf("Post failed: %s", err)
+	}
+	if want := fmt.Sprintf("_Image could not be fetched: `%s`_", c); !strings.Contains(text, want) {
+		t.Errorf("Post doesn't contain %q:\n%s", want, text)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/xrpc"
+
+	"bsky.watch/modkit/pkg/records"
 )
 
 type embed interface {
@@ -64,13 +66,17 @@ func makeLinkEmbed(ctx context.Context, client *xrpc.Client, uploader Uploader,
 }
 
 type genericRecordEmbed struct {
-	Uri  string
-	JSON template.JS
+	Uri    string
+	JSON   template.JS
+	Notice string
 }
 
 var genericRecordTemplate = template.Must(helperTemplates.New("recordEmbed").Parse(`
 | URI: ` + "`{{.Uri | quoteTableCell}}`" + ` |
 | --------- |
+{{- with .Notice }}
+| _{{. | quoteTableCell}}_ |
+{{- end }}
 
 <pre lang="json">
 {{.JSON}}
@@ -84,6 +90,25 @@ func (e *genericRecordEmbed) Render() (template.HTML, error) {
 	return template.HTML(w.String()), nil
 }
 
+// unavailableRecordEmbed is a quoted record that can't be fetched anymore.
+type unavailableRecordEmbed struct {
+	Uri    string
+	Reason string
+}
+
+var unavailableRecordTemplate = template.Must(helperTemplates.New("unavailableRecordEmbed").Parse(`
+| URI: ` + "`{{.Uri | quoteTableCell}}`" + ` |
+| --------- |
+| _Quoted content is not available: {{.Reason | quoteTableCell}}._ |`))
+
+func (e *unavailableRecordEmbed) Render() (template.HTML, error) {
+	w := bytes.NewBuffer(nil)
+	if err := unavailableRecordTemplate.Execute(w, e); err != nil {
+		return "", err
+	}
+	return template.HTML(w.String()), nil
+}
+
 type postEmbed struct {
 	data *postData
 }
@@ -106,10 +131,16 @@ func makeRecordEmbed(ctx context.Context, client *xrpc.Client, ref *comatproto.R
 		return nil, fmt.Errorf("not enough path components: %q", ref.Uri)
 	}
 
-	// TODO: figure out proper request routing to make fetching with CID work.
-	resp, err := comatproto.RepoGetRecord(ctx, client, "" /*ref.Cid*/, parts[0], u.Host, parts[1])
+	resp, err := records.Fetch(ctx, client, u.Host, parts[0], parts[1], ref.Cid)
 	if err != nil {
-		return nil, fmt.Errorf("RepoGetRecord(%q): %w", ref.Uri, err)
+		return nil, err
+	}
+	notice := ""
+	switch resp.Version {
+	case records.Deleted, records.Unavailable:
+		return &unavailableRecordEmbed{Uri: ref.Uri, Reason: resp.Reason}, nil
+	case records.Changed:
+		notice = "Quoted record was changed after it was quoted, showing the current version."
 	}
 
 	switch parts[0] {
@@ -129,6 +160,7 @@ func makeRecordEmbed(ctx context.Context, client *xrpc.Client, ref *comatproto.R
 			return nil, err
 		}
 		data.Embeds = nil
+		data.Notice = notice
 
 		return &postEmbed{data: data}, nil
 	default:
@@ -136,6 +168,6 @@ func makeRecordEmbed(ctx context.Context, client *xrpc.Client, ref *comatproto.R
 		if err != nil {
 			return nil, fmt.Errorf("marshaling record %q: %w", ref.Uri, err)
 		}
-		return &genericRecordEmbed{Uri: ref.Uri, JSON: template.JS(b)}, nil
+		return &genericRecordEmbed{Uri: ref.Uri, JSON: template.JS(b), Notice: notice}, nil
 	}
 }
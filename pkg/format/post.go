@@ -23,11 +23,18 @@ type Uploader interface {
 var postTemplate = template.Must(helperTemplates.New("post").Parse(`
 | [Post by {{template "quoteTableCell" .Author}} @ {{.Timestamp}}]({{.URL | quoteTableCell}}) |
 | ------- |
+{{- with .Notice }}
+| _{{. | quoteTableCell}}_ |
+{{- end }}
 {{- if .Post.Text }}
 | {{ range (.Post | formatText | lines) }}{{. | quoteTableCell}}<br/>{{end}} |
 {{- end }}
 {{- range .Images }}
-| ![]({{. | quoteTableCell}}) |
+{{- if .URL }}
+| ![]({{.URL | quoteTableCell}}) |
+{{- else }}
+| _Image could not be fetched: ` + "`{{.CID}}`" + `_ |
+{{- end }}
 {{- end }}
 {{- with .Post.Langs }}
 | Languages: {{ range .}}{{. | quoteTableCell}} {{end}}
@@ -46,8 +53,16 @@ type postData struct {
 	URL       string
 	Author    string
 	Timestamp time.Time
-	Images    []string
+	Images    []postImage
 	Embeds    []embed
+	// Notice is shown above the post, e.g., if it's not the quoted version.
+	Notice string
+}
+
+type postImage struct {
+	// URL is empty if the image could not be fetched.
+	URL string
+	CID string
 }
 
 func PostFromCommit(ctx context.Context, post *bsky.FeedPost, uploader Uploader) (string, error) {
@@ -78,18 +93,11 @@ func makePostData(ctx context.Context, client *xrpc.Client, post *bsky.FeedPost,
 	if post.Embed != nil {
 		switch {
 		case post.Embed.EmbedImages != nil:
-			for _, image := range post.Embed.EmbedImages.Images {
-				if image.Image == nil {
-					continue
-				}
-
-				href, err := uploadBlob(ctx, client, uploader, image.Image, author.Did)
-				if err != nil {
-					return nil, err
-				}
-
-				data.Images = append(data.Images, href)
+			images, err := makeImages(ctx, client, uploader, post.Embed.EmbedImages.Images, author.Did)
+			if err != nil {
+				return nil, err
 			}
+			data.Images = append(data.Images, images...)
 		case post.Embed.EmbedExternal != nil && post.Embed.EmbedExternal.External != nil:
 			embed, err := makeLinkEmbed(ctx, client, uploader, post.Embed.EmbedExternal.External, author.Did)
 			if err != nil {
@@ -114,18 +122,11 @@ func makePostData(ctx context.Context, client *xrpc.Client, post *bsky.FeedPost,
 				media := post.Embed.EmbedRecordWithMedia.Media
 				switch {
 				case media.EmbedImages != nil:
-					for _, image := range media.EmbedImages.Images {
-						if image.Image == nil {
-							continue
-						}
-
-						href, err := uploadBlob(ctx, client, uploader, image.Image, author.Did)
-						if err != nil {
-							return nil, err
-						}
-
-						data.Images = append(data.Images, href)
+					images, err := makeImages(ctx, client, uploader, media.EmbedImages.Images, author.Did)
+					if err != nil {
+						return nil, err
 					}
+					data.Images = append(data.Images, images...)
 				case media.EmbedExternal != nil:
 					embed, err := makeLinkEmbed(ctx, client, uploader, media.EmbedExternal.External, author.Did)
 					if err != nil {
@@ -139,6 +140,23 @@ func makePostData(ctx context.Context, client *xrpc.Client, post *bsky.FeedPost,
 	return data, nil
 }
 
+func makeImages(ctx context.Context, client *xrpc.Client, uploader Uploader, images []*bsky.EmbedImages_Image, did string) ([]postImage, error) {
+	r := []postImage{}
+	for _, image := range images {
+		if image.Image == nil {
+			continue
+		}
+
+		href, err := uploadBlob(ctx, client, uploader, image.Image, did)
+		if err != nil {
+			return nil, err
+		}
+
+		r = append(r, postImage{URL: href, CID: image.Image.Ref.String()})
+	}
+	return r, nil
+}
+
 func Post(ctx context.Context, client *xrpc.Client, post *bsky.FeedPost, author *bsky.ActorDefs_ProfileViewDetailed, rkey string, uploader Uploader) (string, error) {
 	c := xrpcauth.NewAnonymousClient(ctx)
 	c.Host = "https://api.bsky.app"
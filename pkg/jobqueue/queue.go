@@ -0,0 +1,145 @@
+// Package jobqueue implements a durable queue of jobs on top of a valkey stream.
+//
+// Jobs are delivered at least once: a job stays pending until it's marked
+// as done, and jobs left pending by a crashed consumer are re-delivered
+// to other consumers in the same group.
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/modkit/pkg/streamgroup"
+)
+
+// ErrNotPending is returned by Retry when the job is no longer pending.
+var ErrNotPending = streamgroup.ErrNotPending
+
+type Job struct {
+	// ID of the stream entry.
+	ID   string
+	Type string
+	// Key identifies the job for deduplication purposes.
+	Key     string
+	Payload json.RawMessage
+	// Attempts is the number of times this job was delivered, including the current one.
+	Attempts int64
+}
+
+type Queue struct {
+	client valkey.Client
+	name   string
+	stream *streamgroup.Consumer
+}
+
+// New creates a queue that reads and writes jobs to the given stream.
+// consumer must be unique among all processes reading from the same
+// stream and group. Jobs that were not marked as done by some other consumer
+// for longer than claimAfter will be re-delivered to this one.
+func New(ctx context.Context, client valkey.Client, stream string, group string, consumer string, claimAfter time.Duration) (*Queue, error) {
+	c, err := streamgroup.New(ctx, client, stream, group, consumer, claimAfter)
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{client: client, name: stream, stream: c}, nil
+}
+
+func (q *Queue) dedupeKey(key string) string {
+	return fmt.Sprintf("%s:dedupe:%s", q.name, key)
+}
+
+// Add puts a new job into the queue. If a job with the same non-empty key
+// was added within dedupeWindow, the new one is dropped and false is returned.
+func (q *Queue) Add(ctx context.Context, jobType string, key string, payload any, dedupeWindow time.Duration) (bool, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return false, fmt.Errorf("marshaling payload: %w", err)
+	}
+
+	if key != "" && dedupeWindow > 0 {
+		err := q.client.Do(ctx, q.client.B().
+			Set().
+			Key(q.dedupeKey(key)).
+			Value(fmt.Sprint(time.Now().Unix())).
+			Nx().
+			Ex(dedupeWindow).
+			Build()).Error()
+		if valkey.IsValkeyNil(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("SET NX: %w", err)
+		}
+	}
+
+	err = q.client.Do(ctx, q.client.B().Xadd().Key(q.name).Id("*").
+		FieldValue().
+		FieldValue("type", jobType).
+		FieldValue("key", key).
+		FieldValue("payload", string(b)).
+		Build()).Error()
+	if err != nil {
+		if key != "" && dedupeWindow > 0 {
+			// Allow the caller to try again.
+			q.client.Do(ctx, q.client.B().Del().Key(q.dedupeKey(key)).Build())
+		}
+		return false, fmt.Errorf("XADD: %w", err)
+	}
+	return true, nil
+}
+
+// Next returns the next job to execute, blocking until one is available.
+// Jobs that were left pending by a previous run of the same consumer are
+// returned first, then jobs abandoned by other consumers, then new ones.
+//
+// Next is not safe for concurrent use.
+func (q *Queue) Next(ctx context.Context) (*Job, error) {
+	e, err := q.stream.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return q.parse(ctx, e)
+}
+
+// Retry fetches a pending job again, incrementing its attempt counter.
+func (q *Queue) Retry(ctx context.Context, id string) (*Job, error) {
+	e, err := q.stream.Redeliver(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return q.parse(ctx, e)
+}
+
+// Touch resets the idle time of pending jobs without incrementing their
+// attempt counters, so that jobs waiting for their turn in this consumer
+// are not claimed by other ones.
+func (q *Queue) Touch(ctx context.Context, ids ...string) error {
+	return q.stream.Touch(ctx, ids...)
+}
+
+// Done removes the job from the queue.
+func (q *Queue) Done(ctx context.Context, id string) error {
+	if err := q.stream.Ack(ctx, id); err != nil {
+		return err
+	}
+	return q.client.Do(ctx, q.client.B().Xdel().Key(q.name).Id(id).Build()).Error()
+}
+
+func (q *Queue) parse(ctx context.Context, e valkey.XRangeEntry) (*Job, error) {
+	job := &Job{
+		ID:      e.ID,
+		Type:    e.FieldValues["type"],
+		Key:     e.FieldValues["key"],
+		Payload: json.RawMessage(e.FieldValues["payload"]),
+	}
+	n, err := q.stream.Attempts(ctx, e.ID)
+	if err != nil {
+		return job, fmt.Errorf("getting attempt count for %q: %w", e.ID, err)
+	}
+	job.Attempts = n
+	return job, nil
+}
@@ -0,0 +1,172 @@
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/valkey-io/valkey-go"
+)
+
+const claimAfter = time.Minute
+
+func newTestQueue(t *testing.T, addr string, consumer string) *Queue {
+	t.Helper()
+	client, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{addr},
+		DisableCache: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create valkey client: %s", err)
+	}
+	t.Cleanup(client.Close)
+
+	q, err := New(context.Background(), client, "jobs", "workers", consumer, claimAfter)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	return q
+}
+
+func add(t *testing.T, q *Queue, key string, dedupeWindow time.Duration) bool {
+	t.Helper()
+	added, err := q.Add(context.Background(), "test", key, map[string]string{"key": key}, dedupeWindow)
+	if err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	return added
+}
+
+func next(t *testing.T, q *Queue) *Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	job, err := q.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next failed: %s", err)
+	}
+	return job
+}
+
+func TestDedupe(t *testing.T) {
+	m := miniredis.RunT(t)
+	q := newTestQueue(t, m.Addr(), "a")
+
+	if !add(t, q, "k", time.Minute) {
+		t.Errorf("First job was dropped")
+	}
+	if add(t, q, "k", time.Minute) {
+		t.Errorf("Duplicate job was added")
+	}
+	if !add(t, q, "other", time.Minute) {
+		t.Errorf("Job with a different key was dropped")
+	}
+	if !add(t, q, "", time.Minute) || !add(t, q, "", time.Minute) {
+		t.Errorf("Job without a key was dropped")
+	}
+	m.FastForward(2 * time.Minute)
+	if !add(t, q, "k", time.Minute) {
+		t.Errorf("Job was dropped after the dedupe window")
+	}
+
+	if entries, _ := m.Stream("jobs"); len(entries) != 5 {
+		t.Errorf("Got %d jobs in the stream, want 5", len(entries))
+	}
+}
+
+func TestRetryAndDone(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	q := newTestQueue(t, m.Addr(), "a")
+	add(t, q, "k", 0)
+
+	job := next(t, q)
+	if job.Key != "k" || job.Type != "test" || string(job.Payload) != `{"key":"k"}` || job.Attempts != 1 {
+		t.Fatalf("Unexpected job %+v", job)
+	}
+
+	retried, err := q.Retry(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Retry failed: %s", err)
+	}
+	if retried.ID != job.ID || retried.Attempts != 2 {
+		t.Errorf("Retry returned %+v, want attempt 2 of %q", retried, job.ID)
+	}
+
+	// After a restart, the pending job is returned again.
+	restarted := newTestQueue(t, m.Addr(), "a")
+	if job := next(t, restarted); job.ID != retried.ID {
+		t.Errorf("Got job %q after restart, want %q", job.ID, retried.ID)
+	}
+
+	if err := q.Done(ctx, job.ID); err != nil {
+		t.Fatalf("Done failed: %s", err)
+	}
+	if _, err := q.Retry(ctx, job.ID); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Retry after Done returned %v, want %v", err, ErrNotPending)
+	}
+	if entries, _ := m.Stream("jobs"); len(entries) != 0 {
+		t.Errorf("Got %d jobs in the stream after Done, want 0", len(entries))
+	}
+}
+
+func TestClaimAfterTimeout(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	now := time.Now()
+	m.SetTime(now)
+
+	a := newTestQueue(t, m.Addr(), "a")
+	b := newTestQueue(t, m.Addr(), "b")
+	add(t, a, "first", 0)
+	add(t, a, "second", 0)
+
+	touched := next(t, a)
+	abandoned := next(t, a)
+
+	m.SetTime(now.Add(claimAfter / 2))
+	if err := a.Touch(ctx, touched.ID); err != nil {
+		t.Fatalf("Touch failed: %s", err)
+	}
+
+	// Only the job that wasn't touched is claimed.
+	m.SetTime(now.Add(claimAfter + time.Second))
+	job := next(t, b)
+	if job.ID != abandoned.ID || job.Attempts != 2 {
+		t.Errorf("Claimed %+v, want attempt 2 of %q", job, abandoned.ID)
+	}
+}
+
+func TestClaimWithShortTimeout(t *testing.T) {
+	m := miniredis.RunT(t)
+	now := time.Now()
+	m.SetTime(now)
+
+	a := newTestQueue(t, m.Addr(), "a")
+	add(t, a, "k", 0)
+	abandoned := next(t, a)
+
+	client, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{m.Addr()},
+		DisableCache: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create valkey client: %s", err)
+	}
+	t.Cleanup(client.Close)
+	b, err := New(context.Background(), client, "jobs", "workers", "b", time.Millisecond)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+
+	// The job becomes abandoned only after b is already waiting for new ones.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		m.SetTime(now.Add(time.Second))
+	}()
+	if job := next(t, b); job.ID != abandoned.ID {
+		t.Errorf("Claimed %q, want %q", job.ID, abandoned.ID)
+	}
+}
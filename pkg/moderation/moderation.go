@@ -0,0 +1,98 @@
+// Package moderation looks up the moderation actions that are currently in
+// effect for a subject: labels applied by our labeler and memberships in
+// the lists from the config.
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"bsky.watch/utils/listserver"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"bsky.watch/modkit/pkg/config"
+)
+
+// QueryLabels returns the labels applied by the labeler to the given subjects.
+func QueryLabels(ctx context.Context, client *xrpc.Client, labelerURL string, subjects ...string) ([]*atproto.LabelDefs_Label, error) {
+	if labelerURL == "" {
+		return nil, fmt.Errorf("labeler address is not specified")
+	}
+	labelerClient := *client
+	labelerClient.Host = labelerURL
+
+	// XXX: our labeler implementation doesn't do pagination and returns
+	// everything in one go, so we don't need to do multiple requests.
+	resp, err := atproto.LabelQueryLabels(ctx, &labelerClient, "", 250, nil, subjects)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query existing labels: %w", err)
+	}
+	return resp.Labels, nil
+}
+
+// LabelValues returns unique values of the labels, skipping negations.
+func LabelValues(labels []*atproto.LabelDefs_Label) []string {
+	r := []string{}
+	for _, l := range labels {
+		if l.Neg != nil && *l.Neg {
+			continue
+		}
+		if !slices.Contains(r, l.Val) {
+			r = append(r, l.Val)
+		}
+	}
+	return r
+}
+
+// ListMemberships returns the list items for the account from listserver.
+func ListMemberships(ctx context.Context, listServerURL string, did string) (*listserver.Response, error) {
+	if listServerURL == "" {
+		return nil, fmt.Errorf("listserver address is not specified")
+	}
+	u, err := url.Parse(listServerURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q as a URL: %w", listServerURL, err)
+	}
+	query := u.Query()
+	query.Set("subject", did)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating a request object: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	r := &listserver.Response{}
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	return r, nil
+}
+
+// ConfigLists returns sorted IDs of the lists from the config that
+// the account is a member of.
+func ConfigLists(cfg *config.Config, memberships *listserver.Response) []string {
+	r := []string{}
+	for id, list := range cfg.Lists {
+		for _, m := range memberships.Results {
+			for _, uri := range m.Listitems {
+				if uri == list.URI && !slices.Contains(r, id) {
+					r = append(r, id)
+				}
+			}
+		}
+	}
+	slices.Sort(r)
+	return r
+}
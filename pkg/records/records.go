@@ -0,0 +1,288 @@
+// Package records fetches reported records and checks whether they are
+// still the same version that the reporter saw.
+//
+// Reports about records carry a strong ref with the CID of the reported
+// version. PDSes only serve the current version of a record, so if the
+// record was edited or deleted after the report, the reported content can't
+// be fetched anymore and moderators need to be told about it.
+//
+// The CID of the current version is computed from the record block
+// returned by com.atproto.sync.getRecord, instead of trusting the CID
+// that the PDS puts into com.atproto.repo.getRecord response.
+package records
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/imax9000/errors"
+	"github.com/rs/zerolog"
+
+	"bsky.watch/modkit/pkg/resolver"
+)
+
+// UnavailableMarker starts the notes about content that can't be fetched.
+const UnavailableMarker = "**Content unavailable**"
+
+// Version describes how the fetched record relates to the reported one.
+type Version int
+
+const (
+	// Reported means that the fetched record is the reported version, or
+	// that the report didn't specify a version.
+	Reported Version = iota
+	// Changed means that the record was modified after the report and
+	// the current version was fetched instead.
+	Changed
+	// Deleted means that the record doesn't exist anymore.
+	Deleted
+	// Unavailable means that the record can't be fetched anymore for other
+	// reasons, e.g., the account was deactivated or taken down.
+	Unavailable
+)
+
+// Record is a fetched record along with the information about its version.
+type Record struct {
+	URI string
+	// Value is nil if the record was deleted.
+	Value *util.LexiconTypeDecoder
+	// CID of the fetched version.
+	CID string
+	// ReportedCID is the CID from the report, if any.
+	ReportedCID string
+	// Verified is true if CID was computed from the content of the record.
+	// Otherwise it's the one reported by the PDS.
+	Verified bool
+	Version  Version
+	// Reason explains why the record is unavailable.
+	Reason string
+	// EarlierReport refers to the ticket with the most recent earlier report
+	// about the record, which might have a snapshot of its content.
+	// Filled in by the caller.
+	EarlierReport string
+}
+
+// NewUnavailable returns a record that can't be fetched for the given reason.
+func NewUnavailable(uri string, cid string, reason string) *Record {
+	return &Record{URI: uri, ReportedCID: cid, Version: Unavailable, Reason: reason}
+}
+
+// Fetch fetches the record, trying the reported version first if cid is
+// not empty. client needs to point to the PDS of the repo or to a service
+// that proxies requests to it.
+func Fetch(ctx context.Context, client *xrpc.Client, repo string, collection string, rkey string, cid string) (*Record, error) {
+	r := &Record{
+		URI:         fmt.Sprintf("at://%s/%s/%s", repo, collection, rkey),
+		ReportedCID: cid,
+	}
+
+	if cid != "" {
+		value, current, err := fetchVerified(ctx, client, repo, collection, rkey)
+		if err == nil {
+			r.Value = value
+			r.CID = current
+			r.Verified = true
+			if current != cid {
+				r.Version = Changed
+			}
+			return r, nil
+		}
+		// Fall back to getRecord, which also tells if the record was
+		// deleted or the account is unavailable.
+		zerolog.Ctx(ctx).Debug().Err(err).Msgf("Failed to fetch %q with com.atproto.sync.getRecord: %s", r.URI, err)
+
+		// Some PDSes ignore the cid parameter and return the current
+		// version, so the CID of the result still needs to be checked.
+		resp, err := getRecord(ctx, client, repo, collection, rkey, cid)
+		if err == nil && resp.Cid != nil && *resp.Cid == cid {
+			r.Value = resp.Value
+			r.CID = cid
+			return r, nil
+		}
+	}
+
+	resp, err := getRecord(ctx, client, repo, collection, rkey, "")
+	switch {
+	case IsNotFound(err):
+		r.Version = Deleted
+		r.Reason = UnavailableReason(err)
+		return r, nil
+	case UnavailableReason(err) != "":
+		r.Version = Unavailable
+		r.Reason = UnavailableReason(err)
+		return r, nil
+	case err != nil:
+		return nil, fmt.Errorf("fetching %q: %w", r.URI, err)
+	}
+
+	r.Value = resp.Value
+	if resp.Cid != nil {
+		r.CID = *resp.Cid
+	}
+	if cid != "" && r.CID != cid {
+		r.Version = Changed
+	}
+	return r, nil
+}
+
+// fetchVerified fetches the current version of the record along with
+// the proof of its inclusion in the repo, and returns its value and the CID
+// computed from its content.
+func fetchVerified(ctx context.Context, client *xrpc.Client, did string, collection string, rkey string) (*util.LexiconTypeDecoder, string, error) {
+	// Generated atproto.SyncGetRecord always sends the deprecated commit
+	// parameter, even if it's empty.
+	params := map[string]interface{}{
+		"collection": collection,
+		"did":        did,
+		"rkey":       rkey,
+	}
+	buf := new(bytes.Buffer)
+	if err := client.Do(ctx, xrpc.Query, "", "com.atproto.sync.getRecord", params, nil, buf); err != nil {
+		return nil, "", err
+	}
+
+	r, err := repo.ReadRepoFromCar(ctx, buf)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading CAR file: %w", err)
+	}
+	expected, b, err := r.GetRecordBytes(ctx, collection+"/"+rkey)
+	if err != nil {
+		return nil, "", err
+	}
+	got, err := expected.Prefix().Sum(*b)
+	if err != nil {
+		return nil, "", fmt.Errorf("computing CID: %w", err)
+	}
+	if !got.Equals(expected) {
+		return nil, "", fmt.Errorf("record content doesn't match the CID %s: got %s", expected, got)
+	}
+	value, err := util.CborDecodeValue(*b)
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding record: %w", err)
+	}
+	return &util.LexiconTypeDecoder{Val: value}, got.String(), nil
+}
+
+func getRecord(ctx context.Context, client *xrpc.Client, repo string, collection string, rkey string, cid string) (*atproto.RepoGetRecord_Output, error) {
+	// Generated atproto.RepoGetRecord always sends cid parameter, even if
+	// it's empty, which confuses some PDS implementations (e.g., atproto.brid.gy).
+	params := map[string]interface{}{
+		"collection": collection,
+		"repo":       repo,
+		"rkey":       rkey,
+	}
+	if cid != "" {
+		params["cid"] = cid
+	}
+	var out atproto.RepoGetRecord_Output
+	if err := client.Do(ctx, xrpc.Query, "", "com.atproto.repo.getRecord", params, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// IsNotFound returns true if the error indicates that the record doesn't exist.
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	xrpcErr, ok := errors.As[*xrpc.XRPCError](err)
+	if !ok {
+		return false
+	}
+	return xrpcErr.ErrStr == "RecordNotFound" || strings.HasPrefix(xrpcErr.Message, "Could not locate record")
+}
+
+// UnavailableReason returns the description of why the content is
+// permanently unavailable, or an empty string if the error might be
+// transient and the request is worth retrying.
+func UnavailableReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	if IsNotFound(err) {
+		return "the record was deleted"
+	}
+	if xrpcErr, ok := errors.As[*xrpc.XRPCError](err); ok {
+		switch {
+		case xrpcErr.ErrStr == "RepoDeactivated" || xrpcErr.ErrStr == "AccountDeactivated":
+			return "the account is deactivated"
+		case xrpcErr.ErrStr == "RepoTakendown" || xrpcErr.ErrStr == "AccountTakedown" ||
+			xrpcErr.ErrStr == "RepoSuspended":
+			return "the account was taken down"
+		case xrpcErr.ErrStr == "RepoNotFound" || strings.HasPrefix(xrpcErr.Message, "Could not find repo"):
+			return "the account doesn't exist"
+		}
+		return ""
+	}
+	if _, ok := errors.As[*resolver.NotFoundError](err); ok {
+		return "the account's DID is tombstoned or not registered"
+	}
+	return ""
+}
+
+// IsProfileNotFound returns true if the error is the AppView's response for
+// an account that it doesn't know about. This alone doesn't mean that
+// the account is gone, since the AppView might not have indexed it (yet).
+func IsProfileNotFound(err error) bool {
+	xrpcErr, ok := errors.As[*xrpc.XRPCError](err)
+	return ok && xrpcErr.Message == "Profile not found"
+}
+
+// RepoStatusReason returns the description of why the repo is not hosted,
+// given the status from com.atproto.sync.getRepoStatus response.
+func RepoStatusReason(status *string) string {
+	switch {
+	case status == nil:
+		return "the account is not hosted by its PDS"
+	case *status == "deactivated":
+		return "the account is deactivated"
+	case *status == "takendown" || *status == "suspended":
+		return "the account was taken down"
+	}
+	return fmt.Sprintf("the account is %s", *status)
+}
+
+// EarlierReportNotice returns the sentence pointing to the ticket with
+// an earlier report about the unavailable content.
+func EarlierReportNotice(ticket string) string {
+	return fmt.Sprintf(" It was reported earlier on %s, which might have a snapshot of it.", ticket)
+}
+
+// Notice returns the text explaining to moderators that the record is not
+// the version that was reported, or an empty string if it is.
+func (r *Record) Notice() string {
+	s := ""
+	switch r.Version {
+	case Changed:
+		current := fmt.Sprintf("the current one is `%s`", r.CID)
+		if !r.Verified {
+			current = fmt.Sprintf("the PDS reports the current one as `%s`", r.CID)
+		}
+		return fmt.Sprintf("**Record changed after the report**: the reported version was `%s`, but %s. "+
+			"The content shown on the ticket is the current version, not what the reporter saw.", r.ReportedCID, current)
+	case Deleted, Unavailable:
+		s = fmt.Sprintf("%s: the reported record `%s` can't be fetched because %s.", UnavailableMarker, r.URI, r.Reason)
+	case Reported:
+		if r.ReportedCID == "" || r.Verified {
+			return ""
+		}
+		return fmt.Sprintf("_The content shown on the ticket is the reported version `%s` according to the PDS, "+
+			"but this couldn't be verified._", r.ReportedCID)
+	default:
+		return ""
+	}
+	if r.ReportedCID != "" {
+		s += fmt.Sprintf(" Reported version: `%s`.", r.ReportedCID)
+	}
+	if r.EarlierReport != "" {
+		s += EarlierReportNotice(r.EarlierReport)
+	}
+	return s
+}
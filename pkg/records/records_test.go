@@ -0,0 +1,253 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+
+	"bsky.watch/modkit/pkg/resolver"
+)
+
+const (
+	reportedCID = "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"
+	currentCID  = "bafyreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+)
+
+// fakePDS serves a single post with the given CID. If honorCID is false,
+// the cid parameter is ignored.
+func fakePDS(t *testing.T, cid string, honorCID bool) *xrpc.Client {
+	t.Helper()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		q := req.URL.Query()
+		if cid == "" || (honorCID && q.Get("cid") != "" && q.Get("cid") != cid) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "RecordNotFound", "message": "Could not locate record"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"uri": "at://did:plc:test/app.bsky.feed.post/3kaaa",
+			"cid": cid,
+			"value": map[string]any{
+				"$type":     "app.bsky.feed.post",
+				"text":      "hello",
+				"createdAt": "2024-01-01T00:00:00Z",
+			},
+		})
+	}))
+	t.Cleanup(s.Close)
+	return &xrpc.Client{Client: s.Client(), Host: s.URL}
+}
+
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		name        string
+		currentCID  string
+		honorCID    bool
+		reportedCID string
+		want        Version
+	}{
+		{"reported", reportedCID, true, reportedCID, Reported},
+		{"no CID in the report", currentCID, true, "", Reported},
+		{"changed", currentCID, true, reportedCID, Changed},
+		{"changed, cid ignored", currentCID, false, reportedCID, Changed},
+		{"deleted", "", true, reportedCID, Deleted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fakePDS(t, test.currentCID, test.honorCID)
+			r, err := Fetch(context.Background(), client, "did:plc:test", "app.bsky.feed.post", "3kaaa", test.reportedCID)
+			if err != nil {
+				t.Fatalf("Fetch failed: %s", err)
+			}
+			if r.Version != test.want {
+				t.Errorf("Version is %v, want %v", r.Version, test.want)
+			}
+			if (r.Value == nil) != (test.want == Deleted) {
+				t.Errorf("Value is %v for version %v", r.Value, r.Version)
+			}
+			// Without com.atproto.sync.getRecord the version can't be verified.
+			if r.Verified {
+				t.Errorf("Version %v is verified", r.Version)
+			}
+			if (r.Notice() == "") != (test.want == Reported && test.reportedCID == "") {
+				t.Errorf("Unexpected notice for version %v: %q", r.Version, r.Notice())
+			}
+		})
+	}
+}
+
+// buildCAR returns a CAR file with a repo containing a single post, as
+// served by com.atproto.sync.getRecord, and the CID of the post. If tamper
+// is true, the content of the post doesn't match its CID.
+func buildCAR(t *testing.T, text string, tamper bool) ([]byte, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	r := repo.NewRepo(ctx, "did:plc:test", bs)
+	postCID, err := r.PutRecord(ctx, "app.bsky.feed.post/3kaaa", &bsky.FeedPost{Text: text, CreatedAt: "2024-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatalf("PutRecord failed: %s", err)
+	}
+	root, _, err := r.Commit(ctx, func(context.Context, string, []byte) ([]byte, error) { return []byte("signature"), nil })
+	if err != nil {
+		t.Fatalf("Commit failed: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, buf); err != nil {
+		t.Fatalf("WriteHeader failed: %s", err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatalf("AllKeysChan failed: %s", err)
+	}
+	for key := range keys {
+		// Blockstore keys only keep the multihash, and all blocks in the repo are DAG-CBOR.
+		c := cid.NewCidV1(cid.DagCBOR, key.Hash())
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %s", c, err)
+		}
+		data := blk.RawData()
+		if tamper && c.Equals(postCID) {
+			other := new(bytes.Buffer)
+			if err := (&bsky.FeedPost{Text: "something else", CreatedAt: "2024-01-01T00:00:00Z"}).MarshalCBOR(other); err != nil {
+				t.Fatalf("MarshalCBOR failed: %s", err)
+			}
+			data = other.Bytes()
+		}
+		if err := carutil.LdWrite(buf, c.Bytes(), data); err != nil {
+			t.Fatalf("LdWrite failed: %s", err)
+		}
+	}
+	return buf.Bytes(), postCID.String()
+}
+
+// fakeSyncPDS serves the post from the CAR file, and claims that its CID
+// is pdsCID in com.atproto.repo.getRecord responses.
+func fakeSyncPDS(t *testing.T, carFile []byte, pdsCID string) *xrpc.Client {
+	t.Helper()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/xrpc/com.atproto.sync.getRecord" {
+			w.Header().Set("Content-Type", "application/vnd.ipld.car")
+			w.Write(carFile)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"uri": "at://did:plc:test/app.bsky.feed.post/3kaaa",
+			"cid": pdsCID,
+			"value": map[string]any{
+				"$type":     "app.bsky.feed.post",
+				"text":      "hello",
+				"createdAt": "2024-01-01T00:00:00Z",
+			},
+		})
+	}))
+	t.Cleanup(s.Close)
+	return &xrpc.Client{Client: s.Client(), Host: s.URL}
+}
+
+func TestFetchVerified(t *testing.T) {
+	carFile, postCID := buildCAR(t, "hello", false)
+	tampered, _ := buildCAR(t, "hello", true)
+
+	tests := []struct {
+		name        string
+		car         []byte
+		reportedCID string
+		want        Version
+		verified    bool
+		notice      string
+	}{
+		{"reported", carFile, postCID, Reported, true, ""},
+		{"changed", carFile, reportedCID, Changed, true, "the current one is `" + postCID + "`"},
+		{"content doesn't match the CID", tampered, postCID, Reported, false, "couldn't be verified"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fakeSyncPDS(t, test.car, postCID)
+			r, err := Fetch(context.Background(), client, "did:plc:test", "app.bsky.feed.post", "3kaaa", test.reportedCID)
+			if err != nil {
+				t.Fatalf("Fetch failed: %s", err)
+			}
+			if r.Version != test.want || r.Verified != test.verified {
+				t.Errorf("Version is %v (verified: %v), want %v (verified: %v)", r.Version, r.Verified, test.want, test.verified)
+			}
+			if r.CID != postCID {
+				t.Errorf("CID is %q, want %q", r.CID, postCID)
+			}
+			if post, ok := r.Value.Val.(*bsky.FeedPost); !ok || post.Text != "hello" {
+				t.Errorf("Unexpected value %+v", r.Value.Val)
+			}
+			if notice := r.Notice(); (test.notice == "") != (notice == "") || !strings.Contains(notice, test.notice) {
+				t.Errorf("Notice is %q, want it to contain %q", notice, test.notice)
+			}
+		})
+	}
+}
+
+func TestFetchError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	_, err := Fetch(context.Background(), &xrpc.Client{Client: s.Client(), Host: s.URL}, "did:plc:test", "app.bsky.feed.post", "3kaaa", reportedCID)
+	if err == nil {
+		t.Errorf("Fetch succeeded, want an error")
+	}
+}
+
+func TestUnavailableReason(t *testing.T) {
+	xrpcErr := func(name string, message string) error {
+		return fmt.Errorf("fetching: %w", &xrpc.Error{StatusCode: 400, Wrapped: &xrpc.XRPCError{ErrStr: name, Message: message}})
+	}
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"record not found", xrpcErr("RecordNotFound", "Could not locate record"), true},
+		{"repo deactivated", xrpcErr("RepoDeactivated", "Repo has been deactivated"), true},
+		{"account taken down", xrpcErr("AccountTakedown", "Account has been suspended"), true},
+		{"profile not found", xrpcErr("InvalidRequest", "Profile not found"), false},
+		{"repo not found", xrpcErr("RepoNotFound", "Could not find repo: did:plc:test"), true},
+		{"tombstoned DID", fmt.Errorf("resolving did %q: %w", "did:plc:test",
+			&resolver.NotFoundError{DID: "did:plc:test", Err: fmt.Errorf("get did request failed (code 410): 410 Gone")}), true},
+		{"rate limited", xrpcErr("RateLimitExceeded", "Rate Limit Exceeded"), false},
+		{"PLC unavailable", fmt.Errorf("resolving did %q: %w", "did:plc:test", errors.Join(
+			fmt.Errorf("get did request failed (code 410): 410 Gone"),
+			fmt.Errorf("get did request failed (code 503): 503 Service Unavailable"))), false},
+		{"network error", fmt.Errorf("connection refused"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := UnavailableReason(test.err) != ""; got != test.permanent {
+				t.Errorf("UnavailableReason(%q) = %q, want permanent=%v", test.err, UnavailableReason(test.err), test.permanent)
+			}
+		})
+	}
+}
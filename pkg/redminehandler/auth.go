@@ -0,0 +1,202 @@
+package redminehandler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+const (
+	signatureHeader = "X-Modkit-Signature"
+	timestampHeader = "X-Modkit-Timestamp"
+
+	// Maximum difference between the timestamp in the request and the current time.
+	maxTimestampSkew = 5 * time.Minute
+
+	seenSignaturesPrefix = "modkit:redmine-handler:webhook-signature:"
+)
+
+// webhookAuthError is returned when the request fails authentication.
+// reason is used as a metric label, so it must have a small set of possible values.
+type webhookAuthError struct {
+	reason string
+	msg    string
+}
+
+func (e *webhookAuthError) Error() string {
+	return e.msg
+}
+
+// webhookAuth verifies that webhook requests come from our Redmine instance.
+//
+// Requests are signed with HMAC-SHA256 over "<timestamp>.<body>", where timestamp
+// is the number of seconds since Unix epoch, sent in X-Modkit-Timestamp header.
+// Signature is sent in X-Modkit-Signature header as "sha256=<hex digest>".
+// Requests with timestamps too far from the current time are rejected, and
+// signatures of recently accepted requests are remembered to reject replays
+// within the allowed time window.
+type webhookAuth struct {
+	secret     []byte
+	allowedIPs []netip.Prefix
+	// skipSignature is true if unsigned requests are accepted because
+	// there's no secret.
+	skipSignature bool
+
+	// client stores seen signatures, so that replays are rejected across
+	// restarts and by all instances. If nil, they are only kept in memory.
+	client valkey.Client
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// newWebhookAuth returns an error if secret is empty, unless skipSignature
+// is true. client can be nil.
+func newWebhookAuth(secret string, skipSignature bool, allowedIPs []string, client valkey.Client) (*webhookAuth, error) {
+	if secret == "" && !skipSignature {
+		return nil, fmt.Errorf("webhook secret is not set")
+	}
+	a := &webhookAuth{
+		secret:        []byte(secret),
+		skipSignature: secret == "",
+		client:        client,
+		seen:          map[string]time.Time{},
+	}
+	for _, s := range allowedIPs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q as IP address: %w", s, err)
+			}
+			a.allowedIPs = append(a.allowedIPs, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %q as CIDR: %w", s, err)
+		}
+		a.allowedIPs = append(a.allowedIPs, prefix.Masked())
+	}
+	return a, nil
+}
+
+// checkSource verifies that the request came from one of the allowed addresses.
+func (a *webhookAuth) checkSource(req *http.Request) error {
+	if len(a.allowedIPs) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return &webhookAuthError{reason: "ip_not_allowed", msg: fmt.Sprintf("failed to parse remote address %q: %s", req.RemoteAddr, err)}
+	}
+	addr = addr.Unmap()
+	for _, prefix := range a.allowedIPs {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return &webhookAuthError{reason: "ip_not_allowed", msg: fmt.Sprintf("address %s is not allowed", addr)}
+}
+
+// checkSignature verifies the request signature. Must be called only once per request.
+func (a *webhookAuth) checkSignature(ctx context.Context, req *http.Request, body []byte, now time.Time) error {
+	if len(a.secret) == 0 {
+		if a.skipSignature {
+			return nil
+		}
+		return &webhookAuthError{reason: "no_secret", msg: "webhook secret is not set"}
+	}
+
+	ts := req.Header.Get(timestampHeader)
+	sig := req.Header.Get(signatureHeader)
+	if ts == "" || sig == "" {
+		return &webhookAuthError{reason: "missing_signature", msg: "missing signature or timestamp"}
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return &webhookAuthError{reason: "bad_timestamp", msg: fmt.Sprintf("failed to parse timestamp %q: %s", ts, err)}
+	}
+	t := time.Unix(sec, 0)
+	if t.Before(now.Add(-maxTimestampSkew)) || t.After(now.Add(maxTimestampSkew)) {
+		return &webhookAuthError{reason: "stale_timestamp", msg: fmt.Sprintf("timestamp %s is too far from the current time", t.Format(time.RFC3339))}
+	}
+
+	digest, found := strings.CutPrefix(sig, "sha256=")
+	if !found {
+		return &webhookAuthError{reason: "bad_signature", msg: "unsupported signature format"}
+	}
+	got, err := hex.DecodeString(digest)
+	if err != nil {
+		return &webhookAuthError{reason: "bad_signature", msg: fmt.Sprintf("failed to decode signature: %s", err)}
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return &webhookAuthError{reason: "bad_signature", msg: "signature mismatch"}
+	}
+
+	firstSeen, err := a.markSeen(ctx, hex.EncodeToString(got), t.Add(maxTimestampSkew), now)
+	if err != nil {
+		return &webhookAuthError{reason: "replay_check_failed", msg: fmt.Sprintf("failed to check for replay: %s", err)}
+	}
+	if !firstSeen {
+		return &webhookAuthError{reason: "replay", msg: "request was already received"}
+	}
+	return nil
+}
+
+// markSeen remembers the signature until it expires, and returns false if it
+// was already seen.
+func (a *webhookAuth) markSeen(ctx context.Context, key string, expires time.Time, now time.Time) (bool, error) {
+	if a.client != nil {
+		err := a.client.Do(ctx, a.client.B().
+			Set().
+			Key(seenSignaturesPrefix+key).
+			Value(fmt.Sprint(now.Unix())).
+			Nx().
+			Ex(max(expires.Sub(now), time.Second)).
+			Build()).Error()
+		if valkey.IsValkeyNil(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("SET NX: %w", err)
+		}
+		return true, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, expires := range a.seen {
+		if now.After(expires) {
+			delete(a.seen, k)
+		}
+	}
+	if _, found := a.seen[key]; found {
+		return false, nil
+	}
+	a.seen[key] = expires
+	return true, nil
+}
@@ -0,0 +1,210 @@
+package redminehandler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/valkey-io/valkey-go"
+)
+
+const testWebhookSecret = "test-webhook-secret"
+
+// signedRequest returns a webhook request signed with the secret at time t.
+func signedRequest(secret string, body string, t time.Time) *http.Request {
+	ts := fmt.Sprint(t.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func checkAuthError(t *testing.T, err error, reason string) {
+	t.Helper()
+	if reason == "" {
+		if err != nil {
+			t.Errorf("Request was rejected: %s", err)
+		}
+		return
+	}
+	authErr, ok := err.(*webhookAuthError)
+	if !ok {
+		t.Errorf("Got error %v, want rejection with reason %q", err, reason)
+		return
+	}
+	if authErr.reason != reason {
+		t.Errorf("Request was rejected with reason %q (%s), want %q", authErr.reason, authErr, reason)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	now := time.Now()
+	const body = `{"payload":{}}`
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		body   string
+		reason string
+	}{
+		{"valid", func() *http.Request { return signedRequest(testWebhookSecret, body, now) }, body, ""},
+		{"small clock skew", func() *http.Request { return signedRequest(testWebhookSecret, body, now.Add(-time.Minute)) }, body, ""},
+		{"wrong secret", func() *http.Request { return signedRequest("other", body, now) }, body, "bad_signature"},
+		{"modified body", func() *http.Request { return signedRequest(testWebhookSecret, body, now) }, `{"payload":{"x":1}}`, "bad_signature"},
+		{"modified timestamp", func() *http.Request {
+			req := signedRequest(testWebhookSecret, body, now)
+			req.Header.Set(timestampHeader, fmt.Sprint(now.Unix()+1))
+			return req
+		}, body, "bad_signature"},
+		{"unsupported format", func() *http.Request {
+			req := signedRequest(testWebhookSecret, body, now)
+			req.Header.Set(signatureHeader, strings.Replace(req.Header.Get(signatureHeader), "sha256=", "sha1=", 1))
+			return req
+		}, body, "bad_signature"},
+		{"missing signature", func() *http.Request {
+			req := signedRequest(testWebhookSecret, body, now)
+			req.Header.Del(signatureHeader)
+			return req
+		}, body, "missing_signature"},
+		{"bad timestamp", func() *http.Request {
+			req := signedRequest(testWebhookSecret, body, now)
+			req.Header.Set(timestampHeader, "yesterday")
+			return req
+		}, body, "bad_timestamp"},
+		{"stale timestamp", func() *http.Request {
+			return signedRequest(testWebhookSecret, body, now.Add(-maxTimestampSkew-time.Minute))
+		}, body, "stale_timestamp"},
+		{"future timestamp", func() *http.Request {
+			return signedRequest(testWebhookSecret, body, now.Add(maxTimestampSkew+time.Minute))
+		}, body, "stale_timestamp"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := newWebhookAuth(testWebhookSecret, false, nil, nil)
+			if err != nil {
+				t.Fatalf("newWebhookAuth failed: %s", err)
+			}
+			checkAuthError(t, a.checkSignature(context.Background(), test.req(), []byte(test.body), now), test.reason)
+		})
+	}
+}
+
+func TestWebhookReplay(t *testing.T) {
+	now := time.Now()
+	const body = `{"payload":{}}`
+	a, err := newWebhookAuth(testWebhookSecret, false, nil, nil)
+	if err != nil {
+		t.Fatalf("newWebhookAuth failed: %s", err)
+	}
+
+	req := signedRequest(testWebhookSecret, body, now)
+	checkAuthError(t, a.checkSignature(context.Background(), req, []byte(body), now), "")
+	checkAuthError(t, a.checkSignature(context.Background(), req, []byte(body), now.Add(time.Minute)), "replay")
+
+	// Another request with the same body is not a replay.
+	other := signedRequest(testWebhookSecret, body, now.Add(time.Second))
+	checkAuthError(t, a.checkSignature(context.Background(), other, []byte(body), now.Add(time.Second)), "")
+
+	// Once the timestamp is too old, the request is rejected as stale.
+	checkAuthError(t, a.checkSignature(context.Background(), req, []byte(body), now.Add(2*maxTimestampSkew)), "stale_timestamp")
+}
+
+func TestWebhookReplayAcrossInstances(t *testing.T) {
+	now := time.Now()
+	const body = `{"payload":{}}`
+	m := miniredis.RunT(t)
+	client, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{m.Addr()},
+		DisableCache: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create valkey client: %s", err)
+	}
+	defer client.Close()
+
+	newAuth := func() *webhookAuth {
+		t.Helper()
+		a, err := newWebhookAuth(testWebhookSecret, false, nil, client)
+		if err != nil {
+			t.Fatalf("newWebhookAuth failed: %s", err)
+		}
+		return a
+	}
+
+	req := signedRequest(testWebhookSecret, body, now)
+	checkAuthError(t, newAuth().checkSignature(context.Background(), req, []byte(body), now), "")
+	// Another instance, or the same one after a restart.
+	checkAuthError(t, newAuth().checkSignature(context.Background(), req, []byte(body), now.Add(time.Minute)), "replay")
+
+	// Seen signatures are not kept after the timestamp becomes stale.
+	if keys := m.Keys(); len(keys) != 1 {
+		t.Errorf("Got keys %q, want one seen signature", keys)
+	}
+	for _, key := range m.Keys() {
+		if ttl := m.TTL(key); ttl <= 0 || ttl > maxTimestampSkew {
+			t.Errorf("%q has TTL %s, want up to %s", key, ttl, maxTimestampSkew)
+		}
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	if _, err := newWebhookAuth("", false, nil, nil); err == nil {
+		t.Errorf("newWebhookAuth succeeded without a secret")
+	}
+
+	a, err := newWebhookAuth("", true, nil, nil)
+	if err != nil {
+		t.Fatalf("newWebhookAuth failed: %s", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader("{}"))
+	checkAuthError(t, a.checkSignature(context.Background(), req, []byte("{}"), time.Now()), "")
+
+	// Zero value doesn't accept unsigned requests.
+	checkAuthError(t, (&webhookAuth{}).checkSignature(context.Background(), req, []byte("{}"), time.Now()), "no_secret")
+}
+
+func TestWebhookAllowedIPs(t *testing.T) {
+	a, err := newWebhookAuth(testWebhookSecret, false, []string{"10.1.2.3", " 172.16.0.0/12", "2001:db8::/32", ""}, nil)
+	if err != nil {
+		t.Fatalf("newWebhookAuth failed: %s", err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		reason     string
+	}{
+		{"10.1.2.3:1234", ""},
+		{"10.1.2.4:1234", "ip_not_allowed"},
+		{"172.20.0.5:1234", ""},
+		{"172.32.0.5:1234", "ip_not_allowed"},
+		{"[::ffff:172.20.0.5]:1234", ""},
+		{"[2001:db8::1]:1234", ""},
+		{"[2001:db9::1]:1234", "ip_not_allowed"},
+		{"unix-socket", "ip_not_allowed"},
+	}
+	for _, test := range tests {
+		t.Run(test.remoteAddr, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+			req.RemoteAddr = test.remoteAddr
+			checkAuthError(t, a.checkSource(req), test.reason)
+		})
+	}
+
+	for _, s := range []string{"10.1.2", "10.0.0.0/33"} {
+		if _, err := newWebhookAuth(testWebhookSecret, false, []string{s}, nil); err == nil {
+			t.Errorf("newWebhookAuth succeeded with allowed IP %q", s)
+		}
+	}
+}
@@ -1,8 +1,9 @@
-package main
+package redminehandler
 
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -10,8 +11,6 @@ import (
 	"bsky.watch/modkit/pkg/config"
 )
 
-var cfg Config
-
 type Config struct {
 	cliutil.LoggingConfig
 	ConfigPath            string `split_words:"true"`
@@ -23,11 +22,19 @@ type Config struct {
 	RedmineAddr           string `split_words:"true"`
 	RedmineAPIKey         string `split_words:"true"`
 	Mappings              string
-	TicketIDEncryptionKey string `split_words:"true"`
-	ListServerURL         string `split_words:"true"`
-	DumpPayloads          bool   `split_words:"true"`
-	LabelerPublicURL      string `split_words:"true"`
-	LabelerAdminURL       string `split_words:"true"`
+	TicketIDEncryptionKey string   `split_words:"true"`
+	ListServerURL         string   `split_words:"true"`
+	DumpPayloads          bool     `split_words:"true"`
+	LabelerPublicURL      string   `split_words:"true"`
+	LabelerAdminURL       string   `split_words:"true"`
+	WebhookSecret         string   `split_words:"true"`
+	WebhookAllowedIPs     []string `split_words:"true"`
+	// WebhookSkipSignature disables signature verification when there's
+	// no WebhookSecret. Only meant for local development.
+	WebhookSkipSignature bool          `split_words:"true"`
+	ValkeyAddr           string        `split_words:"true"`
+	ConsumerName         string        `split_words:"true"`
+	ReloadPollInterval   time.Duration `split_words:"true"`
 }
 
 func (cfg *Config) LoadDefaultsFromConfig(filename string) error {
@@ -0,0 +1,379 @@
+package redminehandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/imax9000/errors"
+	"github.com/rs/zerolog"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/modkit/pkg/jobqueue"
+	"bsky.watch/modkit/pkg/reload"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+const (
+	jobsStream = "modkit:redmine-handler:jobs"
+	jobsGroup  = "redmine-handler"
+
+	// Webhooks for the same journal entry received within this window
+	// are enqueued only once.
+	jobDedupeWindow = 24 * time.Hour
+	// Jobs pending for longer than this are assumed to be abandoned
+	// by a crashed instance and are picked up by another one.
+	// Must be larger than maxJobBackoff.
+	jobClaimAfter = 15 * time.Minute
+
+	maxJobAttempts    = 8
+	initialJobBackoff = 5 * time.Second
+	maxJobBackoff     = 5 * time.Minute
+
+	// Maximum number of jobs executed concurrently.
+	maxConcurrentJobs = 8
+)
+
+// Job types.
+const (
+	jobAddToLists     = "add_to_lists"
+	jobApplyLabels    = "apply_labels"
+	jobRevertAppeal   = "revert_appeal"
+	jobUpdateMetadata = "update_metadata"
+	jobRewriteLinks   = "rewrite_links"
+	jobMerge          = "merge"
+)
+
+// NewJobQueue returns the queue for webhook actions stored in valkey.
+// consumer must be unique for each running instance.
+func NewJobQueue(ctx context.Context, client valkey.Client, consumer string) (*jobqueue.Queue, error) {
+	return jobqueue.New(ctx, client, jobsStream, jobsGroup, consumer, jobClaimAfter)
+}
+
+// jobTypeForPayload returns the type of action requested by the webhook, or
+// an empty string if there's nothing to do.
+func jobTypeForPayload(payload *WebhookPayload) string {
+	switch {
+	case payload.Issue == nil:
+		return ""
+	case requestedMerge(payload):
+		return jobMerge
+	case requestedAddingToLists(payload.Issue):
+		return jobAddToLists
+	case requestedMetadataUpdate(payload.Issue):
+		return jobUpdateMetadata
+	case requestedAppealRevert(payload):
+		return jobRevertAppeal
+	case requestedApplyLabels(payload.Issue):
+		return jobApplyLabels
+	default:
+		return jobRewriteLinks
+	}
+}
+
+// jobKey returns the key used to deduplicate jobs. Redmine can deliver
+// the same webhook more than once, but each update has its own journal entry.
+func jobKey(jobType string, payload *WebhookPayload) string {
+	event := payload.Action
+	if payload.Journal != nil {
+		event = fmt.Sprint(payload.Journal.Id)
+	}
+	return fmt.Sprintf("%s:%d:%s", jobType, payload.Issue.Id, event)
+}
+
+// executeJob performs the requested action and returns the text to be added
+// to the ticket as a note.
+func (h *handler) executeJob(ctx context.Context, jobType string, payload *WebhookPayload) (string, error) {
+	switch jobType {
+	case jobAddToLists:
+		if err := h.checkApproval(ctx, payload.Issue); err != nil {
+			return "", err
+		}
+		return h.addToListsAndAccountLabels(ctx, payload.Issue)
+	case jobApplyLabels:
+		if err := h.checkApproval(ctx, payload.Issue); err != nil {
+			return "", err
+		}
+		return h.applyLabels(ctx, payload.Issue)
+	case jobRevertAppeal:
+		return h.revertAppealed(ctx, payload.Issue)
+	case jobUpdateMetadata:
+		return "", h.updateMetadata(ctx, payload.Issue, payload.Action == "opened")
+	case jobRewriteLinks:
+		return "", h.updateJournalIfNeeded(ctx, payload.Issue)
+	case jobMerge:
+		return h.mergeTickets(ctx, payload)
+	}
+	return "", fmt.Errorf("unknown job type %q", jobType)
+}
+
+// finishJob records the outcome of the job in the ticket.
+func (h *handler) finishJob(ctx context.Context, jobType string, ticketId int, note string, jobErr error) error {
+	var updateOpts []tickets.TicketOption
+	switch jobType {
+	case jobAddToLists, jobApplyLabels:
+		if pending, ok := errors.As[*approvalPendingError](jobErr); ok {
+			return h.notifyApprovalPending(ctx, ticketId, pending)
+		}
+		updateOpts = []tickets.TicketOption{tickets.Status(tickets.StatusApplied), tickets.WithNote(note)}
+		if jobErr != nil {
+			updateOpts = []tickets.TicketOption{
+				tickets.Status(tickets.StatusInProgress),
+				tickets.WithNote(fmt.Sprintf("Failed to apply updates: %s", jobErr)),
+			}
+		}
+	case jobRevertAppeal:
+		updateOpts = []tickets.TicketOption{tickets.WithNote(note)}
+		if jobErr != nil {
+			updateOpts = []tickets.TicketOption{
+				tickets.Status(tickets.StatusInProgress),
+				tickets.WithNote(fmt.Sprintf("Failed to revert actions: %s", jobErr)),
+			}
+		}
+	case jobUpdateMetadata:
+		if jobErr == nil {
+			return nil
+		}
+		updateOpts = []tickets.TicketOption{tickets.WithNote(fmt.Sprintf("Failed to update ticket metadata: %s", jobErr))}
+	case jobRewriteLinks:
+		if jobErr == nil {
+			return nil
+		}
+		updateOpts = []tickets.TicketOption{tickets.WithNote(fmt.Sprintf("Failed to rewrite links in notes: %s", jobErr))}
+	case jobMerge:
+		if jobErr == nil {
+			return nil
+		}
+		updateOpts = []tickets.TicketOption{tickets.WithNote(fmt.Sprintf("Failed to merge tickets: %s", jobErr))}
+	default:
+		return fmt.Errorf("unknown job type %q", jobType)
+	}
+
+	redmineTicket, err := h.ticketsClient.Issue(ticketId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the current state of the ticket: %w", err)
+	}
+	_, err = tickets.Update(ctx, h.ticketsClient, redmineTicket, updateOpts...)
+	return err
+}
+
+// enqueue adds a job for the webhook payload to the queue.
+func (h *handler) enqueue(ctx context.Context, payload *WebhookPayload) error {
+	log := zerolog.Ctx(ctx)
+
+	jobType := jobTypeForPayload(payload)
+	if jobType == "" {
+		return nil
+	}
+	key := jobKey(jobType, payload)
+	added, err := h.jobs.Add(ctx, jobType, key, payload, jobDedupeWindow)
+	if err != nil {
+		return fmt.Errorf("adding job to the queue: %w", err)
+	}
+	if !added {
+		log.Info().Str("job_key", key).Msgf("Job %q was already enqueued, skipping", key)
+		return nil
+	}
+	log.Info().Str("job_key", key).Msgf("Enqueued job %q", key)
+	return nil
+}
+
+// RunJobs executes jobs from the queue until the context is cancelled.
+// Jobs for different tickets are executed concurrently, and jobs for
+// the same ticket one after another.
+func (h *handler) RunJobs(ctx context.Context) error {
+	log := zerolog.Ctx(ctx)
+
+	sem := make(chan struct{}, maxConcurrentJobs)
+	serializer := newJobSerializer(h.runJob)
+	go h.touchQueuedJobs(ctx, serializer)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+
+		job, err := h.jobs.Next(ctx)
+		if err != nil {
+			<-sem
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Error().Err(err).Msgf("Failed to fetch next job: %s", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		if !serializer.Start(ctx, jobTicketId(job), job, func() { <-sem }) {
+			// Queued jobs don't occupy a slot, so that the jobs for other
+			// tickets are not blocked by them.
+			<-sem
+		}
+	}
+}
+
+// touchQueuedJobs periodically resets the idle time of the jobs waiting
+// for other jobs for the same ticket, so that they are not claimed
+// by another instance in the meantime.
+func (h *handler) touchQueuedJobs(ctx context.Context, serializer *jobSerializer) {
+	ticker := time.NewTicker(jobClaimAfter / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := h.jobs.Touch(ctx, serializer.Queued()...); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msgf("Failed to refresh queued jobs: %s", err)
+		}
+	}
+}
+
+// jobTicketId returns the ID of the ticket that the job is for, or 0
+// if the payload is malformed.
+func jobTicketId(job *jobqueue.Job) int {
+	payload := &WebhookPayload{}
+	if err := json.Unmarshal(job.Payload, payload); err != nil || payload.Issue == nil {
+		return 0
+	}
+	return payload.Issue.Id
+}
+
+// runJob executes a single job, retrying it with exponential backoff.
+// The job stays in the queue until it either succeeds or runs out of attempts,
+// so it will be picked up again if the process crashes in the middle.
+func (h *handler) runJob(ctx context.Context, job *jobqueue.Job) {
+	log := zerolog.Ctx(ctx).With().Str("job_id", job.ID).Str("job_key", job.Key).Logger()
+	ctx = log.WithContext(ctx)
+
+	payload := &WebhookPayload{}
+	if err := json.Unmarshal(job.Payload, payload); err != nil || payload.Issue == nil {
+		log.Error().Err(err).Msgf("Dropping job with malformed payload: %s", err)
+		jobsProcessed.WithLabelValues(job.Type, "dropped").Inc()
+		if err := h.jobs.Done(ctx, job.ID); err != nil {
+			log.Error().Err(err).Msgf("Failed to remove job from the queue: %s", err)
+		}
+		return
+	}
+
+	for {
+		if h.attemptJob(ctx, job, payload) {
+			return
+		}
+
+		jobsProcessed.WithLabelValues(job.Type, "retry").Inc()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobBackoff(job.Attempts)):
+		}
+
+		var err error
+		job, err = h.jobs.Retry(ctx, job.ID)
+		if err != nil {
+			// Most likely the job was claimed by another instance.
+			log.Warn().Err(err).Msgf("Failed to retry the job: %s", err)
+			return
+		}
+	}
+}
+
+// attemptJob makes a single attempt at executing the job. Returns true
+// if the job is finished and was removed from the queue.
+func (h *handler) attemptJob(ctx context.Context, job *jobqueue.Job, payload *WebhookPayload) bool {
+	log := zerolog.Ctx(ctx)
+
+	ctx, release := reload.Hold(ctx)
+	defer release()
+
+	// The ticket might have been updated since the job was enqueued, so
+	// the action is done on its current state instead of the one in the payload.
+	note := ""
+	current, err := h.currentPayload(payload)
+	if err == nil {
+		if !stillRequested(job.Type, current.Issue) {
+			log.Info().Msgf("Ticket %d doesn't request %q anymore, skipping", current.Issue.Id, job.Type)
+			jobsProcessed.WithLabelValues(job.Type, "skipped").Inc()
+			if err := h.jobs.Done(ctx, job.ID); err != nil {
+				log.Error().Err(err).Msgf("Failed to remove job from the queue: %s", err)
+			}
+			return true
+		}
+		payload = current
+		log.Info().Int64("attempt", job.Attempts).Msgf("Executing job %q (attempt %d)", job.Key, job.Attempts)
+		note, err = h.executeJob(ctx, job.Type, payload)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Job failed: %s", err)
+	}
+
+	// Waiting for approval won't be resolved by retrying.
+	_, pendingApproval := errors.As[*approvalPendingError](err)
+	lastAttempt := job.Attempts >= maxJobAttempts
+	if err == nil || pendingApproval || lastAttempt {
+		finishErr := h.finishJob(ctx, job.Type, payload.Issue.Id, note, err)
+		if finishErr != nil {
+			log.Error().Err(finishErr).Msgf("Failed to update the ticket: %s", finishErr)
+		}
+		if finishErr == nil && err == nil {
+			h.recordOutcome(ctx, job.Type, payload)
+		}
+		if finishErr == nil || lastAttempt {
+			status := "success"
+			switch {
+			case pendingApproval && finishErr == nil:
+				status = "pending_approval"
+			case err != nil || finishErr != nil:
+				status = "failed"
+			}
+			jobsProcessed.WithLabelValues(job.Type, status).Inc()
+			if err := h.jobs.Done(ctx, job.ID); err != nil {
+				log.Error().Err(err).Msgf("Failed to remove job from the queue: %s", err)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// currentPayload returns a copy of the payload with the ticket replaced
+// by its current state.
+func (h *handler) currentPayload(payload *WebhookPayload) (*WebhookPayload, error) {
+	ticket, err := h.ticketsClient.Issue(payload.Issue.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the current state of the ticket: %w", err)
+	}
+	issue, err := issueFromRedmine(ticket)
+	if err != nil {
+		return nil, err
+	}
+	r := *payload
+	r.Issue = issue
+	return &r, nil
+}
+
+// stillRequested returns false if the current state of the ticket doesn't
+// ask for the action anymore, e.g. because an earlier job for the same
+// ticket has already done it.
+func stillRequested(jobType string, ticket *Issue) bool {
+	switch jobType {
+	case jobAddToLists:
+		return requestedAddingToLists(ticket)
+	case jobApplyLabels:
+		return requestedApplyLabels(ticket)
+	case jobUpdateMetadata:
+		return requestedMetadataUpdate(ticket)
+	}
+	return true
+}
+
+func jobBackoff(attempt int64) time.Duration {
+	d := initialJobBackoff
+	for i := int64(1); i < attempt && d < maxJobBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxJobBackoff)
+}
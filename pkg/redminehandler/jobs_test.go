@@ -0,0 +1,617 @@
+package redminehandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/jobqueue"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func testMappings() tickets.IDMappings {
+	m := tickets.IDMappings{ProjectID: 1}
+	m.Priorities.Normal = 2
+	m.Statuses.New = 1
+	m.Statuses.InProgress = 2
+	m.Statuses.Completed = 3
+	m.Statuses.Applied = 4
+	m.Statuses.Duplicate = 5
+	m.Statuses.Granted = 6
+	m.TicketTypes.Ticket = 1
+	m.TicketTypes.Appeal = 2
+	m.TicketTypes.RecordTicket = 3
+	m.Fields.DID = 1
+	m.Fields.Handle = 2
+	m.Fields.Subject = 4
+	m.Fields.AddToLists = 5
+	m.Fields.Labels = 6
+	return m
+}
+
+func newTestHandler(t *testing.T) (*handler, *tickets.MemoryBackend) {
+	t.Helper()
+	tickets.SetMappings(testMappings())
+	backend := tickets.NewMemoryBackend("modkit")
+	h, err := NewHandler(backend, &config.Config{}, &Config{WebhookSecret: testWebhookSecret}, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewHandler() failed: %s", err)
+	}
+	return h, backend
+}
+
+// webhookPayloadFor builds a webhook payload from the current state of the ticket,
+// the same way Redmine does after the ticket was updated.
+func webhookPayloadFor(t *testing.T, backend *tickets.MemoryBackend, id int, details ...JournalDetails) *WebhookPayload {
+	t.Helper()
+	ticket, err := backend.Issue(id)
+	if err != nil {
+		t.Fatalf("Issue(%d) failed: %s", id, err)
+	}
+	issue := &Issue{
+		Id:       ticket.Id,
+		Subject:  ticket.Subject,
+		Status:   ticket.Status,
+		Tracker:  ticket.Tracker,
+		Priority: ticket.Priority,
+	}
+	for _, cf := range ticket.CustomFields {
+		b, err := json.Marshal(cf.Value)
+		if err != nil {
+			t.Fatalf("Failed to marshal field value: %s", err)
+		}
+		issue.CustomFieldValues = append(issue.CustomFieldValues, CustomFieldValue{Id: cf.Id, Value: b})
+	}
+	return &WebhookPayload{
+		Action:  "updated",
+		Issue:   issue,
+		Journal: &Journal{Id: 1000 + id, Author: &User{Id: 1000}, Details: details},
+	}
+}
+
+func lastNote(t *testing.T, backend *tickets.MemoryBackend, id int) string {
+	t.Helper()
+	ticket, err := backend.IssueWithJournals(id)
+	if err != nil {
+		t.Fatalf("IssueWithJournals(%d) failed: %s", id, err)
+	}
+	for i := len(ticket.Journals) - 1; i >= 0; i-- {
+		if ticket.Journals[i].Notes != "" {
+			return ticket.Journals[i].Notes
+		}
+	}
+	return ""
+}
+
+func TestAddToListsFailureIsReportedInTicket(t *testing.T) {
+	h, backend := newTestHandler(t)
+	ctx := context.Background()
+
+	// Ticket is marked as completed without a DID.
+	ticket, err := tickets.Create(ctx, backend, tickets.Subject("alice.test"), tickets.Type(tickets.TypeTicket),
+		tickets.AddToLists([]string{"Trolls [trolls]"}))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := tickets.Update(ctx, backend, ticket, tickets.Status(tickets.StatusCompleted)); err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+
+	payload := webhookPayloadFor(t, backend, ticket.Id)
+	if got := jobTypeForPayload(payload); got != jobAddToLists {
+		t.Fatalf("jobTypeForPayload() = %q, want %q", got, jobAddToLists)
+	}
+	if err := h.processPayload(ctx, payload); err != nil {
+		t.Fatalf("processPayload() failed: %s", err)
+	}
+
+	got, err := backend.Issue(ticket.Id)
+	if err != nil {
+		t.Fatalf("Issue() failed: %s", err)
+	}
+	if got.Status.Id != tickets.Mappings().Statuses.InProgress {
+		t.Errorf("Status is %d, want %d", got.Status.Id, tickets.Mappings().Statuses.InProgress)
+	}
+	if note := lastNote(t, backend, ticket.Id); !strings.Contains(note, "Failed to apply updates: DID not set") {
+		t.Errorf("Unexpected note %q", note)
+	}
+}
+
+func TestAppealGrantedWithNothingSelected(t *testing.T) {
+	h, backend := newTestHandler(t)
+	ctx := context.Background()
+
+	appeal, err := tickets.Create(ctx, backend, tickets.Subject("Appeal"), tickets.Type(tickets.TypeAppeal),
+		tickets.DID("did:plc:alice"))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := tickets.Update(ctx, backend, appeal, tickets.Status(tickets.StatusGranted)); err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+
+	statusChange := JournalDetails{Property: "attr", PropKey: "status_id", Value: fmt.Sprint(tickets.Mappings().Statuses.Granted)}
+	payload := webhookPayloadFor(t, backend, appeal.Id, statusChange)
+	if got := jobTypeForPayload(payload); got != jobRevertAppeal {
+		t.Fatalf("jobTypeForPayload() = %q, want %q", got, jobRevertAppeal)
+	}
+	if err := h.processPayload(ctx, payload); err != nil {
+		t.Fatalf("processPayload() failed: %s", err)
+	}
+
+	got, err := backend.Issue(appeal.Id)
+	if err != nil {
+		t.Fatalf("Issue() failed: %s", err)
+	}
+	if got.Status.Id != tickets.Mappings().Statuses.Granted {
+		t.Errorf("Status is %d, want it to stay %d", got.Status.Id, tickets.Mappings().Statuses.Granted)
+	}
+	if note := lastNote(t, backend, appeal.Id); !strings.HasPrefix(note, "Appeal granted, no changes made") {
+		t.Errorf("Unexpected note %q", note)
+	}
+
+	// Subsequent updates must not trigger the revert again.
+	payload = webhookPayloadFor(t, backend, appeal.Id)
+	if got := jobTypeForPayload(payload); got == jobRevertAppeal {
+		t.Errorf("Update without status change triggered appeal revert")
+	}
+}
+
+func TestFinishJob(t *testing.T) {
+	h, backend := newTestHandler(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		jobType    string
+		jobErr     error
+		wantStatus int
+		wantNote   string
+	}{
+		{jobAddToLists, nil, tickets.Mappings().Statuses.Applied, "Added to lists"},
+		{jobApplyLabels, fmt.Errorf("labeler is down"), tickets.Mappings().Statuses.InProgress, "Failed to apply updates: labeler is down"},
+		{jobRevertAppeal, nil, tickets.Mappings().Statuses.New, "Added to lists"},
+		{jobUpdateMetadata, fmt.Errorf("PDS is down"), tickets.Mappings().Statuses.New, "Failed to update ticket metadata: PDS is down"},
+		{jobUpdateMetadata, nil, tickets.Mappings().Statuses.New, ""},
+		{jobRewriteLinks, fmt.Errorf("appview is down"), tickets.Mappings().Statuses.New, "Failed to rewrite links in notes: appview is down"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%v", test.jobType, test.jobErr), func(t *testing.T) {
+			ticket, err := tickets.Create(ctx, backend, tickets.Subject("alice.test"), tickets.Type(tickets.TypeTicket))
+			if err != nil {
+				t.Fatalf("Create() failed: %s", err)
+			}
+
+			if err := h.finishJob(ctx, test.jobType, ticket.Id, "Added to lists", test.jobErr); err != nil {
+				t.Fatalf("finishJob() failed: %s", err)
+			}
+
+			got, err := backend.Issue(ticket.Id)
+			if err != nil {
+				t.Fatalf("Issue() failed: %s", err)
+			}
+			if got.Status.Id != test.wantStatus {
+				t.Errorf("Status is %d, want %d", got.Status.Id, test.wantStatus)
+			}
+			if note := lastNote(t, backend, ticket.Id); note != test.wantNote {
+				t.Errorf("Note is %q, want %q", note, test.wantNote)
+			}
+		})
+	}
+}
+
+func TestUpdateCustomFieldValues(t *testing.T) {
+	_, backend := newTestHandler(t)
+
+	backend.AddCustomField(redmine.CustomFieldDefinition{
+		Id:       tickets.Mappings().Fields.AddToLists,
+		Name:     "Add to lists",
+		Multiple: true,
+		PossibleValues: []redmine.CustomFieldPossibleValue{
+			{Value: "Old list [old]"},
+		},
+	})
+	fields, err := backend.CustomFields()
+	if err != nil {
+		t.Fatalf("CustomFields() failed: %s", err)
+	}
+	field := fields[0]
+
+	cfg := &config.Config{Lists: map[string]config.ListConfig{"trolls": {Name: "Trolls"}}}
+	if err := updateCustomFieldValues(backend, &field, cfg.ListFieldValues()); err != nil {
+		t.Fatalf("updateCustomFieldValues() failed: %s", err)
+	}
+
+	fields, err = backend.CustomFields()
+	if err != nil {
+		t.Fatalf("CustomFields() failed: %s", err)
+	}
+	values := []string{}
+	for _, v := range fields[0].PossibleValues {
+		values = append(values, v.Value)
+	}
+	if !strings.Contains(strings.Join(values, ","), "Trolls [trolls]") {
+		t.Errorf("Possible values are %q, want them to include %q", values, "Trolls [trolls]")
+	}
+}
+
+func TestAddToListsRequiresApproval(t *testing.T) {
+	h, backend := newTestHandler(t)
+	ctx := context.Background()
+
+	m := testMappings()
+	m.Fields.Approver = 7
+	tickets.SetMappings(m)
+
+	listServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"results": {}}`)
+	}))
+	defer listServer.Close()
+	h.listServerURL = listServer.URL
+	h.config = &config.Config{
+		Lists: map[string]config.ListConfig{
+			"trolls": {Name: "Trolls", URI: "at://did:plc:mod/app.bsky.graph.list/trolls"},
+		},
+		RequireApproval: config.ApprovalConfig{Lists: []string{"trolls"}},
+	}
+
+	alice := backend.AddUser("alice")
+	bob := backend.AddUser("bob")
+
+	ticket, err := tickets.Create(ctx, backend, tickets.Subject("alice.test"), tickets.Type(tickets.TypeTicket),
+		tickets.DID("did:plc:troll"), tickets.AddToLists([]string{"Trolls [trolls]"}))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := tickets.Update(ctx, backend.Impersonate("alice"), ticket, tickets.Status(tickets.StatusCompleted)); err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+
+	process := func() {
+		t.Helper()
+		payload := webhookPayloadFor(t, backend, ticket.Id)
+		if got := jobTypeForPayload(payload); got != jobAddToLists {
+			t.Fatalf("jobTypeForPayload() = %q, want %q", got, jobAddToLists)
+		}
+		if err := h.processPayload(ctx, payload); err != nil {
+			t.Fatalf("processPayload() failed: %s", err)
+		}
+	}
+	checkStatus := func(want int) {
+		t.Helper()
+		got, err := backend.Issue(ticket.Id)
+		if err != nil {
+			t.Fatalf("Issue() failed: %s", err)
+		}
+		if got.Status.Id != want {
+			t.Errorf("Status is %d, want %d", got.Status.Id, want)
+		}
+	}
+
+	process()
+	checkStatus(m.Statuses.Completed)
+	note := lastNote(t, backend, ticket.Id)
+	if !strings.Contains(note, "Trolls") || !strings.Contains(note, "other than alice") {
+		t.Errorf("Unexpected note %q", note)
+	}
+
+	// Approving own changes doesn't count, and the note is not repeated.
+	current, err := backend.Issue(ticket.Id)
+	if err != nil {
+		t.Fatalf("Issue() failed: %s", err)
+	}
+	if _, err := tickets.Update(ctx, backend.Impersonate("alice"), current, tickets.Approver(alice)); err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+	process()
+	checkStatus(m.Statuses.Completed)
+	withJournals, err := backend.IssueWithJournals(ticket.Id)
+	if err != nil {
+		t.Fatalf("IssueWithJournals() failed: %s", err)
+	}
+	notes := 0
+	for _, j := range withJournals.Journals {
+		if j.Notes == note {
+			notes++
+		}
+	}
+	if notes != 1 {
+		t.Errorf("Approval note was added %d times, want 1", notes)
+	}
+
+	current, err = backend.Issue(ticket.Id)
+	if err != nil {
+		t.Fatalf("Issue() failed: %s", err)
+	}
+	if _, err := tickets.Update(ctx, backend.Impersonate("bob"), current, tickets.Approver(bob)); err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+	process()
+	checkStatus(m.Statuses.Applied)
+}
+
+func TestApprovalMustBeGivenByApprover(t *testing.T) {
+	h, backend := newTestHandler(t)
+	ctx := context.Background()
+
+	m := testMappings()
+	m.Fields.Approver = 7
+	tickets.SetMappings(m)
+
+	listServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"results": {}}`)
+	}))
+	defer listServer.Close()
+	h.listServerURL = listServer.URL
+	h.config = &config.Config{
+		Lists: map[string]config.ListConfig{
+			"trolls":   {Name: "Trolls", URI: "at://did:plc:mod/app.bsky.graph.list/trolls"},
+			"spammers": {Name: "Spammers", URI: "at://did:plc:mod/app.bsky.graph.list/spammers"},
+		},
+		RequireApproval: config.ApprovalConfig{Lists: []string{"trolls", "spammers"}},
+	}
+
+	backend.AddUser("alice")
+	bob := backend.AddUser("bob")
+
+	ticket, err := tickets.Create(ctx, backend, tickets.Subject("alice.test"), tickets.Type(tickets.TypeTicket),
+		tickets.DID("did:plc:troll"), tickets.AddToLists([]string{"Trolls [trolls]"}))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+
+	update := func(login string, opts ...tickets.TicketOption) {
+		t.Helper()
+		current, err := backend.Issue(ticket.Id)
+		if err != nil {
+			t.Fatalf("Issue() failed: %s", err)
+		}
+		if _, err := tickets.Update(ctx, backend.Impersonate(login), current, opts...); err != nil {
+			t.Fatalf("Update() failed: %s", err)
+		}
+	}
+	process := func() {
+		t.Helper()
+		if err := h.processPayload(ctx, webhookPayloadFor(t, backend, ticket.Id)); err != nil {
+			t.Fatalf("processPayload() failed: %s", err)
+		}
+	}
+	checkStatus := func(want int) {
+		t.Helper()
+		got, err := backend.Issue(ticket.Id)
+		if err != nil {
+			t.Fatalf("Issue() failed: %s", err)
+		}
+		if got.Status.Id != want {
+			t.Errorf("Status is %d, want %d", got.Status.Id, want)
+		}
+	}
+
+	// Setting someone else as Approver doesn't approve on their behalf.
+	update("alice", tickets.Status(tickets.StatusCompleted), tickets.Approver(bob))
+	process()
+	checkStatus(m.Statuses.Completed)
+	if note := lastNote(t, backend, ticket.Id); !strings.Contains(note, "other than alice") {
+		t.Errorf("Unexpected note %q", note)
+	}
+
+	// The approval doesn't cover lists selected after it.
+	update("bob", tickets.Approver(bob))
+	update("alice", tickets.AddToLists([]string{"Trolls [trolls]", "Spammers [spammers]"}))
+	process()
+	checkStatus(m.Statuses.Completed)
+
+	update("bob", tickets.Approver(0))
+	update("bob", tickets.Approver(bob))
+	process()
+	checkStatus(m.Statuses.Applied)
+}
+
+func TestQueuedJobsForTicketAreSerialized(t *testing.T) {
+	h, backend := newTestHandler(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	valkeyClient, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{miniredis.RunT(t).Addr()},
+		DisableCache: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create valkey client: %s", err)
+	}
+	defer valkeyClient.Close()
+	h.jobs, err = NewJobQueue(ctx, valkeyClient, "test")
+	if err != nil {
+		t.Fatalf("NewJobQueue() failed: %s", err)
+	}
+
+	m := testMappings()
+	m.Fields.Approver = 7
+	tickets.SetMappings(m)
+
+	// Requests for the first ticket block until the one for the second
+	// ticket arrives, so jobs for different tickets need to run concurrently.
+	var (
+		mu       sync.Mutex
+		running  = map[string]int{}
+		overlaps = 0
+		requests = 0
+	)
+	otherTicket := make(chan struct{})
+	finished := make(chan struct{}, 10)
+	listServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject := r.URL.Query().Get("subject")
+		mu.Lock()
+		running[subject]++
+		if running[subject] > 1 {
+			overlaps++
+		}
+		requests++
+		first := requests == 1
+		mu.Unlock()
+
+		switch {
+		case subject == "did:plc:other":
+			close(otherTicket)
+		case first:
+			select {
+			case <-otherTicket:
+			case <-time.After(5 * time.Second):
+				t.Errorf("Job for another ticket didn't start while the first one was running")
+			}
+		}
+		fmt.Fprint(w, `{"results": {}}`)
+
+		mu.Lock()
+		running[subject]--
+		mu.Unlock()
+		finished <- struct{}{}
+	}))
+	defer listServer.Close()
+	h.listServerURL = listServer.URL
+	h.config = &config.Config{
+		Lists: map[string]config.ListConfig{
+			"trolls": {Name: "Trolls", URI: "at://did:plc:mod/app.bsky.graph.list/trolls"},
+		},
+		RequireApproval: config.ApprovalConfig{Lists: []string{"trolls"}},
+	}
+
+	enqueue := func(did string, journalIds ...int) {
+		t.Helper()
+		ticket, err := tickets.Create(ctx, backend, tickets.Subject(did), tickets.Type(tickets.TypeTicket),
+			tickets.DID(did), tickets.AddToLists([]string{"Trolls [trolls]"}))
+		if err != nil {
+			t.Fatalf("Create() failed: %s", err)
+		}
+		if _, err := tickets.Update(ctx, backend, ticket, tickets.Status(tickets.StatusCompleted)); err != nil {
+			t.Fatalf("Update() failed: %s", err)
+		}
+		for _, id := range journalIds {
+			payload := webhookPayloadFor(t, backend, ticket.Id)
+			payload.Journal.Id = id
+			if err := h.enqueue(ctx, payload); err != nil {
+				t.Fatalf("enqueue() failed: %s", err)
+			}
+		}
+	}
+	enqueue("did:plc:troll", 1, 2, 3)
+	enqueue("did:plc:other", 4)
+
+	go h.RunJobs(ctx)
+	for range 4 {
+		select {
+		case <-finished:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for jobs to run")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if overlaps > 0 {
+		t.Errorf("Jobs for the same ticket overlapped %d times", overlaps)
+	}
+}
+
+func TestQueuedJobUsesCurrentTicketState(t *testing.T) {
+	h, backend := newTestHandler(t)
+	ctx := context.Background()
+
+	valkeyClient, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{miniredis.RunT(t).Addr()},
+		DisableCache: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create valkey client: %s", err)
+	}
+	defer valkeyClient.Close()
+	h.jobs, err = NewJobQueue(ctx, valkeyClient, "test")
+	if err != nil {
+		t.Fatalf("NewJobQueue() failed: %s", err)
+	}
+
+	var mu sync.Mutex
+	subjects := []string{}
+	listServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		subjects = append(subjects, r.URL.Query().Get("subject"))
+		mu.Unlock()
+		fmt.Fprint(w, `{"results": {}}`)
+	}))
+	defer listServer.Close()
+	h.listServerURL = listServer.URL
+	h.config = &config.Config{
+		Lists: map[string]config.ListConfig{
+			"trolls": {Name: "Trolls", URI: "at://did:plc:mod/app.bsky.graph.list/trolls"},
+		},
+	}
+
+	nextJob := func() *jobqueue.Job {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		job, err := h.jobs.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next() failed: %s", err)
+		}
+		return job
+	}
+
+	// Job is enqueued while the DID is missing, and the DID is filled in
+	// before the job runs.
+	ticket, err := tickets.Create(ctx, backend, tickets.Subject("alice.test"), tickets.Type(tickets.TypeTicket),
+		tickets.AddToLists([]string{"Trolls [trolls]"}))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	ticket, err = tickets.Update(ctx, backend, ticket, tickets.Status(tickets.StatusCompleted))
+	if err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+	stale := webhookPayloadFor(t, backend, ticket.Id)
+	if err := h.enqueue(ctx, stale); err != nil {
+		t.Fatalf("enqueue() failed: %s", err)
+	}
+	if _, err := tickets.Update(ctx, backend, ticket, tickets.DID("did:plc:alice")); err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+
+	job := nextJob()
+	job.Attempts = maxJobAttempts
+	if !h.attemptJob(ctx, job, stale) {
+		t.Fatalf("Job wasn't finished")
+	}
+	if note := lastNote(t, backend, ticket.Id); strings.Contains(note, "DID not set") {
+		t.Errorf("Job used the ticket state from the payload: %q", note)
+	}
+	mu.Lock()
+	if len(subjects) != 1 || subjects[0] != "did:plc:alice" {
+		t.Errorf("Got list membership requests for %q, want one for did:plc:alice", subjects)
+	}
+	mu.Unlock()
+
+	// The ticket is already applied, so the second job for it is skipped.
+	stale.Journal.Id++
+	if err := h.enqueue(ctx, stale); err != nil {
+		t.Fatalf("enqueue() failed: %s", err)
+	}
+	if !h.attemptJob(ctx, nextJob(), stale) {
+		t.Fatalf("Job wasn't finished")
+	}
+	mu.Lock()
+	if len(subjects) != 1 {
+		t.Errorf("Skipped job made %d list membership requests", len(subjects)-1)
+	}
+	mu.Unlock()
+}
@@ -0,0 +1,25 @@
+package redminehandler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var webhooksRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "redmine_handler",
+	Name:      "webhooks_rejected_total",
+	Help:      "Number of webhook requests that failed authentication",
+}, []string{
+	"reason",
+})
+
+var jobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "redmine_handler",
+	Name:      "jobs_processed_total",
+	Help:      "Number of job executions from the job queue, by outcome",
+}, []string{
+	"type",
+	"status",
+})
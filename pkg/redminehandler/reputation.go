@@ -0,0 +1,53 @@
+package redminehandler
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"bsky.watch/modkit/pkg/reputation"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// ticketOutcome returns the outcome of the ticket that resulted from
+// the successfully executed job, or an empty string if the ticket is not
+// resolved by it.
+func ticketOutcome(jobType string, payload *WebhookPayload) reputation.Outcome {
+	mappings := tickets.Mappings()
+	ticket := payload.Issue
+	if ticket.Tracker == nil || ticket.Tracker.Id == mappings.TicketTypes.Appeal {
+		return ""
+	}
+
+	switch jobType {
+	case jobAddToLists, jobApplyLabels:
+		lists, _ := idsFromField(ticket, mappings.Fields.AddToLists)
+		labels, _ := idsFromField(ticket, mappings.Fields.Labels)
+		if len(lists) > 0 || len(labels) > 0 {
+			return reputation.OutcomeApplied
+		}
+		return reputation.OutcomeNoAction
+	case jobMerge:
+		return reputation.OutcomeDuplicate
+	}
+	if ticket.Status != nil && mappings.Statuses.Duplicate != 0 && ticket.Status.Id == mappings.Statuses.Duplicate {
+		return reputation.OutcomeDuplicate
+	}
+	return ""
+}
+
+// recordOutcome feeds the outcome of the ticket back into the statistics
+// of its reporters. The job is already done at this point, so errors are
+// only logged.
+func (h *handler) recordOutcome(ctx context.Context, jobType string, payload *WebhookPayload) {
+	if h.reputation == nil {
+		return
+	}
+	outcome := ticketOutcome(jobType, payload)
+	if outcome == "" {
+		return
+	}
+	if err := h.reputation.SetOutcome(ctx, payload.Issue.Id, outcome); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("Failed to record ticket outcome: %s", err)
+	}
+}
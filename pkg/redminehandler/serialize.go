@@ -0,0 +1,177 @@
+package redminehandler
+
+import (
+	"context"
+	"sync"
+
+	"bsky.watch/modkit/pkg/jobqueue"
+)
+
+// ticketSerializer makes sure that only one webhook per ticket is processed
+// at a time. Webhooks that arrive while the ticket is being processed are
+// merged into a single pending event, which is processed after the current
+// run finishes. This way no update is dropped, and each run works with
+// the latest state of the ticket.
+type ticketSerializer struct {
+	process func(ctx context.Context, payload *WebhookPayload) error
+
+	mu      sync.Mutex
+	tickets map[int]*ticketSerializerEntry
+}
+
+type ticketSerializerEntry struct {
+	// pending is the merged payload of all events received during the current run.
+	pending    *WebhookPayload
+	pendingCtx context.Context
+	waiters    []chan error
+}
+
+func newTicketSerializer(process func(ctx context.Context, payload *WebhookPayload) error) *ticketSerializer {
+	return &ticketSerializer{
+		process: process,
+		tickets: map[int]*ticketSerializerEntry{},
+	}
+}
+
+// Do processes the payload and returns the result. If the ticket is already
+// being processed, the payload is merged with other pending ones and Do
+// returns the result of processing the merged payload.
+func (s *ticketSerializer) Do(ctx context.Context, id int, payload *WebhookPayload) error {
+	ch := make(chan error, 1)
+
+	s.mu.Lock()
+	e, running := s.tickets[id]
+	if running {
+		e.pending = mergePayloads(e.pending, payload)
+		e.pendingCtx = ctx
+		e.waiters = append(e.waiters, ch)
+		s.mu.Unlock()
+	} else {
+		e = &ticketSerializerEntry{}
+		s.tickets[id] = e
+		s.mu.Unlock()
+		go s.run(ctx, id, e, payload, []chan error{ch})
+	}
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *ticketSerializer) run(ctx context.Context, id int, e *ticketSerializerEntry, payload *WebhookPayload, waiters []chan error) {
+	for {
+		err := s.process(ctx, payload)
+		for _, ch := range waiters {
+			ch <- err
+		}
+
+		s.mu.Lock()
+		if e.pending == nil {
+			delete(s.tickets, id)
+			s.mu.Unlock()
+			return
+		}
+		ctx, payload, waiters = e.pendingCtx, e.pending, e.waiters
+		e.pending, e.pendingCtx, e.waiters = nil, nil, nil
+		s.mu.Unlock()
+	}
+}
+
+// mergePayloads combines two webhook payloads for the same ticket. The state
+// of the ticket is taken from the newer one, while journal details are
+// combined, so that actions triggered by changes in any of them
+// (e.g., status change in requestedAppealRevert) are not missed.
+func mergePayloads(older *WebhookPayload, newer *WebhookPayload) *WebhookPayload {
+	if older == nil {
+		return newer
+	}
+
+	merged := *newer
+	if older.Action == "opened" {
+		// Make sure that new ticket processing is not skipped.
+		merged.Action = older.Action
+	}
+	if merged.Issue == nil {
+		merged.Issue = older.Issue
+	}
+	switch {
+	case older.Journal == nil:
+	case newer.Journal == nil:
+		merged.Journal = older.Journal
+	default:
+		journal := *newer.Journal
+		journal.Details = append(append([]JournalDetails{}, older.Journal.Details...), newer.Journal.Details...)
+		merged.Journal = &journal
+	}
+	return &merged
+}
+
+// jobSerializer is the counterpart of ticketSerializer for the job queue:
+// jobs for the same ticket are executed one at a time, in the order they
+// were received, so that retries of an earlier job don't race with the
+// later ones. Unlike webhooks, jobs are not merged, since each of them
+// needs to be removed from the queue separately.
+type jobSerializer struct {
+	run func(ctx context.Context, job *jobqueue.Job)
+
+	mu sync.Mutex
+	// queued holds the jobs waiting for the current one to finish.
+	// A ticket is present in the map while any of its jobs are running.
+	queued map[int][]*jobqueue.Job
+}
+
+func newJobSerializer(run func(ctx context.Context, job *jobqueue.Job)) *jobSerializer {
+	return &jobSerializer{
+		run:    run,
+		queued: map[int][]*jobqueue.Job{},
+	}
+}
+
+// Start executes the job in a new goroutine and returns true, or, if a job
+// for the same ticket is already running, queues it to be executed after
+// that one and returns false. done is called when the started goroutine
+// exits, after it ran the job and all the ones queued behind it.
+func (s *jobSerializer) Start(ctx context.Context, id int, job *jobqueue.Job, done func()) bool {
+	s.mu.Lock()
+	if queued, running := s.queued[id]; running {
+		s.queued[id] = append(queued, job)
+		s.mu.Unlock()
+		return false
+	}
+	s.queued[id] = nil
+	s.mu.Unlock()
+
+	go func() {
+		defer done()
+		for job != nil {
+			s.run(ctx, job)
+
+			s.mu.Lock()
+			if queued := s.queued[id]; len(queued) > 0 {
+				job, s.queued[id] = queued[0], queued[1:]
+			} else {
+				job = nil
+				delete(s.queued, id)
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return true
+}
+
+// Queued returns the IDs of all jobs waiting for their turn.
+func (s *jobSerializer) Queued() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []string{}
+	for _, queued := range s.queued {
+		for _, job := range queued {
+			ids = append(ids, job.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,314 @@
+package redminehandler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+)
+
+// fakeProcessor records payloads passed to it and blocks until released.
+type fakeProcessor struct {
+	mu       sync.Mutex
+	calls    []*WebhookPayload
+	running  int
+	overlaps int
+
+	started chan *WebhookPayload
+	release chan error
+}
+
+func newFakeProcessor() *fakeProcessor {
+	return &fakeProcessor{
+		started: make(chan *WebhookPayload, 100),
+		release: make(chan error),
+	}
+}
+
+func (p *fakeProcessor) process(ctx context.Context, payload *WebhookPayload) error {
+	p.mu.Lock()
+	p.calls = append(p.calls, payload)
+	p.running++
+	if p.running > 1 {
+		p.overlaps++
+	}
+	p.mu.Unlock()
+
+	p.started <- payload
+	err := <-p.release
+
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+	return err
+}
+
+func (p *fakeProcessor) waitStarted(t *testing.T) *WebhookPayload {
+	t.Helper()
+	select {
+	case payload := <-p.started:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for processing to start")
+		return nil
+	}
+}
+
+func (p *fakeProcessor) expectIdle(t *testing.T) {
+	t.Helper()
+	select {
+	case payload := <-p.started:
+		t.Fatalf("Unexpected processing of %+v", payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func testPayload(ticketId int, lockVersion int, journalId int, details ...JournalDetails) *WebhookPayload {
+	return &WebhookPayload{
+		Action: "updated",
+		Issue: &Issue{
+			Id:          ticketId,
+			LockVersion: lockVersion,
+			Status:      &redmine.IdName{Id: 1},
+		},
+		Journal: &Journal{
+			Id:      journalId,
+			Details: details,
+		},
+	}
+}
+
+// doAsync calls s.Do in a goroutine and returns a channel with the result.
+func doAsync(s *ticketSerializer, id int, payload *WebhookPayload) <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- s.Do(context.Background(), id, payload)
+	}()
+	return ch
+}
+
+func waitResult(t *testing.T, ch <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for Do to return")
+		return nil
+	}
+}
+
+// waitPending waits until the payload is recorded as pending for the ticket.
+func waitPending(t *testing.T, s *ticketSerializer, id int, lockVersion int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		e := s.tickets[id]
+		found := e != nil && e.pending != nil && e.pending.Issue.LockVersion == lockVersion
+		s.mu.Unlock()
+		if found {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for payload with lock version %d to become pending", lockVersion)
+}
+
+func TestSerializerSingle(t *testing.T) {
+	p := newFakeProcessor()
+	s := newTicketSerializer(p.process)
+
+	result := doAsync(s, 1, testPayload(1, 1, 10))
+	p.waitStarted(t)
+	p.release <- nil
+	if err := waitResult(t, result); err != nil {
+		t.Errorf("Do() returned %v, want nil", err)
+	}
+	p.expectIdle(t)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.tickets) != 0 {
+		t.Errorf("Serializer still has %d tickets tracked after finishing", len(s.tickets))
+	}
+}
+
+func TestSerializerOverlappingUpdatesAreNotDropped(t *testing.T) {
+	p := newFakeProcessor()
+	s := newTicketSerializer(p.process)
+
+	first := doAsync(s, 1, testPayload(1, 1, 10))
+	p.waitStarted(t)
+
+	// Moderator saves the ticket again while the first update is being processed.
+	second := doAsync(s, 1, testPayload(1, 2, 11))
+	waitPending(t, s, 1, 2)
+	p.expectIdle(t)
+
+	p.release <- nil
+	if err := waitResult(t, first); err != nil {
+		t.Errorf("first Do() returned %v, want nil", err)
+	}
+
+	got := p.waitStarted(t)
+	if got.Issue.LockVersion != 2 {
+		t.Errorf("Second run got lock version %d, want 2", got.Issue.LockVersion)
+	}
+	p.release <- nil
+	if err := waitResult(t, second); err != nil {
+		t.Errorf("second Do() returned %v, want nil", err)
+	}
+	p.expectIdle(t)
+}
+
+func TestSerializerCoalescesPendingUpdates(t *testing.T) {
+	p := newFakeProcessor()
+	s := newTicketSerializer(p.process)
+
+	first := doAsync(s, 1, testPayload(1, 1, 10))
+	p.waitStarted(t)
+
+	pending := []<-chan error{}
+	for v := 2; v <= 4; v++ {
+		pending = append(pending, doAsync(s, 1, testPayload(1, v, 10+v)))
+		waitPending(t, s, 1, v)
+	}
+
+	p.release <- nil
+	if err := waitResult(t, first); err != nil {
+		t.Errorf("first Do() returned %v, want nil", err)
+	}
+
+	got := p.waitStarted(t)
+	if got.Issue.LockVersion != 4 {
+		t.Errorf("Coalesced run got lock version %d, want the latest one (4)", got.Issue.LockVersion)
+	}
+	if got.Journal.Id != 14 {
+		t.Errorf("Coalesced run got journal %d, want the latest one (14)", got.Journal.Id)
+	}
+
+	wantErr := errors.New("failed")
+	p.release <- wantErr
+	for i, ch := range pending {
+		if err := waitResult(t, ch); !errors.Is(err, wantErr) {
+			t.Errorf("pending Do() #%d returned %v, want %v", i, err, wantErr)
+		}
+	}
+	p.expectIdle(t)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.calls) != 2 {
+		t.Errorf("Processed %d times, want 2", len(p.calls))
+	}
+	if p.overlaps != 0 {
+		t.Errorf("Processing of the same ticket overlapped %d times", p.overlaps)
+	}
+}
+
+func TestSerializerUpdateAfterCoalescedRunStartsNewRun(t *testing.T) {
+	p := newFakeProcessor()
+	s := newTicketSerializer(p.process)
+
+	first := doAsync(s, 1, testPayload(1, 1, 10))
+	p.waitStarted(t)
+	second := doAsync(s, 1, testPayload(1, 2, 11))
+	waitPending(t, s, 1, 2)
+	p.release <- nil
+	waitResult(t, first)
+
+	// Arrives while the coalesced run is in progress.
+	p.waitStarted(t)
+	third := doAsync(s, 1, testPayload(1, 3, 12))
+	waitPending(t, s, 1, 3)
+	p.release <- nil
+	waitResult(t, second)
+
+	got := p.waitStarted(t)
+	if got.Issue.LockVersion != 3 {
+		t.Errorf("Third run got lock version %d, want 3", got.Issue.LockVersion)
+	}
+	p.release <- nil
+	waitResult(t, third)
+	p.expectIdle(t)
+}
+
+func TestSerializerDifferentTicketsRunConcurrently(t *testing.T) {
+	p := newFakeProcessor()
+	s := newTicketSerializer(p.process)
+
+	first := doAsync(s, 1, testPayload(1, 1, 10))
+	second := doAsync(s, 2, testPayload(2, 1, 20))
+	p.waitStarted(t)
+	p.waitStarted(t)
+
+	p.release <- nil
+	p.release <- nil
+	waitResult(t, first)
+	waitResult(t, second)
+}
+
+func TestSerializerCancelledWaiter(t *testing.T) {
+	p := newFakeProcessor()
+	s := newTicketSerializer(p.process)
+
+	first := doAsync(s, 1, testPayload(1, 1, 10))
+	p.waitStarted(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Do(ctx, 1, testPayload(1, 2, 11)) }()
+	waitPending(t, s, 1, 2)
+	cancel()
+	if err := waitResult(t, result); !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() returned %v, want %v", err, context.Canceled)
+	}
+
+	// Cancelled caller doesn't prevent the update from being applied.
+	p.release <- nil
+	waitResult(t, first)
+	p.waitStarted(t)
+	p.release <- nil
+	p.expectIdle(t)
+}
+
+func TestMergePayloads(t *testing.T) {
+	statusChange := JournalDetails{Property: "attr", PropKey: "status_id", Value: "5"}
+	noteOnly := testPayload(1, 3, 12)
+
+	older := testPayload(1, 2, 11, statusChange)
+	got := mergePayloads(older, noteOnly)
+	if got.Issue.LockVersion != 3 {
+		t.Errorf("Merged payload has lock version %d, want 3", got.Issue.LockVersion)
+	}
+	if got.Journal.Id != 12 {
+		t.Errorf("Merged payload has journal %d, want 12", got.Journal.Id)
+	}
+	if len(got.Journal.Details) != 1 || got.Journal.Details[0] != statusChange {
+		t.Errorf("Merged payload has journal details %+v, want %+v", got.Journal.Details, []JournalDetails{statusChange})
+	}
+	if len(noteOnly.Journal.Details) != 0 {
+		t.Errorf("mergePayloads modified its argument")
+	}
+
+	opened := testPayload(1, 1, 0)
+	opened.Action = "opened"
+	opened.Journal = nil
+	got = mergePayloads(opened, noteOnly)
+	if got.Action != "opened" {
+		t.Errorf("Merged payload has action %q, want %q", got.Action, "opened")
+	}
+
+	got = mergePayloads(older, &WebhookPayload{Action: "updated", Issue: &Issue{Id: 1, LockVersion: 4}})
+	if got.Journal == nil || got.Journal.Id != 11 {
+		t.Errorf("Merged payload has journal %+v, want the one from the older payload", got.Journal)
+	}
+
+	if got := mergePayloads(nil, noteOnly); got != noteOnly {
+		t.Errorf("mergePayloads(nil, p) = %+v, want p", got)
+	}
+}
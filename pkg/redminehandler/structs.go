@@ -1,7 +1,8 @@
-package main
+package redminehandler
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"bsky.watch/redmine"
@@ -76,3 +77,24 @@ func (i *Issue) CustomField(id int) (*CustomFieldValue, bool) {
 	}
 	return nil, false
 }
+
+// issueFromRedmine converts the ticket returned by Redmine API into the same
+// form as it's sent in webhooks.
+func issueFromRedmine(ticket *redmine.Issue) (*Issue, error) {
+	issue := &Issue{
+		Id:          ticket.Id,
+		Subject:     ticket.Subject,
+		Description: ticket.Description,
+		Status:      ticket.Status,
+		Tracker:     ticket.Tracker,
+		Priority:    ticket.Priority,
+	}
+	for _, cf := range ticket.CustomFields {
+		b, err := json.Marshal(cf.Value)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling value of field %d: %w", cf.Id, err)
+		}
+		issue.CustomFieldValues = append(issue.CustomFieldValues, CustomFieldValue{Id: cf.Id, Name: cf.Name, Value: b})
+	}
+	return issue, nil
+}
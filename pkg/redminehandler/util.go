@@ -1,12 +1,47 @@
-package main
+package redminehandler
 
 import (
 	"fmt"
 	"strings"
 
 	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/tickets"
 )
 
+// UpdateCustomFields adds lists and labels from the config to the possible
+// values of the corresponding custom fields in Redmine.
+func UpdateCustomFields(ticketsClient tickets.Backend, modkitConfig *config.Config) error {
+	fields, err := ticketsClient.CustomFields()
+	if err != nil {
+		return fmt.Errorf("querying custom fields: %w", err)
+	}
+	var addToListField, labelsField *redmine.CustomFieldDefinition
+	for _, cf := range fields {
+		switch cf.Id {
+		case tickets.Mappings().Fields.AddToLists:
+			cf := cf
+			addToListField = &cf
+		case tickets.Mappings().Fields.Labels:
+			cf := cf
+			labelsField = &cf
+		}
+	}
+	if addToListField != nil {
+		if err := updateCustomFieldValues(ticketsClient, addToListField, modkitConfig.ListFieldValues()); err != nil {
+			return err
+		}
+	}
+
+	if labelsField != nil && len(modkitConfig.LabelerPolicies.LabelValueDefinitions) > 0 {
+		if err := updateCustomFieldValues(ticketsClient, labelsField, modkitConfig.LabelFieldValues()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func extractListId(s string) string {
 	words := strings.Split(s, " ")
 	last := words[len(words)-1]
@@ -16,7 +51,7 @@ func extractListId(s string) string {
 	return strings.TrimSuffix(strings.TrimPrefix(last, "["), "]")
 }
 
-func updateCustomFieldValues(ticketsClient *redmine.Client, field *redmine.CustomFieldDefinition, values map[string]string) error {
+func updateCustomFieldValues(ticketsClient tickets.Backend, field *redmine.CustomFieldDefinition, values map[string]string) error {
 	// XXX: Redmine doesn't return this field, so we're just hardcoding it for now.
 	field.EditTagStyle = ptr("check_box")
 
@@ -62,3 +97,7 @@ func updateCustomFieldValues(ticketsClient *redmine.Client, field *redmine.Custo
 	}
 	return nil
 }
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -1,4 +1,4 @@
-package main
+package redminehandler
 
 import (
 	"context"
@@ -12,13 +12,16 @@ import (
 	"github.com/Jille/convreq"
 	"github.com/Jille/convreq/respond"
 	"github.com/rs/zerolog"
-	"golang.org/x/sync/singleflight"
+	"github.com/valkey-io/valkey-go"
 
-	"bsky.watch/redmine"
 	"github.com/bluesky-social/indigo/xrpc"
 
 	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/jobqueue"
 	"bsky.watch/modkit/pkg/metrics"
+	"bsky.watch/modkit/pkg/reload"
+	"bsky.watch/modkit/pkg/reputation"
+	"bsky.watch/modkit/pkg/tickets"
 )
 
 var requestId atomic.Uint64
@@ -26,31 +29,56 @@ var requestId atomic.Uint64
 type handler struct {
 	client            *xrpc.Client
 	listServerURL     string
-	ticketsClient     *redmine.Client
+	ticketsClient     tickets.Backend
 	listUpdateClients map[string]*xrpc.Client
 	config            *config.Config
 	myId              int
+	auth              *webhookAuth
+	dumpPayloads      bool
+	labelerURL        string
+	labelerAdminURL   string
 
-	dedupe *singleflight.Group
+	// jobs is nil if the job queue is not configured.
+	jobs       *jobqueue.Queue
+	serializer *ticketSerializer
+	// reputation is nil if outcomes of tickets are not recorded.
+	reputation *reputation.Store
 
 	wrapped http.HandlerFunc
 }
 
-func NewHandler(ticketsClient *redmine.Client, config *config.Config, client *xrpc.Client, listUpdateClients map[string]*xrpc.Client, listServerUrl string) (*handler, error) {
+// NewHandler returns the handler for Redmine webhooks. If jobs is nil,
+// requested actions are executed synchronously, without retries. If
+// reputationStore is not nil, outcomes of resolved tickets are recorded
+// in the statistics of their reporters. If valkeyClient is not nil,
+// signatures of accepted webhook requests are stored there, so that replays
+// are rejected across restarts and by all running instances.
+func NewHandler(ticketsClient tickets.Backend, config *config.Config, cfg *Config, client *xrpc.Client, listUpdateClients map[string]*xrpc.Client, jobs *jobqueue.Queue, reputationStore *reputation.Store, valkeyClient valkey.Client) (*handler, error) {
 	me, err := ticketsClient.MyAccount()
 	if err != nil {
 		return nil, err
 	}
 
+	auth, err := newWebhookAuth(cfg.WebhookSecret, cfg.WebhookSkipSignature, cfg.WebhookAllowedIPs, valkeyClient)
+	if err != nil {
+		return nil, fmt.Errorf("configuring webhook authentication: %w", err)
+	}
+
 	h := &handler{
 		client:            client,
-		listServerURL:     listServerUrl,
+		listServerURL:     cfg.ListServerURL,
 		ticketsClient:     ticketsClient,
 		listUpdateClients: listUpdateClients,
 		config:            config,
 		myId:              me.Id,
-		dedupe:            &singleflight.Group{},
+		auth:              auth,
+		dumpPayloads:      cfg.DumpPayloads,
+		labelerURL:        cfg.LabelerPublicURL,
+		labelerAdminURL:   cfg.LabelerAdminURL,
+		jobs:              jobs,
+		reputation:        reputationStore,
 	}
+	h.serializer = newTicketSerializer(h.processPayload)
 
 	h.wrapped = convreq.Wrap(h.HandleWebhook)
 
@@ -77,6 +105,21 @@ func (h *handler) HandleWebhook(ctx context.Context, req *http.Request) convreq.
 
 	start := time.Now()
 
+	reject := func(err error) convreq.HttpResponse {
+		reason := "unknown"
+		if authErr, ok := err.(*webhookAuthError); ok {
+			reason = authErr.reason
+		}
+		log.Warn().Err(err).Str("remote_addr", req.RemoteAddr).Str("reason", reason).Msgf("Rejected webhook request: %s", err)
+		webhooksRejected.WithLabelValues(reason).Inc()
+		updateMetrics(false, http.StatusForbidden, start)
+		return respond.Forbidden("forbidden")
+	}
+
+	if err := h.auth.checkSource(req); err != nil {
+		return reject(err)
+	}
+
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		log.Error().Err(err).Msgf("Failed to read request body: %s", err)
@@ -84,7 +127,11 @@ func (h *handler) HandleWebhook(ctx context.Context, req *http.Request) convreq.
 		return respond.BadRequest("failed to read request body")
 	}
 
-	if cfg.DumpPayloads {
+	if err := h.auth.checkSignature(ctx, req, body, time.Now()); err != nil {
+		return reject(err)
+	}
+
+	if h.dumpPayloads {
 		var fullPayload interface{}
 		if err := json.Unmarshal(body, &fullPayload); err != nil {
 			log.Error().Err(err).Msgf("Failed to parse the payload: %s", err)
@@ -117,12 +164,22 @@ func (h *handler) HandleWebhook(ctx context.Context, req *http.Request) convreq.
 		return respond.NoContent("OK")
 	}
 
+	if h.jobs != nil {
+		if err := h.enqueue(ctx, &payload.Payload); err != nil {
+			log.Error().Err(err).Msgf("Failed to enqueue the job: %s", err)
+			updateMetrics(false, http.StatusBadGateway, start)
+			return respond.BadGateway("error")
+		}
+		updateMetrics(true, http.StatusAccepted, start)
+		return respond.Accepted("OK")
+	}
+
 	id := -1
 	if payload.Payload.Issue != nil {
 		id = payload.Payload.Issue.Id
 	}
 
-	_, err, _ = h.dedupe.Do(fmt.Sprint(id), func() (interface{}, error) { return nil, h.processPayload(ctx, &payload.Payload) })
+	err = h.serializer.Do(ctx, id, &payload.Payload)
 	if err != nil {
 		log.Error().Err(err).Msgf("Processing failed: %s", err)
 		updateMetrics(false, http.StatusBadGateway, start)
@@ -133,22 +190,35 @@ func (h *handler) HandleWebhook(ctx context.Context, req *http.Request) convreq.
 	return respond.String("OK")
 }
 
+// processPayload executes the requested action right away. Used when
+// the job queue is not configured.
 func (h *handler) processPayload(ctx context.Context, payload *WebhookPayload) error {
 	log := zerolog.Ctx(ctx)
 
-	switch {
-	case payload.Issue != nil && requestedAddingToLists(payload.Issue):
-		log.Info().Msgf("Requested adding to lists")
-		return h.addToListsAndUpdateStatus(ctx, payload.Issue)
-	case payload.Issue != nil && requestedMetadataUpdate(payload.Issue):
-		log.Info().Msgf("Metadata update request")
-		return h.updateMetadata(ctx, payload.Issue, payload.Action == "opened")
-	case payload.Issue != nil && requestedApplyLabels(payload.Issue):
-		log.Info().Msgf("Requested applying labels")
-		return h.applyLabelsAndUpdateStatus(ctx, payload.Issue)
-	case payload.Issue != nil:
-		log.Info().Msgf("Checking if we need to rewrite links in notes")
-		return h.updateJournalIfNeeded(ctx, payload.Issue)
+	ctx, release := reload.Hold(ctx)
+	defer release()
+
+	jobType := jobTypeForPayload(payload)
+	if jobType == "" {
+		return nil
+	}
+	log.Info().Msgf("Executing %q action", jobType)
+	note, jobErr := h.executeJob(ctx, jobType, payload)
+	switch jobType {
+	case jobUpdateMetadata, jobRewriteLinks:
+		if jobErr != nil {
+			return jobErr
+		}
+	default:
+		if jobErr != nil {
+			log.Error().Err(jobErr).Msgf("Action %q failed: %s", jobType, jobErr)
+		}
+		if err := h.finishJob(ctx, jobType, payload.Issue.Id, note, jobErr); err != nil {
+			return err
+		}
+	}
+	if jobErr == nil {
+		h.recordOutcome(ctx, jobType, payload)
 	}
 	return nil
 }
@@ -1,11 +1,9 @@
-package main
+package redminehandler
 
 import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
@@ -20,6 +18,7 @@ import (
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/xrpc"
 
+	"bsky.watch/modkit/pkg/moderation"
 	"bsky.watch/modkit/pkg/tickets"
 )
 
@@ -30,64 +29,26 @@ func requestedAddingToLists(ticket *Issue) bool {
 		ticket.Status.Id == tickets.Mappings().Statuses.Completed
 }
 
-func (h *handler) getListMemberships(ctx context.Context, did string) (*listserver.Response, error) {
-	if h.listServerURL == "" {
-		return nil, fmt.Errorf("listserver address is not specified")
-	}
-	u, err := url.Parse(h.listServerURL)
-	if err != nil {
-		return nil, fmt.Errorf("parsing %q as a URL: %w", h.listServerURL, err)
-	}
-	query := u.Query()
-	query.Set("subject", did)
-	u.RawQuery = query.Encode()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating a request object: %w", err)
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-	r := &listserver.Response{}
-	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
-		return nil, fmt.Errorf("unmarshaling response: %w", err)
-	}
-	return r, nil
-}
-
-func (h *handler) addToListsAndUpdateStatus(ctx context.Context, ticket *Issue) error {
-	log := zerolog.Ctx(ctx)
-
-	updateOpts := []tickets.TicketOption{tickets.Status(tickets.StatusApplied)}
-
-	text, addErr := h.addToLists(ctx, ticket)
-	redmineTicket, err := h.ticketsClient.Issue(ticket.Id)
+// addToListsAndAccountLabels updates list memberships and, if the ticket
+// has the Labels field, account-level labels.
+func (h *handler) addToListsAndAccountLabels(ctx context.Context, ticket *Issue) (string, error) {
+	text, err := h.addToLists(ctx, ticket)
 	if err != nil {
-		return fmt.Errorf("failed to fetch the current state of the ticket: %w", err)
+		return "", err
 	}
-	updateOpts = append(updateOpts, tickets.WithNote(text))
-	if addErr != nil {
-		log.Error().Err(addErr).Msgf("Failed to update lists: %s", err)
-		updateOpts = []tickets.TicketOption{
-			tickets.Status(tickets.StatusInProgress),
-			tickets.WithNote(fmt.Sprintf("Failed to apply updates: %s", addErr)),
+	if _, found := ticket.CustomField(tickets.Mappings().Fields.Labels); found {
+		// Labels field is optional on account tickets, it needs to be explicitly
+		// enabled for the tracker in Redmine.
+		labelsText, err := h.applyAccountLabels(ctx, ticket)
+		if err != nil {
+			return "", err
 		}
+		text += "\n\n" + labelsText
 	}
-
-	_, err = tickets.Update(ctx, h.ticketsClient, redmineTicket, updateOpts...)
-	if err != nil {
-		return err
-	}
-	return nil
+	return text, nil
 }
 
 func (h *handler) addToLists(ctx context.Context, ticket *Issue) (string, error) {
-	log := zerolog.Ctx(ctx)
 	mappings := tickets.Mappings()
 
 	didField, found := ticket.CustomField(mappings.Fields.DID)
@@ -128,13 +89,42 @@ func (h *handler) addToLists(ctx context.Context, ticket *Issue) (string, error)
 		shouldBeInList[id] = true
 	}
 
-	memberships, err := h.getListMemberships(ctx, did)
+	result, err := h.updateListMemberships(ctx, did, shouldBeInList)
 	if err != nil {
-		return "", fmt.Errorf("failed to get list memberships: %w", err)
+		return "", err
+	}
+
+	lines := []string{}
+	if len(result.added) == 0 && len(result.removed) == 0 {
+		lines = append(lines, "No changes made, list memberships already match the desired state.")
+	}
+	if len(result.added) > 0 {
+		lines = append(lines, "", "Added to:", "")
+		for _, s := range result.added {
+			lines = append(lines, "* "+s)
+		}
+	}
+	if len(result.removed) > 0 {
+		lines = append(lines, "", "Removed from:", "")
+		for _, s := range result.removed {
+			lines = append(lines, "* "+s)
+		}
 	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// updateListMemberships adds or removes the account to/from the lists from the config,
+// so that it's a member of exactly those lists that are set in shouldBeInList.
+func (h *handler) updateListMemberships(ctx context.Context, did string, shouldBeInList map[string]bool) (listUpdateResult, error) {
+	log := zerolog.Ctx(ctx)
 
 	result := listUpdateResult{}
 
+	memberships, err := moderation.ListMemberships(ctx, h.listServerURL, did)
+	if err != nil {
+		return result, fmt.Errorf("failed to get list memberships: %w", err)
+	}
+
 	for owner, client := range h.listUpdateClients {
 		memberships, found := memberships.Results[owner]
 		if !found {
@@ -153,14 +143,14 @@ func (h *handler) addToLists(ctx context.Context, ticket *Issue) (string, error)
 				// Retry in a few seconds, hopefully with the updated rev & CID in membership response.
 				log.Warn().Err(err).Msgf("Update failed due to concurrent writes (will retry): %s", err)
 				time.Sleep(3 * time.Second)
-				m, err := h.getListMemberships(ctx, did)
+				m, err := moderation.ListMemberships(ctx, h.listServerURL, did)
 				if err != nil {
-					return "", fmt.Errorf("failed to get list memberships: %w", err)
+					return result, fmt.Errorf("failed to get list memberships: %w", err)
 				}
 				memberships = m.Results[owner]
 				continue
 			}
-			return "", addErr
+			return result, addErr
 		}
 
 		if addErr != nil {
@@ -168,31 +158,14 @@ func (h *handler) addToLists(ctx context.Context, ticket *Issue) (string, error)
 			memberships.Cid = ""
 			r, err = h.tryAddToLists(ctx, client, owner, did, &memberships, shouldBeInList)
 			if err != nil {
-				return "", err
+				return result, err
 			}
 		}
 
 		result.added = append(result.added, r.added...)
 		result.removed = append(result.removed, r.removed...)
 	}
-
-	lines := []string{}
-	if len(result.added) == 0 && len(result.removed) == 0 {
-		lines = append(lines, "No changes made, list memberships already match the desired state.")
-	}
-	if len(result.added) > 0 {
-		lines = append(lines, "", "Added to:", "")
-		for _, s := range result.added {
-			lines = append(lines, "* "+s)
-		}
-	}
-	if len(result.removed) > 0 {
-		lines = append(lines, "", "Removed from:", "")
-		for _, s := range result.removed {
-			lines = append(lines, "* "+s)
-		}
-	}
-	return strings.Join(lines, "\n"), nil
+	return result, nil
 }
 
 type listUpdateResult struct {
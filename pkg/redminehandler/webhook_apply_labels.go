@@ -1,4 +1,4 @@
-package main
+package redminehandler
 
 import (
 	"bytes"
@@ -8,12 +8,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 
-	"github.com/rs/zerolog"
-
 	"github.com/bluesky-social/indigo/api/atproto"
 
+	"bsky.watch/modkit/pkg/moderation"
 	"bsky.watch/modkit/pkg/tickets"
 )
 
@@ -24,32 +24,6 @@ func requestedApplyLabels(ticket *Issue) bool {
 		ticket.Status.Id == tickets.Mappings().Statuses.Completed
 }
 
-func (h *handler) applyLabelsAndUpdateStatus(ctx context.Context, ticket *Issue) error {
-	log := zerolog.Ctx(ctx)
-
-	updateOpts := []tickets.TicketOption{tickets.Status(tickets.StatusApplied)}
-
-	text, addErr := h.applyLabels(ctx, ticket)
-	redmineTicket, err := h.ticketsClient.Issue(ticket.Id)
-	if err != nil {
-		return fmt.Errorf("failed to fetch the current state of the ticket: %w", err)
-	}
-	updateOpts = append(updateOpts, tickets.WithNote(text))
-	if addErr != nil {
-		log.Error().Err(addErr).Msgf("Failed to update labels: %s", err)
-		updateOpts = []tickets.TicketOption{
-			tickets.Status(tickets.StatusInProgress),
-			tickets.WithNote(fmt.Sprintf("Failed to apply updates: %s", addErr)),
-		}
-	}
-
-	_, err = tickets.Update(ctx, h.ticketsClient, redmineTicket, updateOpts...)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (h *handler) applyLabels(ctx context.Context, ticket *Issue) (string, error) {
 	mappings := tickets.Mappings()
 
@@ -65,6 +39,31 @@ func (h *handler) applyLabels(ctx context.Context, ticket *Issue) (string, error
 		return "", fmt.Errorf("subject not set")
 	}
 
+	return h.applyLabelsFromField(ctx, ticket, subject, nil)
+}
+
+// applyAccountLabels applies labels selected on an account ticket to the account itself.
+// Labels that the labeler syncs from lists (`labelsFromLists`) are left
+// untouched, since the labeler would overwrite any changes to them anyway.
+func (h *handler) applyAccountLabels(ctx context.Context, ticket *Issue) (string, error) {
+	did, err := stringField(ticket, tickets.Mappings().Fields.DID)
+	if err != nil {
+		return "", fmt.Errorf("DID: %w", err)
+	}
+
+	syncedFromLists := map[string]bool{}
+	for label := range h.config.LabelsFromLists {
+		syncedFromLists[label] = true
+	}
+	return h.applyLabelsFromField(ctx, ticket, did, syncedFromLists)
+}
+
+// applyLabelsFromField makes the set of labels on the subject match the values
+// of "Labels" field of the ticket. Labels in unmanaged and in `skipLabels` can't
+// be selected and are never removed.
+func (h *handler) applyLabelsFromField(ctx context.Context, ticket *Issue, subject string, unmanaged map[string]bool) (string, error) {
+	mappings := tickets.Mappings()
+
 	labels, found := ticket.CustomField(mappings.Fields.Labels)
 	if !found {
 		return "", fmt.Errorf("Labels field is not set")
@@ -100,22 +99,26 @@ func (h *handler) applyLabels(ctx context.Context, ticket *Issue) (string, error
 		if !found {
 			return "", fmt.Errorf("missing mapping for %q", id)
 		}
+		if unmanaged[id] {
+			return "", fmt.Errorf("label %q is synced from a list and can't be applied directly, add the account to the list instead", id)
+		}
 		shouldHaveLabel[id] = true
 	}
 
-	labelerClient := *h.client
-	labelerClient.Host = cfg.LabelerPublicURL
-
-	// XXX: our labeler implementation doesn't do pagination and returns
-	// everything in one go, so we don't need to do multiple requests.
-	labelsResp, err := atproto.LabelQueryLabels(ctx, &labelerClient, "", 100, nil, []string{subject})
+	currentLabels, err := moderation.QueryLabels(ctx, h.client, h.labelerURL, subject)
 	if err != nil {
-		return "", fmt.Errorf("failed to query existing labels: %w", err)
+		return "", err
+	}
+	for _, l := range currentLabels {
+		// Labels that modkit doesn't manage are kept as they are.
+		if unmanaged[l.Val] || slices.Contains(h.config.SkipLabels, l.Val) {
+			shouldHaveLabel[l.Val] = true
+		}
 	}
 
 	result := listUpdateResult{}
 
-	r, err := h.tryApplyingLabels(ctx, subject, labelsResp, shouldHaveLabel)
+	r, err := h.tryApplyingLabels(ctx, subject, currentLabels, shouldHaveLabel)
 	if err != nil {
 		return "", err
 	}
@@ -142,7 +145,7 @@ func (h *handler) applyLabels(ctx context.Context, ticket *Issue) (string, error
 	return strings.Join(lines, "\n"), nil
 }
 
-func (h *handler) tryApplyingLabels(ctx context.Context, subject string, currentLabels *atproto.LabelQueryLabels_Output, shouldHaveLabel map[string]bool) (listUpdateResult, error) {
+func (h *handler) tryApplyingLabels(ctx context.Context, subject string, currentLabels []*atproto.LabelDefs_Label, shouldHaveLabel map[string]bool) (listUpdateResult, error) {
 	add := []string{}
 	remove := []string{}
 
@@ -152,7 +155,7 @@ func (h *handler) tryApplyingLabels(ctx context.Context, subject string, current
 		id := label.Identifier
 
 		hasLabel := false
-		for _, l := range currentLabels.Labels {
+		for _, l := range currentLabels {
 			if l.Val != id {
 				continue
 			}
@@ -167,9 +170,9 @@ func (h *handler) tryApplyingLabels(ctx context.Context, subject string, current
 	}
 
 	if len(add) > 0 || len(remove) > 0 {
-		adminUrl, err := url.Parse(cfg.LabelerAdminURL)
+		adminUrl, err := url.Parse(h.labelerAdminURL)
 		if err != nil {
-			return result, fmt.Errorf("failed to parse %q as URL: %w", cfg.LabelerAdminURL, err)
+			return result, fmt.Errorf("failed to parse %q as URL: %w", h.labelerAdminURL, err)
 		}
 		adminUrl.Path = "/label"
 
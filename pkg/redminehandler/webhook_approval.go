@@ -0,0 +1,206 @@
+package redminehandler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/moderation"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// approvalPendingError is returned when some of the selected changes need
+// an approval from another moderator. The job is not retried in this case.
+type approvalPendingError struct {
+	// pending lists the changes that need approval.
+	pending []string
+	// requestedBy is the name of the user that has set the current status.
+	requestedBy string
+}
+
+func (e *approvalPendingError) Error() string {
+	return fmt.Sprintf("approval required for %s", strings.Join(e.pending, ", "))
+}
+
+// note returns the text explaining what is pending.
+func (e *approvalPendingError) note() string {
+	lines := []string{"The following changes need to be approved by another moderator before they can be applied:", ""}
+	for _, s := range e.pending {
+		lines = append(lines, "* "+s)
+	}
+	lines = append(lines, "", fmt.Sprintf("To approve, a moderator other than %s should set themselves as Approver.", e.requestedBy))
+	return strings.Join(lines, "\n")
+}
+
+// checkApproval returns *approvalPendingError if the ticket adds the account
+// or the record to any lists or labels that require approval, and they were
+// not approved by a moderator other than the user who has set the current
+// status (see isApproved).
+func (h *handler) checkApproval(ctx context.Context, ticket *Issue) error {
+	mappings := tickets.Mappings()
+	required := h.config.RequireApproval
+	if len(required.Lists) == 0 && len(required.Labels) == 0 {
+		return nil
+	}
+
+	issue, err := h.ticketsClient.IssueWithJournals(ticket.Id)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the current state of the ticket: %w", err)
+	}
+	requestedBy := lastStatusChange(issue)
+	if h.isApproved(issue, requestedBy) {
+		return nil
+	}
+
+	pending, err := h.changesNeedingApproval(ctx, ticket)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if mappings.Fields.Approver == 0 {
+		return fmt.Errorf("approval is required for %s, but Approver field is missing from the ID mappings", strings.Join(pending, ", "))
+	}
+	r := &approvalPendingError{pending: pending, requestedBy: "the one who changed the status"}
+	if requestedBy != nil && requestedBy.Name != "" {
+		r.requestedBy = requestedBy.Name
+	}
+	return r
+}
+
+// isApproved returns true if the last change of the Approver field was made
+// by the user that it's set to, that user is not the one who has set
+// the current status, and no lists or labels were selected since then.
+// This way, one can't approve on behalf of someone else, and the approval
+// only covers the changes that were there at the time.
+func (h *handler) isApproved(issue *redmine.Issue, requestedBy *redmine.IdName) bool {
+	mappings := tickets.Mappings()
+	if mappings.Fields.Approver == 0 || requestedBy == nil {
+		return false
+	}
+
+	approverField := fmt.Sprint(mappings.Fields.Approver)
+	selectionFields := []string{fmt.Sprint(mappings.Fields.AddToLists), fmt.Sprint(mappings.Fields.Labels)}
+
+	approvedAt, changedAt := -1, -1
+	var approvedBy *redmine.IdName
+	for i, j := range issue.Journals {
+		for _, d := range j.Details {
+			if d.Property != "cf" {
+				continue
+			}
+			switch {
+			case d.Name == approverField:
+				approvedAt, approvedBy = i, j.User
+			case slices.Contains(selectionFields, d.Name):
+				changedAt = i
+			}
+		}
+	}
+	if approvedBy == nil || approvedAt <= changedAt ||
+		approvedBy.Id == requestedBy.Id || approvedBy.Id == h.myId {
+		return false
+	}
+	for _, v := range tickets.FieldValues(issue, mappings.Fields.Approver) {
+		if approver, err := strconv.Atoi(v); err == nil && approver == approvedBy.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// lastStatusChange returns the user who has set the current status of the ticket.
+func lastStatusChange(issue *redmine.Issue) *redmine.IdName {
+	for _, j := range slices.Backward(issue.Journals) {
+		for _, d := range j.Details {
+			if d.Property == "attr" && d.Name == "status_id" {
+				return j.User
+			}
+		}
+	}
+	return issue.Author
+}
+
+// changesNeedingApproval returns descriptions of the lists and labels that
+// are selected in the ticket, require approval and are not applied yet.
+func (h *handler) changesNeedingApproval(ctx context.Context, ticket *Issue) ([]string, error) {
+	mappings := tickets.Mappings()
+	required := h.config.RequireApproval
+
+	isRecord := ticket.Tracker != nil && ticket.Tracker.Id == mappings.TicketTypes.RecordTicket
+	field := mappings.Fields.DID
+	if isRecord {
+		field = mappings.Fields.Subject
+	}
+	subject, err := stringField(ticket, field)
+	if err != nil {
+		return nil, fmt.Errorf("subject: %w", err)
+	}
+
+	pending := []string{}
+
+	if !isRecord {
+		lists, err := idsFromField(ticket, mappings.Fields.AddToLists)
+		if err != nil {
+			return nil, fmt.Errorf("lists: %w", err)
+		}
+		lists = slices.DeleteFunc(lists, func(id string) bool { return !slices.Contains(required.Lists, id) })
+		if len(lists) > 0 {
+			memberships, err := moderation.ListMemberships(ctx, h.listServerURL, subject)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get list memberships: %w", err)
+			}
+			memberOf := moderation.ConfigLists(h.config, memberships)
+			for _, id := range lists {
+				if !slices.Contains(memberOf, id) {
+					pending = append(pending, fmt.Sprintf("adding to %q (`%s`)", h.config.Lists[id].Name, id))
+				}
+			}
+		}
+	}
+
+	labels, err := idsFromField(ticket, mappings.Fields.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("labels: %w", err)
+	}
+	labels = slices.DeleteFunc(labels, func(id string) bool { return !slices.Contains(required.Labels, id) })
+	if len(labels) > 0 {
+		current, err := moderation.QueryLabels(ctx, h.client, h.labelerURL, subject)
+		if err != nil {
+			return nil, err
+		}
+		applied := moderation.LabelValues(current)
+		for _, id := range labels {
+			if !slices.Contains(applied, id) {
+				pending = append(pending, fmt.Sprintf("applying label `%s`", id))
+			}
+		}
+	}
+	return pending, nil
+}
+
+// notifyApprovalPending adds a note explaining what needs to be approved,
+// unless the last note on the ticket already says the same.
+func (h *handler) notifyApprovalPending(ctx context.Context, ticketId int, pending *approvalPendingError) error {
+	ticket, err := h.ticketsClient.IssueWithJournals(ticketId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the current state of the ticket: %w", err)
+	}
+	note := pending.note()
+	for _, j := range slices.Backward(ticket.Journals) {
+		if j.Notes == "" {
+			continue
+		}
+		if j.Notes == note {
+			return nil
+		}
+		break
+	}
+	_, err = tickets.Update(ctx, h.ticketsClient, ticket, tickets.WithNote(note))
+	return err
+}
@@ -0,0 +1,56 @@
+package redminehandler
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// mergeCommand is a note that requests merging the ticket into another one.
+var mergeCommand = regexp.MustCompile(`(?m)^\s*/merge\s+#?(\d+)\s*$`)
+
+// mergeTarget returns the ID of the ticket that the note asks to merge into,
+// or 0 if it doesn't contain the command.
+func mergeTarget(payload *WebhookPayload) int {
+	if payload.Journal == nil {
+		return 0
+	}
+	m := mergeCommand.FindStringSubmatch(payload.Journal.Notes)
+	if m == nil {
+		return 0
+	}
+	id, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+func requestedMerge(payload *WebhookPayload) bool {
+	return mergeTarget(payload) != 0
+}
+
+// mergeTickets merges the ticket into the one specified in the command.
+func (h *handler) mergeTickets(ctx context.Context, payload *WebhookPayload) (string, error) {
+	targetId := mergeTarget(payload)
+	if targetId == payload.Issue.Id {
+		return "", fmt.Errorf("can't merge the ticket into itself")
+	}
+
+	ticket, err := h.ticketsClient.Issue(payload.Issue.Id)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the current state of the ticket: %w", err)
+	}
+	target, err := h.ticketsClient.Issue(targetId)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch ticket %d: %w", targetId, err)
+	}
+
+	if _, err := tickets.Merge(ctx, h.ticketsClient, ticket, target); err != nil {
+		return "", err
+	}
+	return "", nil
+}
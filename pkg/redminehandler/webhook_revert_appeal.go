@@ -0,0 +1,173 @@
+package redminehandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bsky.watch/modkit/pkg/moderation"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// requestedAppealRevert returns true if the appeal ticket was just marked as granted.
+// Unlike other actions, status is not changed after the actions are reverted,
+// so we need to check that the status was changed in this update to avoid
+// doing it again on every subsequent update.
+func requestedAppealRevert(payload *WebhookPayload) bool {
+	ticket := payload.Issue
+	if ticket.Tracker == nil ||
+		ticket.Tracker.Id != tickets.Mappings().TicketTypes.Appeal ||
+		ticket.Status == nil ||
+		tickets.Mappings().Statuses.Granted == 0 ||
+		ticket.Status.Id != tickets.Mappings().Statuses.Granted {
+		return false
+	}
+	if payload.Journal == nil {
+		return false
+	}
+	for _, d := range payload.Journal.Details {
+		if d.Property == "attr" && d.PropKey == "status_id" && d.Value == fmt.Sprint(ticket.Status.Id) {
+			return true
+		}
+	}
+	return false
+}
+
+// revertAppealed removes labels and list memberships that are selected
+// in the appeal ticket.
+func (h *handler) revertAppealed(ctx context.Context, ticket *Issue) (string, error) {
+	mappings := tickets.Mappings()
+
+	did, err := stringField(ticket, mappings.Fields.DID)
+	if err != nil {
+		return "", fmt.Errorf("DID: %w", err)
+	}
+	// Subject is empty for appeals about the account itself.
+	subject, err := stringField(ticket, mappings.Fields.Subject)
+	if err != nil {
+		subject = did
+	}
+
+	labels, err := idsFromField(ticket, mappings.Fields.Labels)
+	if err != nil {
+		return "", fmt.Errorf("labels: %w", err)
+	}
+	lists, err := idsFromField(ticket, mappings.Fields.AddToLists)
+	if err != nil {
+		return "", fmt.Errorf("lists: %w", err)
+	}
+	for _, id := range lists {
+		if _, found := h.config.Lists[id]; !found {
+			return "", fmt.Errorf("missing mapping for %q", id)
+		}
+	}
+
+	removedLabels := []string{}
+	removedLists := []string{}
+
+	if len(labels) > 0 {
+		currentLabels, err := moderation.QueryLabels(ctx, h.client, h.labelerURL, subject)
+		if err != nil {
+			return "", err
+		}
+
+		shouldHaveLabel := map[string]bool{}
+		for _, l := range currentLabels {
+			shouldHaveLabel[l.Val] = true
+		}
+		for _, id := range labels {
+			delete(shouldHaveLabel, id)
+		}
+
+		r, err := h.tryApplyingLabels(ctx, subject, currentLabels, shouldHaveLabel)
+		if err != nil {
+			return "", err
+		}
+		removedLabels = r.removed
+	}
+
+	if len(lists) > 0 {
+		memberships, err := moderation.ListMemberships(ctx, h.listServerURL, did)
+		if err != nil {
+			return "", fmt.Errorf("failed to get list memberships: %w", err)
+		}
+
+		shouldBeInList := map[string]bool{}
+		for _, id := range moderation.ConfigLists(h.config, memberships) {
+			shouldBeInList[id] = true
+		}
+		for _, id := range lists {
+			delete(shouldBeInList, id)
+		}
+
+		r, err := h.updateListMemberships(ctx, did, shouldBeInList)
+		if err != nil {
+			return "", err
+		}
+		if len(r.added) > 0 {
+			// Shouldn't happen, since we're passing current state minus appealed lists.
+			return "", fmt.Errorf("unexpectedly added the account to some lists: %s", strings.Join(r.added, ", "))
+		}
+		removedLists = r.removed
+	}
+
+	if len(removedLabels) == 0 && len(removedLists) == 0 {
+		return "Appeal granted, no changes made: none of the selected labels and lists are currently applied.", nil
+	}
+
+	lines := []string{"Appeal granted."}
+	if len(removedLabels) > 0 {
+		lines = append(lines, "", "Removed labels:", "")
+		for _, s := range removedLabels {
+			lines = append(lines, "* "+s)
+		}
+	}
+	if len(removedLists) > 0 {
+		lines = append(lines, "", "Removed from:", "")
+		for _, s := range removedLists {
+			lines = append(lines, "* "+s)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func stringField(ticket *Issue, id int) (string, error) {
+	field, found := ticket.CustomField(id)
+	if !found {
+		return "", fmt.Errorf("not set")
+	}
+	var s string
+	if err := json.Unmarshal(field.Value, &s); err != nil {
+		return "", fmt.Errorf("failed to parse: %w", err)
+	}
+	if s == "" {
+		return "", fmt.Errorf("not set")
+	}
+	return s, nil
+}
+
+// idsFromField returns IDs extracted from the values of a multi-value field.
+// Missing field is treated as empty.
+func idsFromField(ticket *Issue, id int) ([]string, error) {
+	field, found := ticket.CustomField(id)
+	if !found {
+		return nil, nil
+	}
+	var values []string
+	if err := json.Unmarshal(field.Value, &values); err != nil {
+		return nil, fmt.Errorf("unmarshaling from JSON: %w", err)
+	}
+	r := []string{}
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		id := extractListId(value)
+		if id == "" {
+			return nil, fmt.Errorf("no ID in the value %q", value)
+		}
+		r = append(r, id)
+	}
+	return r, nil
+}
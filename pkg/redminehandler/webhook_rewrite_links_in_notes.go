@@ -1,4 +1,4 @@
-package main
+package redminehandler
 
 import (
 	"context"
@@ -22,7 +22,7 @@ func (h *handler) updateJournalIfNeeded(ctx context.Context, ticket *Issue) erro
 	client := xrpcauth.NewAnonymousClient(ctx)
 	client.Host = "https://public.api.bsky.app"
 
-	redmineTicket, err := h.ticketsClient.IssueWithArgs(ticket.Id, map[string]string{"include": "journals"})
+	redmineTicket, err := h.ticketsClient.IssueWithJournals(ticket.Id)
 	if err != nil {
 		return fmt.Errorf("fetching notes: %w", err)
 	}
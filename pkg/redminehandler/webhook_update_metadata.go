@@ -1,4 +1,4 @@
-package main
+package redminehandler
 
 import (
 	"context"
@@ -118,7 +118,7 @@ func (h *handler) updateMetadata(ctx context.Context, ticket *Issue, newTicket b
 	}
 
 	if newTicket && redmineTicket.Tracker != nil && redmineTicket.Tracker.Id == tickets.Mappings().TicketTypes.Ticket {
-		similarTickets, err := tickets.FindByDID(ctx, h.ticketsClient, did)
+		similarTickets, err := tickets.FindByDID(ctx, h.ticketsClient, did, tickets.OpenTickets)
 		if err != nil {
 			return fmt.Errorf("checking for other tickets for the same DID: %w", err)
 		}
@@ -142,10 +142,9 @@ func (h *handler) updateMetadata(ctx context.Context, ticket *Issue, newTicket b
 
 		}
 
-		if len(dedupeCandidates) > 0 {
-			// TODO: set appropriate duplicate relationship
-			redmineTicket, err = tickets.Update(ctx, h.ticketsClient, redmineTicket,
-				tickets.Status(tickets.StatusDuplicate))
+		if canonical := tickets.SelectDedupeTicket(ctx, dedupeCandidates); canonical != nil {
+			log.Info().Msgf("Marking as a duplicate of %d", canonical.Id)
+			redmineTicket, err = tickets.MarkDuplicate(ctx, h.ticketsClient, redmineTicket, canonical)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,22 @@
+package reload
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var reloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "config",
+	Name:      "reloads_total",
+	Help:      "Number of attempts to reload config and ID mappings, by outcome",
+}, []string{
+	"status",
+})
+
+var lastReload = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "modkit",
+	Subsystem: "config",
+	Name:      "last_reload_timestamp_seconds",
+	Help:      "Time of the last successful reload",
+})
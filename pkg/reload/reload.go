@@ -0,0 +1,203 @@
+// Package reload implements replacing config.yaml and mappings.yaml
+// contents at runtime, without restarting the service.
+package reload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// mu is held for reading by in-flight work and for writing while swapping
+// the config, so that a single unit of work never sees a mix of old and new
+// values.
+var mu sync.RWMutex
+
+type holdKey struct{}
+
+// Hold prevents reloads from taking effect until release is called. Wrap
+// every independent unit of work (processing a report, executing a job)
+// with it. Calling Hold again with the returned context is a no-op, so
+// nested calls don't deadlock when a reload is waiting.
+//
+// A pending reload blocks new calls to Hold, so it should not be held
+// while waiting for anything other than the work itself.
+func Hold(ctx context.Context) (context.Context, func()) {
+	if ctx.Value(holdKey{}) != nil {
+		return ctx, func() {}
+	}
+	mu.RLock()
+	return context.WithValue(ctx, holdKey{}, true), mu.RUnlock
+}
+
+// Reloader re-reads the config and ID mappings and swaps them in
+// after validation.
+type Reloader struct {
+	configPath    string
+	mappingsPath  string
+	config        *config.Config
+	ticketsClient tickets.Backend
+
+	// prepare is called with the new config before swapping it in.
+	// If it fails, the reload is aborted.
+	prepare []func(ctx context.Context, cfg *config.Config) error
+
+	// reloadMu makes sure that only one reload is running at a time.
+	reloadMu sync.Mutex
+}
+
+// New creates a Reloader that updates cfg in place. If mappingsPath is empty,
+// mappings are discovered by names from Redmine on every reload.
+func New(configPath string, mappingsPath string, cfg *config.Config, ticketsClient tickets.Backend) *Reloader {
+	return &Reloader{
+		configPath:    configPath,
+		mappingsPath:  mappingsPath,
+		config:        cfg,
+		ticketsClient: ticketsClient,
+	}
+}
+
+// BeforeSwap registers a function to be called with the new config after it
+// passed validation, but before it is swapped in. Use it for updating
+// anything that the new config depends on, e.g. custom field values
+// in Redmine.
+func (r *Reloader) BeforeSwap(fn func(ctx context.Context, cfg *config.Config) error) {
+	r.prepare = append(r.prepare, fn)
+}
+
+// Reload reads both files and, if they are valid, replaces the current
+// config and mappings. On error nothing is changed.
+func (r *Reloader) Reload(ctx context.Context) error {
+	log := zerolog.Ctx(ctx)
+
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	newConfig, err := config.Load(r.configPath)
+	if err != nil {
+		return err
+	}
+	if err := newConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	r.keepStaticFields(ctx, newConfig)
+
+	var m tickets.IDMappings
+	if r.mappingsPath != "" {
+		m, err = tickets.ReadMappingsFromFile(r.mappingsPath)
+	} else {
+		m, err = tickets.DiscoverMappings(r.ticketsClient)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tickets.CheckMappings(r.ticketsClient, m); err != nil {
+		return err
+	}
+
+	for _, fn := range r.prepare {
+		if err := fn(ctx, newConfig); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	*r.config = *newConfig
+	tickets.SetMappings(m)
+	mu.Unlock()
+
+	log.Info().Msgf("Reloaded config from %q", r.configPath)
+	return nil
+}
+
+// keepStaticFields copies the fields that are only used during startup
+// from the current config, since changing them requires a restart.
+func (r *Reloader) keepStaticFields(ctx context.Context, newConfig *config.Config) {
+	log := zerolog.Ctx(ctx)
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if newConfig.RedmineAPIKey != r.config.RedmineAPIKey {
+		log.Warn().Msgf("Changing redmineApiKey requires a restart, ignoring the new value")
+		newConfig.RedmineAPIKey = r.config.RedmineAPIKey
+	}
+	if newConfig.ModerationAccount != r.config.ModerationAccount {
+		log.Warn().Msgf("Changing moderationAccount requires a restart, ignoring the new value")
+		newConfig.ModerationAccount = r.config.ModerationAccount
+	}
+	if newConfig.TicketIDEncryptionKey != r.config.TicketIDEncryptionKey {
+		log.Warn().Msgf("Changing ticketIDEncryptionKey requires a restart, ignoring the new value")
+		newConfig.TicketIDEncryptionKey = r.config.TicketIDEncryptionKey
+	}
+	if newConfig.EnablePerRecordTickets != r.config.EnablePerRecordTickets {
+		log.Warn().Msgf("Changing enablePerRecordTickets requires a restart, ignoring the new value")
+		newConfig.EnablePerRecordTickets = r.config.EnablePerRecordTickets
+	}
+}
+
+// Run reloads on SIGHUP, and also when either of the files changes if
+// pollInterval is not zero. Returns when the context is cancelled.
+func (r *Reloader) Run(ctx context.Context, pollInterval time.Duration) {
+	log := zerolog.Ctx(ctx)
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	var tick <-chan time.Time
+	if pollInterval > 0 {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	lastModified := r.modTimes()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighup:
+			log.Info().Msgf("Received SIGHUP, reloading config")
+		case <-tick:
+			modified := r.modTimes()
+			if modified == lastModified {
+				continue
+			}
+			log.Info().Msgf("Config files changed, reloading")
+		}
+		lastModified = r.modTimes()
+
+		if err := r.Reload(ctx); err != nil {
+			log.Error().Err(err).Msgf("Failed to reload config, keeping the current one: %s", err)
+			reloads.WithLabelValues("failed").Inc()
+			continue
+		}
+		reloads.WithLabelValues("success").Inc()
+		lastReload.SetToCurrentTime()
+	}
+}
+
+// modTimes returns modification times of both files. Missing files are
+// ignored, reload will report the error if needed.
+func (r *Reloader) modTimes() [2]time.Time {
+	var t [2]time.Time
+	for i, path := range []string{r.configPath, r.mappingsPath} {
+		if path == "" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil {
+			t[i] = fi.ModTime()
+		}
+	}
+	return t
+}
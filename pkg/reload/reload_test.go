@@ -0,0 +1,117 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+func newRedmine() *tickets.MemoryBackend {
+	b := tickets.NewMemoryBackend("modkit")
+	b.AddProject(1, "Tickets")
+	b.SetNames(
+		map[int]string{1: "New", 2: "In progress", 3: "Closed", 4: "Applied", 5: "Duplicate"},
+		map[int]string{1: "Ticket", 2: "Appeal", 3: "Record ticket"},
+		map[int]string{1: "Low", 2: "Normal", 3: "High", 4: "Urgent"})
+	for id, name := range map[int]string{1: "DID", 2: "Handle", 3: "Display name", 4: "Subject", 5: "Add to lists", 6: "Labels"} {
+		b.AddCustomField(redmine.CustomFieldDefinition{Id: id, Name: name, CustomizedType: "issue"})
+	}
+	return b
+}
+
+func writeConfig(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %s", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "redmineApiKey: key\nlists:\n  old:\n    name: Old\n    uri: at://did:plc:mod/app.bsky.graph.list/old\n")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("config.Load: %s", err)
+	}
+	tickets.SetMappings(tickets.IDMappings{})
+	r := New(path, "", cfg, newRedmine())
+
+	prepared := 0
+	r.BeforeSwap(func(ctx context.Context, c *config.Config) error {
+		prepared++
+		return nil
+	})
+
+	writeConfig(t, path, "redmineApiKey: other\nlists:\n  new:\n    name: New\n    uri: at://did:plc:mod/app.bsky.graph.list/new\n")
+	if err := r.Reload(ctx); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if _, found := cfg.Lists["new"]; !found || len(cfg.Lists) != 1 {
+		t.Errorf("Lists after reload: %v, want only %q", cfg.Lists, "new")
+	}
+	if cfg.RedmineAPIKey != "key" {
+		t.Errorf("redmineApiKey was changed to %q by reload", cfg.RedmineAPIKey)
+	}
+	if got := tickets.Mappings().ProjectID; got != 1 {
+		t.Errorf("Project ID after reload is %d, want 1", got)
+	}
+	if prepared != 1 {
+		t.Errorf("BeforeSwap hook was called %d times, want 1", prepared)
+	}
+
+	writeConfig(t, path, "lists:\n  broken:\n    name: Broken\n    uri: https://example.com\n")
+	if err := r.Reload(ctx); err == nil {
+		t.Errorf("Reload succeeded with an invalid config")
+	}
+	if _, found := cfg.Lists["new"]; !found || len(cfg.Lists) != 1 {
+		t.Errorf("Lists after failed reload: %v, want only %q", cfg.Lists, "new")
+	}
+}
+
+func TestHoldBlocksReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "lists: {}\n")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("config.Load: %s", err)
+	}
+	r := New(path, "", cfg, newRedmine())
+
+	holdCtx, release := Hold(ctx)
+	// Nested calls must not wait for the pending reload.
+	_, releaseNested := Hold(holdCtx)
+	releaseNested()
+
+	writeConfig(t, path, "skipLabels: [spam]\n")
+	done := make(chan error)
+	go func() { done <- r.Reload(ctx) }()
+
+	select {
+	case err := <-done:
+		release()
+		t.Fatalf("Reload finished while the config was held: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if len(cfg.SkipLabels) != 0 {
+		t.Errorf("Config changed while held: %v", cfg.SkipLabels)
+	}
+	release()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if len(cfg.SkipLabels) != 1 {
+		t.Errorf("SkipLabels after reload: %v, want [spam]", cfg.SkipLabels)
+	}
+}
@@ -0,0 +1,141 @@
+package reportprocessor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bsky.watch/redmine"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/rs/zerolog"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// Journal ID of the last report note on the ticket, kept for the duration of
+// the aggregation window so that further reports are added to it.
+const aggregateNoteKeyPrefix = "modkit:report-processor:aggregate-note:"
+
+// recentNote returns the report note that was posted on the ticket within
+// the aggregation window, or nil if there is none.
+func (h *handler) recentNote(ctx context.Context, ticketId int) *redmine.Journal {
+	log := zerolog.Ctx(ctx)
+
+	if h.aggregationWindow == 0 || h.valkey == nil {
+		return nil
+	}
+
+	key := h.aggregateNoteKey(ticketId, true)
+	journalId, err := h.valkey.Do(ctx, h.valkey.B().Get().Key(key).Build()).ToString()
+	switch {
+	case valkey.IsValkeyNil(err):
+		return nil
+	case err != nil:
+		log.Warn().Err(err).Msgf("Failed to fetch the ID of the recent report note: %s", err)
+		return nil
+	}
+	id, err := strconv.Atoi(journalId)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Malformed journal ID %q: %s", journalId, err)
+		return nil
+	}
+
+	issue, err := h.ticketsClient.IssueWithJournals(ticketId)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to fetch notes of ticket %d: %s", ticketId, err)
+		return nil
+	}
+	for _, j := range issue.Journals {
+		if j.Id == id {
+			return j
+		}
+	}
+	// Deleted in the meantime.
+	h.valkey.Do(ctx, h.valkey.B().Del().Key(key).Build())
+	return nil
+}
+
+// appendToNote adds the report to the recent note. The text must start with
+// reportHeader. A retried report that is already included is skipped.
+func (h *handler) appendToNote(ctx context.Context, journal *redmine.Journal, report *atproto.ModerationCreateReport_Output, text string) error {
+	if strings.Contains(journal.Notes, reportHeader(report)) {
+		return nil
+	}
+
+	notes := journal.Notes + "\n\n---\n\n" + text
+	if err := h.ticketsClient.UpdateJournal(&redmine.Journal{Id: journal.Id, Notes: notes}); err != nil {
+		return fmt.Errorf("updating note %d: %w", journal.Id, err)
+	}
+	aggregatedReports.Inc()
+	return nil
+}
+
+// postNote adds the note to the ticket along with other updates. If key
+// is not empty, the ID of the new journal entry is stored under it for
+// the duration of ttl, so that the note can be updated later. The note is
+// already posted at that point, so errors are only logged.
+func (h *handler) postNote(ctx context.Context, client tickets.Backend, ticket *redmine.Issue, text string, key string, ttl time.Duration, opts ...tickets.TicketOption) (*redmine.Issue, error) {
+	log := zerolog.Ctx(ctx)
+
+	ticket, err := tickets.Update(ctx, client, ticket, append([]tickets.TicketOption{tickets.WithNote(text)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" || h.valkey == nil {
+		return ticket, nil
+	}
+
+	issue, err := h.ticketsClient.IssueWithJournals(ticket.Id)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to fetch the ID of the note: %s", err)
+		return ticket, nil
+	}
+	text = strings.TrimSpace(text)
+	for _, j := range slices.Backward(issue.Journals) {
+		if strings.TrimSpace(strings.ReplaceAll(j.Notes, "\r\n", "\n")) != text {
+			continue
+		}
+		err := h.valkey.Do(ctx, h.valkey.B().Set().Key(key).Value(fmt.Sprint(j.Id)).Px(ttl).Build()).Error()
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to store the ID of the note: %s", err)
+		}
+		break
+	}
+	return ticket, nil
+}
+
+// aggregateNoteKey returns the key for the ID of the report note that
+// collects further reports, or an empty string if aggregation is disabled.
+func (h *handler) aggregateNoteKey(ticketId int, aggregate bool) string {
+	if !aggregate || h.aggregationWindow == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s%d", aggregateNoteKeyPrefix, ticketId)
+}
+
+// updateWithoutNote applies the attachments and field changes of a report
+// that was added to an earlier note, if there are any.
+func (h *handler) updateWithoutNote(ctx context.Context, client tickets.Backend, ticket *redmine.Issue, uploads []*redmine.Upload, opts ...tickets.TicketOption) error {
+	if len(uploads) > 0 {
+		opts = append(opts, tickets.Attachments(uploads))
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	_, err := tickets.Update(ctx, client, ticket, opts...)
+	return err
+}
+
+// reportHeader returns the line that starts each of the reports in
+// an aggregated note.
+func reportHeader(report *atproto.ModerationCreateReport_Output) string {
+	received := report.CreatedAt
+	if t, err := time.Parse(time.RFC3339, received); err == nil {
+		received = t.UTC().Format(time.DateTime) + " UTC"
+	}
+	return fmt.Sprintf("_Report %d, received at %s_", report.Id, received)
+}
@@ -0,0 +1,199 @@
+package reportprocessor
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"bsky.watch/redmine"
+	"github.com/rs/zerolog"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+
+	"bsky.watch/modkit/pkg/moderation"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+const reasonAppeal = "com.atproto.moderation.defs#reasonAppeal"
+
+func isAppeal(report *atproto.ModerationCreateReport_Output) bool {
+	return report.ReasonType != nil && *report.ReasonType == reasonAppeal
+}
+
+// processAppeal creates a new appeal ticket, or adds a note to an already
+// open one for the same subject. Appeal ticket is linked to the tickets
+// where the appealed actions were taken, and has "Labels" and "Add to lists"
+// fields pre-filled with the currently applied labels and list memberships,
+// so that redmine-handler can revert them if the appeal is accepted.
+func (h *handler) processAppeal(ctx context.Context, subject string, did string, report *atproto.ModerationCreateReport_Output, profile *bsky.ActorDefs_ProfileViewDetailed) (*redmine.Issue, error) {
+	log := zerolog.Ctx(ctx)
+	mappings := tickets.Mappings()
+
+	// Tickets where actions were taken are closed, so we need to include them too.
+	var related []redmine.Issue
+	var err error
+	if subject != did {
+		related, err = tickets.FindBySubject(ctx, h.ticketsClient, subject, tickets.AllTickets)
+	} else {
+		related, err = tickets.FindByDID(ctx, h.ticketsClient, did, tickets.AllTickets)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query for existing tickets for %q: %w", subject, err)
+	}
+
+	reasonText := h.formatReasonTextAsSubscriber(ctx, report)
+
+	if appeal := findOpenAppeal(related, subject, did); appeal != nil {
+		log.Info().Msgf("Adding appeal to the existing ticket %d", appeal.Id)
+		return tickets.Update(ctx, h.ticketsClient, appeal, tickets.WithNote(reasonText))
+	}
+
+	actioned := []redmine.Issue{}
+	for _, t := range related {
+		if t.Tracker != nil && t.Tracker.Id == mappings.TicketTypes.Appeal {
+			continue
+		}
+		if t.Status != nil && t.Status.Id == mappings.Statuses.Applied {
+			actioned = append(actioned, t)
+		}
+	}
+	if len(actioned) == 0 {
+		// Status might have been changed manually after the action was taken,
+		// so just link all tickets about the subject.
+		for _, t := range related {
+			if t.Tracker != nil && t.Tracker.Id == mappings.TicketTypes.Appeal {
+				continue
+			}
+			if t.Status != nil && t.Status.Id == mappings.Statuses.Duplicate {
+				continue
+			}
+			actioned = append(actioned, t)
+		}
+	}
+
+	labels := []string{}
+	applied, err := moderation.QueryLabels(ctx, h.client, h.labelerURL, subject)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to get labels applied to %q: %s", subject, err)
+	} else {
+		labels = moderation.LabelValues(applied)
+	}
+	lists := []string{}
+	if subject == did {
+		memberships, err := moderation.ListMemberships(ctx, h.listServerURL, did)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to get list memberships of %q: %s", did, err)
+		} else {
+			lists = moderation.ConfigLists(h.modkitConfig, memberships)
+		}
+	}
+
+	lines := []string{reasonText, "", fmt.Sprintf("Subject: `%s`", subject)}
+	if len(labels) > 0 {
+		lines = append(lines, "", "Currently applied labels:", "")
+		for _, l := range labels {
+			lines = append(lines, fmt.Sprintf("* `%s`", l))
+		}
+	}
+	if len(lists) > 0 {
+		lines = append(lines, "", "Currently a member of:", "")
+		for _, id := range lists {
+			lines = append(lines, fmt.Sprintf("* %q (`%s`)", h.modkitConfig.Lists[id].Name, id))
+		}
+	}
+	if len(actioned) > 0 {
+		lines = append(lines, "", "Related tickets:", "")
+		for _, t := range actioned {
+			lines = append(lines, fmt.Sprintf("* #%d", t.Id))
+		}
+	}
+	lines = append(lines, "",
+		"Labels and lists selected in this ticket will be removed if the appeal is granted.")
+
+	labelValues := []string{}
+	labelFieldValues := h.modkitConfig.LabelFieldValues()
+	for _, l := range labels {
+		if v, ok := labelFieldValues[l]; ok {
+			labelValues = append(labelValues, v)
+		}
+	}
+	listValues := []string{}
+	listFieldValues := h.modkitConfig.ListFieldValues()
+	for _, id := range lists {
+		listValues = append(listValues, listFieldValues[id])
+	}
+
+	title := fmt.Sprintf("Appeal: %s", profile.Handle)
+	if subject != did {
+		title = fmt.Sprintf("Appeal: %s %s", profile.Handle, path.Base(subject))
+	}
+
+	opts := []tickets.TicketOption{
+		tickets.Subject(title),
+		tickets.DID(did),
+		tickets.Handle(profile.Handle),
+		tickets.Description(strings.Join(lines, "\n")),
+		tickets.Type(tickets.TypeAppeal),
+		tickets.Priority(tickets.PriorityHigh),
+	}
+	if subject != did {
+		// Subject field only accepts at:// URIs, so it's left empty for appeals about the account itself.
+		opts = append(opts, tickets.ReportSubject(subject))
+	}
+	if profile.DisplayName != nil {
+		opts = append(opts, tickets.DisplayName(*profile.DisplayName))
+	}
+	if len(labelValues) > 0 {
+		opts = append(opts, tickets.Labels(labelValues))
+	}
+	if len(listValues) > 0 {
+		opts = append(opts, tickets.AddToLists(listValues))
+	}
+
+	ticket, err := tickets.Create(ctx, h.ticketsClient, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create appeal ticket: %w", err)
+	}
+
+	for _, t := range actioned {
+		_, err = h.ticketsClient.CreateIssueRelation(redmine.IssueRelation{
+			IssueId:      ticket.Id,
+			IssueToId:    t.Id,
+			RelationType: "relates",
+		})
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to create relationship from %d to %d: %s", ticket.Id, t.Id, err)
+		}
+	}
+	return ticket, nil
+}
+
+// findOpenAppeal returns an appeal ticket for the given subject that is not closed yet.
+// Appeals about accounts have an empty subject field.
+func findOpenAppeal(candidates []redmine.Issue, subject string, did string) *redmine.Issue {
+	if subject == did {
+		subject = ""
+	}
+	mappings := tickets.Mappings()
+	for _, t := range candidates {
+		if t.Tracker == nil || t.Tracker.Id != mappings.TicketTypes.Appeal {
+			continue
+		}
+		if t.Status == nil || (t.Status.Id != mappings.Statuses.New && t.Status.Id != mappings.Statuses.InProgress) {
+			continue
+		}
+		ticketSubject := ""
+		for _, cf := range t.CustomFields {
+			if cf.Id == mappings.Fields.Subject && cf.Value != nil {
+				ticketSubject = fmt.Sprint(cf.Value)
+			}
+		}
+		if ticketSubject == subject {
+			r := t
+			return &r
+		}
+	}
+	return nil
+}
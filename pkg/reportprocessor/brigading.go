@@ -0,0 +1,241 @@
+package reportprocessor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bsky.watch/redmine"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/rs/zerolog"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/reportstore"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+const (
+	// Journal ID of the summary note on the ticket, kept for the duration
+	// of the window so that it gets updated instead of adding new notes.
+	brigadingNoteKeyPrefix = "modkit:report-processor:brigading-note:"
+
+	brigadingNoteMarker = "**Suspected brigading**"
+
+	// Maximum number of accounts accepted by app.bsky.graph.getRelationships.
+	maxRelationships = 30
+)
+
+// brigadingResult is the outcome of checking a report for coordinated
+// reporting.
+type brigadingResult struct {
+	// Collapse is true if the report is a part of suspected brigading and
+	// should only be included in the summary note.
+	Collapse bool
+	// Suspicious are the suspicious reports within the window, oldest first.
+	Suspicious []*recentReport
+	// Signals lists the reasons why each of the reports is suspicious,
+	// keyed by report ID.
+	Signals map[uint64][]string
+	Window  time.Duration
+}
+
+// checkBrigading checks if the current report in the history looks like
+// a part of coordinated reporting. Returns nil if detection is disabled or
+// the reporter is a moderator.
+func (h *handler) checkBrigading(entry *reportstore.Report, history *reportHistory) *brigadingResult {
+	cfg := h.modkitConfig.Brigading
+	if cfg.Window == 0 || tickets.UserForDID(entry.Sender) != "" {
+		return nil
+	}
+
+	r := evaluateBrigading(brigadingCandidates(history.Entries, history.Current.Time, &cfg), &cfg)
+	reporters := map[string]bool{}
+	for _, e := range r.Suspicious {
+		reporters[e.Reporter] = true
+	}
+	r.Collapse = len(reporters) >= cfg.MinReporters && len(r.Signals[history.Current.ID]) > 0
+	return r
+}
+
+// brigadingCandidates returns the reports within the brigading window
+// before t, and the ones received after it, skipping reports from moderators.
+func brigadingCandidates(entries []*recentReport, t time.Time, cfg *config.BrigadingConfig) []*recentReport {
+	r := []*recentReport{}
+	for _, e := range entries {
+		if e.Time.After(t.Add(-cfg.Window)) && tickets.UserForDID(e.Reporter) == "" {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// newRecentReport creates the history entry for the report. If brigading
+// detection is enabled, it also collects the information about the reporter.
+// Failures to fetch it are logged and the corresponding signals are
+// treated as absent.
+func (h *handler) newRecentReport(ctx context.Context, entry *reportstore.Report, t time.Time, recent []*recentReport) *recentReport {
+	log := zerolog.Ctx(ctx)
+
+	e := &recentReport{
+		ID:         entry.ID,
+		Time:       t,
+		Reporter:   entry.Sender,
+		Handle:     entry.Sender,
+		ReasonType: entry.ReasonType,
+		Reason:     entry.Reason,
+	}
+	cfg := h.modkitConfig.Brigading
+	if cfg.Window == 0 || tickets.UserForDID(entry.Sender) != "" {
+		return e
+	}
+
+	profile, err := bsky.ActorGetProfile(ctx, h.client, entry.Sender)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to fetch reporter profile %q: %s", entry.Sender, err)
+	} else {
+		e.Handle = profile.Handle
+		if cfg.MaxAccountAge > 0 && profile.CreatedAt != nil {
+			createdAt, err := time.Parse(time.RFC3339, *profile.CreatedAt)
+			if err == nil && t.Sub(createdAt) < cfg.MaxAccountAge {
+				e.NewAccount = true
+			}
+		}
+	}
+
+	if cfg.FollowGraph {
+		others := []string{}
+		// Most recent reporters first.
+		for _, r := range slices.Backward(brigadingCandidates(recent, t, &cfg)) {
+			if r.Reporter != entry.Sender && !slices.Contains(others, r.Reporter) && len(others) < maxRelationships {
+				others = append(others, r.Reporter)
+			}
+		}
+		if len(others) > 0 {
+			resp, err := bsky.GraphGetRelationships(ctx, h.client, entry.Sender, others)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Failed to fetch relationships of %q: %s", entry.Sender, err)
+			} else {
+				for _, r := range resp.Relationships {
+					rel := r.GraphDefs_Relationship
+					if rel != nil && (rel.Following != nil || rel.FollowedBy != nil) {
+						e.Connected = append(e.Connected, rel.Did)
+					}
+				}
+			}
+		}
+	}
+	return e
+}
+
+// evaluateBrigading returns the suspicious entries and the signals
+// for each of them.
+func evaluateBrigading(entries []*recentReport, cfg *config.BrigadingConfig) *brigadingResult {
+	r := &brigadingResult{Signals: map[uint64][]string{}, Window: cfg.Window}
+
+	// Reporters that sent each of the texts.
+	reasons := map[string]map[string]bool{}
+	connected := map[string]bool{}
+	for _, e := range entries {
+		if s := normalizeReason(e.Reason); s != "" {
+			if reasons[s] == nil {
+				reasons[s] = map[string]bool{}
+			}
+			reasons[s][e.Reporter] = true
+		}
+		if len(e.Connected) > 0 {
+			connected[e.Reporter] = true
+			for _, did := range e.Connected {
+				connected[did] = true
+			}
+		}
+	}
+
+	for _, e := range entries {
+		signals := []string{}
+		if cfg.MaxAccountAge > 0 && e.NewAccount {
+			signals = append(signals, "new account")
+		}
+		if cfg.SameReason && len(reasons[normalizeReason(e.Reason)]) > 1 {
+			signals = append(signals, "same text as other reports")
+		}
+		if cfg.FollowGraph && connected[e.Reporter] {
+			signals = append(signals, "follow relationship with other reporters")
+		}
+		if len(signals) > 0 {
+			r.Suspicious = append(r.Suspicious, e)
+			r.Signals[e.ID] = signals
+		}
+	}
+	slices.SortFunc(r.Suspicious, func(a, b *recentReport) int { return a.Time.Compare(b.Time) })
+	return r
+}
+
+func normalizeReason(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// postBrigadingSummary adds or updates the note listing the suspicious
+// reports on the ticket.
+func (h *handler) postBrigadingSummary(ctx context.Context, ticket *redmine.Issue, r *brigadingResult) error {
+	log := zerolog.Ctx(ctx)
+
+	text := formatBrigadingSummary(r)
+	noteKey := fmt.Sprintf("%s%d", brigadingNoteKeyPrefix, ticket.Id)
+
+	journalId, err := h.valkey.Do(ctx, h.valkey.B().Get().Key(noteKey).Build()).ToString()
+	switch {
+	case valkey.IsValkeyNil(err):
+	case err != nil:
+		return fmt.Errorf("fetching the ID of the summary note: %w", err)
+	default:
+		id, err := strconv.Atoi(journalId)
+		if err == nil {
+			err = h.ticketsClient.UpdateJournal(&redmine.Journal{Id: id, Notes: text})
+		}
+		if err == nil {
+			h.valkey.Do(ctx, h.valkey.B().Pexpire().Key(noteKey).Milliseconds(r.Window.Milliseconds()).Build())
+			return nil
+		}
+		log.Warn().Err(err).Msgf("Failed to update the summary note %q, adding a new one: %s", journalId, err)
+	}
+
+	_, err = h.postNote(ctx, h.ticketsClient, ticket, text, noteKey, r.Window)
+	return err
+}
+
+func formatBrigadingSummary(r *brigadingResult) string {
+	reporters := map[string]bool{}
+	for _, e := range r.Suspicious {
+		reporters[e.Reporter] = true
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s: %d reports from %d reporters within %s look coordinated. "+
+			"Further reports like these are only listed here and don't change the ticket's priority.",
+			brigadingNoteMarker, len(r.Suspicious), len(reporters), formatWindow(r.Window)),
+		"",
+	}
+	for _, e := range r.Suspicious {
+		line := fmt.Sprintf("* %s [%s](https://bsky.app/profile/%s) (%s)",
+			e.Time.UTC().Format(time.DateTime), e.Handle, e.Reporter, strings.Join(r.Signals[e.ID], ", "))
+		reason := []string{}
+		if s := reasonTypes[e.ReasonType]; s != "" {
+			reason = append(reason, s)
+		}
+		if s := strings.Join(strings.Fields(e.Reason), " "); s != "" {
+			if r := []rune(s); len(r) > 200 {
+				s = string(r[:200]) + "…"
+			}
+			reason = append(reason, s)
+		}
+		if len(reason) > 0 {
+			line += ": " + strings.Join(reason, ": ")
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
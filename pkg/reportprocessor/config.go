@@ -1,8 +1,9 @@
-package main
+package reportprocessor
 
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -10,8 +11,6 @@ import (
 	"bsky.watch/modkit/pkg/config"
 )
 
-var cfg Config
-
 type Config struct {
 	cliutil.LoggingConfig
 	ConfigPath              string `split_words:"true"`
@@ -22,11 +21,20 @@ type Config struct {
 	RedmineAddr             string `split_words:"true"`
 	RedmineAPIKey           string `split_words:"true"`
 	Mappings                string
-	TicketIDEncryptionKey   string   `split_words:"true"`
-	ListServerURL           string   `split_words:"true"`
-	PersistentValkeyAddr    string   `split_words:"true"`
-	RemoteReportQueueValkey []string `split_words:"true"`
-	EnablePerRecordTickets  bool     `split_words:"true"`
+	TicketIDEncryptionKey   string        `split_words:"true"`
+	ListServerURL           string        `split_words:"true"`
+	PersistentValkeyAddr    string        `split_words:"true"`
+	RemoteReportQueueValkey []string      `split_words:"true"`
+	EnablePerRecordTickets  bool          `split_words:"true"`
+	ConsumerName            string        `split_words:"true"`
+	ClaimIdleTimeout        time.Duration `split_words:"true"`
+	Concurrency             int
+	ReportStore             string        `split_words:"true"`
+	LabelerPublicURL        string        `split_words:"true"`
+	ReloadPollInterval      time.Duration `split_words:"true"`
+	TicketCacheSize         int           `split_words:"true"`
+	TicketCacheTTL          time.Duration `split_words:"true"`
+	NoteAggregationWindow   time.Duration `split_words:"true"`
 }
 
 func (cfg *Config) LoadDefaultsFromConfig(filename string) error {
@@ -0,0 +1,205 @@
+package reportprocessor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"bsky.watch/redmine"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/rs/zerolog"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/reportstore"
+	"bsky.watch/modkit/pkg/tickets"
+	"bsky.watch/modkit/pkg/triage"
+)
+
+const (
+	// Set for the duration of the window after a threshold was crossed,
+	// to escalate only once.
+	escalatedKeyPrefix = "modkit:report-processor:escalated:"
+)
+
+// reportVolume is the number of reports and unique reporters within a window.
+type reportVolume struct {
+	threshold config.EscalationThreshold
+	reports   int
+	reporters int
+}
+
+func (v *reportVolume) String() string {
+	return fmt.Sprintf("%d reports from %d reporters in %s", v.reports, v.reporters, formatWindow(v.threshold.Window))
+}
+
+// escalationPayload is sent to the NotifyURL from the config. Text makes it
+// usable with Slack-compatible incoming webhooks.
+type escalationPayload struct {
+	Text      string `json:"text"`
+	DID       string `json:"did"`
+	Handle    string `json:"handle"`
+	TicketID  int    `json:"ticketId"`
+	Reports   int    `json:"reports"`
+	Reporters int    `json:"reporters"`
+	Window    string `json:"window"`
+}
+
+// checkVolume escalates the account ticket if the recent history of
+// the account crosses any of the thresholds from the config. The report is
+// already posted at this point, so errors are only logged.
+func (h *handler) checkVolume(ctx context.Context, entry *reportstore.Report, history *reportHistory, ticket *redmine.Issue, profile *bsky.ActorDefs_ProfileViewDetailed) {
+	log := zerolog.Ctx(ctx)
+
+	cfg := h.modkitConfig.Escalation
+	if len(cfg.Thresholds) == 0 || history == nil {
+		return
+	}
+
+	crossed := []*reportVolume{}
+	for _, v := range reportVolumes(history, &cfg) {
+		if v.reports < v.threshold.MinReports || v.reporters < v.threshold.MinReporters {
+			continue
+		}
+		key := escalatedKey(entry.SubjectDID, v.threshold)
+		err := h.valkey.Do(ctx, h.valkey.B().Set().Key(key).Value(fmt.Sprint(ticket.Id)).
+			Nx().Px(v.threshold.Window).Build()).Error()
+		if valkey.IsValkeyNil(err) {
+			// Already escalated within this window.
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to record escalation of %s: %s", entry.SubjectDID, err)
+			continue
+		}
+		crossed = append(crossed, v)
+	}
+	if len(crossed) == 0 {
+		return
+	}
+
+	if err := h.escalate(ctx, ticket.Id, crossed); err != nil {
+		log.Error().Err(err).Msgf("Failed to escalate ticket %d: %s", ticket.Id, err)
+		// Let the next report try again.
+		for _, v := range crossed {
+			h.valkey.Do(ctx, h.valkey.B().Del().Key(escalatedKey(entry.SubjectDID, v.threshold)).Build())
+		}
+		return
+	}
+	log.Info().Msgf("Escalated ticket %d: %s", ticket.Id, crossed[0])
+
+	for _, v := range crossed {
+		escalations.WithLabelValues(formatWindow(v.threshold.Window)).Inc()
+		if !v.threshold.Notify || cfg.NotifyURL == "" {
+			continue
+		}
+		err := notifyEscalation(ctx, cfg.NotifyURL, escalationPayload{
+			Text:      fmt.Sprintf("Ticket #%d (%s): %s", ticket.Id, profile.Handle, v),
+			DID:       entry.SubjectDID,
+			Handle:    profile.Handle,
+			TicketID:  ticket.Id,
+			Reports:   v.reports,
+			Reporters: v.reporters,
+			Window:    formatWindow(v.threshold.Window),
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to send escalation notification: %s", err)
+		}
+	}
+}
+
+// reportVolumes returns the volume for each of the thresholds. Reports that
+// were collapsed into a brigading summary are not counted.
+func reportVolumes(history *reportHistory, cfg *config.EscalationConfig) []*reportVolume {
+	t := history.Current.Time
+	r := []*reportVolume{}
+	for _, threshold := range cfg.Thresholds {
+		v := &reportVolume{threshold: threshold}
+		reporters := map[string]bool{}
+		for _, e := range history.Entries {
+			if e.Collapsed || !e.Time.After(t.Add(-threshold.Window)) || e.Time.After(t) {
+				continue
+			}
+			v.reports++
+			reporters[e.Reporter] = true
+		}
+		v.reporters = len(reporters)
+		r = append(r, v)
+	}
+	return r
+}
+
+// escalate adds a summary note to the ticket and raises its priority.
+func (h *handler) escalate(ctx context.Context, ticketId int, crossed []*reportVolume) error {
+	ticket, err := h.ticketsClient.Issue(ticketId)
+	if err != nil {
+		return fmt.Errorf("fetching ticket: %w", err)
+	}
+
+	lines := []string{}
+	target := tickets.PriorityLow
+	for _, v := range crossed {
+		lines = append(lines, fmt.Sprintf("Escalated: %s", v))
+		prio := tickets.PriorityUrgent
+		if v.threshold.Priority != "" {
+			// Already validated when loading the config.
+			prio, _ = triage.ParsePriority(v.threshold.Priority)
+		}
+		target = max(target, prio)
+	}
+
+	opts := []tickets.TicketOption{tickets.WithNote(strings.Join(lines, "\n\n"))}
+	if prio, ok := tickets.GetPriority(ticket); !ok || prio < target {
+		opts = append(opts, tickets.Priority(target))
+	}
+	_, err = tickets.Update(ctx, h.ticketsClient, ticket, opts...)
+	return err
+}
+
+func notifyEscalation(ctx context.Context, url string, payload escalationPayload) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to serialize payload as JSON: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("constructing request object: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("notification rejected: %s %s", resp.Status, string(respBody))
+	}
+	return nil
+}
+
+func escalatedKey(did string, t config.EscalationThreshold) string {
+	return fmt.Sprintf("%s%s:%s:%d:%d", escalatedKeyPrefix, did, t.Window, t.MinReports, t.MinReporters)
+}
+
+// formatWindow formats the duration without trailing zero units,
+// e.g. "30m" instead of "30m0s".
+func formatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+package reportprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/modkit/pkg/reportstore"
+)
+
+const (
+	// Sorted set of recent reports about an account, scored by report time
+	// in milliseconds. Members are JSON-encoded recentReport.
+	historyKeyPrefix = "modkit:report-processor:history:"
+)
+
+// recentReport is a single report in the recent history of an account.
+// Details about the reporter are only collected if brigading detection
+// is enabled.
+type recentReport struct {
+	ID         uint64    `json:"id"`
+	Time       time.Time `json:"time"`
+	Reporter   string    `json:"reporter"`
+	Handle     string    `json:"handle"`
+	ReasonType string    `json:"reasonType"`
+	Reason     string    `json:"reason"`
+	// NewAccount is true if the reporter's account was younger than
+	// MaxAccountAge at the time of the report.
+	NewAccount bool `json:"newAccount,omitempty"`
+	// Connected lists earlier reporters that this one follows or is
+	// followed by.
+	Connected []string `json:"connected,omitempty"`
+	// Collapsed is true if the report was only listed in the brigading
+	// summary note.
+	Collapsed bool `json:"collapsed,omitempty"`
+}
+
+// reportHistory is the sliding window of recent reports about an account.
+// It's the single source of report counts for triage volume rules,
+// escalation, and brigading detection.
+type reportHistory struct {
+	// Entries are the reports within the window, including Current.
+	Entries []*recentReport
+	Current *recentReport
+	// Brigading is nil if brigading detection is disabled or the reporter
+	// is a moderator.
+	Brigading *brigadingResult
+}
+
+// previousReports returns the times of the reports received before
+// the current one.
+func (r *reportHistory) previousReports() []time.Time {
+	times := []time.Time{}
+	for _, e := range r.Entries {
+		if e != r.Current && !e.Time.After(r.Current.Time) {
+			times = append(times, e.Time)
+		}
+	}
+	return times
+}
+
+// historyWindow returns how long reports need to be kept for, or 0 if
+// nothing uses them.
+func (h *handler) historyWindow() time.Duration {
+	return max(h.modkitConfig.Triage.VolumeWindow(), h.modkitConfig.Escalation.MaxWindow(), h.modkitConfig.Brigading.Window)
+}
+
+// trackReport adds the report to the recent history of the account and
+// returns the history. Reports are keyed by their ID, so retries are not
+// counted twice. Returns nil if nothing uses the history.
+func (h *handler) trackReport(ctx context.Context, entry *reportstore.Report) (*reportHistory, error) {
+	log := zerolog.Ctx(ctx)
+
+	window := h.historyWindow()
+	if window == 0 || h.valkey == nil {
+		return nil, nil
+	}
+
+	t := entry.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	key := historyKeyPrefix + entry.SubjectDID
+
+	members, err := h.valkey.Do(ctx, h.valkey.B().Zrangebyscore().Key(key).
+		Min(fmt.Sprintf("(%d", t.Add(-window).UnixMilli())).Max("+inf").Build()).AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("fetching recent reports: %w", err)
+	}
+	r := &reportHistory{}
+	for _, m := range members {
+		e := &recentReport{}
+		if err := json.Unmarshal([]byte(m), e); err != nil {
+			log.Warn().Err(err).Msgf("Skipping malformed entry %q: %s", m, err)
+			continue
+		}
+		if e.ID == entry.ID {
+			// Retrying the same report.
+			r.Current = e
+		}
+		r.Entries = append(r.Entries, e)
+	}
+
+	if r.Current != nil {
+		r.Brigading = h.checkBrigading(entry, r)
+		if r.Brigading != nil {
+			// Stick to the decision made the first time.
+			r.Brigading.Collapse = r.Current.Collapsed
+		}
+		return r, nil
+	}
+
+	r.Current = h.newRecentReport(ctx, entry, t, r.Entries)
+	r.Entries = append(r.Entries, r.Current)
+	r.Brigading = h.checkBrigading(entry, r)
+	r.Current.Collapsed = r.Brigading != nil && r.Brigading.Collapse
+
+	b, err := json.Marshal(r.Current)
+	if err != nil {
+		return nil, err
+	}
+	cmds := valkey.Commands{
+		h.valkey.B().Zadd().Key(key).ScoreMember().ScoreMember(float64(t.UnixMilli()), string(b)).Build(),
+		h.valkey.B().Zremrangebyscore().Key(key).Min("-inf").
+			Max(fmt.Sprintf("(%d", time.Now().Add(-window).UnixMilli())).Build(),
+		h.valkey.B().Pexpire().Key(key).Milliseconds(window.Milliseconds()).Build(),
+	}
+	for _, resp := range h.valkey.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return nil, fmt.Errorf("recording report: %w", err)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,81 @@
+package reportprocessor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var reportsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "reports_processed_total",
+	Help:      "Number of reports received (not excluding retries)",
+}, []string{
+	"remote",
+	"success",
+})
+
+var processingStats = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "report_processing_duration_seconds",
+	Help:      "Time spent on processing reports",
+}, []string{
+	"remote",
+	"success",
+})
+
+var reportsQueued = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "reports_queued",
+	Help:      "Number of reports fetched from the queues and waiting for or undergoing processing",
+})
+
+var triageRuleMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "triage_rule_matches_total",
+	Help:      "Number of reports matched by each triage rule",
+}, []string{
+	"rule",
+})
+
+var escalations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "escalations_total",
+	Help:      "Number of tickets escalated because of the number of reports, by threshold window",
+}, []string{
+	"window",
+})
+
+var deprioritizedReports = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "deprioritized_reports_total",
+	Help:      "Number of reports given low priority because of the reporter's reputation",
+})
+
+var brigadingReports = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "brigading_reports_total",
+	Help:      "Number of reports collapsed into a summary note as suspected brigading",
+})
+
+var aggregatedReports = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "aggregated_reports_total",
+	Help:      "Number of reports added to an earlier note on the same ticket instead of posting a new one",
+})
+
+var unavailableContent = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "report_processor",
+	Name:      "unavailable_content_total",
+	Help:      "Number of reports posted without the reported content because it can't be fetched anymore, by content kind",
+}, []string{
+	"kind",
+})
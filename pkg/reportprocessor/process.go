@@ -0,0 +1,843 @@
+package reportprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bsky.watch/redmine"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/valkey-io/valkey-go"
+
+	"bsky.watch/utils/bskyurl"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"bsky.watch/modkit/pkg/attachments"
+	"bsky.watch/modkit/pkg/config"
+	"bsky.watch/modkit/pkg/format"
+	"bsky.watch/modkit/pkg/records"
+	"bsky.watch/modkit/pkg/reload"
+	"bsky.watch/modkit/pkg/reportqueue"
+	"bsky.watch/modkit/pkg/reportstore"
+	"bsky.watch/modkit/pkg/reputation"
+	"bsky.watch/modkit/pkg/tickets"
+	"bsky.watch/modkit/pkg/triage"
+)
+
+type handler struct {
+	client        *xrpc.Client
+	ticketsClient tickets.Backend
+	idCipher      *reportqueue.IdCipher
+	// persistentValkey is the address of the instance that keeps the state
+	// of report-processor. It also has a report queue.
+	persistentValkey string
+	// valkeyRemotes are the addresses of other instances with report queues.
+	valkeyRemotes []string
+	consumerName  string
+	claimAfter    time.Duration
+	concurrency   int
+	reportStore   reportstore.Store
+	modkitConfig  *config.Config
+	labelerURL    string
+	listServerURL string
+	// valkey is the client for the persistent instance, used for tracking
+	// report history. Set in Run.
+	valkey valkey.Client
+	// reputation keeps reporter statistics in the same instance. Set in Run.
+	reputation *reputation.Store
+	// aggregationWindow is how long a report note keeps collecting further
+	// reports for the same ticket. 0 disables aggregation.
+	aggregationWindow time.Duration
+
+	// userIds and projectIds cache the IDs of users and projects named
+	// in triage rules.
+	userIds    *expirable.LRU[string, int]
+	projectIds *expirable.LRU[string, int]
+
+	enablePerRecordTickets bool
+}
+
+func NewHandler(ctx context.Context, client *xrpc.Client, ticketsClient tickets.Backend, reportStore reportstore.Store, modkitConfig *config.Config, cfg *Config) (*handler, error) {
+	idCipher, err := reportqueue.NewIdCipher(cfg.TicketIDEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &handler{
+		client:           client,
+		ticketsClient:    ticketsClient,
+		idCipher:         idCipher,
+		persistentValkey: cfg.PersistentValkeyAddr,
+		valkeyRemotes:    cfg.RemoteReportQueueValkey,
+		consumerName:     cfg.ConsumerName,
+		claimAfter:       cfg.ClaimIdleTimeout,
+		concurrency:      max(cfg.Concurrency, 1),
+		reportStore:      reportStore,
+		modkitConfig:     modkitConfig,
+		labelerURL:       cfg.LabelerPublicURL,
+		listServerURL:    cfg.ListServerURL,
+
+		userIds:    expirable.NewLRU[string, int](idCacheSize, nil, idCacheTTL),
+		projectIds: expirable.NewLRU[string, int](idCacheSize, nil, idCacheTTL),
+
+		aggregationWindow:      cfg.NoteAggregationWindow,
+		enablePerRecordTickets: cfg.EnablePerRecordTickets,
+	}, nil
+}
+
+type workItem struct {
+	Payload *reportqueue.QueueEntry
+	Remote  *reportqueue.ValkeyConsumer
+	Label   string
+
+	key string
+}
+
+func pullReports(ctx context.Context, client *reportqueue.ValkeyConsumer, label string, out chan<- workItem) {
+	log := zerolog.Ctx(ctx)
+
+	for {
+		r, err := client.GetNextReport(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Error().Err(err).Msgf("Failed to fetch the next report in the queue: %s", err)
+			continue
+		}
+
+		item := workItem{
+			Payload: &r,
+			Remote:  client,
+			Label:   label,
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case out <- item:
+		}
+	}
+}
+
+// touchWaiting resets the idle time of the items that wait to be processed.
+func touchWaiting(ctx context.Context, items []workItem) {
+	tokens := map[*reportqueue.ValkeyConsumer][]string{}
+	labels := map[*reportqueue.ValkeyConsumer]string{}
+	for _, item := range items {
+		tokens[item.Remote] = append(tokens[item.Remote], item.Payload.AckToken)
+		labels[item.Remote] = item.Label
+	}
+	for remote, tokens := range tokens {
+		if err := remote.Touch(ctx, tokens...); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("remote", labels[remote]).Msgf("Failed to refresh waiting reports: %s", err)
+		}
+	}
+}
+
+// processWithRetries processes the report, retrying on errors until
+// the report is either successfully processed or moved to quarantine.
+func (h *handler) processWithRetries(ctx context.Context, item workItem) {
+	log := zerolog.Ctx(ctx).With().Str("remote", item.Label).Logger()
+	ctx = log.WithContext(ctx)
+
+	for {
+		start := time.Now()
+		holdCtx, release := reload.Hold(ctx)
+		err := h.processReport(holdCtx, item.Payload, item.Label)
+		release()
+		processingStats.WithLabelValues(item.Label, fmt.Sprint(err == nil)).Observe(time.Since(start).Seconds())
+		reportsProcessed.WithLabelValues(item.Label, fmt.Sprint(err == nil)).Inc()
+
+		if err == nil {
+			if err := item.Remote.Ack(ctx, item.Payload.AckToken); err != nil {
+				log.Error().Err(err).Msgf("Failed to ack report %q (token=%s): %s", item.Payload.ID, item.Payload.AckToken, err)
+			}
+			return
+		}
+		log.Error().Err(err).Msgf("Failed to process report %q (token=%s): %s", item.Payload.ID, item.Payload.AckToken, err)
+
+		n, err := item.Remote.AttemptCount(ctx, item.Payload.AckToken)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to get attempt count for report %q (token=%s): %s", item.Payload.ID, item.Payload.AckToken, err)
+		} else {
+			if n > 15 {
+				err := item.Remote.Quarantine(ctx, *item.Payload)
+				if err != nil {
+					log.Error().Err(err).Msgf("Failed to move report %q (token=%s) to quarantine: %s", item.Payload.ID, item.Payload.AckToken, err)
+				} else {
+					return
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+
+		entry, err := item.Remote.Redeliver(ctx, item.Payload.AckToken)
+		if err != nil {
+			if errors.Is(err, reportqueue.ErrNotPending) {
+				log.Warn().Msgf("Report %q (token=%s) is not pending anymore, dropping it", item.Payload.ID, item.Payload.AckToken)
+				return
+			}
+			log.Error().Err(err).Msgf("Failed to re-fetch report %q (token=%s): %s", item.Payload.ID, item.Payload.AckToken, err)
+			continue
+		}
+		item.Payload = &entry
+	}
+}
+
+func (h *handler) Run(ctx context.Context) error {
+	log := zerolog.Ctx(ctx)
+
+	// Report history is tracked in the persistent instance.
+	c, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{h.persistentValkey},
+		DisableCache: true,
+	})
+	if err != nil {
+		return fmt.Errorf("creating valkey client for %q: %w", h.persistentValkey, err)
+	}
+	defer c.Close()
+	h.valkey = c
+	h.reputation = reputation.NewStore(c)
+
+	var wg sync.WaitGroup
+	subCtx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	sched := newScheduler(2*h.concurrency, maxQueuedReports)
+	if h.claimAfter > 0 {
+		// Reports waiting for their turn need to be kept claimed, otherwise
+		// other instances would pick them up too.
+		sched.touchEvery = h.claimAfter / 3
+		sched.touch = func(items []workItem) {
+			go touchWaiting(subCtx, items)
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		sched.Run(subCtx)
+		wg.Done()
+	}()
+
+	for i := 0; i < h.concurrency; i++ {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case item := <-sched.work:
+					h.processWithRetries(ctx, item)
+					select {
+					case <-ctx.Done():
+						return
+					case sched.done <- item.key:
+					}
+				}
+			}
+		}(subCtx)
+	}
+
+	for _, addr := range append([]string{h.persistentValkey}, h.valkeyRemotes...) {
+		c, err := valkey.NewClient(valkey.ClientOption{
+			InitAddress: []string{addr},
+			// Client-side caching is not used for streams, and disabling it
+			// allows using servers that don't support it.
+			DisableCache: true,
+		})
+		if err != nil {
+			return fmt.Errorf("creating valkey client for %q: %w", addr, err)
+		}
+
+		client, err := reportqueue.NewValkeyConsumer(ctx, c, "report-processor", h.consumerName, h.claimAfter)
+		if err != nil {
+			return fmt.Errorf("creating queue consumer for %q: %w", addr, err)
+		}
+
+		wg.Add(1)
+		go func(ctx context.Context, client *reportqueue.ValkeyConsumer) {
+			pullReports(ctx, client, addr, sched.in)
+			wg.Done()
+		}(log.With().Str("remote", addr).Logger().WithContext(subCtx), client)
+	}
+
+	<-ctx.Done()
+	log.Info().Msgf("Shutting down...")
+	return ctx.Err()
+}
+
+func (h *handler) processReport(ctx context.Context, report *reportqueue.QueueEntry, remote string) error {
+	log := zerolog.Ctx(ctx).With().Str("sender", report.ReportedBy).
+		Str("report_id", report.ID).Logger()
+
+	subject := ""
+	switch {
+	case report.Report.Subject.RepoStrongRef != nil:
+		subject = report.Report.Subject.RepoStrongRef.Uri
+	case report.Report.Subject.AdminDefs_RepoRef != nil:
+		subject = report.Report.Subject.AdminDefs_RepoRef.Did
+	}
+	if subject == "" {
+		return fmt.Errorf("missing subject")
+	}
+
+	log.Info().Str("subject", subject).Msgf("Received report of %q from %q", subject, report.ReportedBy)
+
+	url, err := bskyurl.DetermineTarget(subject)
+	if err != nil {
+		return fmt.Errorf("failed to parse subject: %w", err)
+	}
+	target, ok := url.(bskyurl.TargetWithProfile)
+	if !ok {
+		return fmt.Errorf("unsupported URI %q", subject)
+	}
+
+	profile, profileUnavailable, err := h.fetchProfile(ctx, target.GetProfile())
+	if err != nil {
+		return err
+	}
+
+	reportResp := &atproto.ModerationCreateReport_Output{
+		Reason:     report.Report.Reason,
+		ReasonType: report.Report.ReasonType,
+		Subject: &atproto.ModerationCreateReport_Output_Subject{
+			AdminDefs_RepoRef: report.Report.Subject.AdminDefs_RepoRef,
+			RepoStrongRef:     report.Report.Subject.RepoStrongRef,
+		},
+		ReportedBy: report.ReportedBy,
+		CreatedAt:  report.Timestamp,
+	}
+
+	reportID, err := strconv.ParseUint(report.ID, 10, 64)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to parse %q as uint64: %s", report.ID, err)
+	} else {
+		reportResp.Id = h.idCipher.Encrypt(reportID)
+	}
+
+	logEntry := &reportstore.Report{
+		ID:          reportID,
+		EncryptedID: reportResp.Id,
+		Sender:      report.ReportedBy,
+		Subject:     subject,
+		SubjectDID:  profile.Did,
+		Remote:      remote,
+	}
+	if report.Report.ReasonType != nil {
+		logEntry.ReasonType = *report.Report.ReasonType
+	}
+	if report.Report.Reason != nil {
+		logEntry.Reason = *report.Report.Reason
+	}
+	if t, err := time.Parse(time.RFC3339, report.Timestamp); err == nil {
+		logEntry.Timestamp = t
+	} else {
+		log.Warn().Err(err).Msgf("Failed to parse report timestamp %q: %s", report.Timestamp, err)
+	}
+
+	if isAppeal(reportResp) {
+		ticket, err := h.processAppeal(ctx, subject, profile.Did, reportResp, profile)
+		if err != nil {
+			return fmt.Errorf("failed to process appeal: %w", err)
+		}
+		log.Info().Msgf("Appeal ticket ID: %d", ticket.Id)
+		logEntry.AccountTicketID = ticket.Id
+		h.logReport(ctx, logEntry)
+		return nil
+	}
+
+	history, err := h.trackReport(ctx, logEntry)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to update the history of reports about %s: %s", logEntry.SubjectDID, err)
+	}
+	decision := h.triageReport(ctx, logEntry, profile, history)
+	var brigading *brigadingResult
+	if history != nil {
+		brigading = history.Brigading
+	}
+
+	existing, err := tickets.FindByDID(ctx, h.ticketsClient, target.GetProfile(), tickets.OpenTickets)
+	if err != nil {
+		return fmt.Errorf("failed to query for existing tickets for %q: %w", target.GetProfile(), err)
+	}
+	ticket := tickets.SelectDedupeTicket(ctx, existing)
+	if ticket == nil {
+		ticket, err = h.createAccountTicket(ctx, target.GetProfile(), report.ReportedBy, profile, profileUnavailable, reportResp.Id, decision)
+		if err != nil {
+			return fmt.Errorf("failed to create ticket: %w", err)
+		}
+	}
+
+	if brigading != nil && brigading.Collapse {
+		// Only listed in the summary note, without escalating the ticket.
+		if err := h.postBrigadingSummary(ctx, ticket, brigading); err != nil {
+			return fmt.Errorf("failed to post brigading summary: %w", err)
+		}
+		h.recordReporter(ctx, ticket.Id, report.ReportedBy)
+		log.Info().Msgf("Suspected brigading, report added to the summary note on ticket %d", ticket.Id)
+		brigadingReports.Inc()
+		logEntry.AccountTicketID = ticket.Id
+		h.logReport(ctx, logEntry)
+		return nil
+	}
+
+	recordTarget, isRecord := target.(bskyurl.TargetRecord)
+	perRecord := isRecord && h.enablePerRecordTickets
+	switch decision.Tracker {
+	case "ticket":
+		perRecord = false
+	case "recordTicket":
+		perRecord = isRecord && tickets.Mappings().TicketTypes.RecordTicket != 0
+	}
+
+	if perRecord {
+		// One ticket for each unique subject.
+		uri, err := makeNormalizedURI(ctx, h.client, recordTarget)
+		if err != nil {
+			return fmt.Errorf("failed to generate normalized URI: %w", err)
+		}
+
+		record, err := h.fetchRecord(ctx, recordTarget, reportResp)
+		if err != nil {
+			return fmt.Errorf("fetching post: %w", err)
+		}
+
+		existing, err := tickets.FindBySubject(ctx, h.ticketsClient, uri, tickets.OpenTickets)
+		if err != nil {
+			return fmt.Errorf("failed to query for existing tickets for %q: %w", uri, err)
+		}
+
+		recordTicket := tickets.SelectDedupeTicket(ctx, existing)
+		if recordTicket == nil {
+			recordTicket, err = h.createRecordTicket(ctx, uri, recordTarget.GetRKey(), record, recordTarget.GetProfile(), report.ReportedBy, profile, ticket, decision)
+			if err != nil {
+				return fmt.Errorf("failed to create ticket: %w", err)
+			}
+		}
+
+		err = h.postReport(ctx, recordTicket, reportResp, record, decision)
+		if err != nil {
+			return err
+		}
+		h.addWatchers(ctx, recordTicket.Id, decision)
+		h.recordReporter(ctx, recordTicket.Id, report.ReportedBy)
+
+		log.Info().Msgf("Ticket ID: %d, Account ticket ID: %d", recordTicket.Id, ticket.Id)
+		logEntry.RecordTicketID = recordTicket.Id
+	} else {
+		// One ticket per account (reports for all records go into the same ticket).
+		err = h.postReportOnAccountTicket(ctx, ticket, reportResp, target, profile, profileUnavailable, decision)
+		if err != nil {
+			return fmt.Errorf("failed to update ticket: %w", err)
+		}
+		h.addWatchers(ctx, ticket.Id, decision)
+		h.recordReporter(ctx, ticket.Id, report.ReportedBy)
+		log.Info().Msgf("Ticket ID: %d", ticket.Id)
+	}
+	h.checkVolume(ctx, logEntry, history, ticket, profile)
+	logEntry.AccountTicketID = ticket.Id
+	h.logReport(ctx, logEntry)
+	return nil
+}
+
+// logReport writes the report into the report store, if one is configured.
+// Report is already posted to the ticket at this point, so there's no point
+// in retrying it if we fail to record it, and errors are only logged.
+func (h *handler) logReport(ctx context.Context, entry *reportstore.Report) {
+	if h.reportStore == nil || entry.EncryptedID == 0 {
+		return
+	}
+	if err := h.reportStore.Add(ctx, entry); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("Failed to write report %d into the report log: %s", entry.ID, err)
+	}
+}
+
+func (h *handler) getTicketsClient(userDID string) tickets.Backend {
+	userID := tickets.UserForDID(userDID)
+	if userID != "" {
+		// TODO: check if the user actually exist and fallback to
+		// default behaviour if it doesn't.
+		return h.ticketsClient.Impersonate(userID)
+	}
+	return h.ticketsClient
+}
+
+// createAccountTicket creates a new ticket for the account. If the profile
+// is unavailable, the description explains why instead.
+func (h *handler) createAccountTicket(ctx context.Context, did string, reportedBy string, profile *bsky.ActorDefs_ProfileViewDetailed, profileUnavailable string, reportId int64, decision triage.Decision) (*redmine.Issue, error) {
+	userID := tickets.UserForDID(reportedBy)
+	ticketsClient := h.getTicketsClient(reportedBy)
+	uploader := attachments.NewGlobalAttachmentCreator(ticketsClient)
+
+	profileText := ""
+	if profileUnavailable != "" {
+		profileText = h.profileNotice(ctx, did, profileUnavailable, reportId)
+	} else {
+		var err error
+		profileText, err = format.Profile(ctx, profile, uploader)
+		if err != nil {
+			return nil, fmt.Errorf("formatting profile: %w", err)
+		}
+	}
+
+	opts := []tickets.TicketOption{
+		tickets.Subject(profile.Handle),
+		tickets.DID(did),
+		tickets.Handle(profile.Handle),
+		tickets.Description(profileText),
+		tickets.Attachments(uploader.Created()),
+		tickets.Type(tickets.TypeTicket),
+	}
+	if profile.DisplayName != nil {
+		opts = append(opts, tickets.DisplayName(*profile.DisplayName))
+	}
+	opts = append(opts, h.creationOptions(ctx, decision, userID != "")...)
+
+	return tickets.Create(ctx, ticketsClient, opts...)
+}
+
+func (h *handler) createRecordTicket(ctx context.Context, uri string, rkey string, record *records.Record, did string, reportedBy string, profile *bsky.ActorDefs_ProfileViewDetailed, accountTicket *redmine.Issue, decision triage.Decision) (*redmine.Issue, error) {
+	userID := tickets.UserForDID(reportedBy)
+	ticketsClient := h.getTicketsClient(reportedBy)
+	uploader := attachments.NewGlobalAttachmentCreator(ticketsClient)
+
+	text := ""
+	if notice := record.Notice(); notice != "" {
+		text += notice + "\n\n"
+	}
+	var value any
+	if record.Value != nil {
+		value = record.Value.Val
+	}
+	switch record := value.(type) {
+	case nil:
+		// Deleted, explained by the notice.
+	case *bsky.FeedPost:
+		postText, err := format.Post(ctx, h.client, record, profile, rkey, uploader)
+		if err != nil {
+			return nil, fmt.Errorf("formatting post: %w", err)
+		}
+		text += postText
+
+		if b, err := json.MarshalIndent(record, "", "  "); err == nil {
+			if _, err := uploader.Upload(ctx, fmt.Sprintf("post_%s.json", rkey), b); err != nil {
+				log.Warn().Err(err).Msgf("Failed to upload post.json: %s", err)
+			}
+		}
+	default:
+		b, err := json.MarshalIndent(record, "", "  ")
+		if err == nil {
+			if _, err := uploader.Upload(ctx, fmt.Sprintf("record_%s.json", rkey), b); err != nil {
+				log.Warn().Err(err).Msgf("Failed to upload record.json: %s", err)
+			}
+
+			text += fmt.Sprintf("```json\n%s\n```", string(b))
+		}
+	}
+
+	opts := []tickets.TicketOption{
+		tickets.Subject(fmt.Sprintf("%s %s", profile.Handle, rkey)),
+		tickets.DID(did),
+		tickets.Handle(profile.Handle),
+		tickets.Description(text),
+		tickets.Attachments(uploader.Created()),
+		tickets.Type(tickets.TypeRecordTicket),
+		tickets.ReportSubject(uri),
+	}
+	if profile.DisplayName != nil {
+		opts = append(opts, tickets.DisplayName(*profile.DisplayName))
+	}
+	opts = append(opts, h.creationOptions(ctx, decision, userID != "")...)
+
+	ticket, err := tickets.Create(ctx, ticketsClient, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ticketsClient.CreateIssueRelation(redmine.IssueRelation{
+		IssueId:      ticket.Id,
+		IssueToId:    accountTicket.Id,
+		RelationType: "relates",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+var reasonTypes = map[string]string{
+	"com.atproto.moderation.defs#reasonOther":      "",
+	"com.atproto.moderation.defs#reasonSpam":       "Spam",
+	"com.atproto.moderation.defs#reasonViolation":  "ToS violation",
+	"com.atproto.moderation.defs#reasonMisleading": "Impersonation",
+	"com.atproto.moderation.defs#reasonSexual":     "Sexual content",
+	"com.atproto.moderation.defs#reasonRude":       "Antisocial behavior",
+	"com.atproto.moderation.defs#reasonAppeal":     "Appeal",
+}
+
+// reportedCID returns the CID of the reported record version, if the report
+// has one.
+func reportedCID(report *atproto.ModerationCreateReport_Output) string {
+	if report.Subject == nil || report.Subject.RepoStrongRef == nil {
+		return ""
+	}
+	return report.Subject.RepoStrongRef.Cid
+}
+
+func (h *handler) reasonTypeText(report *atproto.ModerationCreateReport_Output) string {
+	if report.ReasonType == nil {
+		return ""
+	}
+	if s, found := reasonTypes[*report.ReasonType]; found {
+		return s
+	}
+	return fmt.Sprintf("`%s`", *report.ReasonType)
+}
+
+func (h *handler) formatReasonTextAsModerator(report *atproto.ModerationCreateReport_Output) string {
+	parts := []string{}
+	reasonTypeText := h.reasonTypeText(report)
+	if reasonTypeText != "" {
+		parts = append(parts, reasonTypeText)
+	}
+	if report.Reason != nil && *report.Reason != "" {
+		parts = append(parts, *report.Reason)
+	}
+	return strings.Join(parts, ": ")
+}
+
+func (h *handler) formatReasonTextAsSubscriber(ctx context.Context, report *atproto.ModerationCreateReport_Output) string {
+	reporterDisplayName := report.ReportedBy
+	reporter, err := bsky.ActorGetProfile(ctx, h.client, report.ReportedBy)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to fetch reporter profile %q: %s", report.ReportedBy, err)
+	} else {
+		reporterDisplayName = reporter.Handle
+		if reporter.DisplayName != nil {
+			reporterDisplayName = fmt.Sprintf("%s (%s)", *reporter.DisplayName, reporter.Handle)
+		}
+	}
+
+	reportedBy := fmt.Sprintf("Reported by [%s](https://bsky.app/profile/%s)", reporterDisplayName, report.ReportedBy)
+	if s := h.formatReputation(h.reporterStats(ctx, report.ReportedBy)); s != "" {
+		reportedBy += ", " + s
+	}
+	parts := []string{reportedBy}
+
+	reasonTypeText := h.reasonTypeText(report)
+	if reasonTypeText != "" {
+		parts = append(parts, reasonTypeText)
+	}
+
+	if report.Reason != nil && *report.Reason != "" {
+		s := ""
+		for _, line := range strings.Split(*report.Reason, "\n") {
+			s += "> " + line + "\n"
+		}
+		parts = append(parts, s)
+
+	}
+
+	return strings.Join(parts, ":\n\n")
+}
+
+func (h *handler) postReportOnAccountTicket(ctx context.Context, ticket *redmine.Issue, report *atproto.ModerationCreateReport_Output, url bskyurl.TargetWithProfile, profile *bsky.ActorDefs_ProfileViewDetailed, profileUnavailable string, decision triage.Decision) error {
+	log := zerolog.Ctx(ctx)
+
+	userID := tickets.UserForDID(report.ReportedBy)
+	ticketsClient := h.getTicketsClient(report.ReportedBy)
+	uploader := attachments.NewGlobalAttachmentCreator(ticketsClient)
+
+	text := ""
+	reasonText := ""
+
+	if userID != "" {
+		reasonText = h.formatReasonTextAsModerator(report)
+	} else {
+		reasonText = h.formatReasonTextAsSubscriber(ctx, report)
+	}
+
+	// Reports from moderators always get their own note.
+	aggregate := userID == "" && h.aggregationWindow > 0
+	var recent *redmine.Journal
+	if aggregate {
+		recent = h.recentNote(ctx, ticket.Id)
+	}
+
+	switch t := url.(type) {
+	case *bskyurl.Post:
+		record, err := h.fetchRecord(ctx, t, report)
+		if err != nil {
+			return fmt.Errorf("fetching post: %w", err)
+		}
+		if notice := record.Notice(); notice != "" {
+			text += notice + "\n\n"
+		}
+		if record.Value == nil {
+			break
+		}
+		post, ok := record.Value.Val.(*bsky.FeedPost)
+		if !ok {
+			return fmt.Errorf("post if on unexpected type %T", record.Value.Val)
+		}
+		postText, err := format.Post(ctx, h.client, post, profile, t.Rkey, uploader)
+		if err != nil {
+			return fmt.Errorf("formatting post: %w", err)
+		}
+		text += postText + "\n\n"
+
+		if b, err := json.MarshalIndent(post, "", "  "); err == nil {
+			if _, err := uploader.Upload(ctx, fmt.Sprintf("post_%s.json", t.Rkey), b); err != nil {
+				log.Warn().Err(err).Msgf("Failed to upload post.json: %s", err)
+			}
+		}
+
+		if ticket == nil {
+			profileText, err := format.Profile(ctx, profile, uploader)
+			if err != nil {
+				return fmt.Errorf("formatting profile: %w", err)
+			}
+			text += profileText
+		}
+	case *bskyurl.Profile:
+		if recent != nil {
+			// Already included in the note.
+			break
+		}
+		if profileUnavailable != "" {
+			text += h.profileNotice(ctx, t.Profile, profileUnavailable, report.Id)
+			break
+		}
+		profileText, err := format.Profile(ctx, profile, uploader)
+		if err != nil {
+			return fmt.Errorf("formatting profile: %w", err)
+		}
+		text += profileText
+	default:
+		reportSubject := "failed to determine report subject"
+		switch {
+		case report.Subject.RepoStrongRef != nil:
+			reportSubject = report.Subject.RepoStrongRef.Uri
+		case report.Subject.AdminDefs_RepoRef != nil:
+			reportSubject = report.Subject.AdminDefs_RepoRef.Did
+		}
+		text += fmt.Sprintf("\n`%s`\n", reportSubject)
+	}
+
+	if recent == nil && profileUnavailable == "" {
+		if b, err := json.MarshalIndent(profile, "", "  "); err == nil {
+			if _, err := uploader.Upload(ctx, fmt.Sprintf("profile_%s.json", time.Now().Format("20060102_030405")), b); err != nil {
+				log.Warn().Err(err).Msgf("Failed to upload profile.json: %s", err)
+			}
+		}
+	}
+
+	if reasonText != "" {
+		text = reasonText + "\n\n" + text
+	}
+	if aggregate {
+		text = reportHeader(report) + "\n\n" + text
+	}
+	if recent != nil {
+		if err := h.appendToNote(ctx, recent, report, text); err != nil {
+			return err
+		}
+		return h.updateWithoutNote(ctx, ticketsClient, ticket, uploader.Created(),
+			h.updateOptions(ctx, ticket, decision, true)...)
+	}
+
+	// progress := 0
+	// if ticket.PercentageDone != nil {
+	// 	progress = *ticket.PercentageDone
+	// }
+	// if progress >= 90 {
+	// 	updates = append(updates, tickets.Status(tickets.StatusInProgress))
+	// }
+
+	updates := h.updateOptions(ctx, ticket, decision, userID == "")
+	updates = append(updates, tickets.Attachments(uploader.Created()))
+
+	_, err := h.postNote(ctx, ticketsClient, ticket, text, h.aggregateNoteKey(ticket.Id, aggregate), h.aggregationWindow, updates...)
+	return err
+}
+
+func (h *handler) postReport(ctx context.Context, ticket *redmine.Issue, report *atproto.ModerationCreateReport_Output, record *records.Record, decision triage.Decision) error {
+	userID := tickets.UserForDID(report.ReportedBy)
+	ticketsClient := h.getTicketsClient(report.ReportedBy)
+	uploader := attachments.NewGlobalAttachmentCreator(ticketsClient)
+
+	text := ""
+
+	if userID != "" {
+		text = h.formatReasonTextAsModerator(report)
+	} else {
+		text = h.formatReasonTextAsSubscriber(ctx, report)
+	}
+	if notice := record.Notice(); notice != "" {
+		text = strings.TrimSpace(text + "\n\n" + notice)
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling report: %w", err)
+	}
+	if _, err := uploader.Upload(ctx, fmt.Sprintf("report_%s_%s.json", report.ReportedBy, time.Now().Format(time.DateOnly)), b); err != nil {
+		return fmt.Errorf("uploading report: %w", err)
+	}
+
+	aggregate := userID == "" && h.aggregationWindow > 0
+	if aggregate {
+		text = reportHeader(report) + "\n\n" + text
+		if recent := h.recentNote(ctx, ticket.Id); recent != nil {
+			if err := h.appendToNote(ctx, recent, report, text); err != nil {
+				return err
+			}
+			return h.updateWithoutNote(ctx, ticketsClient, ticket, uploader.Created(),
+				h.updateOptions(ctx, ticket, decision, false)...)
+		}
+	}
+
+	updates := []tickets.TicketOption{tickets.Attachments(uploader.Created())}
+	updates = append(updates, h.updateOptions(ctx, ticket, decision, false)...)
+	_, err = h.postNote(ctx, ticketsClient, ticket, text, h.aggregateNoteKey(ticket.Id, aggregate), h.aggregationWindow, updates...)
+	return err
+}
+
+func makeNormalizedURI(ctx context.Context, client *xrpc.Client, target bskyurl.TargetRecord) (string, error) {
+	profile := target.GetProfile()
+	if !strings.HasPrefix(profile, "did:") {
+		resp, err := atproto.IdentityResolveHandle(ctx, client, profile)
+		if err != nil {
+			return "", err
+		}
+		profile = resp.Did
+	}
+
+	var u url.URL
+	u.Scheme = "at"
+	u.Host = profile
+	u.Path = path.Join("/", target.GetCollection(), target.GetRKey())
+
+	return u.String(), nil
+}
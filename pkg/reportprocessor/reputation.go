@@ -0,0 +1,62 @@
+package reportprocessor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"bsky.watch/modkit/pkg/reputation"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// reporterStats returns the statistics of the reporter, or nil if they are
+// not available. Reports from moderators are not tracked.
+func (h *handler) reporterStats(ctx context.Context, did string) *reputation.Stats {
+	if h.reputation == nil || tickets.UserForDID(did) != "" {
+		return nil
+	}
+	stats, err := h.reputation.Get(ctx, did)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msgf("Failed to fetch reporter statistics: %s", err)
+		return nil
+	}
+	return &stats
+}
+
+// reporterScore returns the reputation score, or nil if not enough reports
+// from the reporter were resolved yet.
+func (h *handler) reporterScore(stats *reputation.Stats) *float64 {
+	if stats == nil {
+		return nil
+	}
+	score, ok := stats.Score(h.modkitConfig.Reputation.MinResolvedTickets())
+	if !ok {
+		return nil
+	}
+	return &score
+}
+
+// formatReputation returns the text describing the reporter's statistics
+// for the report note, or an empty string if there are none.
+func (h *handler) formatReputation(stats *reputation.Stats) string {
+	if stats == nil || stats.Resolved() == 0 {
+		return ""
+	}
+	if score := h.reporterScore(stats); score != nil {
+		return fmt.Sprintf("reputation %.2f (%s)", *score, stats)
+	}
+	return fmt.Sprintf("no reputation score yet (%s)", stats)
+}
+
+// recordReporter adds the reporter to the ticket, so that the outcome
+// of the ticket counts towards their statistics. The report is already
+// posted at this point, so errors are only logged.
+func (h *handler) recordReporter(ctx context.Context, ticketId int, did string) {
+	if h.reputation == nil || tickets.UserForDID(did) != "" {
+		return
+	}
+	if err := h.reputation.AddReport(ctx, ticketId, did); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("Failed to update reporter statistics: %s", err)
+	}
+}
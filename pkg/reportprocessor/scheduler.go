@@ -0,0 +1,125 @@
+package reportprocessor
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"bsky.watch/utils/bskyurl"
+)
+
+// maxQueuedReports limits the number of reports that are read from
+// the queues and held in memory while waiting for earlier reports about
+// the same subject to be processed.
+const maxQueuedReports = 1000
+
+// subjectKey returns the key that is used to serialize processing of
+// reports. Reports with the same key are never processed concurrently.
+func subjectKey(item *workItem) string {
+	subject := item.Payload.Subject()
+	url, err := bskyurl.DetermineTarget(subject)
+	if err != nil {
+		return subject
+	}
+	target, ok := url.(bskyurl.TargetWithProfile)
+	if !ok {
+		return subject
+	}
+	return strings.ToLower(target.GetProfile())
+}
+
+// scheduler distributes work items between workers, making sure that
+// items with the same subject key are processed one at a time and
+// in the order they were received.
+//
+// Items waiting behind another item with the same key don't count towards
+// the limit, so that a burst of reports about one subject doesn't stop
+// reports about other subjects from being processed.
+type scheduler struct {
+	in   chan workItem
+	work chan workItem
+	done chan string
+
+	// Maximum number of distinct subject keys that were received but
+	// not yet finished processing.
+	limit int
+	// Maximum number of items that were received but not yet finished
+	// processing, regardless of their keys.
+	maxQueued int
+
+	// touch is called periodically, every touchEvery, with the items
+	// waiting for their turn. Disabled if touchEvery is zero.
+	touch      func(items []workItem)
+	touchEvery time.Duration
+}
+
+func newScheduler(limit int, maxQueued int) *scheduler {
+	return &scheduler{
+		in:        make(chan workItem),
+		work:      make(chan workItem),
+		done:      make(chan string),
+		limit:     limit,
+		maxQueued: max(limit, maxQueued),
+	}
+}
+
+// Run dispatches items received on s.in to s.work, until ctx is cancelled.
+// Workers must send the subject key to s.done after they finish processing an item.
+func (s *scheduler) Run(ctx context.Context) {
+	// Items for each subject. First item in each queue is
+	// either being processed or is in the ready list.
+	queues := map[string][]workItem{}
+	ready := []workItem{}
+	total := 0
+
+	var touch <-chan time.Time
+	if s.touch != nil && s.touchEvery > 0 {
+		ticker := time.NewTicker(s.touchEvery)
+		defer ticker.Stop()
+		touch = ticker.C
+	}
+
+	for {
+		in := s.in
+		if len(queues) >= s.limit || total >= s.maxQueued {
+			// Apply backpressure.
+			in = nil
+		}
+		var work chan workItem
+		var next workItem
+		if len(ready) > 0 {
+			work = s.work
+			next = ready[0]
+		}
+		reportsQueued.Set(float64(total))
+
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-in:
+			total++
+			item.key = subjectKey(&item)
+			queues[item.key] = append(queues[item.key], item)
+			if len(queues[item.key]) == 1 {
+				ready = append(ready, item)
+			}
+		case work <- next:
+			ready = ready[1:]
+		case key := <-s.done:
+			total--
+			queues[key] = queues[key][1:]
+			if len(queues[key]) > 0 {
+				ready = append(ready, queues[key][0])
+			} else {
+				delete(queues, key)
+			}
+		case <-touch:
+			waiting := slices.Clone(ready)
+			for _, q := range queues {
+				waiting = append(waiting, q[1:]...)
+			}
+			s.touch(waiting)
+		}
+	}
+}
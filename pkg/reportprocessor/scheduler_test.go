@@ -0,0 +1,165 @@
+package reportprocessor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/modkit/pkg/reportqueue"
+)
+
+func testItem(did string, id string) workItem {
+	return workItem{Payload: &reportqueue.QueueEntry{
+		ID:       id,
+		AckToken: id,
+		Report: atproto.ModerationCreateReport_Input{
+			Subject: &atproto.ModerationCreateReport_Input_Subject{
+				AdminDefs_RepoRef: &atproto.AdminDefs_RepoRef{Did: did},
+			},
+		},
+	}}
+}
+
+func startScheduler(t *testing.T, s *scheduler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func send(t *testing.T, s *scheduler, item workItem) {
+	t.Helper()
+	select {
+	case s.in <- item:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out sending item %q", item.Payload.ID)
+	}
+}
+
+func receive(t *testing.T, s *scheduler) workItem {
+	t.Helper()
+	select {
+	case item := <-s.work:
+		return item
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for an item")
+		return workItem{}
+	}
+}
+
+func expectNoWork(t *testing.T, s *scheduler) {
+	t.Helper()
+	select {
+	case item := <-s.work:
+		t.Fatalf("Unexpected item %q", item.Payload.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSchedulerSerializesSubject(t *testing.T) {
+	s := newScheduler(4, 10)
+	startScheduler(t, s)
+
+	send(t, s, testItem("did:plc:alice", "1"))
+	send(t, s, testItem("did:plc:alice", "2"))
+	send(t, s, testItem("did:plc:bob", "3"))
+
+	first := receive(t, s)
+	if first.Payload.ID != "1" {
+		t.Fatalf("Got item %q first, want %q", first.Payload.ID, "1")
+	}
+	if item := receive(t, s); item.Payload.ID != "3" {
+		t.Fatalf("Got item %q, want %q", item.Payload.ID, "3")
+	}
+	// Second report about alice waits for the first one.
+	expectNoWork(t, s)
+
+	s.done <- first.key
+	if item := receive(t, s); item.Payload.ID != "2" {
+		t.Fatalf("Got item %q, want %q", item.Payload.ID, "2")
+	}
+}
+
+func TestSchedulerBusySubjectDoesNotBlockOthers(t *testing.T) {
+	s := newScheduler(2, 10)
+	startScheduler(t, s)
+
+	send(t, s, testItem("did:plc:alice", "a0"))
+	receive(t, s)
+	for i := range 5 {
+		send(t, s, testItem("did:plc:alice", fmt.Sprintf("a%d", i+1)))
+	}
+	send(t, s, testItem("did:plc:bob", "b"))
+	bob := receive(t, s)
+	if bob.Payload.ID != "b" {
+		t.Fatalf("Got item %q, want %q", bob.Payload.ID, "b")
+	}
+
+	// Two subjects are in progress now, so no more items are accepted
+	// until one of them is done.
+	select {
+	case s.in <- testItem("did:plc:carol", "c"):
+		t.Fatalf("Scheduler accepted an item over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.done <- bob.key
+	send(t, s, testItem("did:plc:carol", "c"))
+}
+
+func TestSchedulerMaxQueued(t *testing.T) {
+	s := newScheduler(2, 3)
+	startScheduler(t, s)
+
+	for i := range 3 {
+		send(t, s, testItem("did:plc:alice", fmt.Sprint(i+1)))
+	}
+	select {
+	case s.in <- testItem("did:plc:bob", "b"):
+		t.Fatalf("Scheduler accepted an item over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSchedulerTouchesWaitingItems(t *testing.T) {
+	touched := make(chan []string, 10)
+	s := newScheduler(4, 10)
+	s.touchEvery = 10 * time.Millisecond
+	s.touch = func(items []workItem) {
+		ids := []string{}
+		for _, item := range items {
+			ids = append(ids, item.Payload.ID)
+		}
+		slices.Sort(ids)
+		touched <- ids
+	}
+	startScheduler(t, s)
+
+	send(t, s, testItem("did:plc:alice", "1"))
+	send(t, s, testItem("did:plc:alice", "2"))
+	send(t, s, testItem("did:plc:alice", "3"))
+	receive(t, s)
+
+	// Wait for the touch after all items were received.
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case ids := <-touched:
+			if slices.Equal(ids, []string{"2", "3"}) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("Waiting items were not touched")
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package reportprocessor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bsky.watch/redmine"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/rs/zerolog"
+
+	"bsky.watch/modkit/pkg/moderation"
+	"bsky.watch/modkit/pkg/reportstore"
+	"bsky.watch/modkit/pkg/tickets"
+	"bsky.watch/modkit/pkg/triage"
+)
+
+const (
+	// Users and projects named in triage rules are looked up once per idCacheTTL.
+	idCacheSize = 100
+	idCacheTTL  = time.Hour
+)
+
+// triageReport evaluates triage rules from the config for the report.
+// Failures to fetch any of the inputs are logged and the conditions
+// depending on them won't match. Reports from reporters with low reputation
+// get low priority, unless the rules set it.
+func (h *handler) triageReport(ctx context.Context, entry *reportstore.Report, profile *bsky.ActorDefs_ProfileViewDetailed, history *reportHistory) triage.Decision {
+	log := zerolog.Ctx(ctx)
+
+	input := triage.NewReport(entry)
+	input.ReporterReputation = h.reporterScore(h.reporterStats(ctx, entry.Sender))
+
+	d := triage.Decision{}
+	if rules := h.modkitConfig.Triage; len(rules) > 0 {
+		input.SetProfile(profile)
+		if h.labelerURL != "" {
+			labels, err := moderation.QueryLabels(ctx, h.client, h.labelerURL, entry.SubjectDID, entry.Subject)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Failed to fetch labels for triage: %s", err)
+			} else {
+				input.AddLabels(labels)
+			}
+		}
+		if rules.VolumeWindow() > 0 && history != nil {
+			input.PreviousReports = history.previousReports()
+		}
+
+		d = rules.Evaluate(input)
+		for _, name := range d.Rules {
+			triageRuleMatches.WithLabelValues(name).Inc()
+		}
+		if len(d.Rules) > 0 {
+			log.Info().Strs("triage_rules", d.Rules).Msgf("Matched triage rules: %v", d.Rules)
+		}
+	}
+
+	threshold := h.modkitConfig.Reputation.DeprioritizeBelow
+	if d.Priority == "" && input.ReporterReputation != nil && *input.ReporterReputation < threshold {
+		log.Info().Msgf("Reporter's reputation %.2f is below %.2f, using low priority", *input.ReporterReputation, threshold)
+		deprioritizedReports.Inc()
+		d.Priority = "low"
+	}
+	return d
+}
+
+// creationOptions returns the options for a new ticket, based on the triage
+// decision. Without any rules, reports from moderators get normal priority
+// and everything else is urgent.
+func (h *handler) creationOptions(ctx context.Context, d triage.Decision, isModerator bool) []tickets.TicketOption {
+	log := zerolog.Ctx(ctx)
+
+	prio := tickets.PriorityUrgent
+	if isModerator {
+		prio = tickets.PriorityNormal
+	}
+	if d.Priority != "" {
+		// Already validated when loading the config.
+		prio, _ = triage.ParsePriority(d.Priority)
+	}
+	opts := []tickets.TicketOption{tickets.Priority(prio)}
+
+	if d.Project != "" {
+		id, err := h.projectId(d.Project)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Ignoring project set by triage rules: %s", err)
+		} else {
+			opts = append(opts, tickets.Project(id))
+		}
+	}
+	if d.Assignee != "" {
+		id, err := h.userId(d.Assignee)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Ignoring assignee set by triage rules: %s", err)
+		} else {
+			opts = append(opts, tickets.AssignedTo(id))
+		}
+	}
+	return opts
+}
+
+// updateOptions returns the options for posting a report to an existing
+// ticket. Priority is only ever raised. Without a priority set by the rules,
+// it's raised to High if raiseByDefault is true.
+func (h *handler) updateOptions(ctx context.Context, ticket *redmine.Issue, d triage.Decision, raiseByDefault bool) []tickets.TicketOption {
+	log := zerolog.Ctx(ctx)
+
+	opts := []tickets.TicketOption{}
+
+	target, raise := tickets.PriorityHigh, raiseByDefault
+	if d.Priority != "" {
+		target, _ = triage.ParsePriority(d.Priority)
+		raise = true
+	}
+	if raise {
+		prio, ok := tickets.GetPriority(ticket)
+		if !ok || prio < target {
+			opts = append(opts, tickets.Priority(target))
+		}
+	}
+
+	if d.Assignee != "" && ticket.AssignedTo == nil {
+		id, err := h.userId(d.Assignee)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Ignoring assignee set by triage rules: %s", err)
+		} else {
+			opts = append(opts, tickets.AssignedTo(id))
+		}
+	}
+	return opts
+}
+
+// addWatchers adds the watchers from the triage decision to the ticket.
+// The report is already posted at this point, so errors are only logged.
+func (h *handler) addWatchers(ctx context.Context, ticketId int, d triage.Decision) {
+	log := zerolog.Ctx(ctx)
+
+	for _, login := range d.Watchers {
+		id, err := h.userId(login)
+		if err == nil {
+			err = h.ticketsClient.AddWatcher(ticketId, id)
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to add %q as a watcher of ticket %d: %s", login, ticketId, err)
+		}
+	}
+}
+
+// userId returns the ID of the Redmine user with the given login.
+// Users are looked up by impersonating them, since listing users is
+// limited to a single page.
+func (h *handler) userId(login string) (int, error) {
+	if id, found := h.userIds.Get(login); found {
+		return id, nil
+	}
+	user, err := h.ticketsClient.Impersonate(login).MyAccount()
+	if err != nil {
+		return 0, fmt.Errorf("looking up user %q: %w", login, err)
+	}
+	h.userIds.Add(login, user.Id)
+	return user.Id, nil
+}
+
+func (h *handler) projectId(identifier string) (int, error) {
+	if id, found := h.projectIds.Get(identifier); found {
+		return id, nil
+	}
+	projects, err := h.ticketsClient.Projects()
+	if err != nil {
+		return 0, fmt.Errorf("fetching the list of projects: %w", err)
+	}
+	for _, p := range projects {
+		h.projectIds.Add(p.Identifier, p.Id)
+	}
+	if id, found := h.projectIds.Get(identifier); found {
+		return id, nil
+	}
+	return 0, fmt.Errorf("project %q not found", identifier)
+}
@@ -0,0 +1,149 @@
+package reportprocessor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"bsky.watch/utils/bskyurl"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/rs/zerolog"
+
+	"bsky.watch/modkit/pkg/records"
+	"bsky.watch/modkit/pkg/reportstore"
+	"bsky.watch/modkit/pkg/resolver"
+)
+
+// fetchProfile fetches the profile of the reported account. If the account
+// is permanently unavailable (deactivated, taken down, or its DID is gone),
+// returns a placeholder profile along with the description of why, so that
+// the report can still be posted. Other errors are returned as is, to retry
+// the report later.
+func (h *handler) fetchProfile(ctx context.Context, actor string) (*bsky.ActorDefs_ProfileViewDetailed, string, error) {
+	profile, err := bsky.ActorGetProfile(ctx, h.client, actor)
+	if err == nil {
+		return profile, "", nil
+	}
+	if !strings.HasPrefix(actor, "did:") {
+		return nil, "", err
+	}
+	reason := records.UnavailableReason(err)
+	if reason == "" && records.IsProfileNotFound(err) {
+		// AppView might just not have indexed the account, so this needs
+		// to be confirmed by the PDS or the DID document.
+		confirmed, confirmErr := h.accountUnavailableReason(ctx, actor)
+		if confirmErr != nil {
+			zerolog.Ctx(ctx).Warn().Err(confirmErr).Msgf("Failed to check if %q is still hosted: %s", actor, confirmErr)
+		}
+		reason = confirmed
+	}
+	if reason == "" {
+		return nil, "", err
+	}
+	zerolog.Ctx(ctx).Info().Err(err).Msgf("Profile of %q is unavailable: %s", actor, err)
+	unavailableContent.WithLabelValues("profile").Inc()
+	return &bsky.ActorDefs_ProfileViewDetailed{Did: actor, Handle: actor}, reason, nil
+}
+
+// accountUnavailableReason asks the PDS of the account whether it still
+// hosts the repo. Returns an empty string if it does.
+func (h *handler) accountUnavailableReason(ctx context.Context, did string) (string, error) {
+	pds, _, err := resolver.GetPDSEndpointAndPublicKey(ctx, did)
+	if reason := records.UnavailableReason(err); reason != "" {
+		return reason, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get the PDS address: %w", err)
+	}
+	pdsClient := *h.client
+	pdsClient.Host = pds.String()
+
+	status, err := atproto.SyncGetRepoStatus(ctx, &pdsClient, did)
+	if reason := records.UnavailableReason(err); reason != "" {
+		return reason, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching repo status: %w", err)
+	}
+	if status.Active {
+		return "", nil
+	}
+	return records.RepoStatusReason(status.Status), nil
+}
+
+// fetchRecord fetches the reported record from the PDS of its author.
+// Permanent failures result in a record without value, so that the report
+// can still be posted, and transient ones are returned as errors.
+func (h *handler) fetchRecord(ctx context.Context, target bskyurl.TargetRecord, report *atproto.ModerationCreateReport_Output) (*records.Record, error) {
+	uri := fmt.Sprintf("at://%s/%s/%s", target.GetProfile(), target.GetCollection(), target.GetRKey())
+	if report.Subject != nil && report.Subject.RepoStrongRef != nil {
+		uri = report.Subject.RepoStrongRef.Uri
+	}
+
+	var record *records.Record
+	pds, _, err := resolver.GetPDSEndpointAndPublicKey(ctx, target.GetProfile())
+	switch {
+	case records.UnavailableReason(err) != "":
+		record = records.NewUnavailable(uri, reportedCID(report), records.UnavailableReason(err))
+	case err != nil:
+		return nil, fmt.Errorf("failed to get the PDS address: %w", err)
+	default:
+		pdsClient := *h.client
+		pdsClient.Host = pds.String()
+
+		record, err = records.Fetch(ctx, &pdsClient, target.GetProfile(), target.GetCollection(), target.GetRKey(), reportedCID(report))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if record.Value == nil {
+		zerolog.Ctx(ctx).Info().Msgf("Record %q is unavailable: %s", uri, record.Reason)
+		unavailableContent.WithLabelValues("record").Inc()
+		record.EarlierReport = h.earlierReport(ctx, uri, report.Id)
+	}
+	return record, nil
+}
+
+// profileNotice returns the text explaining that the profile of the account
+// could not be fetched.
+func (h *handler) profileNotice(ctx context.Context, did string, reason string, encryptedID int64) string {
+	s := fmt.Sprintf("%s: the profile of `%s` can't be fetched because %s.", records.UnavailableMarker, did, reason)
+	if ticket := h.earlierReport(ctx, did, encryptedID); ticket != "" {
+		s += records.EarlierReportNotice(ticket)
+	}
+	return s
+}
+
+// earlierReport returns the reference to the ticket with the most recent
+// earlier report about the subject, according to the report store, or
+// an empty string if there's none. The report store doesn't keep snapshots
+// themselves, so it's up to moderators to look for one on that ticket.
+// The report with encryptedID is the current one and is skipped.
+func (h *handler) earlierReport(ctx context.Context, subject string, encryptedID int64) string {
+	if h.reportStore == nil {
+		return ""
+	}
+	reports, err := h.reportStore.BySubject(ctx, subject)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msgf("Failed to fetch earlier reports about %q: %s", subject, err)
+		return ""
+	}
+
+	reports = slices.DeleteFunc(reports, func(r reportstore.Report) bool {
+		return r.EncryptedID == encryptedID || (r.AccountTicketID == 0 && r.RecordTicketID == 0)
+	})
+	if len(reports) == 0 {
+		return ""
+	}
+	last := slices.MaxFunc(reports, func(a, b reportstore.Report) int { return a.Timestamp.Compare(b.Timestamp) })
+
+	ticketId := last.AccountTicketID
+	if last.RecordTicketID != 0 && last.Subject == subject {
+		ticketId = last.RecordTicketID
+	}
+	return fmt.Sprintf("#%d (reported on %s)", ticketId, last.Timestamp.UTC().Format(time.DateOnly))
+}
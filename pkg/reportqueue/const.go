@@ -1,9 +1,15 @@
 package reportqueue
 
+import "time"
+
 const (
 	valkeyStreamName           = "automod:reports"
 	valkeyQuarantineStreamName = "automod:reports:quarantine"
 
+	// Consumers without pending entries that were idle for longer
+	// than this are removed from the consumer group.
+	staleConsumerAge = 7 * 24 * time.Hour
+
 	// This is annoying and should probably have tests to ensure
 	// there are no unintended overlaps between various values.
 	valkeyReportIdBits       = 48
@@ -10,6 +10,8 @@ import (
 	"github.com/valkey-io/valkey-go"
 
 	"github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/modkit/pkg/streamgroup"
 )
 
 type QueueEntry struct {
@@ -20,116 +22,105 @@ type QueueEntry struct {
 	Report     atproto.ModerationCreateReport_Input
 }
 
+// Subject returns the DID or at:// URI that the report is about.
+func (e *QueueEntry) Subject() string {
+	if e.Report.Subject == nil {
+		return ""
+	}
+	switch {
+	case e.Report.Subject.RepoStrongRef != nil:
+		return e.Report.Subject.RepoStrongRef.Uri
+	case e.Report.Subject.AdminDefs_RepoRef != nil:
+		return e.Report.Subject.AdminDefs_RepoRef.Did
+	}
+	return ""
+}
+
 type ValkeyConsumer struct {
 	client valkey.Client
-	name   string
+	stream *streamgroup.Consumer
 }
 
-func NewValkeyConsumer(ctx context.Context, client valkey.Client, name string) (*ValkeyConsumer, error) {
-	c := &ValkeyConsumer{
-		client: client,
-		name:   name,
+// ErrNotPending is returned by Redeliver when the entry is no longer
+// pending for this consumer group.
+var ErrNotPending = streamgroup.ErrNotPending
+
+// NewValkeyConsumer creates a new consumer that reads reports from valkey
+// as a member of the given consumer group. consumer must be unique among
+// all processes reading from the same group.
+//
+// If claimAfter is non-zero, entries that were delivered to some other
+// consumer, but not acknowledged for longer than claimAfter, will be claimed
+// and re-delivered to this consumer. This allows recovering reports that
+// were left pending by a crashed process.
+func NewValkeyConsumer(ctx context.Context, client valkey.Client, group string, consumer string, claimAfter time.Duration) (*ValkeyConsumer, error) {
+	stream, err := streamgroup.New(ctx, client, valkeyStreamName, group, consumer, claimAfter)
+	if err != nil {
+		return nil, err
 	}
-	return c, c.setup(ctx)
-}
-
-func (c *ValkeyConsumer) setup(ctx context.Context) error {
-	result := c.client.Do(ctx, c.client.B().
-		XgroupCreate().
-		Key(valkeyStreamName).
-		Group(c.name).
-		Id("0").
-		Mkstream().Build())
-
-	if err := result.Error(); err != nil && !valkey.IsValkeyBusyGroup(err) {
-		return fmt.Errorf("creating consumer group: %w", err)
+	if claimAfter > 0 {
+		if err := stream.RemoveStaleConsumers(ctx, staleConsumerAge); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msgf("Failed to remove stale consumers: %s", err)
+		}
 	}
-	return nil
+	return &ValkeyConsumer{client: client, stream: stream}, nil
 }
 
+// GetNextReport returns the next report that needs processing. Entries that
+// were left pending by a previous run of the same consumer are returned first,
+// each of them once. After that, reports abandoned by other consumers and
+// new reports are returned.
+//
+// GetNextReport is not safe for concurrent use, but other methods can be
+// called concurrently with it.
 func (c *ValkeyConsumer) GetNextReport(ctx context.Context) (QueueEntry, error) {
-	log := zerolog.Ctx(ctx)
-
-	var entry QueueEntry
-	result, err := c.client.Do(ctx, c.client.B().
-		Xreadgroup().
-		Group(c.name, c.name).
-		Count(1).
-		Streams().Key(valkeyStreamName).Id("0").
-		Build()).AsXRead()
-
-	if err != nil && !valkey.IsValkeyNil(err) {
-		return entry, err
+	e, err := c.stream.Next(ctx)
+	if err != nil {
+		return QueueEntry{}, err
 	}
+	return parseEntry(e)
+}
 
-	for len(result) == 0 || len(result[valkeyStreamName]) == 0 {
-		// No pending entries, fetch a new one.
-		result, err = c.client.Do(ctx, c.client.B().
-			Xreadgroup().
-			Group(c.name, c.name).
-			Count(1).
-			Block(time.Hour.Milliseconds()).
-			Streams().Key(valkeyStreamName).Id(">").
-			Build()).AsXRead()
-		if err != nil && !valkey.IsValkeyNil(err) {
-			return entry, err
-		}
+// Redeliver fetches a pending entry again, incrementing its delivery counter.
+// Should be used for retrying entries that failed to be processed.
+func (c *ValkeyConsumer) Redeliver(ctx context.Context, ackToken string) (QueueEntry, error) {
+	e, err := c.stream.Redeliver(ctx, ackToken)
+	if err != nil {
+		return QueueEntry{}, err
 	}
+	return parseEntry(e)
+}
+
+// Touch resets the idle time of pending entries, see streamgroup.Consumer.Touch.
+func (c *ValkeyConsumer) Touch(ctx context.Context, ackTokens ...string) error {
+	return c.stream.Touch(ctx, ackTokens...)
+}
 
-	for _, entries := range result {
-		for _, e := range entries {
-			entry.AckToken = e.ID
-			for k, v := range e.FieldValues {
-				switch k {
-				case "id":
-					entry.ID = v
-				case "sender":
-					entry.ReportedBy = v
-				case "timestamp":
-					entry.Timestamp = v
-				case "report":
-					if err := json.Unmarshal([]byte(v), &entry.Report); err != nil {
-						return entry, fmt.Errorf("unmarshaling report: %w", err)
-					}
-				}
+func parseEntry(e valkey.XRangeEntry) (QueueEntry, error) {
+	entry := QueueEntry{AckToken: e.ID}
+	for k, v := range e.FieldValues {
+		switch k {
+		case "id":
+			entry.ID = v
+		case "sender":
+			entry.ReportedBy = v
+		case "timestamp":
+			entry.Timestamp = v
+		case "report":
+			if err := json.Unmarshal([]byte(v), &entry.Report); err != nil {
+				return entry, fmt.Errorf("unmarshaling report: %w", err)
 			}
-			return entry, nil
 		}
 	}
-	log.Error().Interface("result", result).Msgf("unreachable: no results in the response despite len > 0")
-	return entry, fmt.Errorf("unreachable: no results in the response despite len > 0")
+	return entry, nil
 }
 
 func (c *ValkeyConsumer) Ack(ctx context.Context, ackToken string) error {
-	return c.client.Do(ctx, c.client.B().
-		Xack().
-		Key(valkeyStreamName).
-		Group(c.name).
-		Id(ackToken).
-		Build()).Error()
+	return c.stream.Ack(ctx, ackToken)
 }
 
 func (c *ValkeyConsumer) AttemptCount(ctx context.Context, ackToken string) (int64, error) {
-	resp, err := c.client.Do(ctx, c.client.B().Xpending().Key(valkeyStreamName).Group(c.name).Start(ackToken).End(ackToken).Count(1).Build()).ToArray()
-	if err != nil {
-		if valkey.IsValkeyNil(err) {
-			return 0, nil
-		}
-		return 0, fmt.Errorf("XPENDING: %w", err)
-	}
-
-	if len(resp) == 0 {
-		return 0, nil
-	}
-
-	info, err := resp[0].ToArray()
-	if err != nil {
-		return 0, fmt.Errorf("converting first response entry to array: %w", err)
-	}
-	if len(info) < 4 {
-		return 0, fmt.Errorf("expected at least 4 items, got %d", len(info))
-	}
-	return info[3].ToInt64()
+	return c.stream.Attempts(ctx, ackToken)
 }
 
 func (c *ValkeyConsumer) Quarantine(ctx context.Context, item QueueEntry) error {
@@ -138,6 +129,7 @@ func (c *ValkeyConsumer) Quarantine(ctx context.Context, item QueueEntry) error
 		FieldValue("queue_key", item.AckToken).
 		FieldValue("id", item.ID).
 		FieldValue("sender", item.ReportedBy).
+		FieldValue("timestamp", item.Timestamp).
 		FieldValue("report", valkey.JSON(item.Report)).
 		Build()
 
@@ -147,7 +139,3 @@ func (c *ValkeyConsumer) Quarantine(ctx context.Context, item QueueEntry) error
 
 	return c.Ack(ctx, item.AckToken)
 }
-
-func (c *ValkeyConsumer) QuarantinedReports(ctx context.Context) ([]QueueEntry, error) {
-	return nil, fmt.Errorf("not implemented")
-}
@@ -0,0 +1,194 @@
+package reportqueue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+// QuarantinedEntry is a report that was moved out of the main queue
+// after too many failed processing attempts.
+type QuarantinedEntry struct {
+	// QueueEntry contains the original report. AckToken is the ID of
+	// the entry in the quarantine stream.
+	QueueEntry
+
+	// QueueKey is the ID that the report had in the main queue.
+	QueueKey      string
+	QuarantinedAt time.Time
+}
+
+func parseQuarantinedEntry(e valkey.XRangeEntry) (QuarantinedEntry, error) {
+	entry, err := parseEntry(e)
+	if err != nil {
+		return QuarantinedEntry{QueueEntry: entry}, err
+	}
+	r := QuarantinedEntry{
+		QueueEntry: entry,
+		QueueKey:   e.FieldValues["queue_key"],
+	}
+	if ts, err := streamIdTime(e.ID); err == nil {
+		r.QuarantinedAt = ts
+	}
+	return r, nil
+}
+
+// streamIdTime extracts the timestamp from a stream entry ID.
+func streamIdTime(id string) (time.Time, error) {
+	ms, _, _ := strings.Cut(id, "-")
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing stream ID %q: %w", id, err)
+	}
+	return time.UnixMilli(n), nil
+}
+
+// QuarantinedReports returns all entries currently in quarantine, oldest first.
+func (c *ValkeyConsumer) QuarantinedReports(ctx context.Context) ([]QuarantinedEntry, error) {
+	r := []QuarantinedEntry{}
+	start := "-"
+	for {
+		entries, err := c.client.Do(ctx, c.client.B().
+			Xrange().
+			Key(valkeyQuarantineStreamName).
+			Start(start).End("+").
+			Count(100).
+			Build()).AsXRange()
+		if err != nil && !valkey.IsValkeyNil(err) {
+			return nil, fmt.Errorf("XRANGE: %w", err)
+		}
+		if len(entries) == 0 {
+			return r, nil
+		}
+
+		for _, e := range entries {
+			entry, err := parseQuarantinedEntry(e)
+			if err != nil {
+				return nil, fmt.Errorf("parsing quarantined entry %q: %w", e.ID, err)
+			}
+			r = append(r, entry)
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
+
+// QuarantinedReport returns a single quarantined entry by its ID in the quarantine stream.
+func (c *ValkeyConsumer) QuarantinedReport(ctx context.Context, id string) (QuarantinedEntry, error) {
+	entries, err := c.client.Do(ctx, c.client.B().
+		Xrange().
+		Key(valkeyQuarantineStreamName).
+		Start(id).End(id).
+		Build()).AsXRange()
+	if err != nil && !valkey.IsValkeyNil(err) {
+		return QuarantinedEntry{}, fmt.Errorf("XRANGE: %w", err)
+	}
+	if len(entries) == 0 {
+		return QuarantinedEntry{}, fmt.Errorf("entry %q not found in quarantine", id)
+	}
+	return parseQuarantinedEntry(entries[0])
+}
+
+// Requeue moves quarantined entries with given IDs back into the main queue.
+// Requeued reports get a fresh attempt count.
+func (c *ValkeyConsumer) Requeue(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		entry, err := c.QuarantinedReport(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := c.requeue(ctx, entry); err != nil {
+			return fmt.Errorf("requeueing %q: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// RequeueMatching moves all quarantined entries for which filter returns true
+// back into the main queue. Returns the entries that were requeued.
+func (c *ValkeyConsumer) RequeueMatching(ctx context.Context, filter func(*QuarantinedEntry) bool) ([]QuarantinedEntry, error) {
+	entries, err := c.QuarantinedReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	requeued := []QuarantinedEntry{}
+	for _, entry := range entries {
+		if !filter(&entry) {
+			continue
+		}
+		if err := c.requeue(ctx, entry); err != nil {
+			return requeued, fmt.Errorf("requeueing %q: %w", entry.AckToken, err)
+		}
+		requeued = append(requeued, entry)
+	}
+	return requeued, nil
+}
+
+func (c *ValkeyConsumer) requeue(ctx context.Context, entry QuarantinedEntry) error {
+	// Adding to the main queue and removing from quarantine is done in
+	// a single transaction, so that a report never ends up in both places.
+	results := c.client.DoMulti(ctx,
+		c.client.B().Multi().Build(),
+		c.client.B().Xadd().Key(valkeyStreamName).Id("*").
+			FieldValue().
+			FieldValue("id", entry.ID).
+			FieldValue("sender", entry.ReportedBy).
+			FieldValue("report", valkey.JSON(entry.Report)).
+			FieldValue("timestamp", entry.Timestamp).
+			Build(),
+		c.client.B().Xdel().Key(valkeyQuarantineStreamName).Id(entry.AckToken).Build(),
+		c.client.B().Exec().Build(),
+	)
+	for _, r := range results {
+		if err := r.Error(); err != nil {
+			return err
+		}
+	}
+	// Errors of individual commands are only reported in the EXEC result.
+	exec, err := results[len(results)-1].ToArray()
+	if valkey.IsValkeyNil(err) {
+		return fmt.Errorf("transaction was aborted")
+	}
+	if err != nil {
+		return fmt.Errorf("parsing EXEC result: %w", err)
+	}
+	for i, r := range exec {
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("command #%d in the transaction failed: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// DeleteQuarantined permanently removes entries with given IDs from quarantine.
+func (c *ValkeyConsumer) DeleteQuarantined(ctx context.Context, ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	n, err := c.client.Do(ctx, c.client.B().
+		Xdel().
+		Key(valkeyQuarantineStreamName).
+		Id(ids...).
+		Build()).AsInt64()
+	if err != nil {
+		return 0, fmt.Errorf("XDEL: %w", err)
+	}
+	return n, nil
+}
+
+// PurgeQuarantine permanently removes all entries that were quarantined before the given time.
+func (c *ValkeyConsumer) PurgeQuarantine(ctx context.Context, before time.Time) (int64, error) {
+	n, err := c.client.Do(ctx, c.client.B().
+		Xtrim().
+		Key(valkeyQuarantineStreamName).
+		Minid().Exact().Threshold(fmt.Sprintf("%d-0", before.UnixMilli())).
+		Build()).AsInt64()
+	if err != nil {
+		return 0, fmt.Errorf("XTRIM: %w", err)
+	}
+	return n, nil
+}
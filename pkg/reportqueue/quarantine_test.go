@@ -0,0 +1,94 @@
+package reportqueue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/valkey-io/valkey-go"
+)
+
+func newTestConsumer(t *testing.T) (*ValkeyConsumer, *miniredis.Miniredis) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	client, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{m.Addr()},
+		DisableCache: true,
+		// miniredis looks like a cluster to the client, and transactions
+		// across keys in different slots are not allowed there.
+		ForceSingleClient: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create valkey client: %s", err)
+	}
+	t.Cleanup(client.Close)
+
+	c, err := NewValkeyConsumer(context.Background(), client, "report-processor", "test", 0)
+	if err != nil {
+		t.Fatalf("NewValkeyConsumer failed: %s", err)
+	}
+	return c, m
+}
+
+func quarantine(t *testing.T, c *ValkeyConsumer, id string, sender string) QuarantinedEntry {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.Quarantine(ctx, QueueEntry{AckToken: "1-" + id, ID: id, ReportedBy: sender}); err != nil {
+		t.Fatalf("Quarantine failed: %s", err)
+	}
+	entries, err := c.QuarantinedReports(ctx)
+	if err != nil {
+		t.Fatalf("QuarantinedReports failed: %s", err)
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return e
+		}
+	}
+	t.Fatalf("Report %q is not in quarantine", id)
+	return QuarantinedEntry{}
+}
+
+func TestRequeueMatching(t *testing.T) {
+	ctx := context.Background()
+	c, m := newTestConsumer(t)
+	quarantine(t, c, "1", "did:plc:alice")
+	quarantine(t, c, "2", "did:plc:bob")
+
+	requeued, err := c.RequeueMatching(ctx, func(e *QuarantinedEntry) bool { return e.ReportedBy == "did:plc:bob" })
+	if err != nil {
+		t.Fatalf("RequeueMatching failed: %s", err)
+	}
+	if len(requeued) != 1 || requeued[0].ID != "2" {
+		t.Errorf("Requeued %+v, want report 2", requeued)
+	}
+
+	left, err := c.QuarantinedReports(ctx)
+	if err != nil {
+		t.Fatalf("QuarantinedReports failed: %s", err)
+	}
+	if len(left) != 1 || left[0].ID != "1" {
+		t.Errorf("Left in quarantine: %+v, want report 1", left)
+	}
+	queued, err := m.Stream(valkeyStreamName)
+	if err != nil {
+		t.Fatalf("Reading the queue failed: %s", err)
+	}
+	if len(queued) != 1 {
+		t.Errorf("Got %d reports in the queue, want 1", len(queued))
+	}
+}
+
+func TestRequeueFailedTransaction(t *testing.T) {
+	ctx := context.Background()
+	c, m := newTestConsumer(t)
+	e := quarantine(t, c, "1", "did:plc:alice")
+
+	// XADD fails inside the transaction if the queue key has a wrong type.
+	m.Del(valkeyStreamName)
+	m.Set(valkeyStreamName, "not a stream")
+
+	if err := c.Requeue(ctx, e.AckToken); err == nil {
+		t.Errorf("Requeue succeeded, want an error")
+	}
+}
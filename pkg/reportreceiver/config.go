@@ -1,4 +1,4 @@
-package main
+package reportreceiver
 
 import (
 	"fmt"
@@ -10,8 +10,6 @@ import (
 	"bsky.watch/modkit/pkg/config"
 )
 
-var cfg Config
-
 type Config struct {
 	cliutil.LoggingConfig
 	ConfigPath            string   `split_words:"true"`
@@ -0,0 +1,169 @@
+package reportreceiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Jille/convreq"
+	"github.com/Jille/convreq/respond"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"bsky.watch/utils/bskyurl"
+
+	"bsky.watch/modkit/pkg/metrics"
+	"bsky.watch/modkit/pkg/reportqueue"
+)
+
+// NewHandler returns the HTTP handler serving the atproto endpoints of the
+// report receiver. Accepted reports are written into the queue.
+func NewHandler(ctx context.Context, cfg *Config, reportWriter *reportqueue.ValkeyWriter) (http.Handler, error) {
+	if len(cfg.OwnDIDs) == 0 {
+		return nil, fmt.Errorf("moderation account not configured, no reports would be accepted")
+	}
+
+	if cfg.TicketIDEncryptionKey == "" {
+		return nil, fmt.Errorf("missing ticket ID encryption key")
+	}
+
+	idCipher, err := reportqueue.NewIdCipher(cfg.TicketIDEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", convreq.Wrap(func() convreq.HttpResponse {
+		return respond.Forbidden("forbidden")
+	}))
+
+	mux.HandleFunc("/xrpc/com.atproto.moderation.createReport", convreq.Wrap(createReport(ctx, cfg.OwnDIDs, idCipher, reportWriter)))
+	mux.HandleFunc("/xrpc/com.atproto.label.queryLabels", convreq.Wrap(func() convreq.HttpResponse {
+		return respond.JSON(map[string]any{"labels": []string{}})
+	}))
+	mux.HandleFunc("/xrpc/com.atproto.label.subscribeLabels", dummyWebsocket)
+
+	return mux, nil
+}
+
+func createReport(ctx context.Context, ownDIDs []string, idCipher *reportqueue.IdCipher, reportWriter *reportqueue.ValkeyWriter) func(ctx context.Context, req *http.Request) convreq.HttpResponse {
+	updateMetrics := func(success bool, statusCode int, start time.Time) {
+		duration := time.Since(start).Seconds()
+		metrics.RequestStatus.WithLabelValues("com.atproto.moderation.createReport", fmt.Sprint(success), fmt.Sprint(statusCode)).Inc()
+		metrics.RequestDuration.WithLabelValues("com.atproto.moderation.createReport", fmt.Sprint(success), fmt.Sprint(statusCode)).Add(duration)
+		metrics.RequestStats.WithLabelValues("com.atproto.moderation.createReport", fmt.Sprint(success)).Observe(duration)
+	}
+
+	return func(ctx context.Context, req *http.Request) convreq.HttpResponse {
+		start := time.Now()
+		log := zerolog.Ctx(ctx)
+
+		did, err := validateCredentials(ctx, req, ownDIDs)
+		if err != nil {
+			log.Info().Err(err).Msgf("Received invalid request: %s", err)
+			updateMetrics(false, http.StatusForbidden, start)
+			return respond.Forbidden("forbidden")
+		}
+
+		log = ptr(log.With().Str("sender", did).Logger())
+
+		log.Info().Msgf("Received request from %q", did)
+
+		reader := &io.LimitedReader{R: req.Body, N: 16 * 1024}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to read request body: %s", err)
+			updateMetrics(false, http.StatusBadRequest, start)
+			return respond.BadRequest("failed to read request body")
+		}
+		if reader.N == 0 {
+			log.Warn().Msgf("Request is too large")
+			updateMetrics(false, http.StatusRequestEntityTooLarge, start)
+			return respond.PayloadTooLarge("request is too large")
+		}
+		var report comatproto.ModerationCreateReport_Input
+		if err := json.Unmarshal(body, &report); err != nil {
+			log.Warn().Err(err).Msgf("Failed to decode request: %s", err)
+			updateMetrics(false, http.StatusBadRequest, start)
+			return respond.BadRequest("bad request")
+		}
+
+		if report.Subject == nil {
+			updateMetrics(false, http.StatusBadRequest, start)
+			return respond.BadRequest("missing subject")
+		}
+
+		subject := ""
+		switch {
+		case report.Subject.RepoStrongRef != nil:
+			subject = report.Subject.RepoStrongRef.Uri
+		case report.Subject.AdminDefs_RepoRef != nil:
+			subject = report.Subject.AdminDefs_RepoRef.Did
+		}
+		if subject == "" {
+			updateMetrics(false, http.StatusBadRequest, start)
+			return respond.BadRequest("missing subject")
+		}
+
+		url, err := bskyurl.DetermineTarget(subject)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to parse subject: %s", err)
+			updateMetrics(false, http.StatusBadRequest, start)
+			return respond.BadRequest("bad subject")
+		}
+		_, ok := url.(bskyurl.TargetWithProfile)
+		if !ok {
+			log.Warn().Err(err).Msgf("Unsupported URI %q", subject)
+			updateMetrics(false, http.StatusBadRequest, start)
+			return respond.BadRequest("bad subject")
+		}
+
+		log = ptr(log.With().Str("subject", subject).Logger())
+
+		var response comatproto.ModerationCreateReport_Output
+		json.Unmarshal(body, &response)
+		response.CreatedAt = time.Now().Format(time.RFC3339)
+		response.ReportedBy = did
+
+		reportId, err := reportWriter.AddReport(ctx, response.ReportedBy, response.CreatedAt, body)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to write report to the queue: %s", err)
+			updateMetrics(false, http.StatusInternalServerError, start)
+			return respond.InternalServerError("oops")
+		}
+		encrypted := idCipher.Encrypt(reportId)
+		log.Info().Uint64("report_id", reportId).
+			Int64("encrypted_report_id", encrypted).
+			Msgf("Report was written to the queue with ID %d", reportId)
+		response.Id = encrypted
+
+		updateMetrics(true, http.StatusOK, start)
+		return respond.JSON(&response)
+	}
+}
+
+func dummyWebsocket(w http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{}
+	c, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Print("upgrade:", err)
+		return
+	}
+	defer c.Close()
+	for {
+		_, _, err := c.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
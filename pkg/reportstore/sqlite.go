@@ -0,0 +1,217 @@
+package reportstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// migrations are applied in order, tracking the progress in `user_version` pragma.
+// Existing entries must never be changed, only new ones appended.
+var migrations = []string{
+	`CREATE TABLE reports (
+		id INTEGER PRIMARY KEY,
+		encrypted_id INTEGER NOT NULL,
+		sender TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		subject_did TEXT NOT NULL,
+		reason_type TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		remote TEXT NOT NULL,
+		account_ticket_id INTEGER,
+		record_ticket_id INTEGER,
+		processed_at INTEGER NOT NULL
+	);
+	CREATE INDEX reports_subject ON reports (subject);
+	CREATE INDEX reports_subject_did ON reports (subject_did);
+	CREATE INDEX reports_sender ON reports (sender);
+	CREATE INDEX reports_timestamp ON reports (timestamp);
+	CREATE INDEX reports_encrypted_id ON reports (encrypted_id);`,
+}
+
+const reportColumns = `id, encrypted_id, sender, subject, subject_did, reason_type, reason,
+	timestamp, remote, account_ticket_id, record_ticket_id, processed_at`
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ Store = &SQLiteStore{}
+
+// NewSQLiteStore opens (or creates) the database at the given path
+// and brings its schema up to date.
+func NewSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	params := url.Values{}
+	params.Set("_busy_timeout", "5000")
+	params.Set("_journal_mode", "WAL")
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?%s", path, params.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %w", path, err)
+	}
+
+	s := &SQLiteStore{db: db}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("updating database schema: %w", err)
+	}
+	return s, nil
+}
+
+// OpenSQLiteStoreReadOnly opens an existing database without modifying it,
+// e.g., for inspecting the database of a running report-processor.
+// Returns an error if the schema is older than expected, since schema
+// updates are only done by NewSQLiteStore.
+func OpenSQLiteStoreReadOnly(ctx context.Context, path string) (*SQLiteStore, error) {
+	params := url.Values{}
+	params.Set("mode", "ro")
+	params.Set("_busy_timeout", "5000")
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?%s", path, params.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %w", path, err)
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("reading schema version: %w", err)
+	}
+	if version < len(migrations) {
+		db.Close()
+		return nil, fmt.Errorf("database schema version %d is older than expected (%d), it needs to be updated by report-processor first", version, len(migrations))
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) migrate(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported (%d)", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			return fmt.Errorf("applying migration %d: %w", i+1, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, len(migrations))); err != nil {
+		return fmt.Errorf("updating schema version: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func nullableInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+func (s *SQLiteStore) Add(ctx context.Context, r *Report) error {
+	processedAt := r.ProcessedAt
+	if processedAt.IsZero() {
+		processedAt = time.Now()
+	}
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO reports (`+reportColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			encrypted_id = excluded.encrypted_id,
+			sender = excluded.sender,
+			subject = excluded.subject,
+			subject_did = excluded.subject_did,
+			reason_type = excluded.reason_type,
+			reason = excluded.reason,
+			timestamp = excluded.timestamp,
+			remote = excluded.remote,
+			account_ticket_id = excluded.account_ticket_id,
+			record_ticket_id = excluded.record_ticket_id,
+			processed_at = excluded.processed_at`,
+		int64(r.ID), r.EncryptedID, r.Sender, r.Subject, r.SubjectDID, r.ReasonType, r.Reason,
+		r.Timestamp.UnixMilli(), r.Remote, nullableInt(r.AccountTicketID), nullableInt(r.RecordTicketID),
+		processedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("inserting report %d: %w", r.ID, err)
+	}
+	return nil
+}
+
+func scanReport(row interface{ Scan(...any) error }) (Report, error) {
+	var r Report
+	var id, timestamp, processedAt int64
+	var accountTicket, recordTicket sql.NullInt64
+	err := row.Scan(&id, &r.EncryptedID, &r.Sender, &r.Subject, &r.SubjectDID, &r.ReasonType, &r.Reason,
+		&timestamp, &r.Remote, &accountTicket, &recordTicket, &processedAt)
+	if err != nil {
+		return r, err
+	}
+	r.ID = uint64(id)
+	r.Timestamp = time.UnixMilli(timestamp)
+	r.ProcessedAt = time.UnixMilli(processedAt)
+	r.AccountTicketID = int(accountTicket.Int64)
+	r.RecordTicketID = int(recordTicket.Int64)
+	return r, nil
+}
+
+func (s *SQLiteStore) query(ctx context.Context, where string, args ...any) ([]Report, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+reportColumns+` FROM reports WHERE `+where+` ORDER BY timestamp`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, report)
+	}
+	return r, rows.Err()
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, id uint64) (*Report, error) {
+	r, err := scanReport(s.db.QueryRowContext(ctx, `SELECT `+reportColumns+` FROM reports WHERE id = ?`, int64(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *SQLiteStore) BySubject(ctx context.Context, subject string) ([]Report, error) {
+	if strings.HasPrefix(subject, "did:") {
+		return s.query(ctx, `subject_did = ?`, subject)
+	}
+	return s.query(ctx, `subject = ?`, subject)
+}
+
+func (s *SQLiteStore) ByReporter(ctx context.Context, did string) ([]Report, error) {
+	return s.query(ctx, `sender = ?`, did)
+}
+
+func (s *SQLiteStore) ByTimeRange(ctx context.Context, from time.Time, to time.Time) ([]Report, error) {
+	return s.query(ctx, `timestamp >= ? AND timestamp < ?`, from.UnixMilli(), to.UnixMilli())
+}
@@ -0,0 +1,167 @@
+package reportstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testReports() []*Report {
+	t := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []*Report{
+		{ID: 1, EncryptedID: 101, Sender: "did:plc:alice", Subject: "did:plc:spammer", SubjectDID: "did:plc:spammer",
+			ReasonType: "spam", Timestamp: t, AccountTicketID: 10},
+		{ID: 2, EncryptedID: 102, Sender: "did:plc:bob", Subject: "at://did:plc:spammer/app.bsky.feed.post/3kaaa",
+			SubjectDID: "did:plc:spammer", ReasonType: "spam", Timestamp: t.Add(time.Hour), AccountTicketID: 10, RecordTicketID: 11},
+		{ID: 3, EncryptedID: 103, Sender: "did:plc:alice", Subject: "did:plc:troll", SubjectDID: "did:plc:troll",
+			ReasonType: "rude", Timestamp: t.Add(2 * time.Hour), AccountTicketID: 12},
+	}
+}
+
+func ids(reports []Report) []uint64 {
+	r := []uint64{}
+	for _, report := range reports {
+		r = append(r, report.ID)
+	}
+	return r
+}
+
+func checkIds(t *testing.T, what string, reports []Report, err error, want ...uint64) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s failed: %s", what, err)
+	}
+	got := ids(reports)
+	if len(got) != len(want) {
+		t.Errorf("%s returned %v, want %v", what, got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("%s returned %v, want %v", what, got, want)
+			return
+		}
+	}
+}
+
+func newTestStore(t *testing.T, path string) *SQLiteStore {
+	t.Helper()
+	s, err := NewSQLiteStore(context.Background(), path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %s", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "reports.sqlite")
+	s := newTestStore(t, path)
+
+	for _, r := range testReports() {
+		if err := s.Add(ctx, r); err != nil {
+			t.Fatalf("Add failed: %s", err)
+		}
+	}
+
+	r, err := s.Get(ctx, 2)
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	if r.RecordTicketID != 11 || r.AccountTicketID != 10 || !r.Timestamp.Equal(testReports()[1].Timestamp) {
+		t.Errorf("Get returned %+v", r)
+	}
+	if _, err := s.Get(ctx, 42); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing report returned %v, want %v", err, ErrNotFound)
+	}
+
+	reports, err := s.BySubject(ctx, "did:plc:spammer")
+	checkIds(t, "BySubject(did)", reports, err, 1, 2)
+	reports, err = s.BySubject(ctx, "at://did:plc:spammer/app.bsky.feed.post/3kaaa")
+	checkIds(t, "BySubject(uri)", reports, err, 2)
+	reports, err = s.ByReporter(ctx, "did:plc:alice")
+	checkIds(t, "ByReporter", reports, err, 1, 3)
+	from := testReports()[0].Timestamp
+	reports, err = s.ByTimeRange(ctx, from, from.Add(2*time.Hour))
+	checkIds(t, "ByTimeRange", reports, err, 1, 2)
+
+	// Adding a report again overwrites it.
+	updated := testReports()[2]
+	updated.AccountTicketID = 13
+	if err := s.Add(ctx, updated); err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if r, err := s.Get(ctx, 3); err != nil || r.AccountTicketID != 13 {
+		t.Errorf("Get after overwrite returned %+v, %v", r, err)
+	}
+
+	// Opening the database again keeps the data.
+	s.Close()
+	s = newTestStore(t, path)
+	reports, err = s.ByReporter(ctx, "did:plc:alice")
+	checkIds(t, "ByReporter after reopening", reports, err, 1, 3)
+}
+
+func TestOpenSQLiteStoreReadOnly(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "reports.sqlite")
+	s := newTestStore(t, path)
+	for _, r := range testReports() {
+		if err := s.Add(ctx, r); err != nil {
+			t.Fatalf("Add failed: %s", err)
+		}
+	}
+
+	ro, err := OpenSQLiteStoreReadOnly(ctx, path)
+	if err != nil {
+		t.Fatalf("OpenSQLiteStoreReadOnly failed: %s", err)
+	}
+	defer ro.Close()
+
+	reports, err := ro.BySubject(ctx, "did:plc:spammer")
+	checkIds(t, "BySubject", reports, err, 1, 2)
+	if err := ro.Add(ctx, &Report{ID: 4, Timestamp: time.Now()}); err == nil {
+		t.Errorf("Add to a read-only store succeeded")
+	}
+}
+
+func TestOpenSQLiteStoreReadOnlyOutdatedSchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "reports.sqlite")
+
+	// Create an empty database with the schema version 0.
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open failed: %s", err)
+	}
+	if _, err := db.ExecContext(ctx, `CREATE TABLE t (x INTEGER)`); err != nil {
+		t.Fatalf("CREATE TABLE failed: %s", err)
+	}
+	db.Close()
+
+	if s, err := OpenSQLiteStoreReadOnly(ctx, path); err == nil {
+		s.Close()
+		t.Fatalf("OpenSQLiteStoreReadOnly succeeded on a database with outdated schema")
+	}
+
+	db, err = sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open failed: %s", err)
+	}
+	defer db.Close()
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatalf("Reading schema version failed: %s", err)
+	}
+	if version != 0 {
+		t.Errorf("Schema version is %d after opening read-only, want 0", version)
+	}
+
+	if _, err := OpenSQLiteStoreReadOnly(ctx, filepath.Join(t.TempDir(), "missing.sqlite")); err == nil {
+		t.Errorf("OpenSQLiteStoreReadOnly succeeded on a missing file")
+	}
+}
@@ -0,0 +1,53 @@
+// Package reportstore keeps a persistent log of processed reports.
+package reportstore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("report not found")
+
+// Report contains metadata of a single processed report.
+type Report struct {
+	// ID is the internal report ID, as allocated by the report receiver.
+	ID uint64
+	// EncryptedID is the report ID that was returned to the user.
+	EncryptedID int64
+
+	Sender string
+	// Subject is either a DID or an at:// URI of the reported record.
+	Subject string
+	// SubjectDID is the DID of the reported account or the author of the reported record.
+	SubjectDID string
+	ReasonType string
+	Reason     string
+	Timestamp  time.Time
+
+	// Remote is the address of the report queue that the report was received from.
+	Remote string
+
+	AccountTicketID int
+	// RecordTicketID is only set when per-record tickets are enabled
+	// and the report is about a record.
+	RecordTicketID int
+
+	ProcessedAt time.Time
+}
+
+type Store interface {
+	// Add records a processed report. If a report with the same ID
+	// already exists, it gets overwritten.
+	Add(ctx context.Context, report *Report) error
+	Get(ctx context.Context, id uint64) (*Report, error)
+
+	// BySubject returns reports with the given subject. If subject is a DID,
+	// reports about any records of that account are also returned.
+	BySubject(ctx context.Context, subject string) ([]Report, error)
+	ByReporter(ctx context.Context, did string) ([]Report, error)
+	// ByTimeRange returns reports with timestamp in [from, to) range.
+	ByTimeRange(ctx context.Context, from time.Time, to time.Time) ([]Report, error)
+
+	Close() error
+}
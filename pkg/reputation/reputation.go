@@ -0,0 +1,162 @@
+// Package reputation keeps statistics on how reports from each reporter
+// were resolved.
+//
+// report-processor records which reporters reported each ticket, and
+// redmine-handler records the outcome of the ticket once it's resolved.
+// Each reporter's counters are updated whenever either of those changes,
+// so a report counts once per ticket, with the latest outcome of the ticket.
+package reputation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+// All keys share the hash tag, since scripts update keys of both tickets
+// and reporters.
+const keyPrefix = "{modkit:reputation}:"
+
+type Outcome string
+
+const (
+	// OutcomeApplied means that the ticket was completed with some lists
+	// or labels selected.
+	OutcomeApplied Outcome = "applied"
+	// OutcomeNoAction means that the ticket was completed without
+	// any action taken.
+	OutcomeNoAction Outcome = "no_action"
+	// OutcomeDuplicate means that the ticket was marked as a duplicate
+	// or merged into another one.
+	OutcomeDuplicate Outcome = "duplicate"
+)
+
+// Stats are the numbers of resolved tickets reported by the reporter,
+// by outcome.
+type Stats struct {
+	Applied   int
+	NoAction  int
+	Duplicate int
+}
+
+// Resolved returns the number of resolved tickets.
+func (s Stats) Resolved() int {
+	return s.Applied + s.NoAction + s.Duplicate
+}
+
+// Score returns the fraction of resolved tickets that had some action taken.
+// Returns false if fewer than minResolved tickets are resolved.
+func (s Stats) Score(minResolved int) (float64, bool) {
+	if s.Resolved() == 0 || s.Resolved() < minResolved {
+		return 0, false
+	}
+	return float64(s.Applied) / float64(s.Resolved()), true
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d applied, %d with no action, %d duplicates", s.Applied, s.NoAction, s.Duplicate)
+}
+
+// addReportScript adds the reporter to the ticket and, if the ticket is
+// already resolved, counts its outcome for the reporter.
+//
+// KEYS: ticket reporters, ticket outcome, reporter stats. ARGV: reporter DID.
+var addReportScript = valkey.NewLuaScript(`
+if redis.call('SADD', KEYS[1], ARGV[1]) == 1 then
+  local outcome = redis.call('GET', KEYS[2])
+  if outcome then
+    redis.call('HINCRBY', KEYS[3], outcome, 1)
+  end
+end
+return 0
+`)
+
+// setOutcomeScript replaces the outcome of the ticket in the stats of all
+// reporters of the ticket.
+//
+// KEYS: ticket reporters, ticket outcome. ARGV: outcome, reporter stats key prefix.
+var setOutcomeScript = valkey.NewLuaScript(`
+local old = redis.call('GET', KEYS[2])
+if old == ARGV[1] then
+  return 0
+end
+redis.call('SET', KEYS[2], ARGV[1])
+for _, r in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+  if old then
+    redis.call('HINCRBY', ARGV[2] .. r, old, -1)
+  end
+  redis.call('HINCRBY', ARGV[2] .. r, ARGV[1], 1)
+end
+return 1
+`)
+
+// Store keeps the statistics in valkey.
+type Store struct {
+	client valkey.Client
+}
+
+func NewStore(client valkey.Client) *Store {
+	return &Store{client: client}
+}
+
+// AddReport records that the reporter sent a report that was posted
+// to the ticket. Repeated reports of the same ticket are counted once.
+func (s *Store) AddReport(ctx context.Context, ticketId int, reporter string) error {
+	err := addReportScript.Exec(ctx, s.client,
+		[]string{reportersKey(ticketId), outcomeKey(ticketId), statsKey(reporter)},
+		[]string{reporter}).Error()
+	if err != nil {
+		return fmt.Errorf("recording report of ticket %d: %w", ticketId, err)
+	}
+	return nil
+}
+
+// SetOutcome records the outcome of the ticket, replacing the previous one
+// if the ticket was resolved before.
+func (s *Store) SetOutcome(ctx context.Context, ticketId int, outcome Outcome) error {
+	err := setOutcomeScript.Exec(ctx, s.client,
+		[]string{reportersKey(ticketId), outcomeKey(ticketId)},
+		[]string{string(outcome), statsKey("")}).Error()
+	if err != nil {
+		return fmt.Errorf("recording outcome of ticket %d: %w", ticketId, err)
+	}
+	return nil
+}
+
+// Get returns the statistics of the reporter.
+func (s *Store) Get(ctx context.Context, reporter string) (Stats, error) {
+	values, err := s.client.Do(ctx, s.client.B().Hgetall().Key(statsKey(reporter)).Build()).AsStrMap()
+	if err != nil {
+		return Stats{}, fmt.Errorf("fetching stats of %s: %w", reporter, err)
+	}
+	r := Stats{}
+	for k, v := range values {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Stats{}, fmt.Errorf("parsing %q value %q: %w", k, v, err)
+		}
+		switch Outcome(k) {
+		case OutcomeApplied:
+			r.Applied = n
+		case OutcomeNoAction:
+			r.NoAction = n
+		case OutcomeDuplicate:
+			r.Duplicate = n
+		}
+	}
+	return r, nil
+}
+
+func reportersKey(ticketId int) string {
+	return fmt.Sprintf("%sticket:%d:reporters", keyPrefix, ticketId)
+}
+
+func outcomeKey(ticketId int) string {
+	return fmt.Sprintf("%sticket:%d:outcome", keyPrefix, ticketId)
+}
+
+func statsKey(reporter string) string {
+	return keyPrefix + "reporter:" + reporter
+}
@@ -0,0 +1,80 @@
+package reputation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/valkey-io/valkey-go"
+)
+
+func newStore(t *testing.T) *Store {
+	t.Helper()
+	m := miniredis.RunT(t)
+	client, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:  []string{m.Addr()},
+		DisableCache: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create valkey client: %s", err)
+	}
+	t.Cleanup(client.Close)
+	return NewStore(client)
+}
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+
+	check := func(reporter string, want Stats) {
+		t.Helper()
+		got, err := s.Get(ctx, reporter)
+		if err != nil {
+			t.Fatalf("Get(%q) failed: %s", reporter, err)
+		}
+		if got != want {
+			t.Errorf("Get(%q) = %+v, want %+v", reporter, got, want)
+		}
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	must(s.AddReport(ctx, 1, "alice"))
+	must(s.AddReport(ctx, 1, "alice"))
+	must(s.AddReport(ctx, 1, "bob"))
+	must(s.AddReport(ctx, 2, "alice"))
+	check("alice", Stats{})
+
+	must(s.SetOutcome(ctx, 1, OutcomeApplied))
+	must(s.SetOutcome(ctx, 1, OutcomeApplied))
+	must(s.SetOutcome(ctx, 2, OutcomeNoAction))
+	check("alice", Stats{Applied: 1, NoAction: 1})
+	check("bob", Stats{Applied: 1})
+
+	// Reopened and resolved differently.
+	must(s.SetOutcome(ctx, 1, OutcomeDuplicate))
+	check("alice", Stats{NoAction: 1, Duplicate: 1})
+	check("bob", Stats{Duplicate: 1})
+
+	// Report posted to an already resolved ticket.
+	must(s.AddReport(ctx, 2, "carol"))
+	check("carol", Stats{NoAction: 1})
+	check("dave", Stats{})
+}
+
+func TestScore(t *testing.T) {
+	s := Stats{Applied: 3, NoAction: 1}
+	if score, ok := s.Score(4); !ok || score != 0.75 {
+		t.Errorf("Score(4) = %v, %v, want 0.75, true", score, ok)
+	}
+	if _, ok := s.Score(5); ok {
+		t.Errorf("Score(5) is known with only 4 resolved tickets")
+	}
+	if _, ok := (Stats{}).Score(0); ok {
+		t.Errorf("Score(0) is known without any resolved tickets")
+	}
+}
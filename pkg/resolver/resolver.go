@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strings"
 
 	"github.com/rs/zerolog"
 
@@ -31,8 +32,42 @@ func init() {
 	Resolver = resolver
 }
 
+// NotFoundError is returned when all of the queried directories responded
+// that the DID doesn't exist or was tombstoned.
+type NotFoundError struct {
+	DID string
+	Err error
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("DID %q not found: %s", e.DID, e.Err)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
 func GetDocument(ctx context.Context, didstr string) (*did.Document, error) {
-	return Resolver.GetDocument(ctx, didstr)
+	doc, err := Resolver.GetDocument(ctx, didstr)
+	if err != nil && isNotFound(err) {
+		return nil, &NotFoundError{DID: didstr, Err: err}
+	}
+	return doc, err
+}
+
+// isNotFound returns true if all of the errors are 404 or 410 responses from
+// api.PLCServer, which only reports the status code in the error text.
+func isNotFound(err error) bool {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "(code 404)") && !strings.Contains(err.Error(), "(code 410)") {
+			return false
+		}
+	}
+	return len(errs) > 0
 }
 
 type fallbackResolver struct {
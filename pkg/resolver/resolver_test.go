@@ -0,0 +1,61 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api"
+	"github.com/bluesky-social/indigo/did"
+)
+
+// plcServer responds to all requests with the given status code.
+func plcServer(t *testing.T, status int) *api.PLCServer {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return &api.PLCServer{Host: s.URL}
+}
+
+// TestNotFound checks the errors from api.PLCServer, since they can only be
+// told apart by their text.
+func TestNotFound(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		notFound bool
+	}{
+		{"not registered", []int{http.StatusNotFound}, true},
+		{"tombstoned", []int{http.StatusGone}, true},
+		{"all directories", []int{http.StatusNotFound, http.StatusGone}, true},
+		{"directory unavailable", []int{http.StatusGone, http.StatusServiceUnavailable}, false},
+		{"rate limited", []int{http.StatusTooManyRequests}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fr := &fallbackResolver{}
+			for _, status := range test.statuses {
+				fr.resolvers = append(fr.resolvers, plcServer(t, status))
+			}
+			r := did.NewMultiResolver()
+			r.AddHandler("plc", fr)
+			prev := Resolver
+			Resolver = r
+			t.Cleanup(func() { Resolver = prev })
+
+			_, _, err := GetPDSEndpointAndPublicKey(context.Background(), "did:plc:test")
+			if err == nil {
+				t.Fatalf("GetPDSEndpointAndPublicKey succeeded")
+			}
+			var notFound *NotFoundError
+			if got := errors.As(err, &notFound); got != test.notFound {
+				t.Errorf("Got error %q, want NotFoundError=%v", err, test.notFound)
+			}
+		})
+	}
+}
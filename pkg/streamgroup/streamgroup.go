@@ -0,0 +1,289 @@
+// Package streamgroup implements reading from a valkey stream as a member
+// of a consumer group, with at-least-once delivery.
+//
+// Entries stay pending until they are acknowledged. Entries left pending by
+// a previous run of the same consumer are delivered again first, and entries
+// abandoned by other consumers can be claimed after a timeout.
+package streamgroup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/valkey-io/valkey-go"
+)
+
+// ErrNotPending is returned by Redeliver when the entry is no longer
+// pending for this consumer group.
+var ErrNotPending = errors.New("entry is not pending")
+
+type Consumer struct {
+	client   valkey.Client
+	stream   string
+	group    string
+	consumer string
+
+	claimAfter  time.Duration
+	claimCursor string
+	pelCursor   string
+}
+
+// New creates the consumer group if it doesn't exist yet, and returns
+// a consumer reading from it. consumer must be unique among all processes
+// reading from the same stream and group.
+//
+// If claimAfter is non-zero, entries that were delivered to some other
+// consumer, but not acknowledged for longer than claimAfter, will be claimed
+// and re-delivered to this consumer.
+func New(ctx context.Context, client valkey.Client, stream string, group string, consumer string, claimAfter time.Duration) (*Consumer, error) {
+	c := &Consumer{
+		client:      client,
+		stream:      stream,
+		group:       group,
+		consumer:    consumer,
+		claimAfter:  claimAfter,
+		claimCursor: "0-0",
+		pelCursor:   "0",
+	}
+
+	err := client.Do(ctx, client.B().
+		XgroupCreate().
+		Key(stream).
+		Group(group).
+		Id("0").
+		Mkstream().Build()).Error()
+	if err != nil && !valkey.IsValkeyBusyGroup(err) {
+		return nil, fmt.Errorf("creating consumer group: %w", err)
+	}
+	return c, nil
+}
+
+// Next returns the next entry, blocking until one is available. Entries that
+// were left pending by a previous run of the same consumer are returned first,
+// each of them once. After that, entries abandoned by other consumers and
+// new entries are returned.
+//
+// Next is not safe for concurrent use, but other methods can be called
+// concurrently with it.
+func (c *Consumer) Next(ctx context.Context) (valkey.XRangeEntry, error) {
+	log := zerolog.Ctx(ctx)
+
+	if c.pelCursor != "" {
+		result, err := c.client.Do(ctx, c.client.B().
+			Xreadgroup().
+			Group(c.group, c.consumer).
+			Count(1).
+			Streams().Key(c.stream).Id(c.pelCursor).
+			Build()).AsXRead()
+		if err != nil && !valkey.IsValkeyNil(err) {
+			return valkey.XRangeEntry{}, err
+		}
+		if entries := result[c.stream]; len(entries) > 0 {
+			c.pelCursor = entries[0].ID
+			return entries[0], nil
+		}
+		// Done with the entries pending from the previous run.
+		c.pelCursor = ""
+	}
+
+	block := time.Hour
+	if c.claimAfter > 0 {
+		// Need to wake up periodically to check for abandoned entries.
+		// BLOCK 0 means waiting forever, so it has to be at least 1ms.
+		block = max(min(block, c.claimAfter/2), time.Millisecond)
+	}
+
+	for {
+		if c.claimAfter > 0 {
+			claimed, err := c.claimAbandoned(ctx)
+			if err != nil {
+				return valkey.XRangeEntry{}, err
+			}
+			if len(claimed) > 0 {
+				log.Info().Str("stream", c.stream).Str("entry_id", claimed[0].ID).
+					Msgf("Claimed entry %q abandoned by another consumer", claimed[0].ID)
+				return claimed[0], nil
+			}
+		}
+
+		result, err := c.client.Do(ctx, c.client.B().
+			Xreadgroup().
+			Group(c.group, c.consumer).
+			Count(1).
+			Block(block.Milliseconds()).
+			Streams().Key(c.stream).Id(">").
+			Build()).AsXRead()
+		if err != nil && !valkey.IsValkeyNil(err) {
+			return valkey.XRangeEntry{}, err
+		}
+		if entries := result[c.stream]; len(entries) > 0 {
+			return entries[0], nil
+		}
+	}
+}
+
+// Redeliver fetches a pending entry again, incrementing its delivery counter.
+func (c *Consumer) Redeliver(ctx context.Context, id string) (valkey.XRangeEntry, error) {
+	entries, err := c.client.Do(ctx, c.client.B().
+		Xclaim().
+		Key(c.stream).
+		Group(c.group).
+		Consumer(c.consumer).
+		MinIdleTime("0").
+		Id(id).
+		Build()).AsXRange()
+	if err != nil && !valkey.IsValkeyNil(err) {
+		return valkey.XRangeEntry{}, fmt.Errorf("XCLAIM: %w", err)
+	}
+	if len(entries) == 0 {
+		return valkey.XRangeEntry{}, ErrNotPending
+	}
+	return entries[0], nil
+}
+
+// Touch resets the idle time of pending entries without incrementing their
+// delivery counters, so that entries waiting to be processed by this
+// consumer are not claimed by other ones.
+func (c *Consumer) Touch(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := c.client.Do(ctx, c.client.B().
+		Xclaim().
+		Key(c.stream).
+		Group(c.group).
+		Consumer(c.consumer).
+		MinIdleTime("0").
+		Id(ids...).
+		Justid().
+		Build()).Error()
+	if err != nil && !valkey.IsValkeyNil(err) {
+		return fmt.Errorf("XCLAIM: %w", err)
+	}
+	return nil
+}
+
+// Ack removes the entry from the list of pending ones. The entry itself
+// stays in the stream.
+func (c *Consumer) Ack(ctx context.Context, id string) error {
+	return c.client.Do(ctx, c.client.B().
+		Xack().
+		Key(c.stream).
+		Group(c.group).
+		Id(id).
+		Build()).Error()
+}
+
+// Attempts returns the number of times the entry was delivered, or 0 if
+// it's not pending.
+func (c *Consumer) Attempts(ctx context.Context, id string) (int64, error) {
+	resp, err := c.client.Do(ctx, c.client.B().Xpending().Key(c.stream).Group(c.group).Start(id).End(id).Count(1).Build()).ToArray()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("XPENDING: %w", err)
+	}
+	if len(resp) == 0 {
+		return 0, nil
+	}
+	info, err := resp[0].ToArray()
+	if err != nil {
+		return 0, fmt.Errorf("converting first response entry to array: %w", err)
+	}
+	if len(info) < 4 {
+		return 0, fmt.Errorf("expected at least 4 items, got %d", len(info))
+	}
+	return info[3].ToInt64()
+}
+
+// RemoveStaleConsumers deletes consumers that have no pending entries
+// and have been inactive for longer than maxIdle. Every restart of a process
+// can create a new consumer, so without this cleanup the list of consumers
+// would grow indefinitely.
+func (c *Consumer) RemoveStaleConsumers(ctx context.Context, maxIdle time.Duration) error {
+	log := zerolog.Ctx(ctx)
+
+	resp, err := c.client.Do(ctx, c.client.B().
+		XinfoConsumers().
+		Key(c.stream).
+		Group(c.group).
+		Build()).ToArray()
+	if err != nil {
+		return fmt.Errorf("XINFO CONSUMERS: %w", err)
+	}
+
+	for _, item := range resp {
+		info, err := item.AsMap()
+		if err != nil {
+			return fmt.Errorf("parsing consumer info: %w", err)
+		}
+		name, err := ptr(info["name"]).ToString()
+		if err != nil {
+			return fmt.Errorf("parsing consumer name: %w", err)
+		}
+		pending, err := ptr(info["pending"]).AsInt64()
+		if err != nil {
+			return fmt.Errorf("parsing the number of pending entries: %w", err)
+		}
+		idle, err := ptr(info["idle"]).AsInt64()
+		if err != nil {
+			return fmt.Errorf("parsing idle time: %w", err)
+		}
+
+		if name == c.consumer || pending > 0 || time.Duration(idle)*time.Millisecond < maxIdle {
+			continue
+		}
+
+		err = c.client.Do(ctx, c.client.B().
+			XgroupDelconsumer().
+			Key(c.stream).
+			Group(c.group).
+			Consumername(name).
+			Build()).Error()
+		if err != nil {
+			return fmt.Errorf("XGROUP DELCONSUMER %q: %w", name, err)
+		}
+		log.Info().Msgf("Removed stale consumer %q", name)
+	}
+	return nil
+}
+
+// claimAbandoned transfers ownership of one entry that was pending for longer
+// than c.claimAfter to this consumer.
+func (c *Consumer) claimAbandoned(ctx context.Context) ([]valkey.XRangeEntry, error) {
+	resp, err := c.client.Do(ctx, c.client.B().
+		Xautoclaim().
+		Key(c.stream).
+		Group(c.group).
+		Consumer(c.consumer).
+		MinIdleTime(fmt.Sprint(c.claimAfter.Milliseconds())).
+		Start(c.claimCursor).
+		Count(1).
+		Build()).ToArray()
+	if err != nil {
+		return nil, fmt.Errorf("XAUTOCLAIM: %w", err)
+	}
+	if len(resp) < 2 {
+		return nil, fmt.Errorf("expected at least 2 items in XAUTOCLAIM response, got %d", len(resp))
+	}
+
+	cursor, err := resp[0].ToString()
+	if err != nil {
+		return nil, fmt.Errorf("parsing XAUTOCLAIM cursor: %w", err)
+	}
+	c.claimCursor = cursor
+
+	entries, err := resp[1].AsXRange()
+	if err != nil && !valkey.IsValkeyNil(err) {
+		return nil, fmt.Errorf("parsing XAUTOCLAIM entries: %w", err)
+	}
+	return entries, nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,136 @@
+package tickets
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"bsky.watch/redmine"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+type lookupKey struct {
+	projectId int
+	fieldId   int
+	value     string
+	status    StatusFilter
+}
+
+// WithLookupCache wraps the backend with a bounded cache of FindByCustomField
+// results, so that a burst of reports about the same account results in
+// a single query. Cached results are dropped when an issue is created or
+// updated through the returned backend in a way that can change them,
+// changes made by anyone else are picked up once the entry expires.
+func WithLookupCache(backend Backend, size int, ttl time.Duration) Backend {
+	return &cachingBackend{
+		Backend: backend,
+		cache:   expirable.NewLRU[lookupKey, []redmine.Issue](size, nil, ttl),
+	}
+}
+
+type cachingBackend struct {
+	Backend
+	cache *expirable.LRU[lookupKey, []redmine.Issue]
+}
+
+func (b *cachingBackend) Impersonate(login string) Backend {
+	return &cachingBackend{Backend: b.Backend.Impersonate(login), cache: b.cache}
+}
+
+func (b *cachingBackend) FindByCustomField(projectId int, fieldId int, value string, status StatusFilter) ([]redmine.Issue, error) {
+	key := lookupKey{projectId: projectId, fieldId: fieldId, value: value, status: status}
+	if r, found := b.cache.Get(key); found {
+		lookupCache.WithLabelValues("hit").Inc()
+		return slices.Clone(r), nil
+	}
+	lookupCache.WithLabelValues("miss").Inc()
+
+	r, err := b.Backend.FindByCustomField(projectId, fieldId, value, status)
+	if err != nil {
+		return nil, err
+	}
+	b.cache.Add(key, slices.Clone(r))
+	return r, nil
+}
+
+func (b *cachingBackend) CreateIssue(issue redmine.Issue) (*redmine.Issue, error) {
+	r, err := b.Backend.CreateIssue(issue)
+	b.invalidate(&issue)
+	return r, err
+}
+
+func (b *cachingBackend) UpdateIssue(issue redmine.Issue) error {
+	err := b.Backend.UpdateIssue(issue)
+	b.invalidate(&issue)
+	return err
+}
+
+// invalidate drops cached results that contain the issue, unless the change
+// doesn't affect any of the fields we look at, and the results that the issue
+// would now match.
+func (b *cachingBackend) invalidate(issue *redmine.Issue) {
+	for _, key := range b.cache.Keys() {
+		cached, found := b.cache.Peek(key)
+		if !found {
+			continue
+		}
+		idx := -1
+		if issue.Id != 0 {
+			idx = slices.IndexFunc(cached, func(c redmine.Issue) bool { return c.Id == issue.Id })
+		}
+		if (idx >= 0 && changesLookupFields(&cached[idx], issue)) ||
+			(idx < 0 && hasFieldValue(issue, key.fieldId, key.value)) {
+			b.cache.Remove(key)
+		}
+	}
+}
+
+// changesLookupFields returns true if the update changes anything that
+// callers of FindByCustomField look at. Notes and attachments don't count.
+func changesLookupFields(cached *redmine.Issue, update *redmine.Issue) bool {
+	if update.StatusId != 0 && (cached.Status == nil || cached.Status.Id != update.StatusId) {
+		return true
+	}
+	if update.TrackerId != 0 && (cached.Tracker == nil || cached.Tracker.Id != update.TrackerId) {
+		return true
+	}
+	if update.PriorityId != 0 && (cached.Priority == nil || cached.Priority.Id != update.PriorityId) {
+		return true
+	}
+	if update.Subject != "" && update.Subject != cached.Subject {
+		return true
+	}
+	for _, cf := range update.CustomFields {
+		idx := slices.IndexFunc(cached.CustomFields, func(c *redmine.CustomField) bool { return c.Id == cf.Id })
+		if idx < 0 || !reflect.DeepEqual(cached.CustomFields[idx].Value, cf.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFieldValue(issue *redmine.Issue, fieldId int, value string) bool {
+	for _, cf := range issue.CustomFields {
+		if cf.Id != fieldId {
+			continue
+		}
+		switch v := cf.Value.(type) {
+		case []string:
+			if slices.Contains(v, value) {
+				return true
+			}
+		case []interface{}:
+			for _, item := range v {
+				if fmt.Sprint(item) == value {
+					return true
+				}
+			}
+		default:
+			if fmt.Sprint(v) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
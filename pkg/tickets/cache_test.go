@@ -0,0 +1,66 @@
+package tickets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bsky.watch/redmine"
+)
+
+// countingBackend counts lookups that reach the underlying backend.
+type countingBackend struct {
+	*MemoryBackend
+	lookups int
+}
+
+func (b *countingBackend) FindByCustomField(projectId int, fieldId int, value string, status StatusFilter) ([]redmine.Issue, error) {
+	b.lookups++
+	return b.MemoryBackend.FindByCustomField(projectId, fieldId, value, status)
+}
+
+func TestLookupCache(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	memory := NewMemoryBackend("modkit")
+	memory.SetClosedStatuses(testMappings().Statuses.Duplicate)
+	counting := &countingBackend{MemoryBackend: memory}
+	backend := WithLookupCache(counting, 10, time.Hour)
+
+	find := func(wantLookups int, wantTickets int) []redmine.Issue {
+		t.Helper()
+		found, err := FindByDID(ctx, backend, "did:plc:alice", OpenTickets)
+		if err != nil {
+			t.Fatalf("FindByDID() failed: %s", err)
+		}
+		if counting.lookups != wantLookups {
+			t.Errorf("Backend was queried %d times, want %d", counting.lookups, wantLookups)
+		}
+		if len(found) != wantTickets {
+			t.Errorf("FindByDID() returned %d tickets, want %d", len(found), wantTickets)
+		}
+		return found
+	}
+
+	ticket, err := Create(ctx, backend, Subject("alice.test"), DID("did:plc:alice"), Type(TypeTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	find(1, 1)
+	found := find(1, 1)
+
+	if _, err := AddNote(ctx, backend, &found[0], "new report"); err != nil {
+		t.Fatalf("AddNote() failed: %s", err)
+	}
+	find(1, 1)
+
+	if _, err := Create(ctx, backend, Subject("alice.test again"), DID("did:plc:alice"), Type(TypeTicket)); err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	find(2, 2)
+
+	if _, err := Update(ctx, backend, ticket, Status(StatusDuplicate)); err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+	find(3, 1)
+}
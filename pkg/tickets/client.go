@@ -1,9 +1,293 @@
 package tickets
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
 	"bsky.watch/redmine"
 )
 
-func NewClient(addr string, apiKey string) *redmine.Client {
-	return redmine.NewClient(addr, apiKey)
+// Backend is the ticket store. It covers the subset of Redmine API that we use,
+// so that it can be replaced with an in-memory implementation in tests.
+type Backend interface {
+	// Impersonate returns a backend that makes changes on behalf of the given user.
+	Impersonate(login string) Backend
+
+	CreateIssue(issue redmine.Issue) (*redmine.Issue, error)
+	// UpdateIssue updates the issue. If issue.Notes is not empty,
+	// it's added as a new note.
+	UpdateIssue(issue redmine.Issue) error
+	Issue(id int) (*redmine.Issue, error)
+	// IssueWithJournals is the same as Issue, but also populates the list of notes.
+	IssueWithJournals(id int) (*redmine.Issue, error)
+	UpdateJournal(journal *redmine.Journal) error
+	// FindByCustomField returns all issues in the project that have the given
+	// value in the custom field and match the status filter.
+	FindByCustomField(projectId int, fieldId int, value string, status StatusFilter) ([]redmine.Issue, error)
+
+	IssueRelations(issueId int) ([]redmine.IssueRelation, error)
+	CreateIssueRelation(relation redmine.IssueRelation) (*redmine.IssueRelation, error)
+	DeleteIssueRelation(id int) error
+
+	// AddWatcher adds the user to the watchers of the issue.
+	AddWatcher(issueId int, userId int) error
+
+	// IssueAttachments returns the list of files attached to the issue.
+	IssueAttachments(issueId int) ([]Attachment, error)
+	// DownloadAttachment returns the contents of the attached file.
+	DownloadAttachment(attachment Attachment) ([]byte, error)
+
+	// Upload uploads the file and returns a token that can be
+	// attached to an issue.
+	Upload(filename string) (*redmine.Upload, error)
+
+	CustomFields() ([]redmine.CustomFieldDefinition, error)
+	UpdateCustomField(field redmine.CustomFieldDefinition) error
+
+	MyAccount() (*redmine.User, error)
+	User(id int) (*redmine.User, error)
+	Users() ([]redmine.User, error)
+
+	Projects() ([]redmine.Project, error)
+	Trackers() ([]redmine.IdName, error)
+	IssueStatuses() ([]redmine.IssueStatus, error)
+	IssuePriorities() ([]redmine.IssuePriority, error)
+}
+
+// Attachment is a file attached to an issue.
+type Attachment struct {
+	Id          int    `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+// StatusFilter selects tickets by whether their status is closed.
+// Values are the same as for status_id filter in Redmine API.
+type StatusFilter string
+
+const (
+	OpenTickets   StatusFilter = "open"
+	ClosedTickets StatusFilter = "closed"
+	AllTickets    StatusFilter = "*"
+)
+
+// pageSize is the maximum number of issues that Redmine returns in one response.
+const pageSize = 100
+
+// NewClient returns a Backend that talks to the Redmine instance.
+func NewClient(addr string, apiKey string) Backend {
+	return &redmineBackend{
+		client: redmine.NewClient(addr, apiKey),
+		addr:   strings.TrimSuffix(addr, "/"),
+		apiKey: apiKey,
+	}
+}
+
+type redmineBackend struct {
+	client *redmine.Client
+
+	// Used for the requests that the client library doesn't support.
+	addr   string
+	apiKey string
+	login  string
+}
+
+func (b *redmineBackend) Impersonate(login string) Backend {
+	c := *b.client
+	return &redmineBackend{client: c.Impersonate(login), addr: b.addr, apiKey: b.apiKey, login: login}
+}
+
+// get makes a GET request to Redmine and returns the response body.
+func (b *redmineBackend) get(path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, b.addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Redmine-API-Key", b.apiKey)
+	if b.login != "" {
+		req.Header.Set("X-Redmine-Switch-User", b.login)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return body, nil
+}
+
+// post makes a POST request to Redmine with JSON-encoded body.
+func (b *redmineBackend) post(path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Redmine-API-Key", b.apiKey)
+	if b.login != "" {
+		req.Header.Set("X-Redmine-Switch-User", b.login)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("POST %s: %s", path, resp.Status)
+	}
+	return nil
+}
+
+func (b *redmineBackend) CreateIssue(issue redmine.Issue) (*redmine.Issue, error) {
+	return b.client.CreateIssue(issue)
+}
+
+func (b *redmineBackend) UpdateIssue(issue redmine.Issue) error {
+	return b.client.UpdateIssue(issue)
+}
+
+func (b *redmineBackend) Issue(id int) (*redmine.Issue, error) {
+	return b.client.Issue(id)
+}
+
+func (b *redmineBackend) IssueWithJournals(id int) (*redmine.Issue, error) {
+	return b.client.IssueWithArgs(id, map[string]string{"include": "journals"})
+}
+
+func (b *redmineBackend) UpdateJournal(journal *redmine.Journal) error {
+	return b.client.UpdateJournal(journal)
+}
+
+func (b *redmineBackend) FindByCustomField(projectId int, fieldId int, value string, status StatusFilter) ([]redmine.Issue, error) {
+	field := fmt.Sprintf("cf_%d", fieldId)
+	filter := &redmine.IssueFilter{
+		ProjectId: fmt.Sprint(projectId),
+		StatusId:  string(status),
+		ExtraFilters: map[string]string{
+			"f[]":                         field,
+			fmt.Sprintf("op[%s]", field):  "=",
+			fmt.Sprintf("v[%s][]", field): value,
+		},
+	}
+
+	// Redmine returns at most pageSize issues per request, so keep asking
+	// until we get a partial page.
+	r := []redmine.Issue{}
+	seen := map[int]bool{}
+	c := *b.client
+	c.Limit = pageSize
+	for {
+		page, err := c.IssuesByFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("fetching issues with offset %d: %w", c.Offset, err)
+		}
+		added := 0
+		for _, issue := range page {
+			// Issues can shift between pages if they're created or updated
+			// while we're paginating.
+			if seen[issue.Id] {
+				continue
+			}
+			seen[issue.Id] = true
+			r = append(r, issue)
+			added++
+		}
+		if len(page) < pageSize || added == 0 {
+			return r, nil
+		}
+		c.Offset += len(page)
+	}
+}
+
+func (b *redmineBackend) IssueRelations(issueId int) ([]redmine.IssueRelation, error) {
+	return b.client.IssueRelations(issueId)
+}
+
+func (b *redmineBackend) CreateIssueRelation(relation redmine.IssueRelation) (*redmine.IssueRelation, error) {
+	return b.client.CreateIssueRelation(relation)
+}
+
+func (b *redmineBackend) DeleteIssueRelation(id int) error {
+	return b.client.DeleteIssueRelation(id)
+}
+
+func (b *redmineBackend) AddWatcher(issueId int, userId int) error {
+	return b.post(fmt.Sprintf("/issues/%d/watchers.json", issueId), map[string]int{"user_id": userId})
+}
+
+func (b *redmineBackend) IssueAttachments(issueId int) ([]Attachment, error) {
+	body, err := b.get(fmt.Sprintf("/issues/%d.json?include=attachments", issueId))
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Issue struct {
+			Attachments []Attachment `json:"attachments"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	return r.Issue.Attachments, nil
+}
+
+func (b *redmineBackend) DownloadAttachment(attachment Attachment) ([]byte, error) {
+	// Not using content_url from the response, since it's based on the public
+	// hostname, which might not be reachable from here.
+	return b.get(fmt.Sprintf("/attachments/download/%d/%s", attachment.Id, url.PathEscape(attachment.Filename)))
+}
+
+func (b *redmineBackend) Upload(filename string) (*redmine.Upload, error) {
+	return b.client.Upload(filename)
+}
+
+func (b *redmineBackend) CustomFields() ([]redmine.CustomFieldDefinition, error) {
+	return b.client.CustomFields()
+}
+
+func (b *redmineBackend) UpdateCustomField(field redmine.CustomFieldDefinition) error {
+	return b.client.UpdateCustomField(field)
+}
+
+func (b *redmineBackend) MyAccount() (*redmine.User, error) {
+	return b.client.MyAccount()
+}
+
+func (b *redmineBackend) User(id int) (*redmine.User, error) {
+	return b.client.User(id)
+}
+
+func (b *redmineBackend) Users() ([]redmine.User, error) {
+	return b.client.Users()
+}
+
+func (b *redmineBackend) Projects() ([]redmine.Project, error) {
+	return b.client.Projects()
+}
+
+func (b *redmineBackend) Trackers() ([]redmine.IdName, error) {
+	return b.client.Trackers()
+}
+
+func (b *redmineBackend) IssueStatuses() ([]redmine.IssueStatus, error) {
+	return b.client.IssueStatuses()
+}
+
+func (b *redmineBackend) IssuePriorities() ([]redmine.IssuePriority, error) {
+	return b.client.IssuePriorities()
 }
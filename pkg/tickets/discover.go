@@ -0,0 +1,194 @@
+package tickets
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type namedID struct {
+	id   int
+	name string
+}
+
+// mappingEntry is a single ID from the mappings, along with the name
+// that the corresponding object has in the default Redmine setup
+// (see redmine/default_data_loader.rb).
+type mappingEntry struct {
+	key      string
+	id       *int
+	name     string
+	optional bool
+}
+
+// mappingGroup is a set of mappings for objects of the same kind.
+type mappingGroup struct {
+	kind    string
+	query   func(client Backend) ([]namedID, error)
+	entries []mappingEntry
+}
+
+// mappingGroups returns all IDs in m that can be checked against Redmine.
+// Entries point into m, so that they can be updated in place.
+func mappingGroups(m *IDMappings) []mappingGroup {
+	return []mappingGroup{
+		{
+			kind: "project",
+			query: func(client Backend) ([]namedID, error) {
+				projects, err := client.Projects()
+				if err != nil {
+					return nil, fmt.Errorf("querying projects: %w", err)
+				}
+				r := []namedID{}
+				for _, p := range projects {
+					r = append(r, namedID{p.Id, p.Name})
+				}
+				return r, nil
+			},
+			entries: []mappingEntry{
+				{key: "projectId", id: &m.ProjectID, name: "Tickets"},
+			},
+		},
+		{
+			kind: "priority",
+			query: func(client Backend) ([]namedID, error) {
+				priorities, err := client.IssuePriorities()
+				if err != nil {
+					return nil, fmt.Errorf("querying priorities: %w", err)
+				}
+				r := []namedID{}
+				for _, p := range priorities {
+					r = append(r, namedID{p.Id, p.Name})
+				}
+				return r, nil
+			},
+			entries: []mappingEntry{
+				{key: "priorities.low", id: &m.Priorities.Low, name: "Low"},
+				{key: "priorities.normal", id: &m.Priorities.Normal, name: "Normal"},
+				{key: "priorities.high", id: &m.Priorities.High, name: "High"},
+				{key: "priorities.urgent", id: &m.Priorities.Urgent, name: "Urgent"},
+			},
+		},
+		{
+			kind: "status",
+			query: func(client Backend) ([]namedID, error) {
+				statuses, err := client.IssueStatuses()
+				if err != nil {
+					return nil, fmt.Errorf("querying statuses: %w", err)
+				}
+				r := []namedID{}
+				for _, s := range statuses {
+					r = append(r, namedID{s.Id, s.Name})
+				}
+				return r, nil
+			},
+			entries: []mappingEntry{
+				{key: "statuses.new", id: &m.Statuses.New, name: "New"},
+				{key: "statuses.inProgress", id: &m.Statuses.InProgress, name: "In progress"},
+				{key: "statuses.completed", id: &m.Statuses.Completed, name: "Closed"},
+				{key: "statuses.applied", id: &m.Statuses.Applied, name: "Applied"},
+				{key: "statuses.duplicate", id: &m.Statuses.Duplicate, name: "Duplicate"},
+				{key: "statuses.granted", id: &m.Statuses.Granted, name: "Granted", optional: true},
+			},
+		},
+		{
+			kind: "tracker",
+			query: func(client Backend) ([]namedID, error) {
+				trackers, err := client.Trackers()
+				if err != nil {
+					return nil, fmt.Errorf("querying trackers: %w", err)
+				}
+				r := []namedID{}
+				for _, t := range trackers {
+					r = append(r, namedID{t.Id, t.Name})
+				}
+				return r, nil
+			},
+			entries: []mappingEntry{
+				{key: "ticketTypes.ticket", id: &m.TicketTypes.Ticket, name: "Ticket"},
+				{key: "ticketTypes.appeal", id: &m.TicketTypes.Appeal, name: "Appeal"},
+				{key: "ticketTypes.recordTicket", id: &m.TicketTypes.RecordTicket, name: "Record ticket"},
+			},
+		},
+		{
+			kind: "issue custom field",
+			query: func(client Backend) ([]namedID, error) {
+				fields, err := client.CustomFields()
+				if err != nil {
+					return nil, fmt.Errorf("querying custom fields: %w", err)
+				}
+				r := []namedID{}
+				for _, f := range fields {
+					if f.CustomizedType != "" && f.CustomizedType != "issue" {
+						continue
+					}
+					r = append(r, namedID{f.Id, f.Name})
+				}
+				return r, nil
+			},
+			entries: []mappingEntry{
+				{key: "fields.did", id: &m.Fields.DID, name: "DID"},
+				{key: "fields.handle", id: &m.Fields.Handle, name: "Handle"},
+				{key: "fields.displayName", id: &m.Fields.DisplayName, name: "Display name"},
+				{key: "fields.addToLists", id: &m.Fields.AddToLists, name: "Add to lists"},
+				{key: "fields.subject", id: &m.Fields.Subject, name: "Subject"},
+				{key: "fields.labels", id: &m.Fields.Labels, name: "Labels"},
+				{key: "fields.bluesky", id: &m.Fields.Bluesky, name: "Bluesky", optional: true},
+				{key: "fields.clearsky", id: &m.Fields.Clearsky, name: "Clearsky", optional: true},
+				{key: "fields.approver", id: &m.Fields.Approver, name: "Approver", optional: true},
+			},
+		},
+	}
+}
+
+// DiscoverMappings looks up IDs of the project, priorities, statuses, trackers
+// and custom fields by the names they have in the default Redmine setup.
+// Users can't be discovered and are left empty. Returns an error listing
+// all required objects that were not found.
+func DiscoverMappings(client Backend) (IDMappings, error) {
+	m := IDMappings{}
+	missing := []string{}
+
+	for _, group := range mappingGroups(&m) {
+		available, err := group.query(client)
+		if err != nil {
+			return m, err
+		}
+		byName := map[string]int{}
+		for _, a := range available {
+			if _, found := byName[normalizeName(a.name)]; !found {
+				byName[normalizeName(a.name)] = a.id
+			}
+		}
+		for _, e := range group.entries {
+			id, found := byName[normalizeName(e.name)]
+			if !found {
+				if !e.optional {
+					missing = append(missing, fmt.Sprintf("%s: no %s named %q", e.key, group.kind, e.name))
+				}
+				continue
+			}
+			*e.id = id
+		}
+	}
+
+	if len(missing) > 0 {
+		return m, fmt.Errorf("failed to discover ID mappings:\n  %s", strings.Join(missing, "\n  "))
+	}
+	return m, nil
+}
+
+// WriteMappingsToFile saves the mappings in the same format that
+// LoadMappingsFromFile reads.
+func WriteMappingsToFile(filename string, m IDMappings) error {
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshaling mappings: %w", err)
+	}
+	if err := os.WriteFile(filename, b, 0644); err != nil {
+		return fmt.Errorf("writing %q: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package tickets
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bsky.watch/redmine"
+)
+
+func TestDiscoverMappings(t *testing.T) {
+	got, err := DiscoverMappings(newDefaultRedmine())
+	if err != nil {
+		t.Fatalf("DiscoverMappings failed: %s", err)
+	}
+	if want := testMappings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiscoverMappings returned %+v, want %+v", got, want)
+	}
+
+	// Result must survive a round trip through the file.
+	filename := filepath.Join(t.TempDir(), "mappings.yaml")
+	if err := WriteMappingsToFile(filename, got); err != nil {
+		t.Fatalf("WriteMappingsToFile failed: %s", err)
+	}
+	prev := Mappings()
+	defer SetMappings(prev)
+	SetMappings(IDMappings{})
+	if err := LoadMappingsFromFile(filename); err != nil {
+		t.Fatalf("LoadMappingsFromFile failed: %s", err)
+	}
+	loaded := Mappings()
+	if len(loaded.Users) != 0 {
+		t.Errorf("Loaded mappings have users %+v, want none", loaded.Users)
+	}
+	loaded.Users = got.Users
+	if !reflect.DeepEqual(loaded, got) {
+		t.Errorf("Loaded mappings %+v, want %+v", loaded, got)
+	}
+}
+
+func TestDiscoverMappingsMissing(t *testing.T) {
+	b := newDefaultRedmine()
+	// Renamed field can't be discovered.
+	b.UpdateCustomField(redmine.CustomFieldDefinition{Id: 2, Name: "Username", CustomizedType: "issue"})
+
+	_, err := DiscoverMappings(b)
+	if err == nil {
+		t.Fatalf("DiscoverMappings succeeded, want an error")
+	}
+	if !strings.Contains(err.Error(), `fields.handle: no issue custom field named "Handle"`) {
+		t.Errorf("Error %q does not mention the missing field", err)
+	}
+}
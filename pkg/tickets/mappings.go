@@ -35,6 +35,7 @@ const (
 	StatusCompleted
 	StatusApplied
 	StatusDuplicate
+	StatusGranted
 )
 
 type IDMappings struct {
@@ -53,6 +54,7 @@ type IDMappings struct {
 		Applied    int `yaml:"applied"`
 		Duplicate  int `yaml:"duplicate"`
 		InProgress int `yaml:"inProgress"`
+		Granted    int `yaml:"granted"`
 	} `yaml:"statuses"`
 
 	TicketTypes struct {
@@ -80,37 +82,62 @@ type IDMappings struct {
 }
 
 var mappings struct {
-	IDMappings
 	sync.RWMutex
+	current IDMappings
 }
 
-func LoadMappingsFromFile(filename string) error {
+// ReadMappingsFromFile parses the mappings file without making it current.
+func ReadMappingsFromFile(filename string) (IDMappings, error) {
+	var m IDMappings
 	b, err := os.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("reading %q: %w", filename, err)
+		return m, fmt.Errorf("reading %q: %w", filename, err)
+	}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("parsing %q: %w", filename, err)
 	}
-	if err := yaml.Unmarshal(b, &mappings.IDMappings); err != nil {
+	return m, nil
+}
+
+func LoadMappingsFromFile(filename string) error {
+	m, err := ReadMappingsFromFile(filename)
+	if err != nil {
 		return err
 	}
+	SetMappings(m)
 	return nil
 }
 
+// Mappings returns the current mappings. They can be replaced at any time
+// by a reload, so code that needs several IDs to be consistent with each
+// other should call it once and use the returned value.
 func Mappings() IDMappings {
-	return mappings.IDMappings
+	mappings.RLock()
+	defer mappings.RUnlock()
+	return mappings.current
+}
+
+// SetMappings replaces the current mappings, e.g. with the ones
+// returned by DiscoverMappings.
+func SetMappings(m IDMappings) {
+	mappings.Lock()
+	defer mappings.Unlock()
+	mappings.current = m
 }
 
 func GetPriority(ticket *redmine.Issue) (TicketPriority, bool) {
 	if ticket.Priority == nil {
 		return 0, false
 	}
+	m := Mappings()
 	switch ticket.Priority.Id {
-	case mappings.Priorities.Low:
+	case m.Priorities.Low:
 		return PriorityLow, true
-	case mappings.Priorities.Normal:
+	case m.Priorities.Normal:
 		return PriorityNormal, true
-	case mappings.Priorities.High:
+	case m.Priorities.High:
 		return PriorityHigh, true
-	case mappings.Priorities.Urgent:
+	case m.Priorities.Urgent:
 		return PriorityUrgent, true
 	default:
 		return 0, false
@@ -118,7 +145,7 @@ func GetPriority(ticket *redmine.Issue) (TicketPriority, bool) {
 }
 
 func UserForDID(did string) string {
-	for _, u := range mappings.Users {
+	for _, u := range Mappings().Users {
 		if slices.Contains(u.DIDs, did) {
 			return u.Username
 		}
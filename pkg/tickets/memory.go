@@ -0,0 +1,753 @@
+package tickets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"bsky.watch/redmine"
+)
+
+// MemoryBackend is an in-memory implementation of Backend for use in tests.
+// It mimics the behaviour of Redmine closely enough for our purposes:
+// notes and status changes are recorded as journal entries, custom fields
+// are merged on update, and uploads are attached to the issue they are
+// passed with.
+type MemoryBackend struct {
+	*memoryState
+	login string
+}
+
+type memoryState struct {
+	mu sync.Mutex
+
+	lastId       int
+	issues       map[int]*redmine.Issue
+	attachments  map[int][]MemoryAttachment
+	relations    []redmine.IssueRelation
+	watchers     map[int][]int
+	uploads      map[string]MemoryAttachment
+	customFields []redmine.CustomFieldDefinition
+	users        []redmine.User
+	projects     []redmine.Project
+
+	statusNames    map[int]string
+	closedStatuses map[int]bool
+	trackerNames   map[int]string
+	priorityNames  map[int]string
+}
+
+// MemoryAttachment is a file uploaded into MemoryBackend.
+type MemoryAttachment struct {
+	Id       int
+	Filename string
+	Content  []byte
+}
+
+// NewMemoryBackend creates an empty ticket store. login is the name of the
+// user that the backend acts as, it is created automatically.
+func NewMemoryBackend(login string) *MemoryBackend {
+	b := &MemoryBackend{
+		memoryState: &memoryState{
+			issues:         map[int]*redmine.Issue{},
+			attachments:    map[int][]MemoryAttachment{},
+			watchers:       map[int][]int{},
+			uploads:        map[string]MemoryAttachment{},
+			statusNames:    map[int]string{},
+			closedStatuses: map[int]bool{},
+			trackerNames:   map[int]string{},
+			priorityNames:  map[int]string{},
+		},
+		login: login,
+	}
+	b.AddUser(login)
+	return b
+}
+
+func (b *MemoryBackend) nextId() int {
+	b.lastId++
+	return b.lastId
+}
+
+// AddUser creates a new user and returns its ID.
+func (b *MemoryBackend) AddUser(login string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, u := range b.users {
+		if u.Login == login {
+			return u.Id
+		}
+	}
+	u := redmine.User{Id: b.nextId(), Login: login}
+	b.users = append(b.users, u)
+	return u.Id
+}
+
+// AddCustomField adds a custom field definition.
+func (b *MemoryBackend) AddCustomField(field redmine.CustomFieldDefinition) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.customFields = append(b.customFields, field)
+}
+
+// AddProject adds a project with the given ID and name.
+func (b *MemoryBackend) AddProject(id int, name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.projects = append(b.projects, redmine.Project{Id: id, Name: name})
+}
+
+// AddSubproject adds a project nested under the parent one. Issues in it
+// are returned by FindByCustomField for the parent, same as in Redmine.
+func (b *MemoryBackend) AddSubproject(id int, parentId int, identifier string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.projects = append(b.projects, redmine.Project{Id: id, ParentID: redmine.Id{Id: parentId}, Name: identifier, Identifier: identifier})
+}
+
+// inProject returns true if the project is the given one or its subproject.
+func (b *MemoryBackend) inProject(projectId int, parentId int) bool {
+	for projectId != 0 {
+		if projectId == parentId {
+			return true
+		}
+		idx := slices.IndexFunc(b.projects, func(p redmine.Project) bool { return p.Id == projectId })
+		if idx < 0 {
+			return false
+		}
+		projectId = b.projects[idx].ParentID.Id
+	}
+	return false
+}
+
+// SetNames sets the names returned for statuses, trackers and priorities.
+// Any of the maps can be nil.
+func (b *MemoryBackend) SetNames(statuses map[int]string, trackers map[int]string, priorities map[int]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, name := range statuses {
+		b.statusNames[id] = name
+	}
+	for id, name := range trackers {
+		b.trackerNames[id] = name
+	}
+	for id, name := range priorities {
+		b.priorityNames[id] = name
+	}
+}
+
+// SetClosedStatuses marks the given statuses as closed, so that issues
+// with them are excluded by OpenTickets filter.
+func (b *MemoryBackend) SetClosedStatuses(ids ...int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, id := range ids {
+		b.closedStatuses[id] = true
+	}
+}
+
+// Issues returns all issues, sorted by ID.
+func (b *MemoryBackend) Issues() []redmine.Issue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := []redmine.Issue{}
+	for _, issue := range b.issues {
+		r = append(r, *copyIssue(issue, true))
+	}
+	slices.SortFunc(r, func(a, b redmine.Issue) int { return a.Id - b.Id })
+	return r
+}
+
+// Attachments returns the files attached to the issue.
+func (b *MemoryBackend) Attachments(issueId int) []MemoryAttachment {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.attachments[issueId])
+}
+
+// Relations returns all relations between issues.
+func (b *MemoryBackend) Relations() []redmine.IssueRelation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.relations)
+}
+
+func (b *MemoryBackend) user(login string) *redmine.User {
+	for _, u := range b.users {
+		if u.Login == login {
+			return &u
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) userById(id int) *redmine.User {
+	for _, u := range b.users {
+		if u.Id == id {
+			return &u
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Impersonate(login string) Backend {
+	return &MemoryBackend{memoryState: b.memoryState, login: login}
+}
+
+func (b *MemoryBackend) CreateIssue(issue redmine.Issue) (*redmine.Issue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	author := b.user(b.login)
+	if author == nil {
+		return nil, fmt.Errorf("user %q does not exist", b.login)
+	}
+	if issue.ProjectId == 0 {
+		return nil, fmt.Errorf("project is not set")
+	}
+	if issue.TrackerId == 0 {
+		return nil, fmt.Errorf("tracker is not set")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	created := &redmine.Issue{
+		Id:           b.nextId(),
+		Subject:      issue.Subject,
+		Description:  issue.Description,
+		ProjectId:    issue.ProjectId,
+		Project:      &redmine.IdName{Id: issue.ProjectId},
+		TrackerId:    issue.TrackerId,
+		StatusId:     issue.StatusId,
+		PriorityId:   issue.PriorityId,
+		Author:       &redmine.IdName{Id: author.Id, Name: author.Login},
+		AssignedToId: issue.AssignedToId,
+		CreatedOn:    now,
+		UpdatedOn:    now,
+		DoneRatio:    issue.DoneRatio,
+	}
+	for _, cf := range issue.CustomFields {
+		setCustomField(created, cf)
+	}
+	if err := b.attachUploads(created.Id, issue.Uploads); err != nil {
+		return nil, err
+	}
+	b.updateNames(created)
+	b.issues[created.Id] = created
+	if issue.Notes != "" {
+		created.Journals = append(created.Journals, &redmine.Journal{
+			Id:        b.nextId(),
+			User:      &redmine.IdName{Id: author.Id, Name: author.Login},
+			Notes:     issue.Notes,
+			CreatedOn: now,
+		})
+	}
+
+	return copyIssue(created, false), nil
+}
+
+func (b *MemoryBackend) UpdateIssue(issue redmine.Issue) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	author := b.user(b.login)
+	if author == nil {
+		return fmt.Errorf("user %q does not exist", b.login)
+	}
+	existing, found := b.issues[issue.Id]
+	if !found {
+		return fmt.Errorf("issue %d: not found", issue.Id)
+	}
+
+	journal := &redmine.Journal{
+		User:  &redmine.IdName{Id: author.Id, Name: author.Login},
+		Notes: issue.Notes,
+	}
+	changed := func(name string, oldValue string, newValue string) {
+		if oldValue == newValue {
+			return
+		}
+		journal.Details = append(journal.Details, redmine.JournalDetails{
+			Property: "attr",
+			Name:     name,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+	}
+
+	if issue.Subject != "" {
+		changed("subject", existing.Subject, issue.Subject)
+		existing.Subject = issue.Subject
+	}
+	changed("description", existing.Description, issue.Description)
+	existing.Description = issue.Description
+
+	// Fields can be set either by ID or by the embedded object.
+	statusId := issue.StatusId
+	if statusId == 0 && issue.Status != nil {
+		statusId = issue.Status.Id
+	}
+	if statusId != 0 {
+		changed("status_id", fmt.Sprint(existing.StatusId), fmt.Sprint(statusId))
+		existing.StatusId = statusId
+	}
+	trackerId := issue.TrackerId
+	if trackerId == 0 && issue.Tracker != nil {
+		trackerId = issue.Tracker.Id
+	}
+	if trackerId != 0 {
+		changed("tracker_id", fmt.Sprint(existing.TrackerId), fmt.Sprint(trackerId))
+		existing.TrackerId = trackerId
+	}
+	priorityId := issue.PriorityId
+	if priorityId == 0 && issue.Priority != nil {
+		priorityId = issue.Priority.Id
+	}
+	if priorityId != 0 {
+		changed("priority_id", fmt.Sprint(existing.PriorityId), fmt.Sprint(priorityId))
+		existing.PriorityId = priorityId
+	}
+	if issue.AssignedToId != 0 {
+		changed("assigned_to_id", fmt.Sprint(existing.AssignedToId), fmt.Sprint(issue.AssignedToId))
+		existing.AssignedToId = issue.AssignedToId
+	}
+	existing.DoneRatio = issue.DoneRatio
+
+	for _, cf := range issue.CustomFields {
+		oldValue, _ := json.Marshal(customFieldValue(existing, cf.Id))
+		setCustomField(existing, cf)
+		newValue, _ := json.Marshal(customFieldValue(existing, cf.Id))
+		if string(oldValue) != string(newValue) {
+			journal.Details = append(journal.Details, redmine.JournalDetails{
+				Property: "cf",
+				Name:     fmt.Sprint(cf.Id),
+				OldValue: string(oldValue),
+				NewValue: string(newValue),
+			})
+		}
+	}
+
+	if err := b.attachUploads(existing.Id, issue.Uploads); err != nil {
+		return err
+	}
+	b.updateNames(existing)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	existing.UpdatedOn = now
+	if journal.Notes != "" || len(journal.Details) > 0 {
+		journal.Id = b.nextId()
+		journal.CreatedOn = now
+		existing.Journals = append(existing.Journals, journal)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) attachUploads(issueId int, uploads []*redmine.Upload) error {
+	for _, u := range uploads {
+		if u == nil {
+			continue
+		}
+		f, found := b.uploads[u.Token]
+		if !found {
+			return fmt.Errorf("upload with token %q not found", u.Token)
+		}
+		delete(b.uploads, u.Token)
+		if u.Filename != "" {
+			f.Filename = u.Filename
+		}
+		f.Id = b.nextId()
+		b.attachments[issueId] = append(b.attachments[issueId], f)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) updateNames(issue *redmine.Issue) {
+	issue.Status = &redmine.IdName{Id: issue.StatusId, Name: b.statusNames[issue.StatusId]}
+	issue.Tracker = &redmine.IdName{Id: issue.TrackerId, Name: b.trackerNames[issue.TrackerId]}
+	if issue.PriorityId != 0 {
+		issue.Priority = &redmine.IdName{Id: issue.PriorityId, Name: b.priorityNames[issue.PriorityId]}
+	}
+	issue.AssignedTo = nil
+	if u := b.userById(issue.AssignedToId); u != nil {
+		issue.AssignedTo = &redmine.IdName{Id: u.Id, Name: u.Login}
+	}
+}
+
+func (b *MemoryBackend) Issue(id int) (*redmine.Issue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	issue, found := b.issues[id]
+	if !found {
+		return nil, fmt.Errorf("issue %d: not found", id)
+	}
+	return copyIssue(issue, false), nil
+}
+
+func (b *MemoryBackend) IssueWithJournals(id int) (*redmine.Issue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	issue, found := b.issues[id]
+	if !found {
+		return nil, fmt.Errorf("issue %d: not found", id)
+	}
+	return copyIssue(issue, true), nil
+}
+
+func (b *MemoryBackend) UpdateJournal(journal *redmine.Journal) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, issue := range b.issues {
+		for _, j := range issue.Journals {
+			if j.Id == journal.Id {
+				j.Notes = journal.Notes
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("journal %d: not found", journal.Id)
+}
+
+func (b *MemoryBackend) FindByCustomField(projectId int, fieldId int, value string, status StatusFilter) ([]redmine.Issue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := []redmine.Issue{}
+	for _, issue := range b.issues {
+		if !b.inProject(issue.ProjectId, projectId) {
+			continue
+		}
+		closed := issue.Status != nil && b.closedStatuses[issue.Status.Id]
+		switch status {
+		case OpenTickets:
+			if closed {
+				continue
+			}
+		case ClosedTickets:
+			if !closed {
+				continue
+			}
+		case AllTickets:
+		default:
+			return nil, fmt.Errorf("unsupported status filter %q", status)
+		}
+		match := false
+		switch v := customFieldValue(issue, fieldId).(type) {
+		case string:
+			match = v == value
+		case []string:
+			match = slices.Contains(v, value)
+		}
+		if match {
+			r = append(r, *copyIssue(issue, false))
+		}
+	}
+	slices.SortFunc(r, func(a, b redmine.Issue) int { return a.Id - b.Id })
+	return r, nil
+}
+
+func (b *MemoryBackend) IssueRelations(issueId int) ([]redmine.IssueRelation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, found := b.issues[issueId]; !found {
+		return nil, fmt.Errorf("issue %d: not found", issueId)
+	}
+	r := []redmine.IssueRelation{}
+	for _, rel := range b.relations {
+		if rel.IssueId == issueId || rel.IssueToId == issueId {
+			r = append(r, rel)
+		}
+	}
+	return r, nil
+}
+
+func (b *MemoryBackend) CreateIssueRelation(relation redmine.IssueRelation) (*redmine.IssueRelation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if relation.IssueId == relation.IssueToId {
+		return nil, fmt.Errorf("issue can't be related to itself")
+	}
+	for _, id := range []int{relation.IssueId, relation.IssueToId} {
+		if _, found := b.issues[id]; !found {
+			return nil, fmt.Errorf("issue %d: not found", id)
+		}
+	}
+	for _, rel := range b.relations {
+		if (rel.IssueId == relation.IssueId && rel.IssueToId == relation.IssueToId) ||
+			(rel.IssueId == relation.IssueToId && rel.IssueToId == relation.IssueId) {
+			return nil, fmt.Errorf("relation already exists")
+		}
+	}
+	relation.Id = b.nextId()
+	b.relations = append(b.relations, relation)
+	return &relation, nil
+}
+
+func (b *MemoryBackend) DeleteIssueRelation(id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	idx := slices.IndexFunc(b.relations, func(rel redmine.IssueRelation) bool { return rel.Id == id })
+	if idx < 0 {
+		return fmt.Errorf("relation %d: not found", id)
+	}
+	b.relations = slices.Delete(b.relations, idx, idx+1)
+	return nil
+}
+
+func (b *MemoryBackend) AddWatcher(issueId int, userId int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, found := b.issues[issueId]; !found {
+		return fmt.Errorf("issue %d: not found", issueId)
+	}
+	if b.userById(userId) == nil {
+		return fmt.Errorf("user %d: not found", userId)
+	}
+	if !slices.Contains(b.watchers[issueId], userId) {
+		b.watchers[issueId] = append(b.watchers[issueId], userId)
+	}
+	return nil
+}
+
+// Watchers returns IDs of the users watching the issue.
+func (b *MemoryBackend) Watchers(issueId int) []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.watchers[issueId])
+}
+
+func (b *MemoryBackend) IssueAttachments(issueId int) ([]Attachment, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, found := b.issues[issueId]; !found {
+		return nil, fmt.Errorf("issue %d: not found", issueId)
+	}
+	r := []Attachment{}
+	for _, a := range b.attachments[issueId] {
+		r = append(r, Attachment{Id: a.Id, Filename: a.Filename})
+	}
+	return r, nil
+}
+
+func (b *MemoryBackend) DownloadAttachment(attachment Attachment) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, list := range b.attachments {
+		for _, a := range list {
+			if a.Id == attachment.Id {
+				return slices.Clone(a.Content), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("attachment %d: not found", attachment.Id)
+}
+
+func (b *MemoryBackend) Upload(filename string) (*redmine.Upload, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextId()
+	token := fmt.Sprintf("%d.token", id)
+	b.uploads[token] = MemoryAttachment{Filename: filepath.Base(filename), Content: content}
+	return &redmine.Upload{Id: id, Token: token, Filename: filepath.Base(filename)}, nil
+}
+
+func (b *MemoryBackend) CustomFields() ([]redmine.CustomFieldDefinition, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := []redmine.CustomFieldDefinition{}
+	for _, f := range b.customFields {
+		f.PossibleValues = slices.Clone(f.PossibleValues)
+		r = append(r, f)
+	}
+	return r, nil
+}
+
+func (b *MemoryBackend) UpdateCustomField(field redmine.CustomFieldDefinition) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, f := range b.customFields {
+		if f.Id == field.Id {
+			field.PossibleValues = slices.Clone(field.PossibleValues)
+			b.customFields[i] = field
+			return nil
+		}
+	}
+	return fmt.Errorf("custom field %d: not found", field.Id)
+}
+
+func (b *MemoryBackend) MyAccount() (*redmine.User, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	u := b.user(b.login)
+	if u == nil {
+		return nil, fmt.Errorf("user %q does not exist", b.login)
+	}
+	return u, nil
+}
+
+func (b *MemoryBackend) User(id int) (*redmine.User, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, u := range b.users {
+		if u.Id == id {
+			return &u, nil
+		}
+	}
+	return nil, fmt.Errorf("user %d: not found", id)
+}
+
+func (b *MemoryBackend) Users() ([]redmine.User, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.users), nil
+}
+
+func (b *MemoryBackend) Projects() ([]redmine.Project, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.projects), nil
+}
+
+func (b *MemoryBackend) Trackers() ([]redmine.IdName, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := []redmine.IdName{}
+	for id, name := range b.trackerNames {
+		r = append(r, redmine.IdName{Id: id, Name: name})
+	}
+	slices.SortFunc(r, func(a, b redmine.IdName) int { return a.Id - b.Id })
+	return r, nil
+}
+
+func (b *MemoryBackend) IssueStatuses() ([]redmine.IssueStatus, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := []redmine.IssueStatus{}
+	for id, name := range b.statusNames {
+		r = append(r, redmine.IssueStatus{Id: id, Name: name, IsClosed: b.closedStatuses[id]})
+	}
+	slices.SortFunc(r, func(a, b redmine.IssueStatus) int { return a.Id - b.Id })
+	return r, nil
+}
+
+func (b *MemoryBackend) IssuePriorities() ([]redmine.IssuePriority, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := []redmine.IssuePriority{}
+	for id, name := range b.priorityNames {
+		r = append(r, redmine.IssuePriority{Id: id, Name: name})
+	}
+	slices.SortFunc(r, func(a, b redmine.IssuePriority) int { return a.Id - b.Id })
+	return r, nil
+}
+
+// customFieldValue returns the value of the custom field, normalized
+// to either a string or a slice of strings. Returns nil if the field is not set.
+func customFieldValue(issue *redmine.Issue, id int) any {
+	for _, cf := range issue.CustomFields {
+		if cf.Id == id {
+			return cf.Value
+		}
+	}
+	return nil
+}
+
+func setCustomField(issue *redmine.Issue, cf *redmine.CustomField) {
+	if cf == nil {
+		return
+	}
+	value := cf.Value
+	switch v := value.(type) {
+	case []string:
+		value = slices.Clone(v)
+	case []any:
+		s := []string{}
+		for _, item := range v {
+			s = append(s, fmt.Sprint(item))
+		}
+		value = s
+	case string:
+	case nil:
+		value = ""
+	default:
+		value = fmt.Sprint(v)
+	}
+
+	for _, existing := range issue.CustomFields {
+		if existing.Id == cf.Id {
+			existing.Value = value
+			return
+		}
+	}
+	issue.CustomFields = append(issue.CustomFields, &redmine.CustomField{
+		Id:       cf.Id,
+		Name:     cf.Name,
+		Multiple: cf.Multiple,
+		Value:    value,
+	})
+}
+
+func copyIssue(issue *redmine.Issue, withJournals bool) *redmine.Issue {
+	r := *issue
+	r.Uploads = nil
+	r.Notes = ""
+	r.CustomFields = nil
+	for _, cf := range issue.CustomFields {
+		copied := *cf
+		if v, ok := cf.Value.([]string); ok {
+			copied.Value = slices.Clone(v)
+		}
+		r.CustomFields = append(r.CustomFields, &copied)
+	}
+	r.Journals = nil
+	if withJournals {
+		for _, j := range issue.Journals {
+			copied := *j
+			copied.Details = slices.Clone(j.Details)
+			r.Journals = append(r.Journals, &copied)
+		}
+	}
+	for _, p := range []**redmine.IdName{&r.Project, &r.Tracker, &r.Status, &r.Priority, &r.Author, &r.AssignedTo} {
+		if *p != nil {
+			copied := **p
+			*p = &copied
+		}
+	}
+	return &r
+}
@@ -0,0 +1,183 @@
+package tickets
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/attachments"
+)
+
+// MarkDuplicate sets the status of the ticket to "Duplicate" and adds
+// "duplicates" relation pointing to the canonical ticket, replacing any
+// other relation between the two.
+func MarkDuplicate(ctx context.Context, client Backend, ticket *redmine.Issue, canonical *redmine.Issue, opts ...TicketOption) (*redmine.Issue, error) {
+	if ticket.Id == canonical.Id {
+		return nil, fmt.Errorf("ticket %d can't be a duplicate of itself", ticket.Id)
+	}
+
+	rels, err := client.IssueRelations(ticket.Id)
+	if err != nil {
+		return nil, fmt.Errorf("IssueRelations(%d): %w", ticket.Id, err)
+	}
+	linked := false
+	for _, rel := range rels {
+		if rel.IssueId == ticket.Id && rel.IssueToId == canonical.Id && rel.RelationType == "duplicates" {
+			linked = true
+			continue
+		}
+		// Redmine allows only one relation between two issues.
+		if (rel.IssueId == ticket.Id && rel.IssueToId == canonical.Id) ||
+			(rel.IssueId == canonical.Id && rel.IssueToId == ticket.Id) {
+			if err := client.DeleteIssueRelation(rel.Id); err != nil {
+				return nil, fmt.Errorf("removing %q relation between %d and %d: %w", rel.RelationType, rel.IssueId, rel.IssueToId, err)
+			}
+		}
+	}
+	if !linked {
+		_, err := client.CreateIssueRelation(redmine.IssueRelation{
+			IssueId:      ticket.Id,
+			IssueToId:    canonical.Id,
+			RelationType: "duplicates",
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating relation from %d to %d: %w", ticket.Id, canonical.Id, err)
+		}
+	}
+
+	return Update(ctx, client, ticket, append([]TicketOption{Status(StatusDuplicate)}, opts...)...)
+}
+
+// Merge copies notes (as quotes), attachments and values of "Labels" and
+// "Add to lists" fields from the ticket into the canonical one, and then marks
+// the ticket as its duplicate. Returns the updated canonical ticket.
+//
+// Merge can be safely retried: if the canonical ticket already has the note
+// about merging the ticket, nothing is copied again.
+func Merge(ctx context.Context, client Backend, ticket *redmine.Issue, canonical *redmine.Issue) (*redmine.Issue, error) {
+	if ticket.Id == canonical.Id {
+		return nil, fmt.Errorf("can't merge ticket %d into itself", ticket.Id)
+	}
+
+	from, err := client.IssueWithJournals(ticket.Id)
+	if err != nil {
+		return nil, fmt.Errorf("IssueWithJournals(%d): %w", ticket.Id, err)
+	}
+	into, err := client.IssueWithJournals(canonical.Id)
+	if err != nil {
+		return nil, fmt.Errorf("IssueWithJournals(%d): %w", canonical.Id, err)
+	}
+
+	if !slices.ContainsFunc(into.Journals, func(j *redmine.Journal) bool {
+		return strings.HasPrefix(j.Notes, mergeNotePrefix(from))
+	}) {
+		into, err = copyIntoCanonical(ctx, client, from, into)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := MarkDuplicate(ctx, client, from, into, WithNote(fmt.Sprintf("Merged into #%d", into.Id))); err != nil {
+		return nil, err
+	}
+	return into, nil
+}
+
+// copyIntoCanonical does the copying part of Merge.
+func copyIntoCanonical(ctx context.Context, client Backend, from *redmine.Issue, into *redmine.Issue) (*redmine.Issue, error) {
+	m := Mappings()
+
+	files, err := client.IssueAttachments(from.Id)
+	if err != nil {
+		return nil, fmt.Errorf("IssueAttachments(%d): %w", from.Id, err)
+	}
+	uploader := attachments.NewGlobalAttachmentCreator(client)
+	for _, f := range files {
+		content, err := client.DownloadAttachment(f)
+		if err != nil {
+			return nil, fmt.Errorf("downloading %q: %w", f.Filename, err)
+		}
+		if _, err := uploader.Upload(ctx, f.Filename, content); err != nil {
+			return nil, fmt.Errorf("uploading %q: %w", f.Filename, err)
+		}
+	}
+
+	opts := []TicketOption{
+		WithNote(mergeNote(from)),
+		Attachments(uploader.Created()),
+	}
+	for field, opt := range map[int]func([]string) TicketOption{
+		m.Fields.Labels:     Labels,
+		m.Fields.AddToLists: AddToLists,
+	} {
+		if field == 0 {
+			continue
+		}
+		current := FieldValues(into, field)
+		merged := current
+		for _, v := range FieldValues(from, field) {
+			if !slices.Contains(merged, v) {
+				merged = append(merged, v)
+			}
+		}
+		if len(merged) > len(current) {
+			opts = append(opts, opt(merged))
+		}
+	}
+
+	updated, err := Update(ctx, client, into, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("updating ticket %d: %w", into.Id, err)
+	}
+	return updated, nil
+}
+
+func mergeNotePrefix(from *redmine.Issue) string {
+	return fmt.Sprintf("Merged #%d ", from.Id)
+}
+
+// mergeNote returns the text of the note added to the canonical ticket,
+// with all notes from the merged one quoted.
+func mergeNote(from *redmine.Issue) string {
+	text := fmt.Sprintf("%s(%s).", mergeNotePrefix(from), from.Subject)
+	for _, j := range from.Journals {
+		if strings.TrimSpace(j.Notes) == "" {
+			continue
+		}
+		author := "Someone"
+		if j.User != nil && j.User.Name != "" {
+			author = j.User.Name
+		}
+		text += fmt.Sprintf("\n\n%s wrote on %s:\n\n", author, j.CreatedOn)
+		for _, line := range strings.Split(strings.TrimRight(j.Notes, "\n"), "\n") {
+			text += "> " + line + "\n"
+		}
+	}
+	return text
+}
+
+// FieldValues returns the values of a custom field, for both single- and
+// multi-value fields. Empty values are omitted.
+func FieldValues(issue *redmine.Issue, fieldId int) []string {
+	r := []string{}
+	for _, cf := range issue.CustomFields {
+		if cf.Id != fieldId {
+			continue
+		}
+		switch v := cf.Value.(type) {
+		case []string:
+			r = append(r, v...)
+		case []any:
+			for _, item := range v {
+				r = append(r, fmt.Sprint(item))
+			}
+		case nil:
+		default:
+			r = append(r, fmt.Sprint(v))
+		}
+	}
+	return slices.DeleteFunc(r, func(s string) bool { return s == "" })
+}
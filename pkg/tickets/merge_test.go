@@ -0,0 +1,125 @@
+package tickets
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"bsky.watch/redmine"
+
+	"bsky.watch/modkit/pkg/attachments"
+)
+
+func TestMarkDuplicateReplacesRelation(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	canonical, err := Create(ctx, backend, Subject("alice.test"), DID("did:plc:alice"), Type(TypeTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	dupe, err := Create(ctx, backend, Subject("alice.test"), DID("did:plc:alice"), Type(TypeTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := backend.CreateIssueRelation(redmine.IssueRelation{IssueId: canonical.Id, IssueToId: dupe.Id, RelationType: "relates"}); err != nil {
+		t.Fatalf("CreateIssueRelation() failed: %s", err)
+	}
+
+	updated, err := MarkDuplicate(ctx, backend, dupe, canonical)
+	if err != nil {
+		t.Fatalf("MarkDuplicate() failed: %s", err)
+	}
+	if updated.Status == nil || updated.Status.Id != testMappings().Statuses.Duplicate {
+		t.Errorf("Status is %+v, want %d", updated.Status, testMappings().Statuses.Duplicate)
+	}
+	rels := backend.Relations()
+	if len(rels) != 1 || rels[0].IssueId != dupe.Id || rels[0].IssueToId != canonical.Id || rels[0].RelationType != "duplicates" {
+		t.Errorf("Relations: %+v, want %d duplicates %d", rels, dupe.Id, canonical.Id)
+	}
+
+	// Repeating is a no-op.
+	if _, err := MarkDuplicate(ctx, backend, updated, canonical); err != nil {
+		t.Fatalf("MarkDuplicate() failed the second time: %s", err)
+	}
+	if got := backend.Relations(); len(got) != 1 {
+		t.Errorf("Relations after repeating: %+v", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	canonical, err := Create(ctx, backend, Subject("alice.test"), DID("did:plc:alice"), Type(TypeTicket),
+		Labels([]string{"spam", "rude"}))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	upload, err := attachments.NewGlobalAttachmentCreator(backend).Upload(ctx, "post.json", []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Upload() failed: %s", err)
+	}
+	dupe, err := Create(ctx, backend, Subject("alice.test"), DID("did:plc:alice"), Type(TypeTicket),
+		Labels([]string{"rude", "troll"}), AddToLists([]string{"Trolls [trolls]"}),
+		Attachments([]*redmine.Upload{upload}))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	dupe, err = Update(ctx, backend, dupe, WithNote("Looks like a troll"))
+	if err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+
+	check := func() {
+		t.Helper()
+		got, err := backend.IssueWithJournals(canonical.Id)
+		if err != nil {
+			t.Fatalf("IssueWithJournals() failed: %s", err)
+		}
+		if labels := FieldValues(got, testMappings().Fields.Labels); !slices.Equal(labels, []string{"spam", "rude", "troll"}) {
+			t.Errorf("Labels are %q", labels)
+		}
+		if lists := FieldValues(got, testMappings().Fields.AddToLists); !slices.Equal(lists, []string{"Trolls [trolls]"}) {
+			t.Errorf("Lists are %q", lists)
+		}
+		if files := backend.Attachments(canonical.Id); len(files) != 1 || string(files[0].Content) != `{}` {
+			t.Errorf("Attachments are %+v, want one copy of post.json", files)
+		}
+		notes := 0
+		for _, j := range got.Journals {
+			if strings.HasPrefix(j.Notes, fmt.Sprintf("Merged #%d ", dupe.Id)) {
+				notes++
+				if !strings.Contains(j.Notes, "> Looks like a troll") {
+					t.Errorf("Note doesn't quote the merged ticket: %q", j.Notes)
+				}
+			}
+		}
+		if notes != 1 {
+			t.Errorf("Merge note was added %d times, want 1", notes)
+		}
+
+		merged, err := backend.Issue(dupe.Id)
+		if err != nil {
+			t.Fatalf("Issue() failed: %s", err)
+		}
+		if merged.Status == nil || merged.Status.Id != testMappings().Statuses.Duplicate {
+			t.Errorf("Status of the merged ticket is %+v, want %d", merged.Status, testMappings().Statuses.Duplicate)
+		}
+	}
+
+	if _, err := Merge(ctx, backend, dupe, canonical); err != nil {
+		t.Fatalf("Merge() failed: %s", err)
+	}
+	check()
+
+	// Retrying doesn't copy anything again.
+	if _, err := Merge(ctx, backend, dupe, canonical); err != nil {
+		t.Fatalf("Merge() failed the second time: %s", err)
+	}
+	check()
+}
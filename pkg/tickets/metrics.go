@@ -0,0 +1,15 @@
+package tickets
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var lookupCache = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "modkit",
+	Subsystem: "tickets",
+	Name:      "lookup_cache_requests_total",
+	Help:      "Number of ticket lookups served by the cache, by outcome",
+}, []string{
+	"result",
+})
@@ -35,13 +35,13 @@ func Priority(prio TicketPriority) TicketOption {
 		p := -1
 		switch prio {
 		case PriorityLow:
-			p = mappings.Priorities.Low
+			p = Mappings().Priorities.Low
 		case PriorityNormal:
-			p = mappings.Priorities.Normal
+			p = Mappings().Priorities.Normal
 		case PriorityHigh:
-			p = mappings.Priorities.High
+			p = Mappings().Priorities.High
 		case PriorityUrgent:
-			p = mappings.Priorities.Urgent
+			p = Mappings().Priorities.Urgent
 		default:
 			return fmt.Errorf("unknown priority value %+v", prio)
 		}
@@ -64,16 +64,33 @@ func Attachments(list []*redmine.Upload) TicketOption {
 	}
 }
 
+// Project sets the project of the ticket, instead of the one from
+// the ID mappings.
+func Project(id int) TicketOption {
+	return func(ticket *ticketData) error {
+		ticket.ProjectId = id
+		return nil
+	}
+}
+
+// AssignedTo assigns the ticket to the user.
+func AssignedTo(userId int) TicketOption {
+	return func(ticket *ticketData) error {
+		ticket.AssignedToId = userId
+		return nil
+	}
+}
+
 func Type(typ TicketType) TicketOption {
 	return func(ticket *ticketData) error {
 		value := -1
 		switch typ {
 		case TypeTicket:
-			value = mappings.TicketTypes.Ticket
+			value = Mappings().TicketTypes.Ticket
 		case TypeAppeal:
-			value = mappings.TicketTypes.Appeal
+			value = Mappings().TicketTypes.Appeal
 		case TypeRecordTicket:
-			value = mappings.TicketTypes.RecordTicket
+			value = Mappings().TicketTypes.RecordTicket
 		}
 		if value < 0 {
 			return fmt.Errorf("missing mapping for ticket type %+v", typ)
@@ -85,7 +102,7 @@ func Type(typ TicketType) TicketOption {
 
 func DID(did string) TicketOption {
 	return func(ticket *ticketData) error {
-		field := mappings.Fields.DID
+		field := Mappings().Fields.DID
 		if field == 0 {
 			return fmt.Errorf("missing mapping for DID field")
 		}
@@ -99,7 +116,7 @@ func DID(did string) TicketOption {
 
 func Handle(handle string) TicketOption {
 	return func(ticket *ticketData) error {
-		field := mappings.Fields.Handle
+		field := Mappings().Fields.Handle
 		if field == 0 {
 			return fmt.Errorf("missing mapping for handle field")
 		}
@@ -113,7 +130,7 @@ func Handle(handle string) TicketOption {
 
 func DisplayName(displayName string) TicketOption {
 	return func(ticket *ticketData) error {
-		field := mappings.Fields.DisplayName
+		field := Mappings().Fields.DisplayName
 		if field == 0 {
 			return fmt.Errorf("missing mapping for display name field")
 		}
@@ -130,13 +147,15 @@ func Status(status TicketStatus) TicketOption {
 		value := -1
 		switch status {
 		case StatusApplied:
-			value = mappings.Statuses.Applied
+			value = Mappings().Statuses.Applied
 		case StatusCompleted:
-			value = mappings.Statuses.Completed
+			value = Mappings().Statuses.Completed
 		case StatusDuplicate:
-			value = mappings.Statuses.Duplicate
+			value = Mappings().Statuses.Duplicate
 		case StatusInProgress:
-			value = mappings.Statuses.InProgress
+			value = Mappings().Statuses.InProgress
+		case StatusGranted:
+			value = Mappings().Statuses.Granted
 		}
 		if value < 0 {
 			return fmt.Errorf("missing mapping for ticket status %+v", status)
@@ -163,7 +182,7 @@ func WithNote(text string) TicketOption {
 
 func ReportSubject(uri string) TicketOption {
 	return func(ticket *ticketData) error {
-		field := mappings.Fields.Subject
+		field := Mappings().Fields.Subject
 		if field == 0 {
 			return fmt.Errorf("missing mapping for display name field")
 		}
@@ -174,3 +193,50 @@ func ReportSubject(uri string) TicketOption {
 		return nil
 	}
 }
+
+// Labels sets the values of "Labels" field. Values must be in the same
+// format as the ones returned by config.LabelFieldValues.
+func Labels(values []string) TicketOption {
+	return func(ticket *ticketData) error {
+		field := Mappings().Fields.Labels
+		if field == 0 {
+			return fmt.Errorf("missing mapping for labels field")
+		}
+		ticket.CustomFields = append(ticket.CustomFields, &redmine.CustomField{
+			Id:    field,
+			Value: values,
+		})
+		return nil
+	}
+}
+
+// AddToLists sets the values of "Add to lists" field. Values must be in the same
+// format as the ones returned by config.ListFieldValues.
+func AddToLists(values []string) TicketOption {
+	return func(ticket *ticketData) error {
+		field := Mappings().Fields.AddToLists
+		if field == 0 {
+			return fmt.Errorf("missing mapping for add to lists field")
+		}
+		ticket.CustomFields = append(ticket.CustomFields, &redmine.CustomField{
+			Id:    field,
+			Value: values,
+		})
+		return nil
+	}
+}
+
+// Approver sets the value of "Approver" field.
+func Approver(userId int) TicketOption {
+	return func(ticket *ticketData) error {
+		field := Mappings().Fields.Approver
+		if field == 0 {
+			return fmt.Errorf("missing mapping for approver field")
+		}
+		ticket.CustomFields = append(ticket.CustomFields, &redmine.CustomField{
+			Id:    field,
+			Value: fmt.Sprint(userId),
+		})
+		return nil
+	}
+}
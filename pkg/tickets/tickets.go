@@ -11,12 +11,13 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func Create(ctx context.Context, client *redmine.Client, opts ...TicketOption) (*redmine.Issue, error) {
+func Create(ctx context.Context, client Backend, opts ...TicketOption) (*redmine.Issue, error) {
 	log := zerolog.Ctx(ctx)
 
+	m := Mappings()
 	data := &ticketData{}
-	data.ProjectId = mappings.ProjectID
-	data.StatusId = mappings.Statuses.New
+	data.ProjectId = m.ProjectID
+	data.StatusId = m.Statuses.New
 
 	for _, opt := range opts {
 		if err := opt(data); err != nil {
@@ -26,7 +27,7 @@ func Create(ctx context.Context, client *redmine.Client, opts ...TicketOption) (
 
 	related := []int{}
 	if data.newDID != "" {
-		result, err := FindByDID(ctx, client, data.newDID)
+		result, err := FindByDID(ctx, client, data.newDID, AllTickets)
 		if err != nil {
 			return nil, fmt.Errorf("checking for existing tickets with the same DID: %w", err)
 		}
@@ -58,7 +59,7 @@ func Create(ctx context.Context, client *redmine.Client, opts ...TicketOption) (
 	return ticket, nil
 }
 
-func Update(ctx context.Context, client *redmine.Client, ticket *redmine.Issue, opts ...TicketOption) (*redmine.Issue, error) {
+func Update(ctx context.Context, client Backend, ticket *redmine.Issue, opts ...TicketOption) (*redmine.Issue, error) {
 	update := &ticketData{
 		Issue:     *ticket,
 		preUpdate: ticket,
@@ -72,7 +73,7 @@ func Update(ctx context.Context, client *redmine.Client, ticket *redmine.Issue,
 
 	related := []int{}
 	if update.newDID != "" {
-		result, err := FindByDID(ctx, client, update.newDID)
+		result, err := FindByDID(ctx, client, update.newDID, AllTickets)
 		if err != nil {
 			return nil, fmt.Errorf("checking for existing tickets with the same DID: %w", err)
 		}
@@ -118,49 +119,37 @@ func Update(ctx context.Context, client *redmine.Client, ticket *redmine.Issue,
 	return ticket, nil
 }
 
-func AddNote(ctx context.Context, client *redmine.Client, ticket *redmine.Issue, note string) (*redmine.Journal, error) {
+func AddNote(ctx context.Context, client Backend, ticket *redmine.Issue, note string) (*redmine.Journal, error) {
 	copied := *ticket
 	copied.Notes = note
 	return nil, client.UpdateIssue(copied)
 }
 
-func FindByDID(ctx context.Context, client *redmine.Client, did string) ([]redmine.Issue, error) {
-	didField := fmt.Sprintf("cf_%d", mappings.Fields.DID)
-
-	return client.IssuesByFilter(&redmine.IssueFilter{
-		ProjectId: fmt.Sprint(mappings.ProjectID),
-		ExtraFilters: map[string]string{
-			"f[]":                            didField,
-			fmt.Sprintf("op[%s]", didField):  "=",
-			fmt.Sprintf("v[%s][]", didField): did,
-		},
-	})
+// FindByDID returns all tickets about the account that match the status filter.
+func FindByDID(ctx context.Context, client Backend, did string, status StatusFilter) ([]redmine.Issue, error) {
+	m := Mappings()
+	return client.FindByCustomField(m.ProjectID, m.Fields.DID, did, status)
 }
 
-func FindBySubject(ctx context.Context, client *redmine.Client, subject string) ([]redmine.Issue, error) {
-	subjectField := fmt.Sprintf("cf_%d", mappings.Fields.Subject)
-
-	return client.IssuesByFilter(&redmine.IssueFilter{
-		ProjectId: fmt.Sprint(mappings.ProjectID),
-		ExtraFilters: map[string]string{
-			"f[]":                                subjectField,
-			fmt.Sprintf("op[%s]", subjectField):  "=",
-			fmt.Sprintf("v[%s][]", subjectField): subject,
-		},
-	})
+// FindBySubject returns all tickets about the subject (account or record)
+// that match the status filter.
+func FindBySubject(ctx context.Context, client Backend, subject string, status StatusFilter) ([]redmine.Issue, error) {
+	m := Mappings()
+	return client.FindByCustomField(m.ProjectID, m.Fields.Subject, subject, status)
 }
 
 func SelectDedupeTicket(ctx context.Context, tickets []redmine.Issue) *redmine.Issue {
+	m := Mappings()
 	dedupeCandidates := []redmine.Issue{}
 
 	for _, t := range tickets {
 		if t.Tracker != nil &&
-			t.Tracker.Id != mappings.TicketTypes.Ticket &&
-			(mappings.TicketTypes.RecordTicket == 0 ||
-				t.Tracker.Id != mappings.TicketTypes.RecordTicket) {
+			t.Tracker.Id != m.TicketTypes.Ticket &&
+			(m.TicketTypes.RecordTicket == 0 ||
+				t.Tracker.Id != m.TicketTypes.RecordTicket) {
 			continue
 		}
-		if t.Status != nil && t.Status.Id == mappings.Statuses.Duplicate {
+		if t.Status != nil && t.Status.Id == m.Statuses.Duplicate {
 			continue
 		}
 		dedupeCandidates = append(dedupeCandidates, t)
@@ -0,0 +1,355 @@
+package tickets
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"bsky.watch/redmine"
+)
+
+func testMappings() IDMappings {
+	m := IDMappings{ProjectID: 1}
+	m.Priorities.Low = 1
+	m.Priorities.Normal = 2
+	m.Priorities.High = 3
+	m.Priorities.Urgent = 4
+	m.Statuses.New = 1
+	m.Statuses.InProgress = 2
+	m.Statuses.Completed = 3
+	m.Statuses.Applied = 4
+	m.Statuses.Duplicate = 5
+	m.Statuses.Granted = 6
+	m.TicketTypes.Ticket = 1
+	m.TicketTypes.Appeal = 2
+	m.TicketTypes.RecordTicket = 3
+	m.Fields.DID = 1
+	m.Fields.Handle = 2
+	m.Fields.DisplayName = 3
+	m.Fields.Subject = 4
+	m.Fields.AddToLists = 5
+	m.Fields.Labels = 6
+	return m
+}
+
+func stringValue(t *testing.T, issue *redmine.Issue, id int) string {
+	t.Helper()
+	for _, cf := range issue.CustomFields {
+		if cf.Id == id {
+			s, ok := cf.Value.(string)
+			if !ok {
+				t.Fatalf("Field %d has value of type %T, want string", id, cf.Value)
+			}
+			return s
+		}
+	}
+	return ""
+}
+
+func TestCreate(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	ticket, err := Create(ctx, backend,
+		Subject("alice.test"),
+		DID("did:plc:alice"),
+		Handle("alice.test"),
+		Type(TypeTicket),
+		Priority(PriorityUrgent))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+
+	got, err := backend.Issue(ticket.Id)
+	if err != nil {
+		t.Fatalf("Issue(%d) failed: %s", ticket.Id, err)
+	}
+	if got.Subject != "alice.test" {
+		t.Errorf("Subject is %q, want %q", got.Subject, "alice.test")
+	}
+	if got.Status.Id != Mappings().Statuses.New {
+		t.Errorf("Status is %d, want %d", got.Status.Id, Mappings().Statuses.New)
+	}
+	if got.Priority.Id != Mappings().Priorities.Urgent {
+		t.Errorf("Priority is %d, want %d", got.Priority.Id, Mappings().Priorities.Urgent)
+	}
+	if did := stringValue(t, got, Mappings().Fields.DID); did != "did:plc:alice" {
+		t.Errorf("DID is %q, want %q", did, "did:plc:alice")
+	}
+	if got.Author == nil || got.Author.Name != "modkit" {
+		t.Errorf("Author is %+v, want modkit", got.Author)
+	}
+}
+
+func TestFindByDID(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	first, err := Create(ctx, backend, Subject("alice.test"), DID("did:plc:alice"), Type(TypeTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := Create(ctx, backend, Subject("bob.test"), DID("did:plc:bob"), Type(TypeTicket)); err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	second, err := Create(ctx, backend, Subject("alice.test post"), DID("did:plc:alice"), Type(TypeRecordTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+
+	found, err := FindByDID(ctx, backend, "did:plc:alice", AllTickets)
+	if err != nil {
+		t.Fatalf("FindByDID() failed: %s", err)
+	}
+	if len(found) != 2 || found[0].Id != first.Id || found[1].Id != second.Id {
+		t.Errorf("FindByDID() returned %d tickets, want %d and %d", len(found), first.Id, second.Id)
+	}
+}
+
+func TestCreateAsImpersonatedUser(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+	backend.AddUser("moderator")
+
+	ticket, err := Create(ctx, backend, Subject("alice.test"), Type(TypeTicket), Author("moderator"))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if ticket.Author == nil || ticket.Author.Name != "moderator" {
+		t.Errorf("Author is %+v, want moderator", ticket.Author)
+	}
+
+	if _, err := Create(ctx, backend, Subject("bob.test"), Type(TypeTicket), Author("nobody")); err == nil {
+		t.Errorf("Create() as a non-existent user succeeded, want an error")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	existing, err := Create(ctx, backend, Subject("alice.test"), DID("did:plc:alice"), Type(TypeTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	ticket, err := Create(ctx, backend, Subject("https://bsky.app/profile/alice.test"), Type(TypeTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+
+	updated, err := Update(ctx, backend, ticket,
+		Subject("alice.test"),
+		DID("did:plc:alice"),
+		Status(StatusApplied),
+		WithNote("Done"))
+	if err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+	if updated.Subject != "alice.test" {
+		t.Errorf("Subject is %q, want %q", updated.Subject, "alice.test")
+	}
+	if updated.Status.Id != Mappings().Statuses.Applied {
+		t.Errorf("Status is %d, want %d", updated.Status.Id, Mappings().Statuses.Applied)
+	}
+	if did := stringValue(t, updated, Mappings().Fields.DID); did != "did:plc:alice" {
+		t.Errorf("DID is %q, want %q", did, "did:plc:alice")
+	}
+
+	withJournals, err := backend.IssueWithJournals(ticket.Id)
+	if err != nil {
+		t.Fatalf("IssueWithJournals() failed: %s", err)
+	}
+	if len(withJournals.Journals) != 1 || withJournals.Journals[0].Notes != "Done" {
+		t.Fatalf("Got journals %+v, want one with the note", withJournals.Journals)
+	}
+	foundStatusChange := false
+	for _, d := range withJournals.Journals[0].Details {
+		if d.Property == "attr" && d.Name == "status_id" && d.NewValue == "4" {
+			foundStatusChange = true
+		}
+	}
+	if !foundStatusChange {
+		t.Errorf("Journal %+v doesn't record the status change", withJournals.Journals[0])
+	}
+
+	found, err := FindByDID(ctx, backend, "did:plc:alice", AllTickets)
+	if err != nil {
+		t.Fatalf("FindByDID() failed: %s", err)
+	}
+	if len(found) != 2 || found[0].Id != existing.Id || found[1].Id != ticket.Id {
+		t.Errorf("FindByDID() returned %+v, want tickets %d and %d", found, existing.Id, ticket.Id)
+	}
+}
+
+func TestUpdateMultiValueFields(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	ticket, err := Create(ctx, backend, Subject("alice.test"), Type(TypeAppeal), Labels([]string{"Spam [spam]"}))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	ticket, err = Update(ctx, backend, ticket, AddToLists([]string{"Trolls [trolls]"}), Labels([]string{"Spam [spam]", "Rude [rude]"}))
+	if err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+
+	values := map[int][]string{}
+	for _, cf := range ticket.CustomFields {
+		if v, ok := cf.Value.([]string); ok {
+			values[cf.Id] = v
+		}
+	}
+	if got := values[Mappings().Fields.Labels]; len(got) != 2 || got[1] != "Rude [rude]" {
+		t.Errorf("Labels field is %q, want two values", got)
+	}
+	if got := values[Mappings().Fields.AddToLists]; len(got) != 1 || got[0] != "Trolls [trolls]" {
+		t.Errorf("AddToLists field is %q, want one value", got)
+	}
+}
+
+func TestAddNote(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	ticket, err := Create(ctx, backend, Subject("alice.test"), Type(TypeTicket), Status(StatusInProgress))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := AddNote(ctx, backend, ticket, "Another report"); err != nil {
+		t.Fatalf("AddNote() failed: %s", err)
+	}
+
+	got, err := backend.IssueWithJournals(ticket.Id)
+	if err != nil {
+		t.Fatalf("IssueWithJournals() failed: %s", err)
+	}
+	if got.Status.Id != Mappings().Statuses.InProgress {
+		t.Errorf("Status changed to %d", got.Status.Id)
+	}
+	if len(got.Journals) != 1 || got.Journals[0].Notes != "Another report" || len(got.Journals[0].Details) != 0 {
+		t.Errorf("Got journals %+v, want a single note without changes", got.Journals)
+	}
+}
+
+func TestFindBySubject(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	uri := "at://did:plc:alice/app.bsky.feed.post/abc"
+	ticket, err := Create(ctx, backend, Subject("alice.test abc"), Type(TypeRecordTicket), ReportSubject(uri))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := Create(ctx, backend, Subject("alice.test def"), Type(TypeRecordTicket), ReportSubject(uri+"def")); err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+
+	found, err := FindBySubject(ctx, backend, uri, AllTickets)
+	if err != nil {
+		t.Fatalf("FindBySubject() failed: %s", err)
+	}
+	if len(found) != 1 || found[0].Id != ticket.Id {
+		t.Errorf("FindBySubject() returned %+v, want ticket %d", found, ticket.Id)
+	}
+}
+
+func TestSelectDedupeTicket(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	dupe, err := Create(ctx, backend, Subject("a"), DID("did:plc:alice"), Type(TypeTicket), Priority(PriorityLow), Status(StatusDuplicate))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	normal, err := Create(ctx, backend, Subject("b"), DID("did:plc:alice"), Type(TypeTicket), Priority(PriorityNormal))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := Create(ctx, backend, Subject("c"), DID("did:plc:alice"), Type(TypeAppeal), Priority(PriorityLow)); err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	if _, err := Create(ctx, backend, Subject("d"), DID("did:plc:alice"), Type(TypeTicket), Priority(PriorityUrgent)); err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+
+	found, err := FindByDID(ctx, backend, "did:plc:alice", AllTickets)
+	if err != nil {
+		t.Fatalf("FindByDID() failed: %s", err)
+	}
+	got := SelectDedupeTicket(ctx, found)
+	if got == nil || got.Id != normal.Id {
+		t.Errorf("SelectDedupeTicket() returned %+v, want ticket %d (ticket %d is a duplicate)", got, normal.Id, dupe.Id)
+	}
+}
+
+func TestMemoryBackendUploads(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	f := t.TempDir() + "/profile.json"
+	if err := os.WriteFile(f, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	upload, err := backend.Upload(f)
+	if err != nil {
+		t.Fatalf("Upload() failed: %s", err)
+	}
+
+	ticket, err := Create(ctx, backend, Subject("alice.test"), Type(TypeTicket), Attachments([]*redmine.Upload{upload}))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	got := backend.Attachments(ticket.Id)
+	if len(got) != 1 || got[0].Filename != "profile.json" || string(got[0].Content) != "{}" {
+		t.Errorf("Got attachments %+v, want profile.json", got)
+	}
+
+	// Tokens can be used only once.
+	if _, err := Create(ctx, backend, Subject("bob.test"), Type(TypeTicket), Attachments([]*redmine.Upload{upload})); err == nil {
+		t.Errorf("Create() with an already used upload token succeeded, want an error")
+	}
+}
+
+func TestMemoryBackendRelations(t *testing.T) {
+	SetMappings(testMappings())
+	ctx := context.Background()
+	backend := NewMemoryBackend("modkit")
+
+	account, err := Create(ctx, backend, Subject("alice.test"), Type(TypeTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+	record, err := Create(ctx, backend, Subject("alice.test abc"), Type(TypeRecordTicket))
+	if err != nil {
+		t.Fatalf("Create() failed: %s", err)
+	}
+
+	_, err = backend.CreateIssueRelation(redmine.IssueRelation{IssueId: record.Id, IssueToId: account.Id, RelationType: "relates"})
+	if err != nil {
+		t.Fatalf("CreateIssueRelation() failed: %s", err)
+	}
+	_, err = backend.CreateIssueRelation(redmine.IssueRelation{IssueId: account.Id, IssueToId: record.Id, RelationType: "relates"})
+	if err == nil {
+		t.Errorf("Creating a duplicate relation succeeded, want an error")
+	}
+
+	for _, id := range []int{account.Id, record.Id} {
+		rels, err := backend.IssueRelations(id)
+		if err != nil {
+			t.Fatalf("IssueRelations(%d) failed: %s", id, err)
+		}
+		if len(rels) != 1 {
+			t.Errorf("IssueRelations(%d) returned %+v, want one relation", id, rels)
+		}
+	}
+}
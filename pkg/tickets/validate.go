@@ -0,0 +1,153 @@
+package tickets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MappingProblem is a single mismatch between the ID mappings and Redmine.
+type MappingProblem struct {
+	// Key is the path to the value in mappings.yaml, e.g. "statuses.completed".
+	Key     string
+	Message string
+}
+
+func (p MappingProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+// ValidateMappings checks the ID mappings against Redmine and returns
+// all mismatches found. For every wrong or missing ID it suggests
+// the ID of the object with the expected name, if there is one.
+// An error is returned only if Redmine could not be queried.
+func ValidateMappings(client Backend, m IDMappings) ([]MappingProblem, error) {
+	problems := []MappingProblem{}
+
+	for _, group := range mappingGroups(&m) {
+		available, err := group.query(client)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, checkMappingEntries(group.kind, available, group.entries)...)
+	}
+
+	problems = append(problems, checkMappedUsers(client, m)...)
+
+	return problems, nil
+}
+
+// CheckMappings is the same as ValidateMappings, but combines all
+// problems into a single error.
+func CheckMappings(client Backend, m IDMappings) error {
+	problems, err := ValidateMappings(client, m)
+	if err != nil {
+		return fmt.Errorf("validating ID mappings: %w", err)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	lines := []string{"ID mappings don't match Redmine:"}
+	for _, p := range problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return fmt.Errorf("%s", strings.Join(lines, "\n"))
+}
+
+func normalizeName(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func checkMappingEntries(kind string, available []namedID, entries []mappingEntry) []MappingProblem {
+	byId := map[int]namedID{}
+	byName := map[string]namedID{}
+	for _, a := range available {
+		byId[a.id] = a
+		if _, found := byName[normalizeName(a.name)]; !found {
+			byName[normalizeName(a.name)] = a
+		}
+	}
+
+	suggestion := func(name string) string {
+		if a, found := byName[normalizeName(name)]; found {
+			return fmt.Sprintf(", suggested ID: %d (%q)", a.id, a.name)
+		}
+		return fmt.Sprintf(", and there is no %s named %q", kind, name)
+	}
+
+	problems := []MappingProblem{}
+	seen := map[int]string{}
+	for _, e := range entries {
+		id := *e.id
+		if id == 0 {
+			if !e.optional {
+				problems = append(problems, MappingProblem{
+					Key:     e.key,
+					Message: "not set" + suggestion(e.name),
+				})
+			}
+			continue
+		}
+
+		if other, found := seen[id]; found {
+			problems = append(problems, MappingProblem{
+				Key:     e.key,
+				Message: fmt.Sprintf("ID %d is already used for %s%s", id, other, suggestion(e.name)),
+			})
+			continue
+		}
+		seen[id] = e.key
+
+		actual, found := byId[id]
+		if !found {
+			problems = append(problems, MappingProblem{
+				Key:     e.key,
+				Message: fmt.Sprintf("there is no %s with ID %d%s", kind, id, suggestion(e.name)),
+			})
+			continue
+		}
+		// Objects might have been renamed, so a different name is a problem
+		// only if some other object has the expected name.
+		if expected, found := byName[normalizeName(e.name)]; found && expected.id != actual.id {
+			problems = append(problems, MappingProblem{
+				Key: e.key,
+				Message: fmt.Sprintf("%s %d is named %q%s",
+					kind, actual.id, actual.name, suggestion(e.name)),
+			})
+		}
+	}
+	return problems
+}
+
+func checkMappedUsers(client Backend, m IDMappings) []MappingProblem {
+	problems := []MappingProblem{}
+	for i, u := range m.Users {
+		key := fmt.Sprintf("users[%d].username", i)
+		if u.Username == "" {
+			problems = append(problems, MappingProblem{Key: key, Message: "not set"})
+			continue
+		}
+		// Impersonating works for any user, unlike listing users
+		// which is limited to a single page.
+		if _, err := client.Impersonate(u.Username).MyAccount(); err != nil {
+			msg := fmt.Sprintf("failed to find user %q: %s", u.Username, err)
+			if users, err := client.Users(); err == nil {
+				for _, other := range users {
+					if strings.EqualFold(other.Login, u.Username) {
+						msg += fmt.Sprintf(", suggested: %q", other.Login)
+						break
+					}
+				}
+			}
+			problems = append(problems, MappingProblem{Key: key, Message: msg})
+		}
+		for j, did := range u.DIDs {
+			if !strings.HasPrefix(did, "did:") {
+				problems = append(problems, MappingProblem{
+					Key:     fmt.Sprintf("users[%d].dids[%d]", i, j),
+					Message: fmt.Sprintf("%q is not a DID", did),
+				})
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,130 @@
+package tickets
+
+import (
+	"strings"
+	"testing"
+
+	"bsky.watch/redmine"
+)
+
+// newDefaultRedmine returns a backend set up the same way as
+// redmine/default_data_loader.rb does, with IDs matching testMappings.
+func newDefaultRedmine() *MemoryBackend {
+	b := NewMemoryBackend("modkit")
+	b.AddProject(1, "Tickets")
+	b.SetNames(
+		map[int]string{1: "New", 2: "In progress", 3: "Closed", 4: "Applied", 5: "Duplicate", 6: "Granted", 7: "Rejected"},
+		map[int]string{1: "Ticket", 2: "Appeal", 3: "Record ticket", 4: "Incident"},
+		map[int]string{1: "Low", 2: "Normal", 3: "High", 4: "Urgent"})
+	for id, name := range map[int]string{1: "DID", 2: "Handle", 3: "Display name", 4: "Subject", 5: "Add to lists", 6: "Labels"} {
+		b.AddCustomField(redmine.CustomFieldDefinition{Id: id, Name: name, CustomizedType: "issue"})
+	}
+	b.AddCustomField(redmine.CustomFieldDefinition{Id: 7, Name: "Approver", CustomizedType: "user"})
+	b.AddUser("alice")
+	return b
+}
+
+func TestValidateMappings(t *testing.T) {
+	valid := testMappings()
+	valid.Users = append(valid.Users, struct {
+		Username string   `yaml:"username"`
+		DIDs     []string `yaml:"dids"`
+	}{Username: "alice", DIDs: []string{"did:plc:alice"}})
+
+	tests := []struct {
+		name   string
+		modify func(m *IDMappings)
+		// want maps keys to substrings of the expected messages.
+		want map[string]string
+	}{
+		{
+			name:   "valid",
+			modify: func(m *IDMappings) {},
+			want:   map[string]string{},
+		},
+		{
+			name:   "missing ID",
+			modify: func(m *IDMappings) { m.ProjectID = 42 },
+			want:   map[string]string{"projectId": `no project with ID 42, suggested ID: 1 ("Tickets")`},
+		},
+		{
+			name: "swapped IDs",
+			modify: func(m *IDMappings) {
+				m.Statuses.Completed, m.Statuses.Applied = m.Statuses.Applied, m.Statuses.Completed
+			},
+			want: map[string]string{
+				"statuses.completed": `status 4 is named "Applied", suggested ID: 3 ("Closed")`,
+				"statuses.applied":   `status 3 is named "Closed", suggested ID: 4 ("Applied")`,
+			},
+		},
+		{
+			name:   "not set",
+			modify: func(m *IDMappings) { m.TicketTypes.RecordTicket = 0 },
+			want:   map[string]string{"ticketTypes.recordTicket": `not set, suggested ID: 3 ("Record ticket")`},
+		},
+		{
+			name:   "optional not set",
+			modify: func(m *IDMappings) { m.Statuses.Granted = 0 },
+			want:   map[string]string{},
+		},
+		{
+			name:   "renamed",
+			modify: func(m *IDMappings) { m.TicketTypes.RecordTicket = 4 },
+			want: map[string]string{
+				"ticketTypes.recordTicket": `tracker 4 is named "Incident", suggested ID: 3 ("Record ticket")`,
+			},
+		},
+		{
+			name:   "duplicate",
+			modify: func(m *IDMappings) { m.Fields.Labels = m.Fields.Subject },
+			want: map[string]string{
+				"fields.labels": "ID 4 is already used for fields.subject",
+			},
+		},
+		{
+			name:   "not an issue field",
+			modify: func(m *IDMappings) { m.Fields.Approver = 7 },
+			want: map[string]string{
+				"fields.approver": `no issue custom field with ID 7, and there is no issue custom field named "Approver"`,
+			},
+		},
+		{
+			name:   "unknown user",
+			modify: func(m *IDMappings) { m.Users[0].Username = "Alice" },
+			want:   map[string]string{"users[0].username": `suggested: "alice"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := valid
+			m.Users = append(m.Users[:0:0], valid.Users...)
+			test.modify(&m)
+
+			problems, err := ValidateMappings(newDefaultRedmine(), m)
+			if err != nil {
+				t.Fatalf("ValidateMappings failed: %s", err)
+			}
+			got := map[string]string{}
+			for _, p := range problems {
+				if _, found := got[p.Key]; found {
+					t.Errorf("Multiple problems reported for %q", p.Key)
+				}
+				got[p.Key] = p.Message
+			}
+			for key, msg := range got {
+				want, found := test.want[key]
+				if !found {
+					t.Errorf("Unexpected problem %s: %s", key, msg)
+				} else if !strings.Contains(msg, want) {
+					t.Errorf("Problem for %s is %q, want it to contain %q", key, msg, want)
+				}
+			}
+			for key := range test.want {
+				if _, found := got[key]; !found {
+					t.Errorf("Missing problem for %s", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package triage
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+
+	"bsky.watch/modkit/pkg/reportstore"
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// NewReport returns the input for the rules with the attributes that are
+// recorded in the report log. The rest needs to be filled in by the caller.
+func NewReport(entry *reportstore.Report) *Report {
+	r := &Report{
+		ReasonType:          entry.ReasonType,
+		Reason:              entry.Reason,
+		Time:                entry.Timestamp,
+		ReporterIsModerator: tickets.UserForDID(entry.Sender) != "",
+		SubjectIsRecord:     strings.HasPrefix(entry.Subject, "at://"),
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	return r
+}
+
+// SetHistory sets PreviousReports from the log entries about the same
+// account. The report itself and the ones received after it are skipped.
+func (r *Report) SetHistory(id uint64, history []reportstore.Report) {
+	r.PreviousReports = nil
+	for _, h := range history {
+		if h.ID == id || h.Timestamp.After(r.Time) {
+			continue
+		}
+		r.PreviousReports = append(r.PreviousReports, h.Timestamp)
+	}
+}
+
+// SetProfile fills in the attributes of the reported account.
+func (r *Report) SetProfile(profile *bsky.ActorDefs_ProfileViewDetailed) {
+	if profile.CreatedAt != nil {
+		if t, err := time.Parse(time.RFC3339, *profile.CreatedAt); err == nil {
+			r.AccountCreatedAt = t
+		}
+	}
+	r.Followers = profile.FollowersCount
+	r.AddLabels(profile.Labels)
+}
+
+// AddLabels adds label values to the report, skipping negations.
+func (r *Report) AddLabels(labels []*atproto.LabelDefs_Label) {
+	for _, l := range labels {
+		if l.Neg != nil && *l.Neg {
+			continue
+		}
+		if !slices.Contains(r.Labels, l.Val) {
+			r.Labels = append(r.Labels, l.Val)
+		}
+	}
+}
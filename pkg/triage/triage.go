@@ -0,0 +1,344 @@
+// Package triage decides how incoming reports are routed, based on rules
+// from the config.
+package triage
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"bsky.watch/modkit/pkg/tickets"
+)
+
+// Rules are evaluated in order. Actions of all matching rules are applied,
+// with later rules overriding the values set by earlier ones, until a rule
+// with `stop: true` matches.
+type Rules []Rule
+
+type Rule struct {
+	Name  string  `yaml:"name"`
+	Match Match   `yaml:"match"`
+	Set   Actions `yaml:"set"`
+	// Stop prevents evaluation of the following rules if this one matched.
+	Stop bool `yaml:"stop"`
+}
+
+// Match lists the conditions that all need to be true for the rule to match.
+// Empty conditions are ignored, so a rule with no conditions matches every
+// report. Conditions on values that are not known for the report (e.g.,
+// account age if the profile doesn't have it) are false.
+type Match struct {
+	// ReasonTypes matches any of the given reason types. Either full values
+	// (`com.atproto.moderation.defs#reasonSpam`) or short names (`spam`)
+	// can be used.
+	ReasonTypes []string `yaml:"reasonTypes"`
+	// ReasonPattern is a regular expression matched against the text
+	// of the report.
+	ReasonPattern *Pattern `yaml:"reasonPattern"`
+
+	Reporter ReporterMatch `yaml:"reporter"`
+	Subject  SubjectMatch  `yaml:"subject"`
+	Volume   VolumeMatch   `yaml:"volume"`
+}
+
+type ReporterMatch struct {
+	// Moderator matches reports sent by (or not by) accounts listed
+	// in `users` in the ID mappings.
+	Moderator     *bool    `yaml:"moderator"`
+	MinReputation *float64 `yaml:"minReputation"`
+	MaxReputation *float64 `yaml:"maxReputation"`
+}
+
+type SubjectMatch struct {
+	// Record matches reports about records (true) or accounts (false).
+	Record        *bool         `yaml:"record"`
+	MinAccountAge time.Duration `yaml:"minAccountAge"`
+	MaxAccountAge time.Duration `yaml:"maxAccountAge"`
+	MinFollowers  *int64        `yaml:"minFollowers"`
+	MaxFollowers  *int64        `yaml:"maxFollowers"`
+	// Labels matches if the account or the record has any of these labels.
+	Labels []string `yaml:"labels"`
+}
+
+// VolumeMatch matches if there were at least MinReports reports about
+// the account (including the current one) within the Window.
+type VolumeMatch struct {
+	MinReports int           `yaml:"minReports"`
+	Window     time.Duration `yaml:"window"`
+}
+
+// Actions are applied to the ticket that the report is posted to.
+// Tracker and Project only take effect when a new ticket is created.
+type Actions struct {
+	// Priority is one of "low", "normal", "high" or "urgent". Existing
+	// tickets only get their priority raised, never lowered.
+	Priority string `yaml:"priority"`
+	// Assignee is a Redmine login. Existing tickets are assigned only if
+	// they are not assigned to anyone yet.
+	Assignee string `yaml:"assignee"`
+	// Tracker is either "ticket" or "recordTicket", and selects whether
+	// a report about a record goes into the account ticket or a separate
+	// record ticket.
+	Tracker string `yaml:"tracker"`
+	// Project is the identifier of a Redmine project. It needs to be
+	// a subproject of the one in the ID mappings, otherwise the following
+	// reports won't find the ticket.
+	Project string `yaml:"project"`
+	// Watchers are Redmine logins added as watchers of the ticket.
+	Watchers []string `yaml:"watchers"`
+}
+
+// Pattern is a regular expression, compiled when the config is loaded.
+type Pattern struct {
+	*regexp.Regexp
+}
+
+func (p *Pattern) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	p.Regexp = re
+	return nil
+}
+
+func (p Pattern) MarshalYAML() (any, error) {
+	return p.String(), nil
+}
+
+// Report contains everything that rules can look at.
+type Report struct {
+	ReasonType string
+	Reason     string
+	// Time is the time the report was received at.
+	Time time.Time
+
+	ReporterIsModerator bool
+	// ReporterReputation is nil if not known.
+	ReporterReputation *float64
+
+	SubjectIsRecord bool
+	// AccountCreatedAt is zero if not known.
+	AccountCreatedAt time.Time
+	// Followers is nil if not known.
+	Followers *int64
+	// Labels are the labels currently applied to the subject or its author.
+	Labels []string
+
+	// PreviousReports are the timestamps of earlier reports about the account.
+	PreviousReports []time.Time
+}
+
+// Decision is the combined result of all matched rules.
+type Decision struct {
+	// Rules are the names of matched rules.
+	Rules []string
+
+	Priority string
+	Assignee string
+	Tracker  string
+	Project  string
+	Watchers []string
+}
+
+// Evaluate returns the actions for the report.
+func (rules Rules) Evaluate(r *Report) Decision {
+	d := Decision{}
+	for _, rule := range rules {
+		if !rule.Match.matches(r) {
+			continue
+		}
+		d.Rules = append(d.Rules, rule.Name)
+		if rule.Set.Priority != "" {
+			d.Priority = rule.Set.Priority
+		}
+		if rule.Set.Assignee != "" {
+			d.Assignee = rule.Set.Assignee
+		}
+		if rule.Set.Tracker != "" {
+			d.Tracker = rule.Set.Tracker
+		}
+		if rule.Set.Project != "" {
+			d.Project = rule.Set.Project
+		}
+		for _, w := range rule.Set.Watchers {
+			if !slices.Contains(d.Watchers, w) {
+				d.Watchers = append(d.Watchers, w)
+			}
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	return d
+}
+
+// VolumeWindow returns the largest window used by the rules, or 0 if
+// none of them look at the number of reports.
+func (rules Rules) VolumeWindow() time.Duration {
+	var r time.Duration
+	for _, rule := range rules {
+		if rule.Match.Volume.MinReports > 0 {
+			r = max(r, rule.Match.Volume.Window)
+		}
+	}
+	return r
+}
+
+// Validate checks that the rules are well-formed.
+func (rules Rules) Validate() error {
+	seen := map[string]bool{}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule #%d has no name", i+1)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		seen[rule.Name] = true
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (rule *Rule) validate() error {
+	m := rule.Match
+	if m.Reporter.MinReputation != nil && m.Reporter.MaxReputation != nil &&
+		*m.Reporter.MinReputation > *m.Reporter.MaxReputation {
+		return fmt.Errorf("minReputation is larger than maxReputation")
+	}
+	if m.Subject.MinAccountAge < 0 || m.Subject.MaxAccountAge < 0 {
+		return fmt.Errorf("account age can't be negative")
+	}
+	if m.Subject.MaxAccountAge > 0 && m.Subject.MinAccountAge > m.Subject.MaxAccountAge {
+		return fmt.Errorf("minAccountAge is larger than maxAccountAge")
+	}
+	if m.Subject.MinFollowers != nil && m.Subject.MaxFollowers != nil &&
+		*m.Subject.MinFollowers > *m.Subject.MaxFollowers {
+		return fmt.Errorf("minFollowers is larger than maxFollowers")
+	}
+	if m.Volume.MinReports < 0 {
+		return fmt.Errorf("minReports can't be negative")
+	}
+	if m.Volume.MinReports > 0 && m.Volume.Window <= 0 {
+		return fmt.Errorf("volume window must be set when minReports is set")
+	}
+
+	if rule.Set.Priority != "" {
+		if _, err := ParsePriority(rule.Set.Priority); err != nil {
+			return err
+		}
+	}
+	switch rule.Set.Tracker {
+	case "", "ticket", "recordTicket":
+	default:
+		return fmt.Errorf("unknown tracker %q, must be either \"ticket\" or \"recordTicket\"", rule.Set.Tracker)
+	}
+	return nil
+}
+
+// ParsePriority converts the priority name used in the rules into
+// the ticket priority.
+func ParsePriority(s string) (tickets.TicketPriority, error) {
+	switch s {
+	case "low":
+		return tickets.PriorityLow, nil
+	case "normal":
+		return tickets.PriorityNormal, nil
+	case "high":
+		return tickets.PriorityHigh, nil
+	case "urgent":
+		return tickets.PriorityUrgent, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q", s)
+}
+
+// fullReasonType expands short reason type names, e.g. "spam" into
+// "com.atproto.moderation.defs#reasonSpam".
+func fullReasonType(s string) string {
+	if strings.ContainsAny(s, ".#") || s == "" {
+		return s
+	}
+	return "com.atproto.moderation.defs#reason" + strings.ToUpper(s[:1]) + s[1:]
+}
+
+func (m *Match) matches(r *Report) bool {
+	if len(m.ReasonTypes) > 0 && !slices.ContainsFunc(m.ReasonTypes, func(s string) bool {
+		return fullReasonType(s) == r.ReasonType
+	}) {
+		return false
+	}
+	if m.ReasonPattern != nil && m.ReasonPattern.Regexp != nil && !m.ReasonPattern.MatchString(r.Reason) {
+		return false
+	}
+
+	if m.Reporter.Moderator != nil && *m.Reporter.Moderator != r.ReporterIsModerator {
+		return false
+	}
+	if m.Reporter.MinReputation != nil || m.Reporter.MaxReputation != nil {
+		if r.ReporterReputation == nil {
+			return false
+		}
+		if m.Reporter.MinReputation != nil && *r.ReporterReputation < *m.Reporter.MinReputation {
+			return false
+		}
+		if m.Reporter.MaxReputation != nil && *r.ReporterReputation > *m.Reporter.MaxReputation {
+			return false
+		}
+	}
+
+	if m.Subject.Record != nil && *m.Subject.Record != r.SubjectIsRecord {
+		return false
+	}
+	if m.Subject.MinAccountAge > 0 || m.Subject.MaxAccountAge > 0 {
+		if r.AccountCreatedAt.IsZero() {
+			return false
+		}
+		age := r.Time.Sub(r.AccountCreatedAt)
+		if m.Subject.MinAccountAge > 0 && age < m.Subject.MinAccountAge {
+			return false
+		}
+		if m.Subject.MaxAccountAge > 0 && age > m.Subject.MaxAccountAge {
+			return false
+		}
+	}
+	if m.Subject.MinFollowers != nil || m.Subject.MaxFollowers != nil {
+		if r.Followers == nil {
+			return false
+		}
+		if m.Subject.MinFollowers != nil && *r.Followers < *m.Subject.MinFollowers {
+			return false
+		}
+		if m.Subject.MaxFollowers != nil && *r.Followers > *m.Subject.MaxFollowers {
+			return false
+		}
+	}
+	if len(m.Subject.Labels) > 0 && !slices.ContainsFunc(m.Subject.Labels, func(l string) bool {
+		return slices.Contains(r.Labels, l)
+	}) {
+		return false
+	}
+
+	if m.Volume.MinReports > 0 {
+		count := 1
+		for _, t := range r.PreviousReports {
+			if t.After(r.Time.Add(-m.Volume.Window)) && !t.After(r.Time) {
+				count++
+			}
+		}
+		if count < m.Volume.MinReports {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package triage
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseRules(t *testing.T, s string) Rules {
+	t.Helper()
+	var rules Rules
+	if err := yaml.Unmarshal([]byte(s), &rules); err != nil {
+		t.Fatalf("Failed to parse rules: %s", err)
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate() failed: %s", err)
+	}
+	return rules
+}
+
+func TestEvaluate(t *testing.T) {
+	rules := parseRules(t, `
+- name: moderators
+  match:
+    reporter:
+      moderator: true
+  set:
+    priority: normal
+  stop: true
+- name: new-accounts
+  match:
+    subject:
+      maxAccountAge: 72h
+  set:
+    priority: high
+    watchers: [alice]
+- name: spam
+  match:
+    reasonTypes: [spam, com.atproto.moderation.defs#reasonMisleading]
+  set:
+    priority: low
+    assignee: bob
+    watchers: [alice, bob]
+- name: burst
+  match:
+    volume:
+      minReports: 3
+      window: 1h
+  set:
+    priority: urgent
+- name: trusted-reporters
+  match:
+    reporter:
+      minReputation: 0.8
+  set:
+    tracker: recordTicket
+- name: csam
+  match:
+    reasonPattern: (?i)\bminor\b
+    subject:
+      labels: [porn]
+  set:
+    project: legal
+`)
+
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc   string
+		report Report
+		want   Decision
+	}{
+		{
+			desc:   "nothing matches",
+			report: Report{ReasonType: "com.atproto.moderation.defs#reasonRude", Time: now},
+			want:   Decision{},
+		},
+		{
+			desc:   "stop",
+			report: Report{ReasonType: "com.atproto.moderation.defs#reasonSpam", Time: now, ReporterIsModerator: true},
+			want:   Decision{Rules: []string{"moderators"}, Priority: "normal"},
+		},
+		{
+			desc: "later rules override",
+			report: Report{
+				ReasonType:       "com.atproto.moderation.defs#reasonSpam",
+				Time:             now,
+				AccountCreatedAt: now.Add(-time.Hour),
+			},
+			want: Decision{
+				Rules:    []string{"new-accounts", "spam"},
+				Priority: "low",
+				Assignee: "bob",
+				Watchers: []string{"alice", "bob"},
+			},
+		},
+		{
+			desc:   "full reason type",
+			report: Report{ReasonType: "com.atproto.moderation.defs#reasonMisleading", Time: now, AccountCreatedAt: now.Add(-100 * time.Hour)},
+			want:   Decision{Rules: []string{"spam"}, Priority: "low", Assignee: "bob", Watchers: []string{"alice", "bob"}},
+		},
+		{
+			desc: "volume",
+			report: Report{Time: now, PreviousReports: []time.Time{
+				now.Add(-2 * time.Hour), now.Add(-30 * time.Minute), now.Add(-time.Minute),
+			}},
+			want: Decision{Rules: []string{"burst"}, Priority: "urgent"},
+		},
+		{
+			desc: "volume outside of the window",
+			report: Report{Time: now, PreviousReports: []time.Time{
+				now.Add(-2 * time.Hour), now.Add(-90 * time.Minute), now.Add(-time.Minute),
+			}},
+			want: Decision{},
+		},
+		{
+			desc:   "reputation",
+			report: Report{Time: now, ReporterReputation: ptr(0.9)},
+			want:   Decision{Rules: []string{"trusted-reporters"}, Tracker: "recordTicket"},
+		},
+		{
+			desc:   "low reputation",
+			report: Report{Time: now, ReporterReputation: ptr(0.1)},
+			want:   Decision{},
+		},
+		{
+			desc:   "pattern and labels",
+			report: Report{Time: now, Reason: "looks like a Minor", Labels: []string{"spam", "porn"}},
+			want:   Decision{Rules: []string{"csam"}, Project: "legal"},
+		},
+		{
+			desc:   "pattern without labels",
+			report: Report{Time: now, Reason: "looks like a Minor"},
+			want:   Decision{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got := rules.Evaluate(&test.report)
+			if !slices.Equal(got.Rules, test.want.Rules) || !slices.Equal(got.Watchers, test.want.Watchers) ||
+				got.Priority != test.want.Priority || got.Assignee != test.want.Assignee ||
+				got.Tracker != test.want.Tracker || got.Project != test.want.Project {
+				t.Errorf("Evaluate() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnknownValuesDoNotMatch(t *testing.T) {
+	rules := parseRules(t, `
+- name: small
+  match:
+    subject:
+      maxFollowers: 10
+- name: young
+  match:
+    subject:
+      maxAccountAge: 24h
+`)
+	got := rules.Evaluate(&Report{Time: time.Now()})
+	if len(got.Rules) != 0 {
+		t.Errorf("Rules %v matched a report without profile info", got.Rules)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		desc  string
+		rules string
+	}{
+		{"no name", `[{set: {priority: low}}]`},
+		{"duplicate name", `[{name: a}, {name: a}]`},
+		{"unknown priority", `[{name: a, set: {priority: critical}}]`},
+		{"unknown tracker", `[{name: a, set: {tracker: appeal}}]`},
+		{"volume without window", `[{name: a, match: {volume: {minReports: 5}}}]`},
+		{"min followers larger than max", `[{name: a, match: {subject: {minFollowers: 10, maxFollowers: 5}}}]`},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var rules Rules
+			if err := yaml.Unmarshal([]byte(test.rules), &rules); err != nil {
+				t.Fatalf("Failed to parse rules: %s", err)
+			}
+			if err := rules.Validate(); err == nil {
+				t.Errorf("Validate() succeeded")
+			}
+		})
+	}
+
+	var rules Rules
+	if err := yaml.Unmarshal([]byte(`[{name: a, match: {reasonPattern: "(unclosed"}}]`), &rules); err == nil {
+		t.Errorf("Invalid reasonPattern was accepted")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -443,9 +443,12 @@ module Redmine
             ]
             ticket.custom_fields << common_fields
             ticket.custom_fields << ticket_fields
+            ticket.custom_fields << IssueCustomField.where(:name => "Labels").take
             ticket.save!
 
             appeal.custom_fields << common_fields
+            appeal.custom_fields << ticket_fields
+            appeal.custom_fields << record_ticket_fields
             appeal.save!
 
             recordTicket.custom_fields << common_fields
@@ -0,0 +1,68 @@
+# Signs requests sent by redmine_webhook plugin, so that redmine-handler
+# can verify that they are coming from this Redmine instance.
+#
+# Signature is HMAC-SHA256 over "<timestamp>.<body>" using the secret from
+# MODKIT_WEBHOOK_SECRET env var. Timestamp (seconds since Unix epoch) is sent
+# in X-Modkit-Timestamp header, and the signature in X-Modkit-Signature
+# header as "sha256=<hex digest>".
+#
+# The plugin sends requests with Faraday's default connection. Instead of
+# replacing it for the whole process, we override the plugin's post method
+# to use a separate connection that has the signing middleware.
+
+require 'openssl'
+
+secret = ENV['MODKIT_WEBHOOK_SECRET'].to_s
+
+if secret.empty?
+  Rails.logger.warn "MODKIT_WEBHOOK_SECRET is not set, webhook requests will not be signed"
+elsif defined?(Faraday)
+  class ModkitWebhookSignature < Faraday::Middleware
+    def initialize(app, secret)
+      super(app)
+      @secret = secret
+    end
+
+    def call(env)
+      timestamp = Time.now.to_i.to_s
+      body = env.body.to_s
+      digest = OpenSSL::HMAC.hexdigest('SHA256', @secret, "#{timestamp}.#{body}")
+      env.request_headers['X-Modkit-Timestamp'] = timestamp
+      env.request_headers['X-Modkit-Signature'] = "sha256=#{digest}"
+      @app.call(env)
+    end
+  end
+
+  module ModkitSignedWebhooks
+    CONNECTION = Faraday.new do |f|
+      f.use ModkitWebhookSignature, ENV['MODKIT_WEBHOOK_SECRET'].to_s
+      f.adapter Faraday.default_adapter
+    end
+
+    private
+
+    # Same as RedmineWebhook::WebhookListener#post, but with the signing connection.
+    def post(webhooks, request_body)
+      Thread.start do
+        webhooks.each do |webhook|
+          begin
+            CONNECTION.post(webhook.url, request_body, 'Content-Type' => 'application/json')
+          rescue => e
+            Rails.logger.error e
+          end
+        end
+      end
+    end
+  end
+
+  Rails.configuration.to_prepare do
+    listener = 'RedmineWebhook::WebhookListener'.safe_constantize
+    if listener.nil? || !(listener.method_defined?(:post) || listener.private_method_defined?(:post))
+      Rails.logger.warn "redmine_webhook plugin is not loaded or has changed, webhook requests will not be signed"
+    elsif !listener.ancestors.include?(ModkitSignedWebhooks)
+      listener.prepend(ModkitSignedWebhooks)
+    end
+  end
+else
+  Rails.logger.warn "Faraday is not loaded, webhook requests will not be signed"
+end